@@ -1,11 +1,10 @@
 package main
 
 import (
-    "log"
     "math/rand"
     "time"
 
-    pb "main/genproto"
+    pb "adservice/genproto"
 )
 
 // AdService struct holds the data and methods for the ad service
@@ -48,7 +47,7 @@ func createAdsMap() map[string][]*pb.Ad {
 // GetAds processes the AdRequest and returns an AdResponse
 func (s *AdService) GetAds(req *pb.AdRequest, headers *map[string]string) (*pb.AdResponse, error) {
     var allAds []*pb.Ad
-    log.Printf("Received ad request (context_words=%v)", req.ContextKeys)
+    requestLogger(headers).Printf("Received ad request (context_words=%v)", req.ContextKeys)
 
     if len(req.ContextKeys) > 0 {
         for _, contextKey := range req.ContextKeys {
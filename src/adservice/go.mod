@@ -1,16 +1,18 @@
-module main
+module adservice
 
 go 1.22.3
 
 require (
 	github.com/aws/aws-lambda-go v1.47.0
+	github.com/sirupsen/logrus v1.9.3
 	google.golang.org/grpc v1.66.0
 	google.golang.org/protobuf v1.34.2
+	logging v0.0.0
 )
 
 require (
-	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117 // indirect
 )
+
+replace logging => ../logging
@@ -1,26 +1,31 @@
 package main
 
 import (
+    "context"
+    "crypto/rand"
     "encoding/base64"
     "fmt"
     "io"
-    "log"
     "net/http"
     "os"
     "strconv"
     "strings"
 
     "github.com/aws/aws-lambda-go/lambda"
+    "github.com/sirupsen/logrus"
     "google.golang.org/grpc/codes"
     "google.golang.org/grpc/status"
     "google.golang.org/protobuf/proto"
 
-    pb "main/genproto"
+    pb "adservice/genproto"
+    "logging"
 )
 
 var (
     runningInLambda = os.Getenv("RUN_LAMBDA") == "1"
 
+    log = logging.New("adservice")
+
     svc = NewAdService()
 )
 
@@ -139,8 +144,44 @@ func generateErrorResponse(code codes.Code, message string) *ResponseData {
     }
 }
 
-func runLambda(reqData *RequestData) (*ResponseData, error) {
-    log.Printf("Handler started. Event data: %v", reqData)
+// ensureRequestID adopts the caller's request ID, or mints a new one if there is none,
+// and records it in the request headers so RPC functions can see it.
+func ensureRequestID(reqData *RequestData) string {
+    if reqData.Headers == nil {
+        reqData.Headers = make(map[string]string)
+    }
+    requestID := reqData.Headers[logging.RequestIDHeader]
+    if requestID == "" {
+        requestID = newRequestID()
+        reqData.Headers[logging.RequestIDHeader] = requestID
+    }
+    return requestID
+}
+
+// newRequestID generates a random (version 4) UUID string.
+func newRequestID() string {
+    b := make([]byte, 16)
+    _, _ = rand.Read(b)
+    b[6] = (b[6] & 0x0f) | 0x40
+    b[8] = (b[8] & 0x3f) | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// requestLogger returns a logger that tags every entry with the correlation data of a request.
+func requestLogger(headers *map[string]string) *logrus.Entry {
+    return logging.WithRequest(log, *headers)
+}
+
+func runLambda(ctx context.Context, reqData *RequestData) (*ResponseData, error) {
+    inv := logging.StartInvocation(ctx)
+    requestID := ensureRequestID(reqData)
+    if lambdaRequestID := logging.LambdaRequestID(ctx); lambdaRequestID != "" {
+        reqData.Headers[logging.LambdaRequestIDHeader] = lambdaRequestID
+    }
+    reqLog := requestLogger(&reqData.Headers)
+    defer inv.End(reqLog)
+
+    reqLog.Infof("Handler started. Event data: %v", reqData)
 
     reqMsg, respData, err := decodeRequest(reqData)
     if err != nil {
@@ -155,74 +196,83 @@ func runLambda(reqData *RequestData) (*ResponseData, error) {
         }
     }
 
-    log.Printf("Handler finished. Response: %v", respData)
+    respData.Headers[logging.RequestIDHeader] = requestID
+    reqLog.Infof("Handler finished. Response: %v", respData)
     return respData, nil
 }
 
-func runHTTPServer() error {
-    httpHandler := func(w http.ResponseWriter, r *http.Request) {
-        reqBody, err := io.ReadAll(r.Body)
-        if err != nil {
-            log.Printf("Error reading request body: %v", err)
-            http.Error(w, "failed to read request body", http.StatusInternalServerError)
-            return
-        }
-        defer r.Body.Close()
-
-        headers := make(map[string]string)
-        for k, vs := range r.Header {
-            s := vs[0]
-            for _, v := range vs[1:] {
-                s += "," + v
-            }
-            headers[strings.ToLower(k)] = s
-        }
+// flattenHeaders converts HTTP headers into the lowercase, comma-joined form that RequestData carries.
+func flattenHeaders(header http.Header) map[string]string {
+    headers := make(map[string]string, len(header))
+    for k, vs := range header {
+        headers[strings.ToLower(k)] = strings.Join(vs, ",")
+    }
+    return headers
+}
 
-        reqData := &RequestData{
-            BinBody:         reqBody,
-            Headers:         headers,
-            IsBase64Encoded: false,
-        }
+// httpHandler serves a single RPC over plain HTTP.
+func httpHandler(w http.ResponseWriter, r *http.Request) {
+    reqBody, err := io.ReadAll(r.Body)
+    if err != nil {
+        log.Infof("Error reading request body: %v", err)
+        http.Error(w, "failed to read request body", http.StatusInternalServerError)
+        return
+    }
+    defer r.Body.Close()
 
-        var respData *ResponseData
-        reqMsg, respData, err := decodeRequest(reqData)
-        if err != nil {
-            log.Printf("Error decoding request: %v", err)
-            http.Error(w, "failed to decode request", http.StatusInternalServerError)
-            return
+    headers := flattenHeaders(r.Header)
+    delete(headers, logging.LambdaRequestIDHeader)
 
-        } else if respData == nil {
-            respMsg, rpcError := callRPC(reqMsg, reqData)
+    reqData := &RequestData{
+        BinBody:         reqBody,
+        Headers:         headers,
+        IsBase64Encoded: false,
+    }
+    requestID := ensureRequestID(reqData)
 
-            respData, err = encodeResponse(&respMsg, rpcError)
-            if err != nil {
-                log.Printf("Error encoding response: %v", err)
-                http.Error(w, "failed to encode response", http.StatusInternalServerError)
-                return
-            }
-        }
+    var respData *ResponseData
+    reqMsg, respData, err := decodeRequest(reqData)
+    if err != nil {
+        log.Infof("Error decoding request: %v", err)
+        http.Error(w, "failed to decode request", http.StatusInternalServerError)
+        return
 
-        for k, v := range respData.Headers {
-            w.Header().Set(k, v)
-        }
-        w.WriteHeader(respData.StatusCode)
-        if _, err := w.Write(respData.BinBody); err != nil {
-            log.Printf("Error writing response: %v", err)
+    } else if respData == nil {
+        respMsg, rpcError := callRPC(reqMsg, reqData)
+
+        respData, err = encodeResponse(&respMsg, rpcError)
+        if err != nil {
+            log.Infof("Error encoding response: %v", err)
+            http.Error(w, "failed to encode response", http.StatusInternalServerError)
+            return
         }
     }
 
+    respData.Headers[logging.RequestIDHeader] = requestID
+    for k, v := range respData.Headers {
+        w.Header().Set(k, v)
+    }
+    w.WriteHeader(respData.StatusCode)
+    if _, err := w.Write(respData.BinBody); err != nil {
+        log.Infof("Error writing response: %v", err)
+    }
+}
+
+func runHTTPServer() error {
     port := defaultPort
     if p, ok := os.LookupEnv("PORT"); ok {
         port = p
     }
     addr := os.Getenv("LISTEN_ADDR")
 
-    log.Println("Starting HTTP server on " + addr + ":" + port)
+    log.Info("Starting HTTP server on " + addr + ":" + port)
     http.HandleFunc("/", httpHandler)
     return http.ListenAndServe(addr+":"+port, nil)
 }
 
 func main() {
+    logging.InitDone(log)
+
     if runningInLambda {
         lambda.Start(runLambda)
     } else {
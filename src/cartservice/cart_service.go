@@ -1,23 +1,71 @@
 package main
 
 import (
-    "main/cartstore"
-    pb "main/genproto"
+    "context"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "cartservice/cartstore"
+    "cartservice/events"
+    pb "cartservice/genproto"
+    "logging"
 )
 
 type CartService struct {
     cartStore cartstore.CartStore
+    limits    cartstore.Limits
+    publisher events.Publisher
 }
 
-func NewCartService(cartStore cartstore.CartStore) *CartService {
-    return &CartService{cartStore: cartStore}
+// NewCartService creates a service on a cart store, whose carts it keeps within limits. Requests that can't succeed
+// whatever the cart holds fail with InvalidArgument, and updates that would take a cart over a limit fail with
+// FailedPrecondition. Every change of a cart is published as an event through publisher, unless it's nil.
+func NewCartService(cartStore cartstore.CartStore, limits cartstore.Limits, publisher events.Publisher) *CartService {
+    cartStore.SetLimits(limits)
+    return &CartService{cartStore: cartStore, limits: limits, publisher: publisher}
+}
+
+// publish publishes the event of a cart change made by a request. A failure is logged, but the change stands.
+func (s *CartService) publish(event events.Event, headers *map[string]string) {
+    if s.publisher == nil {
+        return
+    }
+    if headers != nil {
+        event.RequestID = (*headers)[logging.RequestIDHeader]
+    }
+    if err := s.publisher.Publish(context.Background(), event); err != nil {
+        log.WithField("event", event.ID).Warnf("failed to publish %s event: %v", event.Type, err)
+    }
+}
+
+// checkQuantity checks that a quantity of a product can be in a cart, zero only if allowZero is set.
+func (s *CartService) checkQuantity(quantity int32, allowZero bool) error {
+    switch {
+    case quantity < 0 || quantity == 0 && !allowZero:
+        return status.Errorf(codes.InvalidArgument, "invalid quantity %d", quantity)
+    case s.limits.MaxItemQuantity > 0 && quantity > s.limits.MaxItemQuantity:
+        return status.Errorf(codes.InvalidArgument, "a cart can't hold more than %d units of a product",
+            s.limits.MaxItemQuantity)
+    }
+    return nil
 }
 
 func (s *CartService) AddItem(req *pb.AddItemRequest, headers *map[string]string) (*pb.Empty, error) {
+    if req.Item == nil || req.Item.ProductId == "" {
+        return nil, status.Error(codes.InvalidArgument, "item with a product id is required")
+    }
+    if err := s.checkQuantity(req.Item.Quantity, false); err != nil {
+        return nil, err
+    }
     err := s.cartStore.AddItemAsync(req.UserId, req.Item.ProductId, req.Item.Quantity)
     if err != nil {
         return nil, err
     }
+    event := events.New(events.ItemAdded, req.UserId)
+    event.ProductID, event.Quantity = req.Item.ProductId, req.Item.Quantity
+    s.publish(event, headers)
     return &pb.Empty{}, nil
 }
 
@@ -34,5 +82,66 @@ func (s *CartService) EmptyCart(req *pb.EmptyCartRequest, headers *map[string]st
     if err != nil {
         return nil, err
     }
+    s.publish(events.New(events.CartEmptied, req.UserId), headers)
     return &pb.Empty{}, nil
 }
+
+func (s *CartService) RemoveItem(req *pb.RemoveItemRequest, headers *map[string]string) (*pb.Empty, error) {
+    if req.ProductId == "" {
+        return nil, status.Error(codes.InvalidArgument, "product id is required")
+    }
+    err := s.cartStore.RemoveItemAsync(req.UserId, req.ProductId)
+    if err != nil {
+        return nil, err
+    }
+    event := events.New(events.QuantityChanged, req.UserId)
+    event.ProductID = req.ProductId
+    s.publish(event, headers)
+    return &pb.Empty{}, nil
+}
+
+func (s *CartService) SetItemQuantity(req *pb.SetItemQuantityRequest, headers *map[string]string) (*pb.Empty, error) {
+    if req.Item == nil || req.Item.ProductId == "" {
+        return nil, status.Error(codes.InvalidArgument, "item with a product id is required")
+    }
+    if err := s.checkQuantity(req.Item.Quantity, true); err != nil {
+        return nil, err
+    }
+    err := s.cartStore.SetItemQuantityAsync(req.UserId, req.Item.ProductId, req.Item.Quantity)
+    if err != nil {
+        return nil, err
+    }
+    event := events.New(events.QuantityChanged, req.UserId)
+    event.ProductID, event.Quantity = req.Item.ProductId, req.Item.Quantity
+    s.publish(event, headers)
+    return &pb.Empty{}, nil
+}
+
+func (s *CartService) MergeCarts(req *pb.MergeCartsRequest, headers *map[string]string) (*pb.Cart, error) {
+    if req.SourceUserId == "" || req.TargetUserId == "" {
+        return nil, status.Error(codes.InvalidArgument, "source and target user ids are required")
+    }
+    maxQuantity := req.MaxQuantity
+    if maxQuantity <= 0 {
+        maxQuantity = cartstore.DefaultMaxMergedQuantity
+    }
+    if s.limits.MaxItemQuantity > 0 {
+        maxQuantity = min(maxQuantity, s.limits.MaxItemQuantity)
+    }
+    cart, err := s.cartStore.MergeCartsAsync(req.SourceUserId, req.TargetUserId, maxQuantity)
+    if err != nil {
+        return nil, err
+    }
+    event := events.New(events.CartsMerged, req.TargetUserId)
+    event.SourceUserID = req.SourceUserId
+    s.publish(event, headers)
+    return cart, nil
+}
+
+func (s *CartService) ListExpiringCarts(req *pb.ListExpiringCartsRequest, headers *map[string]string) (*pb.ListExpiringCartsResponse, error) {
+    carts, err := s.cartStore.ListExpiringCartsAsync(time.Duration(req.WithinSeconds)*time.Second, int(req.Limit))
+    if err != nil {
+        return nil, err
+    }
+    return &pb.ListExpiringCartsResponse{Carts: carts}, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    "cartservice/cartstore"
+    "cartservice/events"
+    pb "cartservice/genproto"
+    "logging"
+)
+
+func TestCartServiceLimits(t *testing.T) {
+    quietLogs(t)
+    s := NewCartService(cartstore.NewInMemoryCartStore(cartstore.DefaultCartTTL),
+        cartstore.Limits{MaxItems: 2, MaxItemQuantity: 5, MaxTotalQuantity: 8}, nil)
+    addItem := func(userId, productId string, quantity int32) error {
+        _, err := s.AddItem(&pb.AddItemRequest{UserId: userId,
+            Item: &pb.CartItem{ProductId: productId, Quantity: quantity}}, nil)
+        return err
+    }
+    setItemQuantity := func(productId string, quantity int32) error {
+        _, err := s.SetItemQuantity(&pb.SetItemQuantityRequest{UserId: "user",
+            Item: &pb.CartItem{ProductId: productId, Quantity: quantity}}, nil)
+        return err
+    }
+
+    for _, test := range []struct {
+        name string
+        call func() error
+        want codes.Code
+    }{
+        {"add", func() error { return addItem("user", "A", 4) }, codes.OK},
+        {"add nothing", func() error { return addItem("user", "A", 0) }, codes.InvalidArgument},
+        {"add a negative quantity", func() error { return addItem("user", "A", -1) }, codes.InvalidArgument},
+        {"add without a product", func() error { return addItem("user", "", 1) }, codes.InvalidArgument},
+        {"add more than a line holds", func() error { return addItem("user", "B", 6) }, codes.InvalidArgument},
+        {"add to a full line", func() error { return addItem("user", "A", 2) }, codes.FailedPrecondition},
+        {"set a negative quantity", func() error { return setItemQuantity("A", -1) }, codes.InvalidArgument},
+        {"set more than a line holds", func() error { return setItemQuantity("A", 6) }, codes.InvalidArgument},
+        {"set", func() error { return setItemQuantity("B", 4) }, codes.OK},
+        {"exceed the total", func() error { return setItemQuantity("B", 5) }, codes.FailedPrecondition},
+        {"exceed the products", func() error { return addItem("user", "C", 1) }, codes.FailedPrecondition},
+        {"set zero", func() error { return setItemQuantity("B", 0) }, codes.OK},
+    } {
+        if err := test.call(); status.Code(err) != test.want {
+            t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+        }
+    }
+
+    // Merged lines are capped at the line limit, even if the request allows more.
+    if err := addItem("anonymous", "A", 5); err != nil {
+        t.Fatal(err)
+    }
+    cart, err := s.MergeCarts(&pb.MergeCartsRequest{SourceUserId: "anonymous", TargetUserId: "user", MaxQuantity: 20}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(cart.Items) != 1 || cart.Items[0].Quantity != 5 {
+        t.Errorf("merged cart = %v, want 5 units of A", cart)
+    }
+}
+
+func TestCartServiceEvents(t *testing.T) {
+    quietLogs(t)
+    publisher := events.NewMemoryPublisher(0)
+    s := NewCartService(cartstore.NewInMemoryCartStore(cartstore.DefaultCartTTL), cartstore.DefaultLimits, publisher)
+    headers := map[string]string{logging.RequestIDHeader: "request"}
+    item := &pb.CartItem{ProductId: "OLJCESPC7Z", Quantity: 2}
+    calls := []func() error{
+        func() error {
+            _, err := s.AddItem(&pb.AddItemRequest{UserId: "anonymous", Item: item}, &headers)
+            return err
+        },
+        func() error {
+            _, err := s.SetItemQuantity(&pb.SetItemQuantityRequest{UserId: "anonymous", Item: item}, &headers)
+            return err
+        },
+        func() error {
+            _, err := s.MergeCarts(&pb.MergeCartsRequest{SourceUserId: "anonymous", TargetUserId: "user"}, &headers)
+            return err
+        },
+        func() error {
+            _, err := s.RemoveItem(&pb.RemoveItemRequest{UserId: "user", ProductId: item.ProductId}, &headers)
+            return err
+        },
+        func() error {
+            _, err := s.EmptyCart(&pb.EmptyCartRequest{UserId: "user"}, &headers)
+            return err
+        },
+        // A failed update publishes nothing.
+        func() error {
+            _, err := s.AddItem(&pb.AddItemRequest{UserId: "user", Item: &pb.CartItem{ProductId: "A"}}, &headers)
+            if err == nil {
+                return errors.New("adding nothing succeeded")
+            }
+            return nil
+        },
+    }
+    for _, call := range calls {
+        if err := call(); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    want := []events.Event{
+        {Type: events.ItemAdded, UserID: "anonymous", ProductID: item.ProductId, Quantity: 2},
+        {Type: events.QuantityChanged, UserID: "anonymous", ProductID: item.ProductId, Quantity: 2},
+        {Type: events.CartsMerged, UserID: "user", SourceUserID: "anonymous"},
+        {Type: events.QuantityChanged, UserID: "user", ProductID: item.ProductId},
+        {Type: events.CartEmptied, UserID: "user"},
+    }
+    got := publisher.Events()
+    if len(got) != len(want) {
+        t.Fatalf("published %d events, want %d: %+v", len(got), len(want), got)
+    }
+    for i, event := range got {
+        if event.ID == "" || event.Time.IsZero() || event.RequestID != "request" {
+            t.Errorf("event %d = %+v, want an ID, a time and the request ID", i, event)
+        }
+        event.ID, event.Time, event.RequestID = "", time.Time{}, ""
+        if event != want[i] {
+            t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+        }
+    }
+}
@@ -0,0 +1,99 @@
+package cartstore
+
+import (
+    "fmt"
+
+    "github.com/klauspost/compress/zstd"
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/proto"
+
+    pb "cartservice/genproto"
+)
+
+// Encoding is how a store serializes the carts it keeps as values, such as the ones of the Redis store.
+type Encoding string
+
+const (
+    // EncodingJSON writes carts in protojson, which is readable with any Redis client. It's the default, and the only
+    // encoding that stores before the others existed can read.
+    EncodingJSON Encoding = "json"
+    // EncodingProto writes carts in the binary protobuf format, which is smaller and faster to parse.
+    EncodingProto Encoding = "proto"
+    // EncodingProtoZstd writes carts in the binary protobuf format compressed with zstd, the smallest of the three
+    // for large carts.
+    EncodingProtoZstd Encoding = "proto-zstd"
+)
+
+// The first byte of an encoded cart tells its format. Carts in protojson have no prefix of their own: they were
+// written before the prefix existed, and their opening brace tells them apart. The binary formats start with a
+// version byte, which a new format must not reuse.
+const (
+    jsonCartPrefix      = '{'
+    protoCartPrefix     = 0x01
+    protoZstdCartPrefix = 0x02
+)
+
+var (
+    // zstdEncoder and zstdDecoder are shared, as they're safe for concurrent use through EncodeAll and DecodeAll.
+    zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+    zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// ParseEncoding returns the encoding of a name, as set in the CART_ENCODING environment variable.
+func ParseEncoding(name string) (Encoding, error) {
+    switch encoding := Encoding(name); encoding {
+    case EncodingJSON, EncodingProto, EncodingProtoZstd:
+        return encoding, nil
+    default:
+        return "", fmt.Errorf("unknown cart encoding %q, expected %s, %s or %s", name, EncodingJSON, EncodingProto,
+            EncodingProtoZstd)
+    }
+}
+
+// encodeCart serializes a cart in an encoding, with the prefix of its format.
+func encodeCart(cart *pb.Cart, encoding Encoding) ([]byte, error) {
+    switch encoding {
+    case EncodingJSON:
+        return protojson.Marshal(cart)
+    case EncodingProto, EncodingProtoZstd:
+        data, err := proto.Marshal(cart)
+        if err != nil {
+            return nil, err
+        }
+        if encoding == EncodingProto {
+            return append([]byte{protoCartPrefix}, data...), nil
+        }
+        return zstdEncoder.EncodeAll(data, []byte{protoZstdCartPrefix}), nil
+    default:
+        return nil, fmt.Errorf("unknown cart encoding %q", encoding)
+    }
+}
+
+// decodeCart parses a cart serialized in any of the encodings, telling the format from the prefix.
+func decodeCart(data []byte) (*pb.Cart, error) {
+    if len(data) == 0 {
+        return nil, fmt.Errorf("empty cart value")
+    }
+    cart := &pb.Cart{}
+    switch data[0] {
+    case jsonCartPrefix:
+        if err := protojson.Unmarshal(data, cart); err != nil {
+            return nil, err
+        }
+    case protoCartPrefix:
+        if err := proto.Unmarshal(data[1:], cart); err != nil {
+            return nil, err
+        }
+    case protoZstdCartPrefix:
+        raw, err := zstdDecoder.DecodeAll(data[1:], nil)
+        if err != nil {
+            return nil, err
+        }
+        if err := proto.Unmarshal(raw, cart); err != nil {
+            return nil, err
+        }
+    default:
+        return nil, fmt.Errorf("unknown cart format 0x%02x", data[0])
+    }
+    return cart, nil
+}
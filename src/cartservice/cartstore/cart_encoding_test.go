@@ -0,0 +1,132 @@
+package cartstore
+
+import (
+    "fmt"
+    "math/rand"
+    "testing"
+
+    "github.com/alicebob/miniredis/v2"
+    "google.golang.org/protobuf/proto"
+
+    pb "cartservice/genproto"
+)
+
+var encodings = []Encoding{EncodingJSON, EncodingProto, EncodingProtoZstd}
+
+// largeCart returns a cart with the given number of lines, whose product IDs look like the catalog's.
+func largeCart(lines int) *pb.Cart {
+    rnd := rand.New(rand.NewSource(int64(lines)))
+    cart := &pb.Cart{UserId: "6c1a9d23-5b7e-4f0a-9c2d-8e4b1f7a3c5d"}
+    for i := 0; i < lines; i++ {
+        id := make([]byte, 10)
+        for j := range id {
+            id[j] = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"[rnd.Intn(36)]
+        }
+        cart.Items = append(cart.Items, &pb.CartItem{ProductId: string(id), Quantity: int32(1 + rnd.Intn(10))})
+    }
+    return cart
+}
+
+func TestCartEncodingsRoundTrip(t *testing.T) {
+    for _, encoding := range encodings {
+        for _, cart := range []*pb.Cart{largeCart(1), largeCart(100)} {
+            data, err := encodeCart(cart, encoding)
+            if err != nil {
+                t.Fatal(err)
+            }
+            got, err := decodeCart(data)
+            if err != nil {
+                t.Fatalf("%s: decodeCart() failed: %v", encoding, err)
+            }
+            if !proto.Equal(got, cart) {
+                t.Errorf("%s: decoded %v, want %v", encoding, got, cart)
+            }
+        }
+    }
+
+    for _, data := range [][]byte{nil, {0x7f}, {protoCartPrefix, 0xff}, {protoZstdCartPrefix, 1, 2, 3}} {
+        if cart, err := decodeCart(data); err == nil {
+            t.Errorf("decodeCart(%q) = %v, want an error", data, cart)
+        }
+    }
+}
+
+func TestParseEncoding(t *testing.T) {
+    for _, encoding := range encodings {
+        if got, err := ParseEncoding(string(encoding)); err != nil || got != encoding {
+            t.Errorf("ParseEncoding(%q) = %q, %v", encoding, got, err)
+        }
+    }
+    if _, err := ParseEncoding("xml"); err == nil {
+        t.Error("ParseEncoding(\"xml\") succeeded")
+    }
+}
+
+// BenchmarkCartEncoding measures how long carts of growing sizes take to encode and decode, and reports the size of
+// the encoded cart in bytes/cart.
+func BenchmarkCartEncoding(b *testing.B) {
+    for _, lines := range []int{10, 100, 1000} {
+        cart := largeCart(lines)
+        for _, encoding := range encodings {
+            data, err := encodeCart(cart, encoding)
+            if err != nil {
+                b.Fatal(err)
+            }
+            name := fmt.Sprintf("%d-lines/%s", lines, encoding)
+            b.Run(name+"/encode", func(b *testing.B) {
+                b.ReportAllocs()
+                for i := 0; i < b.N; i++ {
+                    if _, err := encodeCart(cart, encoding); err != nil {
+                        b.Fatal(err)
+                    }
+                }
+                b.ReportMetric(float64(len(data)), "bytes/cart")
+            })
+            b.Run(name+"/decode", func(b *testing.B) {
+                b.ReportAllocs()
+                for i := 0; i < b.N; i++ {
+                    if _, err := decodeCart(data); err != nil {
+                        b.Fatal(err)
+                    }
+                }
+                b.ReportMetric(float64(len(data)), "bytes/cart")
+            })
+        }
+    }
+}
+
+// BenchmarkRedisCartStoreLargeCart measures reads and updates of a large cart through the Redis store, whose
+// transfers grow with the encoded size.
+func BenchmarkRedisCartStoreLargeCart(b *testing.B) {
+    quietLogs(b)
+    cart := largeCart(1000)
+    for _, encoding := range encodings {
+        server := miniredis.RunT(b)
+        store := NewRedisCartStore(server.Addr(), "", DefaultCartTTL, encoding)
+        b.Cleanup(func() { store.rdb.Close() })
+        data, err := encodeCart(cart, encoding)
+        if err != nil {
+            b.Fatal(err)
+        }
+        if err := server.Set(cart.UserId, string(data)); err != nil {
+            b.Fatal(err)
+        }
+
+        b.Run(string(encoding)+"/GetCart", func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := store.GetCartAsync(cart.UserId); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+        b.Run(string(encoding)+"/SetItemQuantity", func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if err := store.SetItemQuantityAsync(cart.UserId, cart.Items[0].ProductId, int32(1+i%10)); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
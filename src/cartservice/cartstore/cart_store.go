@@ -1,12 +1,227 @@
 package cartstore
 
 import (
-    pb "main/genproto"
+    "math"
+    "math/rand"
+    "sort"
+    "time"
+
+    "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "cartservice/genproto"
+    "logging"
 )
 
+// DefaultCartTTL is how long a cart is kept after its last update. It matches the lifetime of the frontend's
+// session cookie, after which a cart can't be reached anymore.
+const DefaultCartTTL = 48 * time.Hour
+
+// DefaultMaxMergedQuantity caps the quantity of a line that results from merging two carts. It matches the largest
+// quantity the frontend lets a shopper add at once.
+const DefaultMaxMergedQuantity = 10
+
+// DefaultLimits are the cart limits of the service unless they're configured otherwise.
+var DefaultLimits = Limits{MaxItems: 50, MaxItemQuantity: 99, MaxTotalQuantity: 500}
+
+const (
+    // maxUpdateAttempts is how many times a cart update is tried before giving up because of concurrent updates.
+    maxUpdateAttempts = 32
+
+    // updateRetryBackoff and maxUpdateRetryBackoff bound the randomized exponential backoff between attempts.
+    updateRetryBackoff    = time.Millisecond
+    maxUpdateRetryBackoff = 128 * time.Millisecond
+)
+
+var log = logging.New("cartservice")
+
+// SetLogger makes the stores write their log entries through the given logger.
+func SetLogger(l *logrus.Entry) {
+    log = l
+}
+
 type CartStore interface {
     AddItemAsync(userId, productId string, quantity int32) error
     GetCartAsync(userId string) (*pb.Cart, error)
     EmptyCartAsync(userId string) error
+    RemoveItemAsync(userId, productId string) error
+    // SetItemQuantityAsync sets the quantity of a product in a cart. A quantity of zero or less removes the product.
+    SetItemQuantityAsync(userId, productId string, quantity int32) error
+    // ReplaceCartAsync replaces the items of a cart with the given ones, in their order, atomically. Items with a
+    // quantity of zero or less are left out, and the last of several items of a product wins.
+    ReplaceCartAsync(userId string, items []*pb.CartItem) error
+    // MergeCartsAsync moves the items of the source user's cart into the target user's cart atomically, and returns
+    // the merged cart. The quantities of a product found in both carts are summed, up to maxQuantity, or up to
+    // DefaultMaxMergedQuantity if maxQuantity is zero or less.
+    MergeCartsAsync(sourceUserId, targetUserId string, maxQuantity int32) (*pb.Cart, error)
+    // ListExpiringCartsAsync lists the carts that expire within the given duration, the ones closest to expiry
+    // first. A limit of zero or less means no limit.
+    ListExpiringCartsAsync(within time.Duration, limit int) ([]*pb.ExpiringCart, error)
+    // ScanCartsAsync calls fn with every cart of the store that has items, in no particular order, and stops at the
+    // first error fn returns, which it returns too. A cart updated during the scan is seen either before or after the
+    // update. It's meant for maintenance tasks such as exports, as it reads every cart.
+    ScanCartsAsync(fn func(cart *pb.Cart) error) error
+    // SetLimits bounds the carts of the store. It must be called before the store is used.
+    SetLimits(limits Limits)
     Ping() bool
 }
+
+// Limits bounds the size of a cart. A zero limit is no limit.
+type Limits struct {
+    // MaxItems is the number of different products a cart can hold.
+    MaxItems int
+    // MaxItemQuantity is the number of units of a product a cart can hold.
+    MaxItemQuantity int32
+    // MaxTotalQuantity is the number of units a cart can hold over all its products.
+    MaxTotalQuantity int32
+}
+
+// limiter gives a store its limits, and the SetLimits method of CartStore when it's embedded.
+type limiter struct {
+    limits Limits
+}
+
+func (l *limiter) SetLimits(limits Limits) {
+    l.limits = limits
+}
+
+// apply runs change on a cart and fails with its error, or with FailedPrecondition if the cart then exceeds a limit it
+// didn't exceed before. Carts stored before a limit was lowered can thus still shrink.
+func (l Limits) apply(cart *pb.Cart, change func() error) error {
+    items, total := len(cart.Items), int64(0)
+    quantities := make(map[string]int32, len(cart.Items))
+    for _, item := range cart.Items {
+        quantities[item.ProductId] = item.Quantity
+        total += int64(item.Quantity)
+    }
+    if err := change(); err != nil {
+        return err
+    }
+    if l.MaxItems > 0 && len(cart.Items) > l.MaxItems && len(cart.Items) > items {
+        return status.Errorf(codes.FailedPrecondition, "a cart can't hold more than %d different products", l.MaxItems)
+    }
+    newTotal := int64(0)
+    for _, item := range cart.Items {
+        newTotal += int64(item.Quantity)
+        if l.MaxItemQuantity > 0 && item.Quantity > l.MaxItemQuantity && item.Quantity > quantities[item.ProductId] {
+            return status.Errorf(codes.FailedPrecondition, "a cart can't hold more than %d units of product %s",
+                l.MaxItemQuantity, item.ProductId)
+        }
+    }
+    if l.MaxTotalQuantity > 0 && newTotal > int64(l.MaxTotalQuantity) && newTotal > total {
+        return status.Errorf(codes.FailedPrecondition, "a cart can't hold more than %d units in total",
+            l.MaxTotalQuantity)
+    }
+    return nil
+}
+
+// addItem adds a quantity of a product to a cart, or fails with InvalidArgument if the quantity of the product would
+// overflow.
+func addItem(cart *pb.Cart, productId string, quantity int32) error {
+    for _, item := range cart.Items {
+        if item.ProductId == productId {
+            if int64(item.Quantity)+int64(quantity) > math.MaxInt32 {
+                return status.Errorf(codes.InvalidArgument, "a cart can't hold more than %d units of product %s",
+                    math.MaxInt32, productId)
+            }
+            item.Quantity += quantity
+            return nil
+        }
+    }
+    cart.Items = append(cart.Items, &pb.CartItem{ProductId: productId, Quantity: quantity})
+    return nil
+}
+
+// setItemQuantity sets the quantity of a product in a cart, removing the product if the quantity isn't positive.
+func setItemQuantity(cart *pb.Cart, productId string, quantity int32) {
+    for i, item := range cart.Items {
+        if item.ProductId == productId {
+            if quantity <= 0 {
+                cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
+            } else {
+                item.Quantity = quantity
+            }
+            return
+        }
+    }
+    if quantity > 0 {
+        cart.Items = append(cart.Items, &pb.CartItem{ProductId: productId, Quantity: quantity})
+    }
+}
+
+// retryBackoff returns a random pause before retrying an update that lost a race with a concurrent update, so the
+// retries of concurrent callers spread out. Its range grows exponentially with the attempt, up to a limit.
+func retryBackoff(attempt int) time.Duration {
+    backoff := maxUpdateRetryBackoff
+    if attempt < 7 {
+        backoff = updateRetryBackoff << attempt
+    }
+    return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// unavailable wraps an error of a storage backend in the Unavailable status, unless it already carries a status.
+func unavailable(err error) error {
+    if _, ok := status.FromError(err); ok {
+        return err
+    }
+    return status.Errorf(codes.Unavailable, "can't access cart storage: %v", err)
+}
+
+// checkMergeUsers checks that a merge involves two different users.
+func checkMergeUsers(sourceUserId, targetUserId string) error {
+    if sourceUserId == targetUserId {
+        return status.Errorf(codes.InvalidArgument, "can't merge the cart of user %s into itself", targetUserId)
+    }
+    return nil
+}
+
+// mergeCarts moves the items of source into target, summing the quantities of the products found in both. A summed
+// quantity is capped at maxQuantity, or DefaultMaxMergedQuantity if it's zero or less, but a line already above the
+// cap keeps its quantity. The source cart is left without items.
+func mergeCarts(target, source *pb.Cart, maxQuantity int32) {
+    if maxQuantity <= 0 {
+        maxQuantity = DefaultMaxMergedQuantity
+    }
+    for _, item := range source.Items {
+        if item.Quantity <= 0 {
+            continue
+        }
+        var line *pb.CartItem
+        for _, t := range target.Items {
+            if t.ProductId == item.ProductId {
+                line = t
+                break
+            }
+        }
+        if line == nil {
+            line = &pb.CartItem{ProductId: item.ProductId}
+            target.Items = append(target.Items, line)
+        }
+        sum := min(int64(line.Quantity)+int64(item.Quantity), int64(maxQuantity))
+        line.Quantity = max(line.Quantity, int32(sum))
+    }
+    source.Items = nil
+}
+
+// replaceItems replaces the items of a cart as ReplaceCartAsync does.
+func replaceItems(cart *pb.Cart, items []*pb.CartItem) {
+    cart.Items = nil
+    for _, item := range items {
+        setItemQuantity(cart, item.ProductId, item.Quantity)
+    }
+}
+
+// sortExpiringCarts orders carts by expiry and applies a limit, as ListExpiringCartsAsync returns them.
+func sortExpiringCarts(carts []*pb.ExpiringCart, limit int) []*pb.ExpiringCart {
+    sort.Slice(carts, func(i, j int) bool {
+        if carts[i].ExpiresAt != carts[j].ExpiresAt {
+            return carts[i].ExpiresAt < carts[j].ExpiresAt
+        }
+        return carts[i].Cart.UserId < carts[j].Cart.UserId
+    })
+    if limit > 0 && len(carts) > limit {
+        carts = carts[:limit]
+    }
+    return carts
+}
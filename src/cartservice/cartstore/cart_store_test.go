@@ -0,0 +1,72 @@
+package cartstore
+
+import (
+    "testing"
+
+    "google.golang.org/protobuf/proto"
+
+    pb "cartservice/genproto"
+)
+
+func TestSetItemQuantity(t *testing.T) {
+    newCart := func() *pb.Cart {
+        return &pb.Cart{UserId: "user", Items: []*pb.CartItem{
+            {ProductId: "A", Quantity: 1},
+            {ProductId: "B", Quantity: 2},
+            {ProductId: "C", Quantity: 3},
+        }}
+    }
+    for _, tc := range []struct {
+        name      string
+        productId string
+        quantity  int32
+        want      []*pb.CartItem
+    }{
+        {"update", "B", 5, []*pb.CartItem{{ProductId: "A", Quantity: 1}, {ProductId: "B", Quantity: 5}, {ProductId: "C", Quantity: 3}}},
+        {"add", "D", 4, []*pb.CartItem{{ProductId: "A", Quantity: 1}, {ProductId: "B", Quantity: 2}, {ProductId: "C", Quantity: 3}, {ProductId: "D", Quantity: 4}}},
+        {"zero removes", "B", 0, []*pb.CartItem{{ProductId: "A", Quantity: 1}, {ProductId: "C", Quantity: 3}}},
+        {"negative removes", "C", -1, []*pb.CartItem{{ProductId: "A", Quantity: 1}, {ProductId: "B", Quantity: 2}}},
+        {"remove missing", "D", 0, []*pb.CartItem{{ProductId: "A", Quantity: 1}, {ProductId: "B", Quantity: 2}, {ProductId: "C", Quantity: 3}}},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            cart := newCart()
+            setItemQuantity(cart, tc.productId, tc.quantity)
+            if want := (&pb.Cart{UserId: "user", Items: tc.want}); !proto.Equal(cart, want) {
+                t.Errorf("cart = %v, want %v", cart, want)
+            }
+        })
+    }
+}
+
+func TestMergeCarts(t *testing.T) {
+    for _, tc := range []struct {
+        name   string
+        target []*pb.CartItem
+        source []*pb.CartItem
+        max    int32
+        want   []*pb.CartItem
+    }{
+        {"into empty", nil, []*pb.CartItem{{ProductId: "A", Quantity: 2}}, 10, []*pb.CartItem{{ProductId: "A", Quantity: 2}}},
+        {"from empty", []*pb.CartItem{{ProductId: "A", Quantity: 2}}, nil, 10, []*pb.CartItem{{ProductId: "A", Quantity: 2}}},
+        {"sum", []*pb.CartItem{{ProductId: "A", Quantity: 2}, {ProductId: "B", Quantity: 1}}, []*pb.CartItem{{ProductId: "B", Quantity: 3}, {ProductId: "C", Quantity: 4}},
+            10, []*pb.CartItem{{ProductId: "A", Quantity: 2}, {ProductId: "B", Quantity: 4}, {ProductId: "C", Quantity: 4}}},
+        {"cap", []*pb.CartItem{{ProductId: "A", Quantity: 7}}, []*pb.CartItem{{ProductId: "A", Quantity: 6}, {ProductId: "B", Quantity: 12}},
+            10, []*pb.CartItem{{ProductId: "A", Quantity: 10}, {ProductId: "B", Quantity: 10}}},
+        {"above cap kept", []*pb.CartItem{{ProductId: "A", Quantity: 15}}, []*pb.CartItem{{ProductId: "A", Quantity: 1}}, 10, []*pb.CartItem{{ProductId: "A", Quantity: 15}}},
+        {"no overflow", []*pb.CartItem{{ProductId: "A", Quantity: 1 << 30}}, []*pb.CartItem{{ProductId: "A", Quantity: 1 << 30}},
+            1<<31 - 1, []*pb.CartItem{{ProductId: "A", Quantity: 1<<31 - 1}}},
+        {"skip empty lines", nil, []*pb.CartItem{{ProductId: "A", Quantity: 0}}, 10, nil},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            target := &pb.Cart{UserId: "target", Items: tc.target}
+            source := &pb.Cart{UserId: "source", Items: tc.source}
+            mergeCarts(target, source, tc.max)
+            if want := (&pb.Cart{UserId: "target", Items: tc.want}); !proto.Equal(target, want) {
+                t.Errorf("merged cart = %v, want %v", target, want)
+            }
+            if len(source.Items) != 0 {
+                t.Errorf("source cart = %v, want it emptied", source)
+            }
+        })
+    }
+}
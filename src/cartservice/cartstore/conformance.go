@@ -0,0 +1,448 @@
+package cartstore
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "sync"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+
+    pb "cartservice/genproto"
+)
+
+// ConformanceSuite checks that a CartStore implementation behaves like the others: how quantities add up and merge,
+// how missing and emptied carts read, what concurrent updates leave behind, and which status codes errors carry. A new
+// implementation should pass it before it's wired into main:
+//
+//	func TestMyCartStore(t *testing.T) {
+//	    cartstore.ConformanceSuite{NewStore: newTestStore}.Run(t)
+//	}
+type ConformanceSuite struct {
+    // NewStore returns an empty store whose carts expire after DefaultCartTTL. It's called once per test.
+    NewStore func(t *testing.T) CartStore
+
+    // NewBrokenStore, if set, returns a store whose backend can't be reached, e.g. because its server is down. Every
+    // call to it must then fail with the Unavailable status code.
+    NewBrokenStore func(t *testing.T) CartStore
+}
+
+// Run runs the suite, each check in a subtest of its own.
+func (s ConformanceSuite) Run(t *testing.T) {
+    for _, test := range []struct {
+        name string
+        run  func(t *testing.T, store CartStore)
+    }{
+        {"MissingCart", testMissingCart},
+        {"AddItem", testAddItem},
+        {"EmptyCart", testEmptyCart},
+        {"LineUpdates", testLineUpdates},
+        {"ReplaceCart", testReplaceCart},
+        {"MergeCarts", testMergeCarts},
+        {"MergeErrors", testMergeErrors},
+        {"Limits", testLimits},
+        {"QuantityOverflow", testQuantityOverflow},
+        {"Isolation", testIsolation},
+        {"ListExpiringCarts", testListExpiringCarts},
+        {"ScanCarts", testScanCarts},
+        {"ConcurrentAddItem", testConcurrentAddItem},
+        {"ConcurrentMergeCarts", testConcurrentMergeCarts},
+    } {
+        t.Run(test.name, func(t *testing.T) {
+            test.run(t, s.NewStore(t))
+        })
+    }
+    if s.NewBrokenStore != nil {
+        t.Run("Unavailable", func(t *testing.T) {
+            testUnavailable(t, s.NewBrokenStore(t))
+        })
+    }
+}
+
+// checkCart fails the test if the cart of a user isn't the expected one, lines in order.
+func checkCart(t *testing.T, store CartStore, userId string, items ...*pb.CartItem) {
+    t.Helper()
+    cart, err := store.GetCartAsync(userId)
+    if err != nil {
+        t.Fatalf("GetCartAsync(%q) failed: %v", userId, err)
+    }
+    if want := (&pb.Cart{UserId: userId, Items: items}); !proto.Equal(cart, want) {
+        t.Errorf("cart of %s = %v, want %v", userId, cart, want)
+    }
+}
+
+// mustRun runs the steps of a test in order, and stops the test at the first error.
+func mustRun(t *testing.T, steps ...func() error) {
+    t.Helper()
+    for i, step := range steps {
+        if err := step(); err != nil {
+            t.Fatalf("step %d failed: %v", i+1, err)
+        }
+    }
+}
+
+func testMissingCart(t *testing.T, store CartStore) {
+    // A user without a cart has an empty one, with its user id set.
+    checkCart(t, store, "nobody")
+    mustRun(t,
+        func() error { return store.EmptyCartAsync("nobody") },
+        func() error { return store.RemoveItemAsync("nobody", "A") },
+    )
+    checkCart(t, store, "nobody")
+}
+
+func testAddItem(t *testing.T, store CartStore) {
+    mustRun(t,
+        func() error { return store.AddItemAsync("user", "B", 2) },
+        func() error { return store.AddItemAsync("user", "A", 1) },
+        func() error { return store.AddItemAsync("user", "B", 3) },
+    )
+    // Quantities of the same product add up, and lines keep the order in which they were added.
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "B", Quantity: 5}, &pb.CartItem{ProductId: "A", Quantity: 1})
+}
+
+func testEmptyCart(t *testing.T, store CartStore) {
+    mustRun(t,
+        func() error { return store.AddItemAsync("user", "A", 2) },
+        func() error { return store.AddItemAsync("other", "A", 1) },
+        func() error { return store.EmptyCartAsync("user") },
+    )
+    checkCart(t, store, "user")
+    checkCart(t, store, "other", &pb.CartItem{ProductId: "A", Quantity: 1})
+
+    // An emptied cart starts over.
+    mustRun(t, func() error { return store.AddItemAsync("user", "B", 1) })
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "B", Quantity: 1})
+}
+
+func testLineUpdates(t *testing.T, store CartStore) {
+    mustRun(t,
+        func() error { return store.AddItemAsync("user", "A", 1) },
+        func() error { return store.AddItemAsync("user", "B", 2) },
+        func() error { return store.SetItemQuantityAsync("user", "A", 7) },
+        func() error { return store.RemoveItemAsync("user", "B") },
+        func() error { return store.SetItemQuantityAsync("user", "C", 0) },
+    )
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "A", Quantity: 7})
+
+    // Setting a quantity adds the line if it's missing, and a quantity that isn't positive removes it.
+    mustRun(t,
+        func() error { return store.SetItemQuantityAsync("user", "D", 4) },
+        func() error { return store.SetItemQuantityAsync("user", "A", -3) },
+    )
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "D", Quantity: 4})
+    mustRun(t, func() error { return store.RemoveItemAsync("user", "D") })
+    checkCart(t, store, "user")
+}
+
+func testReplaceCart(t *testing.T, store CartStore) {
+    mustRun(t,
+        func() error { return store.AddItemAsync("user", "A", 1) },
+        func() error { return store.AddItemAsync("user", "B", 2) },
+        func() error {
+            return store.ReplaceCartAsync("user", []*pb.CartItem{
+                {ProductId: "C", Quantity: 3},
+                {ProductId: "A", Quantity: 0},
+                {ProductId: "B", Quantity: 5},
+                {ProductId: "C", Quantity: 1},
+            })
+        },
+    )
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "C", Quantity: 1}, &pb.CartItem{ProductId: "B", Quantity: 5})
+
+    // Replacing a cart over a limit fails and leaves it as it was.
+    store.SetLimits(Limits{MaxItems: 1})
+    if err := store.ReplaceCartAsync("user", []*pb.CartItem{{ProductId: "A", Quantity: 1}, {ProductId: "B", Quantity: 1}, {ProductId: "C", Quantity: 1}}); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("replacing a cart over the limits returned %v, want FailedPrecondition", err)
+    }
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "C", Quantity: 1}, &pb.CartItem{ProductId: "B", Quantity: 5})
+
+    mustRun(t, func() error { return store.ReplaceCartAsync("user", nil) })
+    checkCart(t, store, "user")
+}
+
+func testMergeCarts(t *testing.T, store CartStore) {
+    mustRun(t,
+        func() error { return store.AddItemAsync("anonymous", "A", 4) },
+        func() error { return store.AddItemAsync("anonymous", "B", 1) },
+        func() error { return store.AddItemAsync("user", "A", 8) },
+    )
+    merged, err := store.MergeCartsAsync("anonymous", "user", DefaultMaxMergedQuantity)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Quantities are summed up to the cap, and the source cart is gone.
+    want := []*pb.CartItem{{ProductId: "A", Quantity: 10}, {ProductId: "B", Quantity: 1}}
+    if !proto.Equal(merged, &pb.Cart{UserId: "user", Items: want}) {
+        t.Errorf("merged cart = %v, want %v", merged, want)
+    }
+    checkCart(t, store, "user", want...)
+    checkCart(t, store, "anonymous")
+
+    // Merging a missing cart changes nothing, and merging into a missing cart moves the source cart.
+    if merged, err := store.MergeCartsAsync("nobody", "user", DefaultMaxMergedQuantity); err != nil || len(merged.Items) != 2 {
+        t.Errorf("merging a missing cart = %v, %v, want the target cart", merged, err)
+    }
+    if _, err := store.MergeCartsAsync("user", "new-user", DefaultMaxMergedQuantity); err != nil {
+        t.Fatal(err)
+    }
+    checkCart(t, store, "new-user", want...)
+    checkCart(t, store, "user")
+
+    // Without a cap, the default one applies, rather than a cap of zero.
+    mustRun(t,
+        func() error { return store.AddItemAsync("source", "A", 7) },
+        func() error { return store.AddItemAsync("source", "B", 2) },
+        func() error { return store.AddItemAsync("target", "A", 6) },
+    )
+    if merged, err := store.MergeCartsAsync("source", "target", 0); err != nil || len(merged.Items) != 2 {
+        t.Errorf("merging without a cap returned %v, %v", merged, err)
+    }
+    checkCart(t, store, "target",
+        &pb.CartItem{ProductId: "A", Quantity: DefaultMaxMergedQuantity}, &pb.CartItem{ProductId: "B", Quantity: 2})
+}
+
+func testMergeErrors(t *testing.T, store CartStore) {
+    mustRun(t, func() error { return store.AddItemAsync("user", "A", 1) })
+    if _, err := store.MergeCartsAsync("user", "user", DefaultMaxMergedQuantity); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("merging a cart into itself returned %v, want InvalidArgument", err)
+    }
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "A", Quantity: 1})
+}
+
+func testLimits(t *testing.T, store CartStore) {
+    store.SetLimits(Limits{MaxItems: 2, MaxItemQuantity: 5, MaxTotalQuantity: 8})
+    mustRun(t,
+        func() error { return store.AddItemAsync("user", "A", 3) },
+        func() error { return store.AddItemAsync("user", "B", 4) },
+    )
+    // An update that takes the cart over a limit fails and leaves the cart as it was.
+    for name, update := range map[string]func() error{
+        "too many products":   func() error { return store.AddItemAsync("user", "C", 1) },
+        "too many units of A": func() error { return store.AddItemAsync("user", "A", 3) },
+        "too many units":      func() error { return store.SetItemQuantityAsync("user", "A", 5) },
+        "merging too much": func() error {
+            if err := store.AddItemAsync("anonymous", "C", 1); err != nil {
+                return err
+            }
+            _, err := store.MergeCartsAsync("anonymous", "user", DefaultMaxMergedQuantity)
+            return err
+        },
+    } {
+        if err := update(); status.Code(err) != codes.FailedPrecondition {
+            t.Errorf("%s: got %v, want FailedPrecondition", name, err)
+        }
+    }
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "A", Quantity: 3}, &pb.CartItem{ProductId: "B", Quantity: 4})
+    checkCart(t, store, "anonymous", &pb.CartItem{ProductId: "C", Quantity: 1})
+
+    // A cart over a limit that was lowered can still shrink.
+    store.SetLimits(Limits{MaxItems: 1, MaxTotalQuantity: 4})
+    mustRun(t,
+        func() error { return store.SetItemQuantityAsync("user", "B", 2) },
+        func() error { return store.RemoveItemAsync("user", "B") },
+        func() error { return store.SetItemQuantityAsync("user", "A", 4) },
+    )
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "A", Quantity: 4})
+}
+
+func testQuantityOverflow(t *testing.T, store CartStore) {
+    // Without limits, a quantity still can't grow past what a line holds.
+    mustRun(t, func() error { return store.AddItemAsync("user", "A", math.MaxInt32-1) })
+    if err := store.AddItemAsync("user", "A", 2); status.Code(err) != codes.InvalidArgument {
+        t.Errorf("overflowing the quantity of A returned %v, want InvalidArgument", err)
+    }
+    mustRun(t, func() error { return store.AddItemAsync("user", "A", 1) })
+    checkCart(t, store, "user", &pb.CartItem{ProductId: "A", Quantity: math.MaxInt32})
+}
+
+func testIsolation(t *testing.T, store CartStore) {
+    mustRun(t,
+        func() error { return store.AddItemAsync("alice", "A", 1) },
+        func() error { return store.AddItemAsync("bob", "A", 2) },
+    )
+    // Callers own the carts they get: changing one must not change the store.
+    cart, err := store.GetCartAsync("alice")
+    if err != nil {
+        t.Fatal(err)
+    }
+    cart.Items[0].Quantity = 100
+    cart.Items = append(cart.Items, &pb.CartItem{ProductId: "B", Quantity: 1})
+
+    checkCart(t, store, "alice", &pb.CartItem{ProductId: "A", Quantity: 1})
+    checkCart(t, store, "bob", &pb.CartItem{ProductId: "A", Quantity: 2})
+}
+
+func testListExpiringCarts(t *testing.T, store CartStore) {
+    mustRun(t,
+        func() error { return store.AddItemAsync("alice", "A", 1) },
+        func() error { return store.AddItemAsync("bob", "A", 1) },
+    )
+    if carts, err := store.ListExpiringCartsAsync(time.Hour, 0); err != nil || len(carts) != 0 {
+        t.Errorf("carts expiring within an hour = %v, %v, want none", carts, err)
+    }
+    carts, err := store.ListExpiringCartsAsync(DefaultCartTTL+time.Hour, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(carts) != 2 {
+        t.Fatalf("carts expiring within the TTL = %v, want alice's and bob's", carts)
+    }
+    deadline := time.Now().Add(DefaultCartTTL + time.Minute).Unix()
+    for _, c := range carts {
+        if len(c.Cart.Items) != 1 || c.ExpiresAt > deadline || c.ExpiresAt < deadline-int64((time.Hour).Seconds()) {
+            t.Errorf("expiring cart = %v, want one line and an expiry in about %v", c, DefaultCartTTL)
+        }
+    }
+    if carts, err := store.ListExpiringCartsAsync(DefaultCartTTL+time.Hour, 1); err != nil || len(carts) != 1 {
+        t.Errorf("carts with a limit of 1 = %v, %v, want one", carts, err)
+    }
+}
+
+// concurrentWorkers and concurrentUpdates set the load of the concurrency checks.
+const (
+    concurrentWorkers = 16
+    concurrentUpdates = 25
+)
+
+func testScanCarts(t *testing.T, store CartStore) {
+    // More carts than a store reads at once, so that scans go over several batches.
+    const users = scanBatchSize + scanBatchSize/2
+    for i := 0; i < users; i++ {
+        user := fmt.Sprintf("user-%03d", i)
+        mustRun(t,
+            func() error { return store.AddItemAsync(user, "A", int32(1+i%5)) },
+            func() error { return store.AddItemAsync(user, "B", 1) },
+        )
+    }
+    mustRun(t,
+        func() error { return store.AddItemAsync("emptied", "A", 1) },
+        func() error { return store.EmptyCartAsync("emptied") },
+    )
+
+    seen := make(map[string]*pb.Cart)
+    err := store.ScanCartsAsync(func(cart *pb.Cart) error {
+        if _, ok := seen[cart.UserId]; ok {
+            t.Errorf("cart of %s scanned twice", cart.UserId)
+        }
+        seen[cart.UserId] = cart
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(seen) != users {
+        t.Errorf("scanned %d carts, want %d", len(seen), users)
+    }
+    for i := 0; i < users; i++ {
+        user := fmt.Sprintf("user-%03d", i)
+        want := &pb.Cart{UserId: user, Items: []*pb.CartItem{{ProductId: "A", Quantity: int32(1 + i%5)}, {ProductId: "B", Quantity: 1}}}
+        if !proto.Equal(seen[user], want) {
+            t.Errorf("scanned cart of %s = %v, want %v", user, seen[user], want)
+        }
+    }
+
+    // The scan stops at the first error of the callback.
+    stop, calls := errors.New("stop"), 0
+    if err := store.ScanCartsAsync(func(*pb.Cart) error { calls++; return stop }); err != stop || calls != 1 {
+        t.Errorf("scan returned %v after %d calls, want the callback's error after 1", err, calls)
+    }
+}
+
+func testConcurrentAddItem(t *testing.T, store CartStore) {
+    products := []string{"OLJCESPC7Z", "66VCHSJNUP", "1YMWWN1N4O", "L9ECAV7KIM"}
+
+    var wg sync.WaitGroup
+    errs := make(chan error, concurrentWorkers*concurrentUpdates)
+    for w := 0; w < concurrentWorkers; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for i := 0; i < concurrentUpdates; i++ {
+                if err := store.AddItemAsync("user", products[(w+i)%len(products)], 1); err != nil {
+                    errs <- err
+                }
+            }
+        }(w)
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Errorf("AddItemAsync failed: %v", err)
+    }
+
+    cart, err := store.GetCartAsync("user")
+    if err != nil {
+        t.Fatal(err)
+    }
+    total := int32(0)
+    for _, item := range cart.Items {
+        total += item.Quantity
+    }
+    if len(cart.Items) != len(products) || total != concurrentWorkers*concurrentUpdates {
+        t.Errorf("cart has %d lines and %d units, want %d and %d: updates were lost",
+            len(cart.Items), total, len(products), concurrentWorkers*concurrentUpdates)
+    }
+}
+
+func testConcurrentMergeCarts(t *testing.T, store CartStore) {
+    const sources = 20
+    for i := 0; i < sources; i++ {
+        if err := store.AddItemAsync(fmt.Sprintf("session-%d", i), "C", 1); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    // Merge many carts into one at the same time, while other merges target the source carts: no unit may be lost or
+    // duplicated.
+    var wg sync.WaitGroup
+    errs := make(chan error, 2*sources)
+    for i := 0; i < sources; i++ {
+        wg.Add(2)
+        go func(i int) {
+            defer wg.Done()
+            if _, err := store.MergeCartsAsync(fmt.Sprintf("session-%d", i), "shopper", 1000); err != nil {
+                errs <- err
+            }
+        }(i)
+        go func(i int) {
+            defer wg.Done()
+            if _, err := store.MergeCartsAsync("other", fmt.Sprintf("session-%d", i), 1000); err != nil {
+                errs <- err
+            }
+        }(i)
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Errorf("MergeCartsAsync failed: %v", err)
+    }
+    checkCart(t, store, "shopper", &pb.CartItem{ProductId: "C", Quantity: sources})
+}
+
+func testUnavailable(t *testing.T, store CartStore) {
+    if store.Ping() {
+        t.Error("Ping succeeded on a broken store")
+    }
+    for name, call := range map[string]func() error{
+        "AddItemAsync":         func() error { return store.AddItemAsync("user", "A", 1) },
+        "GetCartAsync":         func() error { _, err := store.GetCartAsync("user"); return err },
+        "EmptyCartAsync":       func() error { return store.EmptyCartAsync("user") },
+        "RemoveItemAsync":      func() error { return store.RemoveItemAsync("user", "A") },
+        "SetItemQuantityAsync": func() error { return store.SetItemQuantityAsync("user", "A", 2) },
+        "MergeCartsAsync": func() error {
+            _, err := store.MergeCartsAsync("anonymous", "user", DefaultMaxMergedQuantity)
+            return err
+        },
+        "ListExpiringCartsAsync": func() error { _, err := store.ListExpiringCartsAsync(time.Hour, 0); return err },
+        "ScanCartsAsync":         func() error { return store.ScanCartsAsync(func(*pb.Cart) error { return nil }) },
+    } {
+        if err := call(); status.Code(err) != codes.Unavailable {
+            t.Errorf("%s returned %v, want Unavailable", name, err)
+        }
+    }
+}
@@ -0,0 +1,531 @@
+package cartstore
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/config"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "cartservice/genproto"
+)
+
+// Attributes of a cart item in the DynamoDB table. The table's partition key is user_id, a string, and its TTL
+// attribute should be set to expires_at.
+const (
+    dynamoUserIdAttr    = "user_id"
+    dynamoItemsAttr     = "items"
+    dynamoVersionAttr   = "version"
+    dynamoExpiresAtAttr = "expires_at"
+    // dynamoTotalQuantityAttr is the number of units in the cart, which the limit on it is checked against when a line
+    // is added to in place.
+    dynamoTotalQuantityAttr = "total_quantity"
+
+    dynamoProductIdAttr = "product_id"
+    dynamoQuantityAttr  = "quantity"
+)
+
+// DynamoDBCartStore keeps each cart in an item of a DynamoDB table, with its lines in a list attribute. Every item has
+// a version number, and updates are conditional writes that only succeed if the version hasn't changed since the cart
+// was read; otherwise, the update is retried on a fresh copy, like in the Redis store. Adding to a line the cart
+// already has is a single UpdateItem instead, so concurrent additions don't conflict. Expiry uses the TTL feature of
+// DynamoDB. As DynamoDB deletes expired items lazily, the store also ignores the carts whose expiry has passed.
+type DynamoDBCartStore struct {
+    client *dynamodb.Client
+    table  string
+    ctx    context.Context
+    ttl    time.Duration
+    now    func() time.Time
+    limiter
+}
+
+// NewDynamoDBCartStore creates a store on a DynamoDB table, with the AWS configuration of the environment (e.g. the
+// role of a Lambda function). An endpoint, such as the one of DynamoDB Local, replaces the regional endpoint if it
+// isn't empty. Carts expire after ttl without updates; a ttl of zero disables expiry.
+func NewDynamoDBCartStore(table, endpoint string, ttl time.Duration) (*DynamoDBCartStore, error) {
+    log.Infof("Initializing DynamoDB CartStore with table %s", table)
+    ctx := context.Background()
+    cfg, err := config.LoadDefaultConfig(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load the AWS configuration: %w", err)
+    }
+    client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+        if endpoint != "" {
+            o.BaseEndpoint = aws.String(endpoint)
+        }
+    })
+    return &DynamoDBCartStore{client: client, table: table, ctx: ctx, ttl: ttl, now: time.Now}, nil
+}
+
+// dynamoCart is a cart read from the table, with the version its conditional write must match. The version is zero
+// if the table has no item for the cart.
+type dynamoCart struct {
+    cart    *pb.Cart
+    version int64
+}
+
+// readCart reads the cart of a user, or returns an empty cart if the user has none or if it has expired.
+func (store *DynamoDBCartStore) readCart(userId string) (*dynamoCart, error) {
+    out, err := store.client.GetItem(store.ctx, &dynamodb.GetItemInput{
+        TableName:      aws.String(store.table),
+        Key:            dynamoKey(userId),
+        ConsistentRead: aws.Bool(true),
+    })
+    if err != nil {
+        return nil, unavailable(err)
+    }
+    if out.Item == nil {
+        return &dynamoCart{cart: &pb.Cart{UserId: userId}}, nil
+    }
+    return decodeDynamoCart(userId, out.Item, store.now())
+}
+
+// decodeDynamoCart decodes the item of a cart. An expired cart is decoded as empty, with the version of its item.
+func decodeDynamoCart(userId string, item map[string]types.AttributeValue, now time.Time) (*dynamoCart, error) {
+    c := &dynamoCart{cart: &pb.Cart{UserId: userId}}
+    var err error
+    if c.version, err = dynamoNumber(item[dynamoVersionAttr]); err != nil {
+        return nil, status.Errorf(codes.Internal, "error parsing cart: %v", err)
+    }
+    if v, ok := item[dynamoExpiresAtAttr]; ok {
+        expiresAt, err := dynamoNumber(v)
+        if err != nil {
+            return nil, status.Errorf(codes.Internal, "error parsing cart: %v", err)
+        }
+        if now.Unix() >= expiresAt {
+            return c, nil
+        }
+    }
+    lines, _ := item[dynamoItemsAttr].(*types.AttributeValueMemberL)
+    if lines == nil {
+        return c, nil
+    }
+    for _, line := range lines.Value {
+        m, ok := line.(*types.AttributeValueMemberM)
+        if !ok {
+            return nil, status.Errorf(codes.Internal, "error parsing cart: line is a %T", line)
+        }
+        productId, _ := m.Value[dynamoProductIdAttr].(*types.AttributeValueMemberS)
+        quantity, err := dynamoNumber(m.Value[dynamoQuantityAttr])
+        if productId == nil || err != nil {
+            return nil, status.Errorf(codes.Internal, "error parsing cart: invalid line %v", m.Value)
+        }
+        c.cart.Items = append(c.cart.Items, &pb.CartItem{ProductId: productId.Value, Quantity: int32(quantity)})
+    }
+    return c, nil
+}
+
+// write returns the conditional write that replaces the item of a cart read at the given version, or deletes it if
+// the cart has no items.
+func (store *DynamoDBCartStore) write(c *dynamoCart) types.TransactWriteItem {
+    condition := aws.String("attribute_not_exists(user_id)")
+    values := map[string]types.AttributeValue{}
+    if c.version > 0 {
+        condition = aws.String("version = :version")
+        values[":version"] = dynamoInt(c.version)
+    }
+
+    if len(c.cart.Items) == 0 {
+        if c.version == 0 {
+            // Nothing to delete; the condition still fails if a concurrent update created the cart.
+            return types.TransactWriteItem{ConditionCheck: &types.ConditionCheck{
+                TableName:           aws.String(store.table),
+                Key:                 dynamoKey(c.cart.UserId),
+                ConditionExpression: condition,
+            }}
+        }
+        return types.TransactWriteItem{Delete: &types.Delete{
+            TableName:                 aws.String(store.table),
+            Key:                       dynamoKey(c.cart.UserId),
+            ConditionExpression:       condition,
+            ExpressionAttributeValues: values,
+        }}
+    }
+
+    lines := make([]types.AttributeValue, len(c.cart.Items))
+    total := int64(0)
+    for i, item := range c.cart.Items {
+        lines[i] = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+            dynamoProductIdAttr: &types.AttributeValueMemberS{Value: item.ProductId},
+            dynamoQuantityAttr:  dynamoInt(int64(item.Quantity)),
+        }}
+        total += int64(item.Quantity)
+    }
+    item := map[string]types.AttributeValue{
+        dynamoUserIdAttr:        &types.AttributeValueMemberS{Value: c.cart.UserId},
+        dynamoItemsAttr:         &types.AttributeValueMemberL{Value: lines},
+        dynamoVersionAttr:       dynamoInt(c.version + 1),
+        dynamoTotalQuantityAttr: dynamoInt(total),
+    }
+    if store.ttl > 0 {
+        item[dynamoExpiresAtAttr] = dynamoInt(store.now().Add(store.ttl).Unix())
+    }
+    put := &types.Put{
+        TableName:           aws.String(store.table),
+        Item:                item,
+        ConditionExpression: condition,
+    }
+    if len(values) > 0 {
+        put.ExpressionAttributeValues = values
+    }
+    return types.TransactWriteItem{Put: put}
+}
+
+// updateCart applies a read-modify-write update to the cart of a user with a conditional write, like updateCarts.
+func (store *DynamoDBCartStore) updateCart(userId string, update func(cart *pb.Cart) error) error {
+    return store.updateCarts([]string{userId}, func(carts []*pb.Cart) error {
+        return update(carts[0])
+    })
+}
+
+// updateCarts applies a read-modify-write update to the carts of several users atomically. The new carts are written
+// with writes that are conditional on the versions that were read, in a single transaction if there are several, and
+// the update is retried on fresh copies if a concurrent update got in first. A cart left without items is deleted.
+func (store *DynamoDBCartStore) updateCarts(userIds []string, update func(carts []*pb.Cart) error) error {
+    for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+        read := make([]*dynamoCart, len(userIds))
+        carts := make([]*pb.Cart, len(userIds))
+        for i, userId := range userIds {
+            c, err := store.readCart(userId)
+            if err != nil {
+                return err
+            }
+            read[i], carts[i] = c, c.cart
+        }
+        if err := update(carts); err != nil {
+            return err
+        }
+
+        writes := make([]types.TransactWriteItem, len(read))
+        for i, c := range read {
+            writes[i] = store.write(c)
+        }
+        err := store.commit(writes)
+        if !isDynamoConflict(err) {
+            if err != nil {
+                return unavailable(err)
+            }
+            return nil
+        }
+        // Another update won the race; back off a little so the retries of concurrent callers spread out.
+        time.Sleep(retryBackoff(attempt))
+    }
+    return status.Errorf(codes.Aborted, "cart of user %s is being updated concurrently, try again", strings.Join(userIds, ", "))
+}
+
+// commit performs the writes of an update: a single write on its own, which is cheaper, and several in a transaction.
+func (store *DynamoDBCartStore) commit(writes []types.TransactWriteItem) error {
+    if len(writes) > 1 {
+        _, err := store.client.TransactWriteItems(store.ctx, &dynamodb.TransactWriteItemsInput{TransactItems: writes})
+        return err
+    }
+    switch w := writes[0]; {
+    case w.Put != nil:
+        _, err := store.client.PutItem(store.ctx, &dynamodb.PutItemInput{
+            TableName:                 w.Put.TableName,
+            Item:                      w.Put.Item,
+            ConditionExpression:       w.Put.ConditionExpression,
+            ExpressionAttributeValues: w.Put.ExpressionAttributeValues,
+        })
+        return err
+    case w.Delete != nil:
+        _, err := store.client.DeleteItem(store.ctx, &dynamodb.DeleteItemInput{
+            TableName:                 w.Delete.TableName,
+            Key:                       w.Delete.Key,
+            ConditionExpression:       w.Delete.ConditionExpression,
+            ExpressionAttributeValues: w.Delete.ExpressionAttributeValues,
+        })
+        return err
+    default:
+        // The cart was empty and stays empty: there's nothing to write.
+        return nil
+    }
+}
+
+// isDynamoConflict reports whether a write failed because a concurrent update changed a cart.
+func isDynamoConflict(err error) bool {
+    var conditionFailed *types.ConditionalCheckFailedException
+    if errors.As(err, &conditionFailed) {
+        return true
+    }
+    var canceled *types.TransactionCanceledException
+    if errors.As(err, &canceled) {
+        for _, reason := range canceled.CancellationReasons {
+            if code := aws.ToString(reason.Code); code == "ConditionalCheckFailed" || code == "TransactionConflict" {
+                return true
+            }
+        }
+    }
+    var conflict *types.TransactionConflictException
+    return errors.As(err, &conflict)
+}
+
+// AddItemAsync adds to the line of the product in a single UpdateItem if the cart has one, and within the limits. A new
+// line, or one that would exceed a limit, takes a read-modify-write update.
+func (store *DynamoDBCartStore) AddItemAsync(userId, productId string, quantity int32) error {
+    log.Infof("AddItemAsync called with userId=%s, productId=%s, quantity=%d", userId, productId, quantity)
+
+    if quantity > 0 {
+        c, err := store.readCart(userId)
+        if err != nil {
+            return err
+        }
+        for i, item := range c.cart.Items {
+            if item.ProductId != productId {
+                continue
+            }
+            added, err := store.addToLine(userId, i, productId, quantity)
+            if err != nil || added {
+                return err
+            }
+            break
+        }
+    }
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error { return addItem(cart, productId, quantity) })
+    })
+}
+
+// addToLine adds a quantity to the line at an index of a cart, on the condition that the line is still the one of the
+// product, that the cart hasn't expired and that the quantities stay within the limits, and reports whether the
+// condition held. The update increases the version, so the read-modify-write updates it raced with are retried.
+func (store *DynamoDBCartStore) addToLine(userId string, index int, productId string, quantity int32) (bool, error) {
+    maxQuantity, maxTotal := int64(math.MaxInt32), int64(math.MaxInt64)
+    if store.limits.MaxItemQuantity > 0 {
+        maxQuantity = int64(store.limits.MaxItemQuantity)
+    }
+    if store.limits.MaxTotalQuantity > 0 {
+        maxTotal = int64(store.limits.MaxTotalQuantity)
+    }
+    line := fmt.Sprintf("#items[%d]", index)
+    names := map[string]string{
+        "#items":    dynamoItemsAttr,
+        "#product":  dynamoProductIdAttr,
+        "#quantity": dynamoQuantityAttr,
+        "#version":  dynamoVersionAttr,
+        "#total":    dynamoTotalQuantityAttr,
+        "#expires":  dynamoExpiresAtAttr,
+    }
+    values := map[string]types.AttributeValue{
+        ":product":      &types.AttributeValueMemberS{Value: productId},
+        ":quantity":     dynamoInt(int64(quantity)),
+        ":one":          dynamoInt(1),
+        ":max_quantity": dynamoInt(maxQuantity - int64(quantity)),
+        ":max_total":    dynamoInt(maxTotal - int64(quantity)),
+    }
+    condition := line + ".#product = :product AND " + line + ".#quantity <= :max_quantity AND #total <= :max_total"
+    update := "SET " + line + ".#quantity = " + line + ".#quantity + :quantity"
+    if store.ttl > 0 {
+        condition += " AND #expires > :now"
+        update += ", #expires = :expires"
+        values[":now"] = dynamoInt(store.now().Unix())
+        values[":expires"] = dynamoInt(store.now().Add(store.ttl).Unix())
+    } else {
+        // Without a TTL, the store writes no expiry; a cart that has one is left to read-modify-write updates.
+        condition += " AND attribute_not_exists(#expires)"
+    }
+    update += " ADD #version :one, #total :quantity"
+
+    _, err := store.client.UpdateItem(store.ctx, &dynamodb.UpdateItemInput{
+        TableName:                 aws.String(store.table),
+        Key:                       dynamoKey(userId),
+        UpdateExpression:          aws.String(update),
+        ConditionExpression:       aws.String(condition),
+        ExpressionAttributeNames:  names,
+        ExpressionAttributeValues: values,
+    })
+    if isDynamoConflict(err) {
+        return false, nil
+    }
+    if err != nil {
+        return false, unavailable(err)
+    }
+    return true, nil
+}
+
+func (store *DynamoDBCartStore) GetCartAsync(userId string) (*pb.Cart, error) {
+    log.Infof("GetCartAsync called with userId=%s", userId)
+
+    c, err := store.readCart(userId)
+    if err != nil {
+        return nil, err
+    }
+    return c.cart, nil
+}
+
+func (store *DynamoDBCartStore) EmptyCartAsync(userId string) error {
+    log.Infof("EmptyCartAsync called with userId=%s", userId)
+
+    _, err := store.client.DeleteItem(store.ctx, &dynamodb.DeleteItemInput{
+        TableName: aws.String(store.table),
+        Key:       dynamoKey(userId),
+    })
+    if err != nil {
+        return status.Errorf(codes.Unavailable, "failed to empty cart for user %s: %v", userId, err)
+    }
+    return nil
+}
+
+func (store *DynamoDBCartStore) RemoveItemAsync(userId, productId string) error {
+    log.Infof("RemoveItemAsync called with userId=%s, productId=%s", userId, productId)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        setItemQuantity(cart, productId, 0)
+        return nil
+    })
+}
+
+func (store *DynamoDBCartStore) SetItemQuantityAsync(userId, productId string, quantity int32) error {
+    log.Infof("SetItemQuantityAsync called with userId=%s, productId=%s, quantity=%d", userId, productId, quantity)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error {
+            setItemQuantity(cart, productId, quantity)
+            return nil
+        })
+    })
+}
+
+func (store *DynamoDBCartStore) ReplaceCartAsync(userId string, items []*pb.CartItem) error {
+    log.Infof("ReplaceCartAsync called with userId=%s, items=%d", userId, len(items))
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error {
+            replaceItems(cart, items)
+            return nil
+        })
+    })
+}
+
+func (store *DynamoDBCartStore) MergeCartsAsync(sourceUserId, targetUserId string, maxQuantity int32) (*pb.Cart, error) {
+    log.Infof("MergeCartsAsync called with sourceUserId=%s, targetUserId=%s, maxQuantity=%d", sourceUserId, targetUserId, maxQuantity)
+
+    if err := checkMergeUsers(sourceUserId, targetUserId); err != nil {
+        return nil, err
+    }
+    var merged *pb.Cart
+    err := store.updateCarts([]string{sourceUserId, targetUserId}, func(carts []*pb.Cart) error {
+        if err := store.limits.apply(carts[1], func() error {
+            mergeCarts(carts[1], carts[0], maxQuantity)
+            return nil
+        }); err != nil {
+            return err
+        }
+        merged = carts[1]
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return merged, nil
+}
+
+// ListExpiringCartsAsync scans the table for carts that expire within the given duration. It's meant for occasional
+// maintenance tasks, as it reads the whole table.
+func (store *DynamoDBCartStore) ListExpiringCartsAsync(within time.Duration, limit int) ([]*pb.ExpiringCart, error) {
+    log.Infof("ListExpiringCartsAsync called with within=%v, limit=%d", within, limit)
+
+    now := store.now()
+    pages := dynamodb.NewScanPaginator(store.client, &dynamodb.ScanInput{
+        TableName:        aws.String(store.table),
+        ConsistentRead:   aws.Bool(true),
+        FilterExpression: aws.String("expires_at BETWEEN :from AND :to"),
+        ExpressionAttributeValues: map[string]types.AttributeValue{
+            ":from": dynamoInt(now.Unix() + 1),
+            ":to":   dynamoInt(now.Add(within).Unix()),
+        },
+    })
+    var carts []*pb.ExpiringCart
+    for pages.HasMorePages() {
+        page, err := pages.NextPage(store.ctx)
+        if err != nil {
+            return nil, unavailable(err)
+        }
+        for _, item := range page.Items {
+            userId, _ := item[dynamoUserIdAttr].(*types.AttributeValueMemberS)
+            if userId == nil {
+                continue
+            }
+            c, err := decodeDynamoCart(userId.Value, item, now)
+            if err != nil {
+                return nil, err
+            }
+            expiresAt, _ := dynamoNumber(item[dynamoExpiresAtAttr])
+            if len(c.cart.Items) > 0 {
+                carts = append(carts, &pb.ExpiringCart{Cart: c.cart, ExpiresAt: expiresAt})
+            }
+        }
+    }
+    return sortExpiringCarts(carts, limit), nil
+}
+
+// ScanCartsAsync reads the whole table, a page at a time.
+func (store *DynamoDBCartStore) ScanCartsAsync(fn func(cart *pb.Cart) error) error {
+    log.Info("ScanCartsAsync called")
+
+    pages := dynamodb.NewScanPaginator(store.client, &dynamodb.ScanInput{
+        TableName:      aws.String(store.table),
+        ConsistentRead: aws.Bool(true),
+    })
+    for pages.HasMorePages() {
+        page, err := pages.NextPage(store.ctx)
+        if err != nil {
+            return unavailable(err)
+        }
+        now := store.now()
+        for _, item := range page.Items {
+            userId, _ := item[dynamoUserIdAttr].(*types.AttributeValueMemberS)
+            if userId == nil {
+                continue
+            }
+            c, err := decodeDynamoCart(userId.Value, item, now)
+            if err != nil {
+                return err
+            }
+            if len(c.cart.Items) == 0 { // expired, but not deleted by DynamoDB yet
+                continue
+            }
+            if err := fn(c.cart); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (store *DynamoDBCartStore) Ping() bool {
+    _, err := store.client.DescribeTable(store.ctx, &dynamodb.DescribeTableInput{TableName: aws.String(store.table)})
+    if err != nil {
+        log.Errorf("DynamoDB CartStore Ping failed: %v", err)
+        return false
+    }
+    return true
+}
+
+// dynamoKey returns the key of the item of a cart.
+func dynamoKey(userId string) map[string]types.AttributeValue {
+    return map[string]types.AttributeValue{dynamoUserIdAttr: &types.AttributeValueMemberS{Value: userId}}
+}
+
+// dynamoInt returns a number attribute.
+func dynamoInt(n int64) types.AttributeValue {
+    return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// dynamoNumber parses an integer number attribute.
+func dynamoNumber(v types.AttributeValue) (int64, error) {
+    n, ok := v.(*types.AttributeValueMemberN)
+    if !ok {
+        return 0, fmt.Errorf("expected a number, got %T", v)
+    }
+    return strconv.ParseInt(n.Value, 10, 64)
+}
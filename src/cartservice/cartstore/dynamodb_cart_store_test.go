@@ -0,0 +1,513 @@
+package cartstore
+
+import (
+    "encoding/json"
+    "fmt"
+    "hash/crc32"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+    "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+
+    pb "cartservice/genproto"
+)
+
+// fakeDynamoDB is a stand-in for DynamoDB that serves the JSON protocol of the operations the store uses, on a single
+// table keyed by user_id. It evaluates the condition and filter expressions the store writes, and nothing more.
+type fakeDynamoDB struct {
+    mu       sync.Mutex
+    table    string
+    items    map[string]map[string]any
+    pageSize int // the number of items a Scan call returns at most
+}
+
+// newTestDynamoDBCartStore returns a store on a table of a fake DynamoDB server or, if CART_TEST_DYNAMODB_ENDPOINT is
+// set, on a new table of that endpoint (e.g. DynamoDB Local).
+func newTestDynamoDBCartStore(t *testing.T, ttl time.Duration) *DynamoDBCartStore {
+    t.Helper()
+    quietLogs(t)
+    for key, value := range map[string]string{
+        "AWS_ACCESS_KEY_ID":           "test",
+        "AWS_SECRET_ACCESS_KEY":       "test",
+        "AWS_REGION":                  "us-east-1",
+        "AWS_CONFIG_FILE":             os.DevNull,
+        "AWS_SHARED_CREDENTIALS_FILE": os.DevNull,
+        "AWS_EC2_METADATA_DISABLED":   "true",
+    } {
+        t.Setenv(key, value)
+    }
+
+    table := "carts"
+    endpoint := os.Getenv("CART_TEST_DYNAMODB_ENDPOINT")
+    if endpoint == "" {
+        fake := &fakeDynamoDB{table: table, items: make(map[string]map[string]any), pageSize: 2}
+        server := httptest.NewServer(fake)
+        t.Cleanup(server.Close)
+        endpoint = server.URL
+    } else {
+        table = fmt.Sprintf("carts-%d", time.Now().UnixNano())
+    }
+
+    store, err := NewDynamoDBCartStore(table, endpoint, ttl)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if os.Getenv("CART_TEST_DYNAMODB_ENDPOINT") != "" {
+        _, err := store.client.CreateTable(store.ctx, &dynamodb.CreateTableInput{
+            TableName:            aws.String(table),
+            AttributeDefinitions: []types.AttributeDefinition{{AttributeName: aws.String(dynamoUserIdAttr), AttributeType: types.ScalarAttributeTypeS}},
+            KeySchema:            []types.KeySchemaElement{{AttributeName: aws.String(dynamoUserIdAttr), KeyType: types.KeyTypeHash}},
+            BillingMode:          types.BillingModePayPerRequest,
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(func() {
+            store.client.DeleteTable(store.ctx, &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+        })
+    }
+    if !store.Ping() {
+        t.Fatal("Ping failed")
+    }
+    return store
+}
+
+func TestDynamoDBCartStoreConformance(t *testing.T) {
+    ConformanceSuite{
+        NewStore: func(t *testing.T) CartStore {
+            return newTestDynamoDBCartStore(t, DefaultCartTTL)
+        },
+        NewBrokenStore: func(t *testing.T) CartStore {
+            // Fail right away instead of retrying the calls to the unreachable endpoint.
+            t.Setenv("AWS_MAX_ATTEMPTS", "1")
+            store := newTestDynamoDBCartStore(t, DefaultCartTTL)
+            store.client = dynamodb.New(store.client.Options(), func(o *dynamodb.Options) {
+                o.BaseEndpoint = aws.String("http://127.0.0.1:1")
+            })
+            return store
+        },
+    }.Run(t)
+}
+
+func TestDynamoDBCartStoreExpiry(t *testing.T) {
+    store := newTestDynamoDBCartStore(t, time.Hour)
+    clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+    store.now = clock.Now
+
+    for _, userId := range []string{"alice", "carol"} {
+        if err := store.AddItemAsync(userId, "OLJCESPC7Z", 1); err != nil {
+            t.Fatal(err)
+        }
+    }
+    clock.Advance(40 * time.Minute)
+    if err := store.AddItemAsync("bob", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+
+    // Scans return two items per page here, so listing the carts takes several pages.
+    carts, err := store.ListExpiringCartsAsync(30*time.Minute, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(carts) != 2 || carts[0].Cart.UserId != "alice" || carts[1].Cart.UserId != "carol" {
+        t.Errorf("expiring carts = %v, want alice's and carol's", carts)
+    }
+    if want := clock.Now().Add(20 * time.Minute).Unix(); len(carts) > 0 && carts[0].ExpiresAt != want {
+        t.Errorf("alice's cart expires at %d, want %d", carts[0].ExpiresAt, want)
+    }
+    if carts, _ := store.ListExpiringCartsAsync(2*time.Hour, 1); len(carts) != 1 || carts[0].Cart.UserId != "alice" {
+        t.Errorf("limited expiring carts = %v, want only alice's", carts)
+    }
+
+    // DynamoDB deletes expired items some time after they expire: until then, the store must ignore them.
+    clock.Advance(30 * time.Minute)
+    if cart, _ := store.GetCartAsync("alice"); len(cart.Items) != 0 {
+        t.Errorf("alice's cart = %v, want it expired", cart)
+    }
+    if err := store.AddItemAsync("alice", "66VCHSJNUP", 1); err != nil {
+        t.Fatal(err)
+    }
+    want := &pb.Cart{UserId: "alice", Items: []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}}}
+    if cart, _ := store.GetCartAsync("alice"); !proto.Equal(cart, want) {
+        t.Errorf("alice's cart = %v, want %v", cart, want)
+    }
+    if cart, _ := store.GetCartAsync("bob"); len(cart.Items) != 1 {
+        t.Errorf("bob's cart = %v, want it kept", cart)
+    }
+}
+
+func TestDynamoDBCartStoreAddToLine(t *testing.T) {
+    store := newTestDynamoDBCartStore(t, time.Hour)
+    store.SetLimits(Limits{MaxItemQuantity: 5, MaxTotalQuantity: 6})
+
+    // readItem returns the quantity of the first line, the total quantity and the version of alice's cart.
+    readItem := func() (quantity, total, version int64) {
+        t.Helper()
+        out, err := store.client.GetItem(store.ctx, &dynamodb.GetItemInput{
+            TableName: aws.String(store.table), Key: dynamoKey("alice"), ConsistentRead: aws.Bool(true),
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        c, err := decodeDynamoCart("alice", out.Item, store.now())
+        if err != nil || len(c.cart.Items) == 0 {
+            t.Fatalf("cart = %v, %v", c, err)
+        }
+        total, _ = dynamoNumber(out.Item[dynamoTotalQuantityAttr])
+        return int64(c.cart.Items[0].Quantity), total, c.version
+    }
+
+    // A cart stored without its total can't be added to in place, and is rewritten with it.
+    _, err := store.client.PutItem(store.ctx, &dynamodb.PutItemInput{
+        TableName: aws.String(store.table),
+        Item: map[string]types.AttributeValue{
+            dynamoUserIdAttr: &types.AttributeValueMemberS{Value: "alice"},
+            dynamoItemsAttr: &types.AttributeValueMemberL{Value: []types.AttributeValue{
+                &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+                    dynamoProductIdAttr: &types.AttributeValueMemberS{Value: "OLJCESPC7Z"},
+                    dynamoQuantityAttr:  dynamoInt(2),
+                }},
+            }},
+            dynamoVersionAttr:   dynamoInt(3),
+            dynamoExpiresAtAttr: dynamoInt(store.now().Add(time.Hour).Unix()),
+        },
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := store.AddItemAsync("alice", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+    if quantity, total, version := readItem(); quantity != 3 || total != 3 || version != 4 {
+        t.Errorf("quantity, total, version = %d, %d, %d, want 3, 3, 4", quantity, total, version)
+    }
+
+    if err := store.AddItemAsync("alice", "OLJCESPC7Z", 2); err != nil {
+        t.Fatal(err)
+    }
+    if quantity, total, version := readItem(); quantity != 5 || total != 5 || version != 5 {
+        t.Errorf("quantity, total, version = %d, %d, %d, want 5, 5, 5", quantity, total, version)
+    }
+
+    // The limits hold for additions in place.
+    if err := store.AddItemAsync("alice", "OLJCESPC7Z", 1); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("AddItemAsync over the product limit = %v, want FailedPrecondition", err)
+    }
+    if err := store.AddItemAsync("alice", "66VCHSJNUP", 1); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.AddItemAsync("alice", "66VCHSJNUP", 1); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("AddItemAsync over the total limit = %v, want FailedPrecondition", err)
+    }
+    if quantity, total, _ := readItem(); quantity != 5 || total != 6 {
+        t.Errorf("quantity, total = %d, %d, want 5, 6", quantity, total)
+    }
+}
+
+// ServeHTTP handles a call of the DynamoDB JSON protocol.
+func (f *fakeDynamoDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    var req map[string]any
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        f.fail(w, "SerializationException", err.Error(), nil)
+        return
+    }
+    if table, ok := req["TableName"]; ok && table != f.table {
+        f.fail(w, "ResourceNotFoundException", "Requested resource not found", nil)
+        return
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    var resp map[string]any
+    switch op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."); op {
+    case "DescribeTable":
+        resp = map[string]any{"Table": map[string]any{"TableName": f.table, "TableStatus": "ACTIVE"}}
+    case "GetItem":
+        resp = map[string]any{}
+        if item, ok := f.items[f.key(req["Key"])]; ok {
+            resp["Item"] = item
+        }
+    case "PutItem", "DeleteItem", "UpdateItem":
+        if !f.check(req) {
+            f.fail(w, "ConditionalCheckFailedException", "The conditional request failed", nil)
+            return
+        }
+        f.apply(op, req)
+        resp = map[string]any{}
+    case "TransactWriteItems":
+        ops := req["TransactItems"].([]any)
+        reasons := make([]any, len(ops))
+        failed := false
+        for i, op := range ops {
+            for _, write := range op.(map[string]any) {
+                reasons[i] = map[string]any{"Code": "None"}
+                if !f.check(write.(map[string]any)) {
+                    reasons[i] = map[string]any{"Code": "ConditionalCheckFailed", "Message": "The conditional request failed"}
+                    failed = true
+                }
+            }
+        }
+        if failed {
+            f.fail(w, "TransactionCanceledException", "Transaction cancelled", map[string]any{"CancellationReasons": reasons})
+            return
+        }
+        for _, op := range ops {
+            for kind, write := range op.(map[string]any) {
+                f.apply(kind, write.(map[string]any))
+            }
+        }
+        resp = map[string]any{}
+    case "Scan":
+        keys := make([]string, 0, len(f.items))
+        for key := range f.items {
+            if start, ok := req["ExclusiveStartKey"]; !ok || key > f.key(start) {
+                keys = append(keys, key)
+            }
+        }
+        sort.Strings(keys)
+        var items []any
+        for i, key := range keys {
+            if i == f.pageSize {
+                resp = map[string]any{"LastEvaluatedKey": map[string]any{dynamoUserIdAttr: map[string]any{"S": keys[i-1]}}}
+                break
+            }
+            if f.matches(req["FilterExpression"], req, f.items[key]) {
+                items = append(items, f.items[key])
+            }
+        }
+        if resp == nil {
+            resp = map[string]any{}
+        }
+        resp["Items"], resp["Count"] = items, len(items)
+    default:
+        f.fail(w, "UnknownOperationException", "unsupported operation "+op, nil)
+        return
+    }
+    f.reply(w, http.StatusOK, resp)
+}
+
+// key returns the user id of a key.
+func (f *fakeDynamoDB) key(key any) string {
+    return key.(map[string]any)[dynamoUserIdAttr].(map[string]any)["S"].(string)
+}
+
+// check evaluates the condition of a write.
+func (f *fakeDynamoDB) check(write map[string]any) bool {
+    var key string
+    if item, ok := write["Item"]; ok {
+        key = f.key(item)
+    } else {
+        key = f.key(write["Key"])
+    }
+    return f.matches(write["ConditionExpression"], write, f.items[key])
+}
+
+// apply performs a write whose condition holds.
+func (f *fakeDynamoDB) apply(kind string, write map[string]any) {
+    switch kind {
+    case "Put", "PutItem":
+        f.items[f.key(write["Item"])] = write["Item"].(map[string]any)
+    case "Delete", "DeleteItem":
+        delete(f.items, f.key(write["Key"]))
+    case "UpdateItem":
+        f.update(write)
+    }
+}
+
+// update performs an UpdateItem whose expression is made of a SET clause, with a = :v and a = a + :v actions, and an
+// ADD clause on numbers, in that order.
+func (f *fakeDynamoDB) update(req map[string]any) {
+    key := f.key(req["Key"])
+    item, ok := f.items[key]
+    if !ok {
+        item = map[string]any{dynamoUserIdAttr: map[string]any{"S": key}}
+        f.items[key] = item
+    }
+    values, _ := req["ExpressionAttributeValues"].(map[string]any)
+    set, add, _ := strings.Cut(fakeNames(req["UpdateExpression"].(string), req), " ADD ")
+    if set, ok := strings.CutPrefix(set, "SET "); ok {
+        for _, action := range strings.Split(set, ", ") {
+            fields := strings.Fields(action)
+            switch {
+            case len(fields) == 3 && fields[1] == "=":
+                fakeSet(item, fields[0], values[fields[2]])
+            case len(fields) == 5 && fields[1] == "=" && fields[3] == "+":
+                n, _ := fakeNumber(fakeGet(item, fields[2]))
+                v, _ := fakeNumber(values[fields[4]])
+                fakeSet(item, fields[0], map[string]any{"N": strconv.FormatInt(n+v, 10)})
+            default:
+                panic("fakeDynamoDB: unsupported update " + action)
+            }
+        }
+    }
+    if add != "" {
+        for _, action := range strings.Split(add, ", ") {
+            path, value, _ := strings.Cut(action, " ")
+            n, _ := fakeNumber(fakeGet(item, path))
+            v, _ := fakeNumber(values[value])
+            fakeSet(item, path, map[string]any{"N": strconv.FormatInt(n+v, 10)})
+        }
+    }
+}
+
+// matches evaluates an expression made of terms joined by OR, each of which is a BETWEEN :v AND :w, or conditions
+// joined by AND: attribute_not_exists(a), a = :v, a <= :v or a > :v. An absent expression always matches.
+func (f *fakeDynamoDB) matches(expression any, req map[string]any, item map[string]any) bool {
+    if expression == nil {
+        return true
+    }
+    values, _ := req["ExpressionAttributeValues"].(map[string]any)
+    for _, term := range strings.Split(fakeNames(expression.(string), req), " OR ") {
+        if fields := strings.Fields(term); len(fields) == 5 && fields[1] == "BETWEEN" && fields[3] == "AND" {
+            n, ok := fakeNumber(fakeGet(item, fields[0]))
+            from, _ := fakeNumber(values[fields[2]])
+            to, _ := fakeNumber(values[fields[4]])
+            if ok && from <= n && n <= to {
+                return true
+            }
+            continue
+        }
+        all := true
+        for _, condition := range strings.Split(term, " AND ") {
+            all = all && fakeCondition(condition, values, item)
+        }
+        if all {
+            return true
+        }
+    }
+    return false
+}
+
+// fakeCondition evaluates attribute_not_exists(a), a = :v, a <= :v or a > :v. Comparisons with a missing attribute
+// are false, like in DynamoDB.
+func fakeCondition(condition string, values map[string]any, item map[string]any) bool {
+    if name, ok := strings.CutPrefix(condition, "attribute_not_exists("); ok {
+        return fakeGet(item, strings.TrimSuffix(name, ")")) == nil
+    }
+    fields := strings.Fields(condition)
+    if len(fields) != 3 {
+        panic("fakeDynamoDB: unsupported expression " + condition)
+    }
+    v := fakeGet(item, fields[0])
+    if fields[1] == "=" {
+        return v != nil && reflect.DeepEqual(v, values[fields[2]])
+    }
+    n, ok := fakeNumber(v)
+    w, _ := fakeNumber(values[fields[2]])
+    switch fields[1] {
+    case "<=":
+        return ok && n <= w
+    case ">":
+        return ok && n > w
+    }
+    panic("fakeDynamoDB: unsupported expression " + condition)
+}
+
+// fakeNames replaces the placeholders of attribute names in an expression with the names.
+func fakeNames(expression string, req map[string]any) string {
+    names, _ := req["ExpressionAttributeNames"].(map[string]any)
+    return regexp.MustCompile(`#\w+`).ReplaceAllStringFunc(expression, func(placeholder string) string {
+        return names[placeholder].(string)
+    })
+}
+
+// fakePath splits a document path such as items[1].quantity into attribute names and list indexes.
+func fakePath(path string) []any {
+    var steps []any
+    for _, part := range strings.Split(path, ".") {
+        name, rest, _ := strings.Cut(part, "[")
+        steps = append(steps, name)
+        for rest != "" {
+            index, after, _ := strings.Cut(rest, "]")
+            i, _ := strconv.Atoi(index)
+            steps = append(steps, i)
+            rest = strings.TrimPrefix(after, "[")
+        }
+    }
+    return steps
+}
+
+// fakeGet returns the attribute value at a document path of an item, or nil if there's none.
+func fakeGet(item map[string]any, path string) any {
+    return fakeWalk(item, fakePath(path))
+}
+
+// fakeSet sets the attribute value at a document path of an item, whose parent must exist.
+func fakeSet(item map[string]any, path string, value any) {
+    steps := fakePath(path)
+    parent := fakeWalk(item, steps[:len(steps)-1]).(map[string]any)
+    switch step := steps[len(steps)-1].(type) {
+    case string:
+        parent["M"].(map[string]any)[step] = value
+    case int:
+        parent["L"].([]any)[step] = value
+    }
+}
+
+// fakeWalk returns the attribute value that the steps of a document path lead to from an item, or nil if there's none.
+func fakeWalk(item map[string]any, steps []any) any {
+    var v any = map[string]any{"M": item}
+    for _, step := range steps {
+        switch step := step.(type) {
+        case string:
+            m, _ := v.(map[string]any)["M"].(map[string]any)
+            if v = m[step]; v == nil {
+                return nil
+            }
+        case int:
+            l, _ := v.(map[string]any)["L"].([]any)
+            if step >= len(l) {
+                return nil
+            }
+            v = l[step]
+        }
+    }
+    return v
+}
+
+// fakeNumber parses a number attribute.
+func fakeNumber(v any) (int64, bool) {
+    m, ok := v.(map[string]any)
+    if !ok {
+        return 0, false
+    }
+    s, ok := m["N"].(string)
+    if !ok {
+        return 0, false
+    }
+    n, err := strconv.ParseInt(s, 10, 64)
+    return n, err == nil
+}
+
+// fail writes an error response of the DynamoDB JSON protocol.
+func (f *fakeDynamoDB) fail(w http.ResponseWriter, errorType, message string, fields map[string]any) {
+    body := map[string]any{"__type": "com.amazonaws.dynamodb.v20120810#" + errorType, "message": message}
+    for k, v := range fields {
+        body[k] = v
+    }
+    f.reply(w, http.StatusBadRequest, body)
+}
+
+// reply writes a response of the DynamoDB JSON protocol, with the checksum header the SDK verifies.
+func (f *fakeDynamoDB) reply(w http.ResponseWriter, code int, body map[string]any) {
+    data, _ := json.Marshal(body)
+    w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+    w.Header().Set("X-Amz-Crc32", strconv.FormatUint(uint64(crc32.ChecksumIEEE(data)), 10))
+    w.WriteHeader(code)
+    w.Write(data)
+}
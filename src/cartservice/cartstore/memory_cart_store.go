@@ -1,69 +1,288 @@
 package cartstore
 
 import (
-    "log"
+    "hash/fnv"
     "sync"
+    "time"
 
     "google.golang.org/protobuf/proto"
 
-    pb "main/genproto"
+    pb "cartservice/genproto"
 )
 
+const (
+    // shardCount is the number of independently locked partitions of the in-memory store.
+    shardCount = 32
+
+    // maxSweepInterval is the longest time between two eviction sweeps of the in-memory store.
+    maxSweepInterval = time.Minute
+)
+
+// InMemoryCartStore keeps the carts in a map that is split into shards, each guarded by its own mutex, so requests for
+// different users rarely wait for each other. Carts never leave the store: updates work on a copy that replaces the
+// stored cart only if the update succeeds, and readers get their own copy.
 type InMemoryCartStore struct {
-    carts sync.Map
+    shards [shardCount]cartShard
+    ttl    time.Duration
+    now    func() time.Time
+    limiter
 }
 
-func NewInMemoryCartStore() *InMemoryCartStore {
-    log.Println("Initializing InMemory CartStore")
-    return &InMemoryCartStore{}
+type cartShard struct {
+    mu    sync.Mutex
+    carts map[string]*cartEntry
 }
 
-func (store *InMemoryCartStore) AddItemAsync(userId, productId string, quantity int32) error {
-    log.Printf("AddItemAsync called with userId=%s, productId=%s, quantity=%d\n", userId, productId, quantity)
+type cartEntry struct {
+    cart      *pb.Cart
+    expiresAt time.Time // zero if the cart never expires
+}
 
-    var cart *pb.Cart
+// expired reports whether the entry has expired at the given time.
+func (e *cartEntry) expired(now time.Time) bool {
+    return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
 
-    if value, ok := store.carts.Load(userId); ok {
-        cart = value.(*pb.Cart)
-    } else {
-        cart = &pb.Cart{UserId: userId}
+// NewInMemoryCartStore creates a store whose carts expire after ttl without updates. A ttl of zero disables expiry.
+// Expired carts are invisible right away, and a background sweep frees their memory.
+func NewInMemoryCartStore(ttl time.Duration) *InMemoryCartStore {
+    log.Info("Initializing InMemory CartStore")
+    store := &InMemoryCartStore{ttl: ttl, now: time.Now}
+    for i := range store.shards {
+        store.shards[i].carts = make(map[string]*cartEntry)
     }
+    if ttl > 0 {
+        go store.sweepLoop(min(ttl, maxSweepInterval))
+    }
+    return store
+}
 
-    itemFound := false
-    for _, item := range cart.Items {
-        if item.ProductId == productId {
-            item.Quantity += quantity
-            itemFound = true
-            break
+// shard returns the shard that holds the cart of a user.
+func (store *InMemoryCartStore) shard(userId string) *cartShard {
+    return &store.shards[store.shardIndex(userId)]
+}
+
+// shardIndex returns the index of the shard that holds the cart of a user.
+func (store *InMemoryCartStore) shardIndex(userId string) int {
+    h := fnv.New32a()
+    _, _ = h.Write([]byte(userId))
+    return int(h.Sum32() % shardCount)
+}
+
+// updateCart applies a read-modify-write update to the cart of a user while holding the lock of its shard, and
+// refreshes the cart's expiry. A cart left without items is deleted.
+func (store *InMemoryCartStore) updateCart(userId string, update func(cart *pb.Cart) error) error {
+    return store.updateCarts([]string{userId}, func(carts []*pb.Cart) error {
+        return update(carts[0])
+    })
+}
+
+// updateCarts applies a read-modify-write update to the carts of several users at once, like updateCart. The locks of
+// their shards are taken in shard order, so concurrent multi-cart updates can't deadlock. Either all the carts are
+// stored or, if the update fails, none is.
+func (store *InMemoryCartStore) updateCarts(userIds []string, update func(carts []*pb.Cart) error) error {
+    var locked [shardCount]bool
+    for _, userId := range userIds {
+        locked[store.shardIndex(userId)] = true
+    }
+    for i := range store.shards {
+        if locked[i] {
+            store.shards[i].mu.Lock()
+            defer store.shards[i].mu.Unlock()
         }
     }
 
-    if !itemFound {
-        cart.Items = append(cart.Items, &pb.CartItem{ProductId: productId, Quantity: quantity})
+    now := store.now()
+    carts := make([]*pb.Cart, len(userIds))
+    for i, userId := range userIds {
+        carts[i] = &pb.Cart{UserId: userId}
+        if entry, ok := store.shard(userId).carts[userId]; ok && !entry.expired(now) {
+            carts[i] = proto.Clone(entry.cart).(*pb.Cart)
+        }
+    }
+    if err := update(carts); err != nil {
+        return err
     }
 
-    store.carts.Store(userId, cart)
+    for i, userId := range userIds {
+        shard := store.shard(userId)
+        if len(carts[i].Items) == 0 {
+            delete(shard.carts, userId)
+            continue
+        }
+        entry := &cartEntry{cart: carts[i]}
+        if store.ttl > 0 {
+            entry.expiresAt = now.Add(store.ttl)
+        }
+        shard.carts[userId] = entry
+    }
     return nil
 }
 
+func (store *InMemoryCartStore) AddItemAsync(userId, productId string, quantity int32) error {
+    log.Infof("AddItemAsync called with userId=%s, productId=%s, quantity=%d", userId, productId, quantity)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error { return addItem(cart, productId, quantity) })
+    })
+}
+
 func (store *InMemoryCartStore) GetCartAsync(userId string) (*pb.Cart, error) {
-    log.Printf("GetCartAsync called with userId=%s\n", userId)
+    log.Infof("GetCartAsync called with userId=%s", userId)
 
-    if value, ok := store.carts.Load(userId); ok {
-        return proto.Clone(value.(*pb.Cart)).(*pb.Cart), nil
-    }
+    shard := store.shard(userId)
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
 
+    if entry, ok := shard.carts[userId]; ok && !entry.expired(store.now()) {
+        return proto.Clone(entry.cart).(*pb.Cart), nil
+    }
     return &pb.Cart{UserId: userId}, nil
 }
 
 func (store *InMemoryCartStore) EmptyCartAsync(userId string) error {
-    log.Printf("EmptyCartAsync called with userId=%s\n", userId)
+    log.Infof("EmptyCartAsync called with userId=%s", userId)
+
+    shard := store.shard(userId)
+    shard.mu.Lock()
+    defer shard.mu.Unlock()
+
+    delete(shard.carts, userId)
+    return nil
+}
+
+func (store *InMemoryCartStore) RemoveItemAsync(userId, productId string) error {
+    log.Infof("RemoveItemAsync called with userId=%s, productId=%s", userId, productId)
 
-    store.carts.Delete(userId)
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        setItemQuantity(cart, productId, 0)
+        return nil
+    })
+}
+
+func (store *InMemoryCartStore) SetItemQuantityAsync(userId, productId string, quantity int32) error {
+    log.Infof("SetItemQuantityAsync called with userId=%s, productId=%s, quantity=%d", userId, productId, quantity)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error {
+            setItemQuantity(cart, productId, quantity)
+            return nil
+        })
+    })
+}
+
+func (store *InMemoryCartStore) ReplaceCartAsync(userId string, items []*pb.CartItem) error {
+    log.Infof("ReplaceCartAsync called with userId=%s, items=%d", userId, len(items))
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error {
+            replaceItems(cart, items)
+            return nil
+        })
+    })
+}
+
+func (store *InMemoryCartStore) MergeCartsAsync(sourceUserId, targetUserId string, maxQuantity int32) (*pb.Cart, error) {
+    log.Infof("MergeCartsAsync called with sourceUserId=%s, targetUserId=%s, maxQuantity=%d", sourceUserId, targetUserId, maxQuantity)
+
+    if err := checkMergeUsers(sourceUserId, targetUserId); err != nil {
+        return nil, err
+    }
+    var merged *pb.Cart
+    err := store.updateCarts([]string{sourceUserId, targetUserId}, func(carts []*pb.Cart) error {
+        if err := store.limits.apply(carts[1], func() error {
+            mergeCarts(carts[1], carts[0], maxQuantity)
+            return nil
+        }); err != nil {
+            return err
+        }
+        merged = proto.Clone(carts[1]).(*pb.Cart)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return merged, nil
+}
+
+func (store *InMemoryCartStore) ListExpiringCartsAsync(within time.Duration, limit int) ([]*pb.ExpiringCart, error) {
+    log.Infof("ListExpiringCartsAsync called with within=%v, limit=%d", within, limit)
+
+    now := store.now()
+    deadline := now.Add(within)
+    var carts []*pb.ExpiringCart
+    for i := range store.shards {
+        shard := &store.shards[i]
+        shard.mu.Lock()
+        for _, entry := range shard.carts {
+            if entry.expiresAt.IsZero() || entry.expired(now) || entry.expiresAt.After(deadline) {
+                continue
+            }
+            carts = append(carts, &pb.ExpiringCart{
+                Cart:      proto.Clone(entry.cart).(*pb.Cart),
+                ExpiresAt: entry.expiresAt.Unix(),
+            })
+        }
+        shard.mu.Unlock()
+    }
+    return sortExpiringCarts(carts, limit), nil
+}
+
+func (store *InMemoryCartStore) ScanCartsAsync(fn func(cart *pb.Cart) error) error {
+    log.Info("ScanCartsAsync called")
+
+    for i := range store.shards {
+        // fn runs without the lock held, so it may call the store.
+        shard := &store.shards[i]
+        now := store.now()
+        shard.mu.Lock()
+        carts := make([]*pb.Cart, 0, len(shard.carts))
+        for _, entry := range shard.carts {
+            if !entry.expired(now) {
+                carts = append(carts, proto.Clone(entry.cart).(*pb.Cart))
+            }
+        }
+        shard.mu.Unlock()
+        for _, cart := range carts {
+            if err := fn(cart); err != nil {
+                return err
+            }
+        }
+    }
     return nil
 }
 
+// sweepLoop evicts expired carts periodically, for the lifetime of the process.
+func (store *InMemoryCartStore) sweepLoop(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for range ticker.C {
+        if evicted := store.sweep(); evicted > 0 {
+            log.Infof("Evicted %d expired carts", evicted)
+        }
+    }
+}
+
+// sweep removes the expired carts and returns how many were removed. Shards are locked one at a time, so requests
+// are held up for a single shard at most.
+func (store *InMemoryCartStore) sweep() int {
+    now := store.now()
+    evicted := 0
+    for i := range store.shards {
+        shard := &store.shards[i]
+        shard.mu.Lock()
+        for userId, entry := range shard.carts {
+            if entry.expired(now) {
+                delete(shard.carts, userId)
+                evicted++
+            }
+        }
+        shard.mu.Unlock()
+    }
+    return evicted
+}
+
 func (store *InMemoryCartStore) Ping() bool {
-    log.Println("InMemory CartStore Ping called - always returns true")
+    log.Info("InMemory CartStore Ping called - always returns true")
     return true
 }
@@ -0,0 +1,183 @@
+package cartstore
+
+import (
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestInMemoryCartStoreConformance(t *testing.T) {
+    quietLogs(t)
+    ConformanceSuite{
+        NewStore: func(t *testing.T) CartStore {
+            return NewInMemoryCartStore(DefaultCartTTL)
+        },
+    }.Run(t)
+}
+
+func TestInMemoryCartStoreConcurrentAddItem(t *testing.T) {
+    quietLogs(t)
+    store := NewInMemoryCartStore(DefaultCartTTL)
+
+    const (
+        workers   = 16
+        perWorker = 200
+    )
+    products := []string{"OLJCESPC7Z", "66VCHSJNUP", "1YMWWN1N4O", "L9ECAV7KIM"}
+
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for i := 0; i < perWorker; i++ {
+                if err := store.AddItemAsync("user", products[(w+i)%len(products)], 1); err != nil {
+                    t.Errorf("AddItemAsync failed: %v", err)
+                }
+            }
+        }(w)
+    }
+    wg.Wait()
+
+    cart, err := store.GetCartAsync("user")
+    if err != nil {
+        t.Fatal(err)
+    }
+    total := int32(0)
+    for _, item := range cart.Items {
+        total += item.Quantity
+    }
+    if len(cart.Items) != len(products) || total != workers*perWorker {
+        t.Errorf("cart has %d lines and %d units, want %d lines and %d units", len(cart.Items), total, len(products), workers*perWorker)
+    }
+}
+
+// TestInMemoryCartStoreStress mixes every operation on a few shared carts. Run it with -race.
+func TestInMemoryCartStoreStress(t *testing.T) {
+    quietLogs(t)
+    store := NewInMemoryCartStore(DefaultCartTTL)
+
+    const (
+        workers    = 12
+        iterations = 500
+    )
+    users := []string{"alice", "bob", "carol"}
+
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for i := 0; i < iterations; i++ {
+                userId := users[(w+i)%len(users)]
+                switch (w + i) % 5 {
+                case 0:
+                    if err := store.EmptyCartAsync(userId); err != nil {
+                        t.Errorf("EmptyCartAsync failed: %v", err)
+                    }
+                case 1, 2:
+                    if err := store.AddItemAsync(userId, fmt.Sprintf("product-%d", i%7), 1); err != nil {
+                        t.Errorf("AddItemAsync failed: %v", err)
+                    }
+                default:
+                    cart, err := store.GetCartAsync(userId)
+                    if err != nil {
+                        t.Errorf("GetCartAsync failed: %v", err)
+                        continue
+                    }
+                    // Callers own the returned cart, so changing it must not affect the store.
+                    seen := make(map[string]bool)
+                    for _, item := range cart.Items {
+                        if seen[item.ProductId] || item.Quantity <= 0 {
+                            t.Errorf("inconsistent cart: %v", cart)
+                        }
+                        seen[item.ProductId] = true
+                        item.Quantity = -1
+                    }
+                }
+            }
+        }(w)
+    }
+    wg.Wait()
+
+    for _, userId := range users {
+        cart, err := store.GetCartAsync(userId)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, item := range cart.Items {
+            if item.Quantity <= 0 {
+                t.Errorf("cart of %s was changed through a returned copy: %v", userId, cart)
+            }
+        }
+    }
+}
+
+// fakeClock is a settable time source for expiry tests.
+type fakeClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+}
+
+func TestInMemoryCartStoreExpiry(t *testing.T) {
+    quietLogs(t)
+    clock := &fakeClock{now: time.Unix(1700000000, 0)}
+    store := NewInMemoryCartStore(time.Hour)
+    store.now = clock.Now
+
+    if err := store.AddItemAsync("alice", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+    clock.Advance(40 * time.Minute)
+    if err := store.AddItemAsync("bob", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+
+    carts, err := store.ListExpiringCartsAsync(30*time.Minute, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(carts) != 1 || carts[0].Cart.UserId != "alice" || carts[0].ExpiresAt != clock.Now().Add(20*time.Minute).Unix() {
+        t.Errorf("expiring carts = %v, want only alice's, expiring in 20 minutes", carts)
+    }
+    if carts, _ := store.ListExpiringCartsAsync(2*time.Hour, 1); len(carts) != 1 || carts[0].Cart.UserId != "alice" {
+        t.Errorf("limited expiring carts = %v, want only alice's", carts)
+    }
+
+    // Writes refresh the expiry.
+    clock.Advance(10 * time.Minute)
+    if err := store.AddItemAsync("alice", "66VCHSJNUP", 1); err != nil {
+        t.Fatal(err)
+    }
+    clock.Advance(55 * time.Minute)
+    if cart, _ := store.GetCartAsync("alice"); len(cart.Items) != 2 {
+        t.Errorf("alice's cart = %v, want it kept alive by the last write", cart)
+    }
+    if cart, _ := store.GetCartAsync("bob"); len(cart.Items) != 0 {
+        t.Errorf("bob's cart = %v, want it expired", cart)
+    }
+
+    if evicted := store.sweep(); evicted != 1 {
+        t.Errorf("sweep evicted %d carts, want 1", evicted)
+    }
+    clock.Advance(time.Hour)
+    if evicted := store.sweep(); evicted != 1 {
+        t.Errorf("sweep evicted %d carts, want 1", evicted)
+    }
+    if carts, _ := store.ListExpiringCartsAsync(time.Hour, 0); len(carts) != 0 {
+        t.Errorf("expiring carts = %v, want none", carts)
+    }
+}
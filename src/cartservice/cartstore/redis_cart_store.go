@@ -3,98 +3,140 @@ package cartstore
 import (
     "context"
     "errors"
-    "log"
+    "strings"
+    "time"
 
     "github.com/redis/go-redis/v9"
     "google.golang.org/grpc/codes"
     "google.golang.org/grpc/status"
-    "google.golang.org/protobuf/encoding/protojson"
 
-    pb "main/genproto"
+    pb "cartservice/genproto"
+)
+
+const (
+    // scanBatchSize is the number of keys asked for in each SCAN call when looking for expiring carts.
+    scanBatchSize = 100
 )
 
 type RedisCartStore struct {
-    rdb *redis.Client
-    ctx context.Context
+    rdb      *redis.Client
+    ctx      context.Context
+    ttl      time.Duration
+    encoding Encoding
+    limiter
 }
 
-func NewRedisCartStore(redisAddr, redisPassword string) *RedisCartStore {
-    log.Printf("Initializing Redis CartStore with address %s", redisAddr)
+// NewRedisCartStore creates a store whose carts expire after ttl without updates. A ttl of zero disables expiry. Carts
+// are written in the given encoding, and read in any of them, so a cart written in another encoding is rewritten in
+// this one at its next update.
+func NewRedisCartStore(redisAddr, redisPassword string, ttl time.Duration, encoding Encoding) *RedisCartStore {
+    log.Infof("Initializing Redis CartStore with address %s and encoding %s", redisAddr, encoding)
     ctx := context.Background()
     rdb := redis.NewClient(&redis.Options{
         Addr:     redisAddr,
         Password: redisPassword, // no password set if empty
         DB:       0,             // use default DB
     })
-    return &RedisCartStore{rdb: rdb, ctx: ctx}
+    return &RedisCartStore{rdb: rdb, ctx: ctx, ttl: ttl, encoding: encoding}
 }
 
 func (store *RedisCartStore) AddItemAsync(userId, productId string, quantity int32) error {
-    log.Printf("AddItemAsync called with userId=%s, productId=%s, quantity=%d\n", userId, productId, quantity)
+    log.Infof("AddItemAsync called with userId=%s, productId=%s, quantity=%d", userId, productId, quantity)
 
-    val, err := store.rdb.Get(store.ctx, userId).Result()
-    cart := &pb.Cart{UserId: userId}
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error { return addItem(cart, productId, quantity) })
+    })
+}
 
-    if errors.Is(err, redis.Nil) {
-        cart.Items = []*pb.CartItem{
-            {ProductId: productId, Quantity: quantity},
+// updateCart applies a read-modify-write update to the cart of a user atomically. The cart key is watched while the
+// update runs, and the new cart is only stored if nobody else changed the key in the meantime. Otherwise, the update
+// is retried on a fresh copy of the cart, so concurrent updates (e.g. from frontend functions scaled out in Lambda)
+// never overwrite each other. A cart left without items is deleted.
+func (store *RedisCartStore) updateCart(userId string, update func(cart *pb.Cart) error) error {
+    return store.updateCarts([]string{userId}, func(carts []*pb.Cart) error {
+        return update(carts[0])
+    })
+}
+
+// updateCarts applies a read-modify-write update to the carts of several users atomically, like updateCart. All their
+// keys are watched, and the new carts are written in a single transaction.
+func (store *RedisCartStore) updateCarts(userIds []string, update func(carts []*pb.Cart) error) error {
+    txf := func(tx *redis.Tx) error {
+        carts := make([]*pb.Cart, len(userIds))
+        for i, userId := range userIds {
+            cart, err := readCart(store.ctx, tx, userId)
+            if err != nil {
+                return err
+            }
+            carts[i] = cart
         }
-    } else if err != nil {
-        return status.Errorf(codes.Unavailable, "can't access cart storage: %v", err)
-    } else {
-        err = protojson.Unmarshal([]byte(val), cart)
-        if err != nil {
-            return status.Errorf(codes.Internal, "error parsing cart: %v", err)
+        if err := update(carts); err != nil {
+            return err
         }
 
-        itemFound := false
-        for _, item := range cart.Items {
-            if item.ProductId == productId {
-                item.Quantity += quantity
-                itemFound = true
-                break
+        cartData := make([][]byte, len(carts))
+        for i, cart := range carts {
+            data, err := encodeCart(cart, store.encoding)
+            if err != nil {
+                return status.Errorf(codes.Internal, "error serializing cart: %v", err)
             }
+            cartData[i] = data
         }
 
-        if !itemFound {
-            cart.Items = append(cart.Items, &pb.CartItem{ProductId: productId, Quantity: quantity})
+        _, err := tx.TxPipelined(store.ctx, func(pipe redis.Pipeliner) error {
+            for i, userId := range userIds {
+                if len(carts[i].Items) == 0 {
+                    pipe.Del(store.ctx, userId)
+                } else {
+                    pipe.Set(store.ctx, userId, cartData[i], store.ttl)
+                }
+            }
+            return nil
+        })
+        if err != nil && !errors.Is(err, redis.TxFailedErr) {
+            return status.Errorf(codes.Unavailable, "error storing cart in Redis: %v", err)
         }
+        return err
     }
 
-    cartData, err := protojson.Marshal(cart)
-    if err != nil {
-        return status.Errorf(codes.Internal, "error serializing cart: %v", err)
-    }
-
-    err = store.rdb.Set(store.ctx, userId, cartData, 0).Err()
-    if err != nil {
-        return status.Errorf(codes.Unavailable, "error storing cart in Redis: %v", err)
+    for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+        err := store.rdb.Watch(store.ctx, txf, userIds...)
+        if !errors.Is(err, redis.TxFailedErr) {
+            if err != nil && status.Code(err) == codes.Unknown {
+                return status.Errorf(codes.Unavailable, "can't access cart storage: %v", err)
+            }
+            return err
+        }
+        // Another update won the race; back off a little so the retries of concurrent callers spread out.
+        time.Sleep(retryBackoff(attempt))
     }
-
-    return nil
+    return status.Errorf(codes.Aborted, "cart of user %s is being updated concurrently, try again", strings.Join(userIds, ", "))
 }
 
-func (store *RedisCartStore) GetCartAsync(userId string) (*pb.Cart, error) {
-    log.Printf("GetCartAsync called with userId=%s\n", userId)
-
-    val, err := store.rdb.Get(store.ctx, userId).Result()
+// readCart reads the cart of a user, or returns an empty cart if the user has none.
+func readCart(ctx context.Context, cmd redis.Cmdable, userId string) (*pb.Cart, error) {
+    val, err := cmd.Get(ctx, userId).Bytes()
     if errors.Is(err, redis.Nil) {
         return &pb.Cart{UserId: userId}, nil
     } else if err != nil {
         return nil, status.Errorf(codes.Unavailable, "can't access cart storage: %v", err)
     }
 
-    cart := &pb.Cart{}
-    err = protojson.Unmarshal([]byte(val), cart)
+    cart, err := decodeCart(val)
     if err != nil {
         return nil, status.Errorf(codes.Internal, "error parsing cart: %v", err)
     }
-
     return cart, nil
 }
 
+func (store *RedisCartStore) GetCartAsync(userId string) (*pb.Cart, error) {
+    log.Infof("GetCartAsync called with userId=%s", userId)
+
+    return readCart(store.ctx, store.rdb, userId)
+}
+
 func (store *RedisCartStore) EmptyCartAsync(userId string) error {
-    log.Printf("EmptyCartAsync called with userId=%s\n", userId)
+    log.Infof("EmptyCartAsync called with userId=%s", userId)
 
     err := store.rdb.Del(store.ctx, userId).Err()
     if err != nil {
@@ -104,12 +146,181 @@ func (store *RedisCartStore) EmptyCartAsync(userId string) error {
     return nil
 }
 
+func (store *RedisCartStore) RemoveItemAsync(userId, productId string) error {
+    log.Infof("RemoveItemAsync called with userId=%s, productId=%s", userId, productId)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        setItemQuantity(cart, productId, 0)
+        return nil
+    })
+}
+
+func (store *RedisCartStore) SetItemQuantityAsync(userId, productId string, quantity int32) error {
+    log.Infof("SetItemQuantityAsync called with userId=%s, productId=%s, quantity=%d", userId, productId, quantity)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error {
+            setItemQuantity(cart, productId, quantity)
+            return nil
+        })
+    })
+}
+
+func (store *RedisCartStore) ReplaceCartAsync(userId string, items []*pb.CartItem) error {
+    log.Infof("ReplaceCartAsync called with userId=%s, items=%d", userId, len(items))
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error {
+            replaceItems(cart, items)
+            return nil
+        })
+    })
+}
+
+func (store *RedisCartStore) MergeCartsAsync(sourceUserId, targetUserId string, maxQuantity int32) (*pb.Cart, error) {
+    log.Infof("MergeCartsAsync called with sourceUserId=%s, targetUserId=%s, maxQuantity=%d", sourceUserId, targetUserId, maxQuantity)
+
+    if err := checkMergeUsers(sourceUserId, targetUserId); err != nil {
+        return nil, err
+    }
+    var merged *pb.Cart
+    err := store.updateCarts([]string{sourceUserId, targetUserId}, func(carts []*pb.Cart) error {
+        if err := store.limits.apply(carts[1], func() error {
+            mergeCarts(carts[1], carts[0], maxQuantity)
+            return nil
+        }); err != nil {
+            return err
+        }
+        merged = carts[1]
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return merged, nil
+}
+
+// ListExpiringCartsAsync scans the keys of the database for carts whose remaining TTL is within the given duration.
+// It's meant for occasional maintenance tasks, as it walks over every key.
+func (store *RedisCartStore) ListExpiringCartsAsync(within time.Duration, limit int) ([]*pb.ExpiringCart, error) {
+    log.Infof("ListExpiringCartsAsync called with within=%v, limit=%d", within, limit)
+
+    now := time.Now()
+    expiries := make(map[string]time.Time)
+    iter := store.rdb.Scan(store.ctx, 0, "", scanBatchSize).Iterator()
+    var batch []string
+    flush := func() error {
+        if len(batch) == 0 {
+            return nil
+        }
+        cmds := make([]*redis.DurationCmd, len(batch))
+        _, err := store.rdb.Pipelined(store.ctx, func(pipe redis.Pipeliner) error {
+            for i, key := range batch {
+                cmds[i] = pipe.PTTL(store.ctx, key)
+            }
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+        for i, cmd := range cmds {
+            // Keys without an expiry (or deleted since the scan) have a negative TTL.
+            if ttl := cmd.Val(); ttl > 0 && ttl <= within {
+                expiries[batch[i]] = now.Add(ttl)
+            }
+        }
+        batch = batch[:0]
+        return nil
+    }
+    for iter.Next(store.ctx) {
+        batch = append(batch, iter.Val())
+        if len(batch) == scanBatchSize {
+            if err := flush(); err != nil {
+                return nil, status.Errorf(codes.Unavailable, "can't access cart storage: %v", err)
+            }
+        }
+    }
+    if err := iter.Err(); err != nil {
+        return nil, status.Errorf(codes.Unavailable, "can't access cart storage: %v", err)
+    }
+    if err := flush(); err != nil {
+        return nil, status.Errorf(codes.Unavailable, "can't access cart storage: %v", err)
+    }
+
+    var carts []*pb.ExpiringCart
+    for userId, expiresAt := range expiries {
+        carts = append(carts, &pb.ExpiringCart{Cart: &pb.Cart{UserId: userId}, ExpiresAt: expiresAt.Unix()})
+    }
+    // The limit applies to the carts that are left once those that were emptied or expired in the meantime are
+    // skipped, so it can't be applied before they're read.
+    carts = sortExpiringCarts(carts, 0)
+    found := carts[:0]
+    for _, c := range carts {
+        if limit > 0 && len(found) == limit {
+            break
+        }
+        cart, err := readCart(store.ctx, store.rdb, c.Cart.UserId)
+        if err != nil {
+            return nil, err
+        }
+        if len(cart.Items) > 0 {
+            c.Cart = cart
+            found = append(found, c)
+        }
+    }
+    return found, nil
+}
+
+// ScanCartsAsync scans the string keys of the database, and reads them as carts in batches.
+func (store *RedisCartStore) ScanCartsAsync(fn func(cart *pb.Cart) error) error {
+    log.Info("ScanCartsAsync called")
+
+    iter := store.rdb.ScanType(store.ctx, 0, "", scanBatchSize, "string").Iterator()
+    var batch []string
+    flush := func() error {
+        if len(batch) == 0 {
+            return nil
+        }
+        values, err := store.rdb.MGet(store.ctx, batch...).Result()
+        if err != nil {
+            return unavailable(err)
+        }
+        for i, value := range values {
+            data, ok := value.(string)
+            if !ok { // deleted since the scan
+                continue
+            }
+            cart, err := decodeCart([]byte(data))
+            if err != nil {
+                return status.Errorf(codes.Internal, "error parsing cart of key %s: %v", batch[i], err)
+            }
+            if err := fn(cart); err != nil {
+                return err
+            }
+        }
+        batch = batch[:0]
+        return nil
+    }
+    for iter.Next(store.ctx) {
+        batch = append(batch, iter.Val())
+        if len(batch) == scanBatchSize {
+            if err := flush(); err != nil {
+                return err
+            }
+        }
+    }
+    if err := iter.Err(); err != nil {
+        return unavailable(err)
+    }
+    return flush()
+}
+
 func (store *RedisCartStore) Ping() bool {
     pong, err := store.rdb.Ping(store.ctx).Result()
     if err != nil {
-        log.Printf("Error pinging Redis: %v\n", err)
+        log.Infof("Error pinging Redis: %v", err)
         return false
     }
-    log.Printf("Redis Ping result: %s\n", pong)
+    log.Infof("Redis Ping result: %s", pong)
     return true
 }
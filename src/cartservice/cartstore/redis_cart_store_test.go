@@ -0,0 +1,178 @@
+package cartstore
+
+import (
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "cartservice/genproto"
+)
+
+// newTestRedisCartStore returns a store backed by an in-process Redis server, which writes carts in protojson.
+func newTestRedisCartStore(t *testing.T) (*RedisCartStore, *miniredis.Miniredis) {
+    t.Helper()
+    quietLogs(t)
+    server := miniredis.RunT(t)
+    return NewRedisCartStore(server.Addr(), "", DefaultCartTTL, EncodingJSON), server
+}
+
+// quietLogs keeps the log lines of every store call out of the test output.
+func quietLogs(t testing.TB) {
+    level := log.Logger.GetLevel()
+    log.Logger.SetLevel(logrus.WarnLevel)
+    t.Cleanup(func() { log.Logger.SetLevel(level) })
+}
+
+func TestRedisCartStoreConformance(t *testing.T) {
+    for _, encoding := range []Encoding{EncodingJSON, EncodingProto, EncodingProtoZstd} {
+        t.Run(string(encoding), func(t *testing.T) {
+            ConformanceSuite{
+                NewStore: func(t *testing.T) CartStore {
+                    store, _ := newTestRedisCartStore(t)
+                    store.encoding = encoding
+                    return store
+                },
+                NewBrokenStore: func(t *testing.T) CartStore {
+                    store, server := newTestRedisCartStore(t)
+                    server.Close()
+                    return store
+                },
+            }.Run(t)
+        })
+    }
+}
+
+func TestRedisCartStoreMigratesEncodingLazily(t *testing.T) {
+    store, server := newTestRedisCartStore(t)
+    // A cart written before the encodings existed, in protojson.
+    if err := server.Set("user", `{"userId":"user","items":[{"productId":"OLJCESPC7Z","quantity":2}]}`); err != nil {
+        t.Fatal(err)
+    }
+
+    store.encoding = EncodingProtoZstd
+    if cart, err := store.GetCartAsync("user"); err != nil || len(cart.Items) != 1 || cart.Items[0].Quantity != 2 {
+        t.Fatalf("GetCartAsync() = %v, %v, want the cart written in protojson", cart, err)
+    }
+    if val, _ := server.Get("user"); val[0] != jsonCartPrefix {
+        t.Errorf("cart was rewritten by a read: %q", val)
+    }
+
+    // The next update rewrites the cart in the store's encoding, and another store can still read it.
+    if err := store.AddItemAsync("user", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+    if val, _ := server.Get("user"); val[0] != protoZstdCartPrefix {
+        t.Errorf("cart wasn't rewritten in proto-zstd by an update: %q", val)
+    }
+    reader := NewRedisCartStore(server.Addr(), "", DefaultCartTTL, EncodingJSON)
+    if cart, err := reader.GetCartAsync("user"); err != nil || len(cart.Items) != 1 || cart.Items[0].Quantity != 3 {
+        t.Errorf("GetCartAsync() = %v, %v, want 3 units read from proto-zstd", cart, err)
+    }
+
+    if err := server.Set("user", "\x7fnot a cart"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := store.GetCartAsync("user"); status.Code(err) != codes.Internal {
+        t.Errorf("reading a cart of unknown format returned %v, want Internal", err)
+    }
+}
+
+func TestRedisCartStoreRetriesOnConflict(t *testing.T) {
+    store, server := newTestRedisCartStore(t)
+
+    if err := store.AddItemAsync("user", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+
+    // Change the cart behind the store's back while its first attempt is in flight.
+    attempts := 0
+    err := store.updateCart("user", func(cart *pb.Cart) error {
+        attempts++
+        if attempts == 1 {
+            if err := server.Set("user", `{"userId":"user","items":[{"productId":"OLJCESPC7Z","quantity":5}]}`); err != nil {
+                t.Fatal(err)
+            }
+        }
+        cart.Items[0].Quantity++
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if attempts != 2 {
+        t.Errorf("update ran %d times, want 2", attempts)
+    }
+
+    cart, err := store.GetCartAsync("user")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := cart.Items[0].Quantity; got != 6 {
+        t.Errorf("quantity = %d, want 6: the concurrent write was overwritten", got)
+    }
+}
+
+func TestRedisCartStoreExpiry(t *testing.T) {
+    quietLogs(t)
+    server := miniredis.RunT(t)
+    store := NewRedisCartStore(server.Addr(), "", time.Hour, EncodingJSON)
+
+    if err := store.AddItemAsync("alice", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+    server.FastForward(40 * time.Minute)
+    if err := store.AddItemAsync("bob", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+    if ttl := server.TTL("bob"); ttl != time.Hour {
+        t.Errorf("TTL of bob's cart = %v, want it refreshed to an hour", ttl)
+    }
+
+    carts, err := store.ListExpiringCartsAsync(30*time.Minute, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(carts) != 1 || carts[0].Cart.UserId != "alice" || len(carts[0].Cart.Items) != 1 {
+        t.Errorf("expiring carts = %v, want only alice's", carts)
+    }
+    if carts, _ := store.ListExpiringCartsAsync(2*time.Hour, 1); len(carts) != 1 || carts[0].Cart.UserId != "alice" {
+        t.Errorf("limited expiring carts = %v, want only alice's", carts)
+    }
+
+    server.FastForward(30 * time.Minute)
+    if cart, _ := store.GetCartAsync("alice"); len(cart.Items) != 0 {
+        t.Errorf("alice's cart = %v, want it expired", cart)
+    }
+    if cart, _ := store.GetCartAsync("bob"); len(cart.Items) != 1 {
+        t.Errorf("bob's cart = %v, want it kept", cart)
+    }
+}
+
+func TestRedisCartStoreExpiringLimitSkipsEmptyCarts(t *testing.T) {
+    quietLogs(t)
+    server := miniredis.RunT(t)
+    store := NewRedisCartStore(server.Addr(), "", time.Hour, EncodingJSON)
+
+    // An empty cart that still has an expiry, and expires first.
+    empty, err := encodeCart(&pb.Cart{UserId: "alice"}, EncodingJSON)
+    if err != nil {
+        t.Fatal(err)
+    }
+    server.Set("alice", string(empty))
+    server.SetTTL("alice", 10*time.Minute)
+    if err := store.AddItemAsync("bob", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+
+    carts, err := store.ListExpiringCartsAsync(2*time.Hour, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(carts) != 1 || carts[0].Cart.UserId != "bob" {
+        t.Errorf("limited expiring carts = %v, want bob's", carts)
+    }
+}
@@ -0,0 +1,476 @@
+package cartstore
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+
+    _ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" driver
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    _ "modernc.org/sqlite" // registers the "sqlite" driver
+
+    pb "cartservice/genproto"
+)
+
+// sqlMigrations are the schema changes of the SQL store, in order. A migration is applied once, in a transaction, and
+// recorded in the schema_migrations table; applied migrations must never change. The statements are written in the
+// dialect shared by PostgreSQL and SQLite.
+var sqlMigrations = []string{
+    `CREATE TABLE carts (
+        user_id    TEXT PRIMARY KEY,
+        expires_at BIGINT NOT NULL DEFAULT 0
+    );
+    CREATE TABLE cart_items (
+        user_id    TEXT NOT NULL,
+        product_id TEXT NOT NULL,
+        quantity   INTEGER NOT NULL,
+        position   INTEGER NOT NULL,
+        PRIMARY KEY (user_id, product_id)
+    );
+    CREATE INDEX carts_expires_at ON carts (expires_at);`,
+}
+
+// sqlDialect holds what differs between the supported databases.
+type sqlDialect struct {
+    driver string
+    // lockMigrations takes a lock, held until the end of the transaction, so that instances starting at the same time
+    // apply the migrations one after the other.
+    lockMigrations string
+}
+
+var sqlDialects = map[string]sqlDialect{
+    "postgres": {
+        driver:         "pgx",
+        lockMigrations: "SELECT pg_advisory_xact_lock(7070)",
+    },
+    "sqlite": {
+        driver: "sqlite",
+        // Writing to the table takes the database's write lock.
+        lockMigrations: "DELETE FROM schema_migrations WHERE version < 0",
+    },
+}
+
+// SQLCartStore keeps the carts in a relational database: PostgreSQL, or an SQLite file. A cart is a row of the carts
+// table, which holds its expiry, and its lines are rows of the cart_items table. Updates run in transactions that lock
+// the rows of the carts they change, so concurrent updates of a cart are applied one after the other.
+type SQLCartStore struct {
+    db      *sql.DB
+    dialect sqlDialect
+    ctx     context.Context
+    ttl     time.Duration
+    now     func() time.Time
+    limiter
+
+    done      chan struct{}
+    closeOnce sync.Once
+}
+
+// NewSQLCartStore opens a store on a PostgreSQL database, given its connection string, or on an SQLite database, given
+// the path of its file, and brings its schema up to date. Carts expire after ttl without updates; a ttl of zero
+// disables expiry.
+func NewSQLCartStore(database, dsn string, ttl time.Duration) (*SQLCartStore, error) {
+    log.Infof("Initializing SQL CartStore with database %s", database)
+    dialect, ok := sqlDialects[database]
+    if !ok {
+        return nil, fmt.Errorf("unsupported SQL database %q", database)
+    }
+    if database == "sqlite" {
+        // Transactions take the write lock right away, so they never fail when upgrading a read lock, and wait for
+        // each other instead of failing with SQLITE_BUSY.
+        dsn = "file:" + dsn + "?_txlock=immediate&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)"
+    }
+    db, err := sql.Open(dialect.driver, dsn)
+    if err != nil {
+        return nil, err
+    }
+    store := &SQLCartStore{db: db, dialect: dialect, ctx: context.Background(), ttl: ttl, now: time.Now,
+        done: make(chan struct{})}
+    if err := store.migrate(); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to migrate the cart database: %w", err)
+    }
+    if ttl > 0 {
+        go store.sweepLoop(min(ttl, maxSweepInterval))
+    }
+    return store, nil
+}
+
+// migrate applies the migrations that the database hasn't seen yet.
+func (store *SQLCartStore) migrate() error {
+    if _, err := store.db.ExecContext(store.ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+        return err
+    }
+    tx, err := store.db.BeginTx(store.ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.ExecContext(store.ctx, store.dialect.lockMigrations); err != nil {
+        return err
+    }
+    var version int
+    if err := tx.QueryRowContext(store.ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+        return err
+    }
+    for ; version < len(sqlMigrations); version++ {
+        log.Infof("Applying cart database migration %d", version+1)
+        if _, err := tx.ExecContext(store.ctx, sqlMigrations[version]); err != nil {
+            return fmt.Errorf("migration %d: %w", version+1, err)
+        }
+        if _, err := tx.ExecContext(store.ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version+1); err != nil {
+            return err
+        }
+    }
+    return tx.Commit()
+}
+
+// expiry returns the expiry of a cart written now, in Unix milliseconds, or zero if carts don't expire.
+func (store *SQLCartStore) expiry(now time.Time) int64 {
+    if store.ttl <= 0 {
+        return 0
+    }
+    return now.Add(store.ttl).UnixMilli()
+}
+
+// updateCarts applies a read-modify-write update to the carts of several users in a transaction. The rows of the carts
+// are locked first, in a fixed order so that concurrent updates can't deadlock, and the carts are rewritten with a
+// fresh expiry. A cart left without items is deleted.
+func (store *SQLCartStore) updateCarts(userIds []string, update func(carts []*pb.Cart) error) error {
+    tx, err := store.db.BeginTx(store.ctx, nil)
+    if err != nil {
+        return unavailable(err)
+    }
+    defer tx.Rollback()
+
+    now := store.now()
+    locked := append([]string(nil), userIds...)
+    sort.Strings(locked)
+    for _, userId := range locked {
+        // Upserting the cart row locks it, even if the cart doesn't exist yet.
+        _, err := tx.ExecContext(store.ctx, `INSERT INTO carts (user_id, expires_at) VALUES ($1, $2)
+            ON CONFLICT (user_id) DO UPDATE SET user_id = excluded.user_id`, userId, store.expiry(now))
+        if err != nil {
+            return unavailable(err)
+        }
+    }
+
+    carts := make([]*pb.Cart, len(userIds))
+    for i, userId := range userIds {
+        if carts[i], err = readSQLCart(store.ctx, tx, userId, now); err != nil {
+            return err
+        }
+    }
+    if err := update(carts); err != nil {
+        return err
+    }
+
+    for i, userId := range userIds {
+        if _, err := tx.ExecContext(store.ctx, "DELETE FROM cart_items WHERE user_id = $1", userId); err != nil {
+            return unavailable(err)
+        }
+        if len(carts[i].Items) == 0 {
+            if _, err := tx.ExecContext(store.ctx, "DELETE FROM carts WHERE user_id = $1", userId); err != nil {
+                return unavailable(err)
+            }
+            continue
+        }
+        if _, err := tx.ExecContext(store.ctx, "UPDATE carts SET expires_at = $1 WHERE user_id = $2", store.expiry(now), userId); err != nil {
+            return unavailable(err)
+        }
+        for position, item := range carts[i].Items {
+            _, err := tx.ExecContext(store.ctx, "INSERT INTO cart_items (user_id, product_id, quantity, position) VALUES ($1, $2, $3, $4)",
+                userId, item.ProductId, item.Quantity, position)
+            if err != nil {
+                return unavailable(err)
+            }
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return unavailable(err)
+    }
+    return nil
+}
+
+// updateCart applies a read-modify-write update to the cart of a user in a transaction, like updateCarts.
+func (store *SQLCartStore) updateCart(userId string, update func(cart *pb.Cart) error) error {
+    return store.updateCarts([]string{userId}, func(carts []*pb.Cart) error {
+        return update(carts[0])
+    })
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+    QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// readSQLCart reads the cart of a user, or returns an empty cart if the user has none or if it has expired.
+func readSQLCart(ctx context.Context, q sqlQuerier, userId string, now time.Time) (*pb.Cart, error) {
+    rows, err := q.QueryContext(ctx, `SELECT i.product_id, i.quantity FROM cart_items i JOIN carts c ON c.user_id = i.user_id
+        WHERE i.user_id = $1 AND (c.expires_at = 0 OR c.expires_at > $2) ORDER BY i.position`, userId, now.UnixMilli())
+    if err != nil {
+        return nil, unavailable(err)
+    }
+    defer rows.Close()
+
+    cart := &pb.Cart{UserId: userId}
+    for rows.Next() {
+        item := &pb.CartItem{}
+        if err := rows.Scan(&item.ProductId, &item.Quantity); err != nil {
+            return nil, status.Errorf(codes.Internal, "error reading cart: %v", err)
+        }
+        cart.Items = append(cart.Items, item)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, unavailable(err)
+    }
+    return cart, nil
+}
+
+func (store *SQLCartStore) AddItemAsync(userId, productId string, quantity int32) error {
+    log.Infof("AddItemAsync called with userId=%s, productId=%s, quantity=%d", userId, productId, quantity)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error { return addItem(cart, productId, quantity) })
+    })
+}
+
+func (store *SQLCartStore) GetCartAsync(userId string) (*pb.Cart, error) {
+    log.Infof("GetCartAsync called with userId=%s", userId)
+
+    return readSQLCart(store.ctx, store.db, userId, store.now())
+}
+
+func (store *SQLCartStore) EmptyCartAsync(userId string) error {
+    log.Infof("EmptyCartAsync called with userId=%s", userId)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        cart.Items = nil
+        return nil
+    })
+}
+
+func (store *SQLCartStore) RemoveItemAsync(userId, productId string) error {
+    log.Infof("RemoveItemAsync called with userId=%s, productId=%s", userId, productId)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        setItemQuantity(cart, productId, 0)
+        return nil
+    })
+}
+
+func (store *SQLCartStore) SetItemQuantityAsync(userId, productId string, quantity int32) error {
+    log.Infof("SetItemQuantityAsync called with userId=%s, productId=%s, quantity=%d", userId, productId, quantity)
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error {
+            setItemQuantity(cart, productId, quantity)
+            return nil
+        })
+    })
+}
+
+func (store *SQLCartStore) ReplaceCartAsync(userId string, items []*pb.CartItem) error {
+    log.Infof("ReplaceCartAsync called with userId=%s, items=%d", userId, len(items))
+
+    return store.updateCart(userId, func(cart *pb.Cart) error {
+        return store.limits.apply(cart, func() error {
+            replaceItems(cart, items)
+            return nil
+        })
+    })
+}
+
+func (store *SQLCartStore) MergeCartsAsync(sourceUserId, targetUserId string, maxQuantity int32) (*pb.Cart, error) {
+    log.Infof("MergeCartsAsync called with sourceUserId=%s, targetUserId=%s, maxQuantity=%d", sourceUserId, targetUserId, maxQuantity)
+
+    if err := checkMergeUsers(sourceUserId, targetUserId); err != nil {
+        return nil, err
+    }
+    var merged *pb.Cart
+    err := store.updateCarts([]string{sourceUserId, targetUserId}, func(carts []*pb.Cart) error {
+        if err := store.limits.apply(carts[1], func() error {
+            mergeCarts(carts[1], carts[0], maxQuantity)
+            return nil
+        }); err != nil {
+            return err
+        }
+        merged = carts[1]
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return merged, nil
+}
+
+// ListExpiringCartsAsync pages through the carts that expire within the given duration, in expiry order, and skips
+// those emptied or expired since the page was read, so they don't count against the limit.
+func (store *SQLCartStore) ListExpiringCartsAsync(within time.Duration, limit int) ([]*pb.ExpiringCart, error) {
+    log.Infof("ListExpiringCartsAsync called with within=%v, limit=%d", within, limit)
+
+    now := store.now()
+    pageSize := scanBatchSize
+    if limit > 0 {
+        pageSize = limit
+    }
+    var (
+        carts       []*pb.ExpiringCart
+        afterUser   string
+        afterExpiry = now.UnixMilli()
+    )
+    for {
+        rows, err := store.db.QueryContext(store.ctx, `SELECT user_id, expires_at FROM carts
+            WHERE (expires_at > $1 OR (expires_at = $1 AND user_id > $2)) AND expires_at <= $3
+            ORDER BY expires_at, user_id LIMIT $4`, afterExpiry, afterUser, now.Add(within).UnixMilli(), pageSize)
+        if err != nil {
+            return nil, unavailable(err)
+        }
+        var page []*pb.ExpiringCart
+        for rows.Next() {
+            var (
+                userId    string
+                expiresAt int64
+            )
+            if err := rows.Scan(&userId, &expiresAt); err != nil {
+                rows.Close()
+                return nil, status.Errorf(codes.Internal, "error reading cart: %v", err)
+            }
+            page = append(page, &pb.ExpiringCart{Cart: &pb.Cart{UserId: userId}, ExpiresAt: expiresAt})
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, unavailable(err)
+        }
+
+        for _, c := range page {
+            afterUser, afterExpiry = c.Cart.UserId, c.ExpiresAt
+            c.ExpiresAt = time.UnixMilli(c.ExpiresAt).Unix()
+            if c.Cart, err = readSQLCart(store.ctx, store.db, c.Cart.UserId, now); err != nil {
+                return nil, err
+            }
+            if len(c.Cart.Items) == 0 {
+                continue
+            }
+            carts = append(carts, c)
+            if limit > 0 && len(carts) == limit {
+                return carts, nil
+            }
+        }
+        if len(page) < pageSize {
+            return carts, nil
+        }
+    }
+}
+
+// ScanCartsAsync pages through the carts in user ID order, so that no query is left open while fn runs.
+func (store *SQLCartStore) ScanCartsAsync(fn func(cart *pb.Cart) error) error {
+    log.Info("ScanCartsAsync called")
+
+    after := ""
+    for {
+        now := store.now()
+        rows, err := store.db.QueryContext(store.ctx, `SELECT user_id FROM carts WHERE user_id > $1
+            AND (expires_at = 0 OR expires_at > $2) ORDER BY user_id LIMIT $3`, after, now.UnixMilli(), scanBatchSize)
+        if err != nil {
+            return unavailable(err)
+        }
+        var userIds []string
+        for rows.Next() {
+            var userId string
+            if err := rows.Scan(&userId); err != nil {
+                rows.Close()
+                return status.Errorf(codes.Internal, "error reading cart: %v", err)
+            }
+            userIds = append(userIds, userId)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return unavailable(err)
+        }
+
+        for _, userId := range userIds {
+            cart, err := readSQLCart(store.ctx, store.db, userId, now)
+            if err != nil {
+                return err
+            }
+            if len(cart.Items) == 0 { // emptied since
+                continue
+            }
+            if err := fn(cart); err != nil {
+                return err
+            }
+        }
+        if len(userIds) < scanBatchSize {
+            return nil
+        }
+        after = userIds[len(userIds)-1]
+    }
+}
+
+// sweepLoop deletes expired carts periodically, for the lifetime of the process.
+func (store *SQLCartStore) sweepLoop(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-store.done:
+            return
+        case <-ticker.C:
+        }
+        evicted, err := store.sweep()
+        if err != nil {
+            log.Warnf("Failed to delete expired carts: %v", err)
+        } else if evicted > 0 {
+            log.Infof("Deleted %d expired carts", evicted)
+        }
+    }
+}
+
+// sweep deletes the expired carts and returns how many were deleted.
+func (store *SQLCartStore) sweep() (int, error) {
+    tx, err := store.db.BeginTx(store.ctx, nil)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    now := store.now().UnixMilli()
+    _, err = tx.ExecContext(store.ctx, `DELETE FROM cart_items WHERE user_id IN
+        (SELECT user_id FROM carts WHERE expires_at > 0 AND expires_at <= $1)`, now)
+    if err != nil {
+        return 0, err
+    }
+    res, err := tx.ExecContext(store.ctx, "DELETE FROM carts WHERE expires_at > 0 AND expires_at <= $1", now)
+    if err != nil {
+        return 0, err
+    }
+    evicted, err := res.RowsAffected()
+    if err != nil {
+        return 0, err
+    }
+    return int(evicted), tx.Commit()
+}
+
+func (store *SQLCartStore) Ping() bool {
+    err := store.db.PingContext(store.ctx)
+    if err != nil {
+        log.Errorf("SQL CartStore Ping failed: %v", err)
+        return false
+    }
+    return true
+}
+
+// Close stops the background sweep and closes the connections to the database. Later calls do nothing.
+func (store *SQLCartStore) Close() error {
+    var err error
+    store.closeOnce.Do(func() {
+        close(store.done)
+        err = store.db.Close()
+    })
+    return err
+}
@@ -0,0 +1,174 @@
+package cartstore
+
+import (
+    "database/sql"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "google.golang.org/protobuf/proto"
+
+    pb "cartservice/genproto"
+)
+
+// newTestSQLCartStore returns a store backed by an SQLite file in a temporary directory.
+func newTestSQLCartStore(t *testing.T, path string, ttl time.Duration) *SQLCartStore {
+    t.Helper()
+    quietLogs(t)
+    if path == "" {
+        path = filepath.Join(t.TempDir(), "carts.db")
+    }
+    store, err := NewSQLCartStore("sqlite", path, ttl)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { store.Close() })
+    return store
+}
+
+func TestSQLCartStoreConformance(t *testing.T) {
+    ConformanceSuite{
+        NewStore: func(t *testing.T) CartStore {
+            return newTestSQLCartStore(t, "", DefaultCartTTL)
+        },
+        NewBrokenStore: func(t *testing.T) CartStore {
+            store := newTestSQLCartStore(t, "", DefaultCartTTL)
+            store.Close()
+            return store
+        },
+    }.Run(t)
+}
+
+func TestSQLCartStorePersistsAcrossRestarts(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "carts.db")
+    store := newTestSQLCartStore(t, path, DefaultCartTTL)
+    for _, id := range []string{"B", "A", "C"} {
+        if err := store.AddItemAsync("user", id, 2); err != nil {
+            t.Fatal(err)
+        }
+    }
+    store.Close()
+
+    // Reopening the database must not apply the migrations again.
+    reopened := newTestSQLCartStore(t, path, DefaultCartTTL)
+    var versions int
+    if err := reopened.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&versions); err != nil {
+        t.Fatal(err)
+    }
+    if versions != len(sqlMigrations) {
+        t.Errorf("%d migrations recorded, want %d", versions, len(sqlMigrations))
+    }
+
+    cart, err := reopened.GetCartAsync("user")
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := &pb.Cart{UserId: "user", Items: []*pb.CartItem{
+        {ProductId: "B", Quantity: 2}, {ProductId: "A", Quantity: 2}, {ProductId: "C", Quantity: 2},
+    }}
+    if !proto.Equal(cart, want) {
+        t.Errorf("cart = %v, want %v with its lines in order", cart, want)
+    }
+}
+
+func TestSQLCartStoreExpiry(t *testing.T) {
+    store := newTestSQLCartStore(t, "", time.Hour)
+    clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+    store.now = clock.Now
+
+    if err := store.AddItemAsync("alice", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+    clock.Advance(40 * time.Minute)
+    if err := store.AddItemAsync("bob", "OLJCESPC7Z", 1); err != nil {
+        t.Fatal(err)
+    }
+
+    carts, err := store.ListExpiringCartsAsync(30*time.Minute, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(carts) != 1 || carts[0].Cart.UserId != "alice" || len(carts[0].Cart.Items) != 1 {
+        t.Errorf("expiring carts = %v, want only alice's", carts)
+    }
+    if want := clock.Now().Add(20 * time.Minute).Unix(); len(carts) == 1 && carts[0].ExpiresAt != want {
+        t.Errorf("alice's cart expires at %d, want %d", carts[0].ExpiresAt, want)
+    }
+    if carts, _ := store.ListExpiringCartsAsync(2*time.Hour, 1); len(carts) != 1 || carts[0].Cart.UserId != "alice" {
+        t.Errorf("limited expiring carts = %v, want only alice's", carts)
+    }
+
+    clock.Advance(30 * time.Minute)
+    if cart, _ := store.GetCartAsync("alice"); len(cart.Items) != 0 {
+        t.Errorf("alice's cart = %v, want it expired", cart)
+    }
+    if evicted, err := store.sweep(); err != nil || evicted != 1 {
+        t.Errorf("sweep() = %d, %v, want 1 cart deleted", evicted, err)
+    }
+    if cart, _ := store.GetCartAsync("bob"); len(cart.Items) != 1 {
+        t.Errorf("bob's cart = %v, want it kept", cart)
+    }
+
+    // An expired cart that wasn't swept yet starts over when it's updated.
+    clock.Advance(2 * time.Hour)
+    if err := store.AddItemAsync("bob", "66VCHSJNUP", 1); err != nil {
+        t.Fatal(err)
+    }
+    if cart, _ := store.GetCartAsync("bob"); len(cart.Items) != 1 || cart.Items[0].ProductId != "66VCHSJNUP" {
+        t.Errorf("bob's cart = %v, want only the new item", cart)
+    }
+}
+
+// TestSQLCartStorePostgres runs against the PostgreSQL database of CART_TEST_POSTGRES_DSN, if it's set. It drops the
+// tables of the store first, so it must not be pointed at a database in use.
+func TestSQLCartStoreExpiringLimitSkipsEmptyCarts(t *testing.T) {
+    store := newTestSQLCartStore(t, "", time.Hour)
+    clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+    store.now = clock.Now
+
+    for _, userId := range []string{"alice", "bob", "carol"} {
+        if err := store.AddItemAsync(userId, "OLJCESPC7Z", 1); err != nil {
+            t.Fatal(err)
+        }
+        clock.Advance(time.Minute)
+    }
+    // Alice's and Bob's carts lose their items between the listing and the reading of the carts.
+    if _, err := store.db.Exec("DELETE FROM cart_items WHERE user_id IN ('alice', 'bob')"); err != nil {
+        t.Fatal(err)
+    }
+
+    carts, err := store.ListExpiringCartsAsync(2*time.Hour, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(carts) != 1 || carts[0].Cart.UserId != "carol" {
+        t.Errorf("limited expiring carts = %v, want carol's", carts)
+    }
+}
+
+func TestSQLCartStorePostgres(t *testing.T) {
+    dsn := os.Getenv("CART_TEST_POSTGRES_DSN")
+    if dsn == "" {
+        t.Skip("CART_TEST_POSTGRES_DSN not set")
+    }
+    quietLogs(t)
+    ConformanceSuite{
+        NewStore: func(t *testing.T) CartStore {
+            db, err := sql.Open("pgx", dsn)
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer db.Close()
+            if _, err := db.Exec("DROP TABLE IF EXISTS cart_items, carts, schema_migrations"); err != nil {
+                t.Fatal(err)
+            }
+            store, err := NewSQLCartStore("postgres", dsn, DefaultCartTTL)
+            if err != nil {
+                t.Fatal(err)
+            }
+            t.Cleanup(func() { store.Close() })
+            return store
+        },
+    }.Run(t)
+}
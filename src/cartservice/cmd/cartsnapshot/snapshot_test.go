@@ -0,0 +1,110 @@
+package main
+
+import (
+    "bytes"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/sirupsen/logrus"
+    "google.golang.org/protobuf/proto"
+
+    "cartservice/cartstore"
+    pb "cartservice/genproto"
+    "logging"
+)
+
+// openTestStore opens a store, and closes it when the test ends.
+func openTestStore(t *testing.T, storeURL string) cartstore.CartStore {
+    t.Helper()
+    log := logging.New("cartsnapshot")
+    log.Logger.SetLevel(logrus.WarnLevel)
+    cartstore.SetLogger(log)
+    store, err := openStore(storeURL, cartstore.DefaultCartTTL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { closeStore(store) })
+    return store
+}
+
+// allCarts returns the carts of a store by user ID.
+func allCarts(t *testing.T, store cartstore.CartStore) map[string]*pb.Cart {
+    t.Helper()
+    carts := make(map[string]*pb.Cart)
+    if err := store.ScanCartsAsync(func(cart *pb.Cart) error { carts[cart.UserId] = cart; return nil }); err != nil {
+        t.Fatal(err)
+    }
+    return carts
+}
+
+func checkSameCarts(t *testing.T, got, want map[string]*pb.Cart) {
+    t.Helper()
+    if len(got) != len(want) {
+        t.Errorf("%d carts, want %d", len(got), len(want))
+    }
+    for userId, cart := range want {
+        if !proto.Equal(got[userId], cart) {
+            t.Errorf("cart of %s = %v, want %v with its lines in order", userId, got[userId], cart)
+        }
+    }
+}
+
+func TestExportImport(t *testing.T) {
+    source := openTestStore(t, "redis://"+miniredis.RunT(t).Addr()+"?encoding=proto-zstd")
+    for _, add := range []struct {
+        user, product string
+        quantity      int32
+    }{{"alice", "B", 2}, {"alice", "A", 1}, {"bob", "C", 5}} {
+        if err := source.AddItemAsync(add.user, add.product, add.quantity); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    var snapshot bytes.Buffer
+    if n, err := exportCarts(source, &snapshot); err != nil || n != 2 {
+        t.Fatalf("exportCarts() = %d, %v, want 2 carts", n, err)
+    }
+    if lines := strings.Count(snapshot.String(), "\n"); lines != 2 {
+        t.Errorf("snapshot has %d lines, want one per cart:\n%s", lines, snapshot.String())
+    }
+
+    // Importing replaces the carts already in the target.
+    target := openTestStore(t, "sqlite:"+filepath.Join(t.TempDir(), "carts.db"))
+    if err := target.AddItemAsync("alice", "Z", 9); err != nil {
+        t.Fatal(err)
+    }
+    if n, err := importCarts(target, bytes.NewReader(snapshot.Bytes())); err != nil || n != 2 {
+        t.Fatalf("importCarts() = %d, %v, want 2 carts", n, err)
+    }
+    checkSameCarts(t, allCarts(t, target), allCarts(t, source))
+
+    if _, err := importCarts(target, strings.NewReader("{\"userId\":\"carol\"}\nnot json\n")); err == nil ||
+        !strings.Contains(err.Error(), "line 2") {
+        t.Errorf("importing a malformed snapshot returned %v, want an error on line 2", err)
+    }
+    if _, err := importCarts(target, strings.NewReader(`{"items":[{"productId":"A","quantity":1}]}`)); err == nil {
+        t.Error("importing a cart without a user id succeeded")
+    }
+}
+
+func TestCopy(t *testing.T) {
+    source := openTestStore(t, "memory:")
+    if err := source.SetItemQuantityAsync("alice", "A", 3); err != nil {
+        t.Fatal(err)
+    }
+    target := openTestStore(t, "redis://"+miniredis.RunT(t).Addr())
+    if n, err := copyCarts(source, target); err != nil || n != 1 {
+        t.Fatalf("copyCarts() = %d, %v, want 1 cart", n, err)
+    }
+    checkSameCarts(t, allCarts(t, target), allCarts(t, source))
+}
+
+func TestOpenStore(t *testing.T) {
+    for _, storeURL := range []string{"mongodb://localhost", "redis://localhost:6379?encoding=xml", "::"} {
+        if _, err := openStore(storeURL, cartstore.DefaultCartTTL); err == nil {
+            t.Errorf("openStore(%q) succeeded", storeURL)
+        }
+    }
+}
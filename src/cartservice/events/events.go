@@ -0,0 +1,80 @@
+// Package events publishes the changes of carts, so that services outside cartservice, such as recommendations and
+// analytics, can react to them.
+package events
+
+import (
+    "context"
+    "crypto/rand"
+    "fmt"
+    "time"
+
+    "github.com/sirupsen/logrus"
+
+    "logging"
+)
+
+// Type tells what happened to a cart.
+type Type string
+
+const (
+    // ItemAdded is published when units of a product are added to a cart.
+    ItemAdded Type = "item_added"
+    // QuantityChanged is published when the quantity of a product is set. A quantity of zero means the product was
+    // removed from the cart.
+    QuantityChanged Type = "quantity_changed"
+    // CartEmptied is published when every item is removed from a cart at once.
+    CartEmptied Type = "cart_emptied"
+    // CartsMerged is published when the cart of a user is folded into the cart of another.
+    CartsMerged Type = "carts_merged"
+)
+
+// Event is a change of a cart, as consumers receive it in JSON.
+type Event struct {
+    // ID identifies the event, so that consumers can ignore the copies of an event that was delivered again.
+    ID   string    `json:"id"`
+    Type Type      `json:"type"`
+    Time time.Time `json:"time"`
+
+    // UserID is the user whose cart changed. For CartsMerged, it's the user whose cart received the items.
+    UserID string `json:"user_id"`
+    // SourceUserID is the user whose cart was merged and deleted, for CartsMerged only.
+    SourceUserID string `json:"source_user_id,omitempty"`
+
+    // ProductID and Quantity are set for ItemAdded, the units added, and for QuantityChanged, the new quantity. Like
+    // the other empty fields, a quantity of zero is left out of the JSON.
+    ProductID string `json:"product_id,omitempty"`
+    Quantity  int32  `json:"quantity,omitempty"`
+
+    // RequestID is the request that changed the cart.
+    RequestID string `json:"request_id,omitempty"`
+}
+
+// New creates an event of the given type that happens now, with a new ID.
+func New(eventType Type, userID string) Event {
+    return Event{ID: newID(), Type: eventType, Time: time.Now().UTC(), UserID: userID}
+}
+
+// newID generates a random (version 4) UUID string.
+func newID() string {
+    b := make([]byte, 16)
+    _, _ = rand.Read(b)
+    b[6] = (b[6] & 0x0f) | 0x40
+    b[8] = (b[8] & 0x3f) | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Publisher delivers events to their consumers.
+type Publisher interface {
+    // Publish delivers events in order. It either delivers them all or fails, in which case some of them may still
+    // have been delivered, so consumers should expect duplicates.
+    Publish(ctx context.Context, events ...Event) error
+    // Close releases the resources of the publisher.
+    Close() error
+}
+
+var log = logging.New("cartservice")
+
+// SetLogger makes the publishers write their log entries through the given logger.
+func SetLogger(l *logrus.Entry) {
+    log = l
+}
@@ -0,0 +1,47 @@
+package events
+
+import (
+    "context"
+    "sync"
+)
+
+// DefaultMemoryCapacity is how many events a MemoryPublisher keeps by default.
+const DefaultMemoryCapacity = 10000
+
+// MemoryPublisher keeps the latest events in memory, for local runs and tests. Once it holds its capacity, each new
+// event pushes out the oldest one.
+type MemoryPublisher struct {
+    mu       sync.Mutex
+    events   []Event
+    capacity int
+}
+
+// NewMemoryPublisher creates a publisher that keeps up to capacity events. A capacity of zero or less keeps
+// DefaultMemoryCapacity events.
+func NewMemoryPublisher(capacity int) *MemoryPublisher {
+    if capacity <= 0 {
+        capacity = DefaultMemoryCapacity
+    }
+    return &MemoryPublisher{capacity: capacity}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, events ...Event) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.events = append(p.events, events...)
+    if over := len(p.events) - p.capacity; over > 0 {
+        p.events = append(p.events[:0], p.events[over:]...)
+    }
+    return nil
+}
+
+// Events returns a copy of the events kept, oldest first.
+func (p *MemoryPublisher) Events() []Event {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return append([]Event(nil), p.events...)
+}
+
+func (p *MemoryPublisher) Close() error {
+    return nil
+}
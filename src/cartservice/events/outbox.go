@@ -0,0 +1,184 @@
+package events
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+)
+
+const (
+    // DefaultOutboxCapacity is how many undelivered events an Outbox holds by default.
+    DefaultOutboxCapacity = 10000
+
+    // outboxBatchSize is the largest number of events handed to the publisher at once.
+    outboxBatchSize = 100
+    // outboxTimeout bounds each delivery attempt, and the last one made by Close.
+    outboxTimeout = 10 * time.Second
+
+    // outboxRetryBackoff and maxOutboxRetryBackoff bound the exponential backoff between failed deliveries.
+    outboxRetryBackoff    = 100 * time.Millisecond
+    maxOutboxRetryBackoff = 30 * time.Second
+)
+
+// errNothingPending tells that the outbox has no event left to deliver.
+var errNothingPending = errors.New("no pending events")
+
+// Outbox queues events in front of a publisher, so that cart updates neither wait for the publisher nor fail with
+// it. A background loop delivers the queued events in order, in batches, and retries with an exponential backoff
+// while the publisher fails. Events stay queued until they're delivered; if the queue fills up because the publisher
+// stays down, the oldest events are dropped, and a warning is logged.
+//
+// The queue lives in memory, so the events not yet delivered are lost if the process dies. Where the process may be
+// frozen between requests, as in Lambda, Flush delivers the queue before each response. Delivery is still best
+// effort: the outbox doesn't share the transactions of the cart store. Close delivers what it can before returning.
+type Outbox struct {
+    publisher Publisher
+    capacity  int
+
+    mu      sync.Mutex
+    pending []Event
+    // first is the sequence number of pending[0]; every event queued gets the next number.
+    first   uint64
+    dropped int
+
+    // delivering holds a token while a delivery is under way. It serializes the deliveries, so that events go out in
+    // order and only once, and lets Flush give up waiting for one when its context is done.
+    delivering chan struct{}
+
+    wake      chan struct{}
+    done      chan struct{}
+    stopped   chan struct{}
+    closeOnce sync.Once
+}
+
+// NewOutbox creates an outbox that holds up to capacity undelivered events for publisher, and starts its delivery
+// loop. A capacity of zero or less holds DefaultOutboxCapacity events.
+func NewOutbox(publisher Publisher, capacity int) *Outbox {
+    if capacity <= 0 {
+        capacity = DefaultOutboxCapacity
+    }
+    o := &Outbox{
+        publisher:  publisher,
+        capacity:   capacity,
+        delivering: make(chan struct{}, 1),
+        wake:       make(chan struct{}, 1),
+        done:       make(chan struct{}),
+        stopped:    make(chan struct{}),
+    }
+    go o.deliverLoop()
+    return o
+}
+
+// Publish queues the events for delivery. It never fails.
+func (o *Outbox) Publish(ctx context.Context, events ...Event) error {
+    o.mu.Lock()
+    o.pending = append(o.pending, events...)
+    if over := len(o.pending) - o.capacity; over > 0 {
+        o.pending = append(o.pending[:0], o.pending[over:]...)
+        o.first += uint64(over)
+        o.dropped += over
+        log.Warnf("event outbox is full, dropped the %d oldest events (%d so far)", over, o.dropped)
+    }
+    o.mu.Unlock()
+
+    select {
+    case o.wake <- struct{}{}:
+    default:
+    }
+    return nil
+}
+
+// Pending returns the number of events waiting for delivery.
+func (o *Outbox) Pending() int {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    return len(o.pending)
+}
+
+// Flush delivers the queued events right away, and returns the error of the publisher if it fails. It gives up with
+// the error of ctx once ctx is done, so a deadline bounds how long it holds a request; each batch otherwise takes up to
+// outboxTimeout.
+func (o *Outbox) Flush(ctx context.Context) error {
+    for {
+        if err := o.deliverBatch(ctx); err != nil {
+            if err == errNothingPending {
+                return nil
+            }
+            return err
+        }
+    }
+}
+
+// deliverBatch hands the oldest queued events to the publisher, and dequeues them once they're delivered.
+func (o *Outbox) deliverBatch(ctx context.Context) error {
+    select {
+    case o.delivering <- struct{}{}:
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+    defer func() { <-o.delivering }()
+
+    o.mu.Lock()
+    batch := append([]Event(nil), o.pending[:min(len(o.pending), outboxBatchSize)]...)
+    first := o.first
+    o.mu.Unlock()
+    if len(batch) == 0 {
+        return errNothingPending
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, outboxTimeout)
+    defer cancel()
+    if err := o.publisher.Publish(ctx, batch...); err != nil {
+        return err
+    }
+
+    // Events may have been dropped meanwhile, shifting the queue, so the delivered ones are found by their sequence
+    // numbers.
+    o.mu.Lock()
+    defer o.mu.Unlock()
+    if delivered := first + uint64(len(batch)); delivered > o.first {
+        n := min(int(delivered-o.first), len(o.pending))
+        o.pending = append(o.pending[:0], o.pending[n:]...)
+        o.first += uint64(n)
+    }
+    return nil
+}
+
+// deliverLoop delivers the queued events whenever some are published, until the outbox is closed.
+func (o *Outbox) deliverLoop() {
+    defer close(o.stopped)
+    var retry <-chan time.Time
+    backoff := outboxRetryBackoff
+    for {
+        select {
+        case <-o.done:
+            return
+        case <-o.wake:
+        case <-retry:
+        }
+        retry = nil
+        if err := o.Flush(context.Background()); err != nil {
+            log.Warnf("failed to publish cart events, retrying in %v: %v", backoff, err)
+            retry = time.After(backoff)
+            backoff = min(2*backoff, maxOutboxRetryBackoff)
+            continue
+        }
+        backoff = outboxRetryBackoff
+    }
+}
+
+// Close stops the delivery loop, makes a last attempt to deliver the queued events, and closes the publisher. The
+// events still queued after that are lost.
+func (o *Outbox) Close() error {
+    var err error
+    o.closeOnce.Do(func() {
+        close(o.done)
+        <-o.stopped
+        if err = o.Flush(context.Background()); err != nil {
+            log.Warnf("failed to publish %d cart events before closing: %v", o.Pending(), err)
+        }
+        err = errors.Join(err, o.publisher.Close())
+    })
+    return err
+}
@@ -0,0 +1,190 @@
+package events
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/sirupsen/logrus"
+)
+
+// quietLogs keeps the warnings of failed deliveries out of the test output.
+func quietLogs(t *testing.T) {
+    level := log.Logger.GetLevel()
+    log.Logger.SetLevel(logrus.ErrorLevel)
+    t.Cleanup(func() { log.Logger.SetLevel(level) })
+}
+
+// flakyPublisher records the events it's given, and fails while it's down.
+type flakyPublisher struct {
+    mu     sync.Mutex
+    down   bool
+    events []Event
+    calls  int
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, events ...Event) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.calls++
+    if p.down {
+        return errors.New("publisher is down")
+    }
+    p.events = append(p.events, events...)
+    return nil
+}
+
+func (p *flakyPublisher) Close() error {
+    return nil
+}
+
+func (p *flakyPublisher) setDown(down bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.down = down
+}
+
+// delivered returns the user IDs of the events delivered so far, in order.
+func (p *flakyPublisher) delivered() []string {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    ids := make([]string, len(p.events))
+    for i, event := range p.events {
+        ids[i] = event.UserID
+    }
+    return ids
+}
+
+// waitFor polls a condition until it holds, or fails the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+    }
+}
+
+func publishUsers(t *testing.T, p Publisher, users ...string) {
+    t.Helper()
+    for _, user := range users {
+        if err := p.Publish(context.Background(), New(ItemAdded, user)); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestOutboxDeliversInOrder(t *testing.T) {
+    publisher := &flakyPublisher{}
+    outbox := NewOutbox(publisher, 0)
+    defer outbox.Close()
+
+    var want []string
+    for i := 0; i < 3*outboxBatchSize; i++ {
+        want = append(want, fmt.Sprint("user-", i))
+    }
+    publishUsers(t, outbox, want...)
+    waitFor(t, "the events to be delivered", func() bool { return outbox.Pending() == 0 })
+    if got := publisher.delivered(); fmt.Sprint(got) != fmt.Sprint(want) {
+        t.Errorf("delivered %v, want %v", got, want)
+    }
+}
+
+func TestOutboxRetriesFailedDeliveries(t *testing.T) {
+    quietLogs(t)
+    publisher := &flakyPublisher{down: true}
+    outbox := NewOutbox(publisher, 0)
+    defer outbox.Close()
+
+    publishUsers(t, outbox, "alice", "bob")
+    waitFor(t, "a failed delivery", func() bool {
+        publisher.mu.Lock()
+        defer publisher.mu.Unlock()
+        return publisher.calls > 0
+    })
+    if err := outbox.Flush(context.Background()); err == nil {
+        t.Error("Flush() succeeded while the publisher is down")
+    }
+    if n := outbox.Pending(); n != 2 {
+        t.Errorf("%d events pending after failed deliveries, want 2", n)
+    }
+
+    publisher.setDown(false)
+    waitFor(t, "the events to be delivered", func() bool { return outbox.Pending() == 0 })
+    if got := publisher.delivered(); fmt.Sprint(got) != "[alice bob]" {
+        t.Errorf("delivered %v, want [alice bob]", got)
+    }
+}
+
+func TestOutboxDropsOldestEventsWhenFull(t *testing.T) {
+    quietLogs(t)
+    publisher := &flakyPublisher{down: true}
+    outbox := NewOutbox(publisher, 2)
+
+    publishUsers(t, outbox, "a", "b", "c", "d")
+    if n := outbox.Pending(); n != 2 {
+        t.Errorf("%d events pending, want the capacity of 2", n)
+    }
+
+    // Close delivers what's left.
+    publisher.setDown(false)
+    if err := outbox.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if got := publisher.delivered(); fmt.Sprint(got) != "[c d]" {
+        t.Errorf("delivered %v, want the newest events [c d]", got)
+    }
+}
+
+// stuckPublisher hangs on every delivery until it's released or the context of the delivery is done.
+type stuckPublisher struct {
+    release chan struct{}
+}
+
+func (p *stuckPublisher) Publish(ctx context.Context, events ...Event) error {
+    select {
+    case <-p.release:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+func (p *stuckPublisher) Close() error {
+    return nil
+}
+
+func TestOutboxFlushGivesUpAtDeadline(t *testing.T) {
+    quietLogs(t)
+    publisher := &stuckPublisher{release: make(chan struct{})}
+    outbox := NewOutbox(publisher, 0)
+    defer outbox.Close()
+    defer close(publisher.release)
+
+    // The delivery loop picks the event up and hangs on it, so Flush first waits for that delivery.
+    publishUsers(t, outbox, "alice")
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    if err := outbox.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("Flush() = %v, want %v", err, context.DeadlineExceeded)
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Errorf("Flush() returned after %v, past its deadline", elapsed)
+    }
+    if n := outbox.Pending(); n != 1 {
+        t.Errorf("%d events pending, want 1", n)
+    }
+}
+
+func TestMemoryPublisherKeepsLatestEvents(t *testing.T) {
+    publisher := NewMemoryPublisher(2)
+    publishUsers(t, publisher, "a", "b", "c")
+    events := publisher.Events()
+    if len(events) != 2 || events[0].UserID != "b" || events[1].UserID != "c" {
+        t.Errorf("events = %v, want the ones of b and c", events)
+    }
+}
@@ -0,0 +1,88 @@
+package events
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisStreamPublisher(t *testing.T) {
+    quietLogs(t)
+    mr := miniredis.RunT(t)
+    publisher := NewRedisStreamPublisher(mr.Addr(), "", DefaultStream, 0)
+    defer publisher.Close()
+
+    added := New(ItemAdded, "alice")
+    added.ProductID, added.Quantity = "OLJCESPC7Z", 2
+    if err := publisher.Publish(context.Background(), added, New(CartEmptied, "alice")); err != nil {
+        t.Fatal(err)
+    }
+
+    entries, err := mr.Stream(DefaultStream)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 2 {
+        t.Fatalf("stream has %d entries, want 2", len(entries))
+    }
+    // Values alternate field names and values: type, user_id, event.
+    values := entries[0].Values
+    if len(values) != 6 || values[1] != string(ItemAdded) || values[3] != "alice" {
+        t.Errorf("first entry = %v, want the item_added event of alice", values)
+    }
+    var event Event
+    if err := json.Unmarshal([]byte(values[5]), &event); err != nil {
+        t.Fatal(err)
+    }
+    if event.ID != added.ID || event.ProductID != "OLJCESPC7Z" || event.Quantity != 2 {
+        t.Errorf("first event = %+v, want %+v", event, added)
+    }
+    if entries[1].Values[1] != string(CartEmptied) {
+        t.Errorf("second entry = %v, want the cart_emptied event", entries[1].Values)
+    }
+
+    mr.Close()
+    if err := publisher.Publish(context.Background(), added); err == nil {
+        t.Error("Publish() succeeded while Redis is down")
+    }
+}
+
+func TestWebhookPublisher(t *testing.T) {
+    quietLogs(t)
+    var received struct {
+        Events []Event `json:"events"`
+    }
+    status := http.StatusNoContent
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        if got, want := r.Header.Get(SignatureHeader), Sign([]byte("secret"), body); got != want {
+            t.Errorf("signature = %q, want %q", got, want)
+        }
+        if err := json.Unmarshal(body, &received); err != nil {
+            t.Error(err)
+        }
+        w.WriteHeader(status)
+    }))
+    defer server.Close()
+    publisher := NewWebhookPublisher(server.URL, "secret")
+    defer publisher.Close()
+
+    merged := New(CartsMerged, "user")
+    merged.SourceUserID = "anonymous"
+    if err := publisher.Publish(context.Background(), merged); err != nil {
+        t.Fatal(err)
+    }
+    if len(received.Events) != 1 || received.Events[0].ID != merged.ID || received.Events[0].SourceUserID != "anonymous" {
+        t.Errorf("webhook received %+v, want %+v", received.Events, merged)
+    }
+
+    status = http.StatusServiceUnavailable
+    if err := publisher.Publish(context.Background(), merged); err == nil {
+        t.Error("Publish() succeeded while the webhook answers 503")
+    }
+}
@@ -0,0 +1,61 @@
+package events
+
+import (
+    "context"
+    "encoding/json"
+
+    "github.com/redis/go-redis/v9"
+)
+
+const (
+    // DefaultStream is the Redis stream the events are added to by default.
+    DefaultStream = "cart-events"
+    // DefaultStreamMaxLen is about how many events the stream keeps by default; older entries are trimmed.
+    DefaultStreamMaxLen = 100000
+)
+
+// RedisStreamPublisher adds events to a Redis stream, which consumers read with XREAD or with a consumer group. Each
+// entry has the event's type and user ID as fields of their own, so they can be read without parsing, and the whole
+// event in JSON in the event field.
+type RedisStreamPublisher struct {
+    rdb    *redis.Client
+    stream string
+    maxLen int64
+}
+
+// NewRedisStreamPublisher creates a publisher on the stream of a Redis server, trimmed to about maxLen entries. A
+// maxLen of zero or less keeps DefaultStreamMaxLen entries.
+func NewRedisStreamPublisher(redisAddr, redisPassword, stream string, maxLen int64) *RedisStreamPublisher {
+    log.Infof("Initializing Redis stream publisher with address %s and stream %s", redisAddr, stream)
+    if maxLen <= 0 {
+        maxLen = DefaultStreamMaxLen
+    }
+    rdb := redis.NewClient(&redis.Options{
+        Addr:     redisAddr,
+        Password: redisPassword, // no password set if empty
+    })
+    return &RedisStreamPublisher{rdb: rdb, stream: stream, maxLen: maxLen}
+}
+
+// Publish adds the events to the stream in a single transaction, so either all of them are added or none is.
+func (p *RedisStreamPublisher) Publish(ctx context.Context, events ...Event) error {
+    values := make([][]interface{}, len(events))
+    for i, event := range events {
+        data, err := json.Marshal(event)
+        if err != nil {
+            return err
+        }
+        values[i] = []interface{}{"type", string(event.Type), "user_id", event.UserID, "event", data}
+    }
+    _, err := p.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+        for _, v := range values {
+            pipe.XAdd(ctx, &redis.XAddArgs{Stream: p.stream, MaxLen: p.maxLen, Approx: true, Values: v})
+        }
+        return nil
+    })
+    return err
+}
+
+func (p *RedisStreamPublisher) Close() error {
+    return p.rdb.Close()
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "time"
+)
+
+const (
+    // SignatureHeader carries the HMAC-SHA256 of a webhook's body, keyed with the shared secret, as
+    // "sha256=<hex digest>".
+    SignatureHeader = "X-Cart-Events-Signature"
+
+    // webhookTimeout bounds a webhook call, including the reading of its response.
+    webhookTimeout = 10 * time.Second
+)
+
+// WebhookPublisher posts events to an HTTP endpoint, as a JSON object whose events field lists them. The endpoint
+// must answer with a 2xx status code; anything else is a failed delivery.
+type WebhookPublisher struct {
+    url    string
+    secret []byte
+    client *http.Client
+}
+
+// NewWebhookPublisher creates a publisher that posts to url. If secret isn't empty, each request is signed with it in
+// the SignatureHeader header, so that the endpoint can check where the events come from.
+func NewWebhookPublisher(url, secret string) *WebhookPublisher {
+    log.Infof("Initializing webhook publisher with URL %s", url)
+    return &WebhookPublisher{url: url, secret: []byte(secret), client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, events ...Event) error {
+    body, err := json.Marshal(struct {
+        Events []Event `json:"events"`
+    }{events})
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if len(p.secret) > 0 {
+        req.Header.Set(SignatureHeader, Sign(p.secret, body))
+    }
+    resp, err := p.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    _, _ = io.Copy(io.Discard, resp.Body)
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("webhook answered with status %s", resp.Status)
+    }
+    return nil
+}
+
+func (p *WebhookPublisher) Close() error {
+    p.client.CloseIdleConnections()
+    return nil
+}
+
+// Sign returns the value of the SignatureHeader header for a body, which a webhook endpoint compares with the one it
+// received.
+func Sign(secret, body []byte) string {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
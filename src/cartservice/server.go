@@ -1,38 +1,59 @@
 package main
 
 import (
+    "context"
+    "crypto/rand"
     "encoding/base64"
     "fmt"
     "io"
-    "log"
     "net/http"
     "os"
     "strconv"
     "strings"
+    "time"
 
     "github.com/aws/aws-lambda-go/lambda"
+    "github.com/sirupsen/logrus"
     "google.golang.org/grpc/codes"
     "google.golang.org/grpc/status"
     "google.golang.org/protobuf/proto"
 
-    "main/cartstore"
-    pb "main/genproto"
+    "cartservice/cartstore"
+    "cartservice/events"
+    pb "cartservice/genproto"
+    "logging"
 )
 
 var (
     runningInLambda = os.Getenv("RUN_LAMBDA") == "1"
 
+    log = logging.New("cartservice")
+
     svc *CartService = nil
 )
 
 const (
-    defaultPort = "7070"
+    defaultPort       = "7070"
+    defaultSQLitePath = "carts.db"
+
+    // lambdaOutboxFlushTimeout bounds how long a Lambda response waits for the event outbox to be delivered.
+    lambdaOutboxFlushTimeout = 2 * time.Second
 
     addItemRPC   = "add-item"
     getCartRPC   = "get-cart"
     emptyCartRPC = "empty-cart"
+
+    removeItemRPC        = "remove-item"
+    setItemQuantityRPC   = "set-item-quantity"
+    mergeCartsRPC        = "merge-carts"
+    listExpiringCartsRPC = "list-expiring-carts"
 )
 
+func init() {
+    cartstore.SetLogger(log)
+    events.SetLogger(log)
+}
+
 // callRPC chooses the correct handler function to call.
 func callRPC(msg *proto.Message, reqData *RequestData) (proto.Message, error) {
     switch reqData.Headers["rpc-name"] {
@@ -40,8 +61,16 @@ func callRPC(msg *proto.Message, reqData *RequestData) (proto.Message, error) {
         return svc.AddItem((*msg).(*pb.AddItemRequest), &reqData.Headers)
     case getCartRPC:
         return svc.GetCart((*msg).(*pb.GetCartRequest), &reqData.Headers)
-    default:
+    case emptyCartRPC:
         return svc.EmptyCart((*msg).(*pb.EmptyCartRequest), &reqData.Headers)
+    case removeItemRPC:
+        return svc.RemoveItem((*msg).(*pb.RemoveItemRequest), &reqData.Headers)
+    case setItemQuantityRPC:
+        return svc.SetItemQuantity((*msg).(*pb.SetItemQuantityRequest), &reqData.Headers)
+    case mergeCartsRPC:
+        return svc.MergeCarts((*msg).(*pb.MergeCartsRequest), &reqData.Headers)
+    default:
+        return svc.ListExpiringCarts((*msg).(*pb.ListExpiringCartsRequest), &reqData.Headers)
     }
 }
 
@@ -54,6 +83,14 @@ func determineMessageType(rpcName string) proto.Message {
         return &pb.GetCartRequest{}
     case emptyCartRPC:
         return &pb.EmptyCartRequest{}
+    case removeItemRPC:
+        return &pb.RemoveItemRequest{}
+    case setItemQuantityRPC:
+        return &pb.SetItemQuantityRequest{}
+    case mergeCartsRPC:
+        return &pb.MergeCartsRequest{}
+    case listExpiringCartsRPC:
+        return &pb.ListExpiringCartsRequest{}
     default:
         return nil
     }
@@ -150,8 +187,44 @@ func generateErrorResponse(code codes.Code, message string) *ResponseData {
     }
 }
 
-func runLambda(reqData *RequestData) (*ResponseData, error) {
-    log.Printf("Handler started. Event data: %v", reqData)
+// ensureRequestID adopts the caller's request ID, or mints a new one if there is none,
+// and records it in the request headers so RPC functions can see it.
+func ensureRequestID(reqData *RequestData) string {
+    if reqData.Headers == nil {
+        reqData.Headers = make(map[string]string)
+    }
+    requestID := reqData.Headers[logging.RequestIDHeader]
+    if requestID == "" {
+        requestID = newRequestID()
+        reqData.Headers[logging.RequestIDHeader] = requestID
+    }
+    return requestID
+}
+
+// newRequestID generates a random (version 4) UUID string.
+func newRequestID() string {
+    b := make([]byte, 16)
+    _, _ = rand.Read(b)
+    b[6] = (b[6] & 0x0f) | 0x40
+    b[8] = (b[8] & 0x3f) | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// requestLogger returns a logger that tags every entry with the correlation data of a request.
+func requestLogger(headers *map[string]string) *logrus.Entry {
+    return logging.WithRequest(log, *headers)
+}
+
+func runLambda(ctx context.Context, reqData *RequestData) (*ResponseData, error) {
+    inv := logging.StartInvocation(ctx)
+    requestID := ensureRequestID(reqData)
+    if lambdaRequestID := logging.LambdaRequestID(ctx); lambdaRequestID != "" {
+        reqData.Headers[logging.LambdaRequestIDHeader] = lambdaRequestID
+    }
+    reqLog := requestLogger(&reqData.Headers)
+    defer inv.End(reqLog)
+
+    reqLog.Infof("Handler started. Event data: %v", reqData)
 
     reqMsg, respData, err := decodeRequest(reqData)
     if err != nil {
@@ -166,91 +239,234 @@ func runLambda(reqData *RequestData) (*ResponseData, error) {
         }
     }
 
-    log.Printf("Handler finished. Response: %v", respData)
+    // Lambda may freeze the execution environment once the handler returns, and shut it down while frozen, so the
+    // events of the request are delivered before, within a bound that keeps a failing publisher from holding up the
+    // response.
+    if outbox, ok := svc.publisher.(*events.Outbox); ok {
+        flushCtx, cancel := context.WithTimeout(ctx, lambdaOutboxFlushTimeout)
+        if err := outbox.Flush(flushCtx); err != nil {
+            reqLog.Warnf("failed to publish cart events, %d left in the outbox: %v", outbox.Pending(), err)
+        }
+        cancel()
+    }
+
+    respData.Headers[logging.RequestIDHeader] = requestID
+    reqLog.Infof("Handler finished. Response: %v", respData)
     return respData, nil
 }
 
-func runHTTPServer() error {
-    httpHandler := func(w http.ResponseWriter, r *http.Request) {
-        reqBody, err := io.ReadAll(r.Body)
-        if err != nil {
-            log.Printf("Error reading request body: %v", err)
-            http.Error(w, "failed to read request body", http.StatusInternalServerError)
-            return
-        }
-        defer r.Body.Close()
+// flattenHeaders converts HTTP headers into the lowercase, comma-joined form that RequestData carries.
+func flattenHeaders(header http.Header) map[string]string {
+    headers := make(map[string]string, len(header))
+    for k, vs := range header {
+        headers[strings.ToLower(k)] = strings.Join(vs, ",")
+    }
+    return headers
+}
 
-        headers := make(map[string]string)
-        for k, vs := range r.Header {
-            s := vs[0]
-            for _, v := range vs[1:] {
-                s += "," + v
-            }
-            headers[strings.ToLower(k)] = s
-        }
+// httpHandler serves a single RPC over plain HTTP.
+func httpHandler(w http.ResponseWriter, r *http.Request) {
+    reqBody, err := io.ReadAll(r.Body)
+    if err != nil {
+        log.Infof("Error reading request body: %v", err)
+        http.Error(w, "failed to read request body", http.StatusInternalServerError)
+        return
+    }
+    defer r.Body.Close()
 
-        reqData := &RequestData{
-            BinBody:         reqBody,
-            Headers:         headers,
-            IsBase64Encoded: false,
-        }
+    headers := flattenHeaders(r.Header)
+    delete(headers, logging.LambdaRequestIDHeader)
 
-        var respData *ResponseData
-        reqMsg, respData, err := decodeRequest(reqData)
-        if err != nil {
-            log.Printf("Error decoding request: %v", err)
-            http.Error(w, "failed to decode request", http.StatusInternalServerError)
-            return
+    reqData := &RequestData{
+        BinBody:         reqBody,
+        Headers:         headers,
+        IsBase64Encoded: false,
+    }
+    requestID := ensureRequestID(reqData)
 
-        } else if respData == nil {
-            respMsg, rpcError := callRPC(reqMsg, reqData)
+    var respData *ResponseData
+    reqMsg, respData, err := decodeRequest(reqData)
+    if err != nil {
+        log.Infof("Error decoding request: %v", err)
+        http.Error(w, "failed to decode request", http.StatusInternalServerError)
+        return
 
-            respData, err = encodeResponse(&respMsg, rpcError)
-            if err != nil {
-                log.Printf("Error encoding response: %v", err)
-                http.Error(w, "failed to encode response", http.StatusInternalServerError)
-                return
-            }
-        }
+    } else if respData == nil {
+        respMsg, rpcError := callRPC(reqMsg, reqData)
 
-        for k, v := range respData.Headers {
-            w.Header().Set(k, v)
-        }
-        w.WriteHeader(respData.StatusCode)
-        if _, err := w.Write(respData.BinBody); err != nil {
-            log.Printf("Error writing response: %v", err)
+        respData, err = encodeResponse(&respMsg, rpcError)
+        if err != nil {
+            log.Infof("Error encoding response: %v", err)
+            http.Error(w, "failed to encode response", http.StatusInternalServerError)
+            return
         }
     }
 
+    respData.Headers[logging.RequestIDHeader] = requestID
+    for k, v := range respData.Headers {
+        w.Header().Set(k, v)
+    }
+    w.WriteHeader(respData.StatusCode)
+    if _, err := w.Write(respData.BinBody); err != nil {
+        log.Infof("Error writing response: %v", err)
+    }
+}
+
+func runHTTPServer() error {
     port := defaultPort
     if p, ok := os.LookupEnv("PORT"); ok {
         port = p
     }
     addr := os.Getenv("LISTEN_ADDR")
 
-    log.Println("Starting HTTP server on " + addr + ":" + port)
+    log.Info("Starting HTTP server on " + addr + ":" + port)
     http.HandleFunc("/", httpHandler)
     return http.ListenAndServe(addr+":"+port, nil)
 }
 
-func main() {
-    if redisAddr, ok := os.LookupEnv("REDIS_ADDR"); ok {
-        svc = NewCartService(cartstore.NewRedisCartStore(redisAddr, os.Getenv("REDIS_PASS")))
+// newCartStore creates the store selected by CART_STORE: redis, dynamodb, postgres, sqlite or memory. If CART_STORE
+// isn't set, carts are stored in Redis if REDIS_ADDR is set, and in memory otherwise.
+func newCartStore(ttl time.Duration) cartstore.CartStore {
+    kind, ok := os.LookupEnv("CART_STORE")
+    if !ok {
+        kind = "memory"
+        if _, ok := os.LookupEnv("REDIS_ADDR"); ok {
+            kind = "redis"
+        }
+    }
+    log.Infof("cart store: %s", kind)
+    if runningInLambda && (kind == "memory" || kind == "sqlite") {
+        // Every instance of the function would have carts of its own.
+        log.Fatalf("the %s cart store can't be used while running in lambda, set CART_STORE to redis, dynamodb or postgres", kind)
     }
 
-    if runningInLambda {
-        if svc == nil {
-            log.Fatalf("REDIS_ADDR environment variable not set while running in lambda")
+    switch kind {
+    case "redis":
+        redisAddr, ok := os.LookupEnv("REDIS_ADDR")
+        if !ok {
+            log.Fatal("REDIS_ADDR environment variable not set")
+        }
+        encoding := cartstore.EncodingJSON
+        if name := os.Getenv("CART_ENCODING"); name != "" {
+            var err error
+            if encoding, err = cartstore.ParseEncoding(name); err != nil {
+                log.Fatalf("failed to parse CART_ENCODING: %v", err)
+            }
+        }
+        return cartstore.NewRedisCartStore(redisAddr, os.Getenv("REDIS_PASS"), ttl, encoding)
+    case "dynamodb":
+        table, ok := os.LookupEnv("DYNAMODB_TABLE")
+        if !ok {
+            log.Fatal("DYNAMODB_TABLE environment variable not set")
+        }
+        store, err := cartstore.NewDynamoDBCartStore(table, os.Getenv("DYNAMODB_ENDPOINT"), ttl)
+        if err != nil {
+            log.Fatalf("failed to create the DynamoDB cart store: %v", err)
+        }
+        return store
+    case "postgres":
+        dsn, ok := os.LookupEnv("POSTGRES_DSN")
+        if !ok {
+            log.Fatal("POSTGRES_DSN environment variable not set")
+        }
+        store, err := cartstore.NewSQLCartStore("postgres", dsn, ttl)
+        if err != nil {
+            log.Fatalf("failed to open the PostgreSQL cart store: %v", err)
+        }
+        return store
+    case "sqlite":
+        path := os.Getenv("SQLITE_PATH")
+        if path == "" {
+            path = defaultSQLitePath
+        }
+        store, err := cartstore.NewSQLCartStore("sqlite", path, ttl)
+        if err != nil {
+            log.Fatalf("failed to open the SQLite cart store at %s: %v", path, err)
+        }
+        return store
+    case "memory":
+        return cartstore.NewInMemoryCartStore(ttl)
+    default:
+        log.Fatalf("unknown CART_STORE %q, expected redis, dynamodb, postgres, sqlite or memory", kind)
+        return nil
+    }
+}
+
+// newEventPublisher creates the publisher of cart events selected by CART_EVENTS: redis, webhook or memory. It returns
+// nil, so that no events are published, if CART_EVENTS isn't set. The Redis and webhook publishers sit behind an
+// outbox, so cart updates don't wait for them and events outlive their failures. In Lambda, each response waits up to
+// lambdaOutboxFlushTimeout for the outbox to be delivered; what's left is retried by later invocations, and lost if
+// the execution environment is shut down first.
+func newEventPublisher() events.Publisher {
+    switch kind := os.Getenv("CART_EVENTS"); kind {
+    case "":
+        return nil
+    case "redis":
+        addr := os.Getenv("CART_EVENTS_REDIS_ADDR")
+        if addr == "" {
+            addr = os.Getenv("REDIS_ADDR")
+        }
+        if addr == "" {
+            log.Fatal("CART_EVENTS_REDIS_ADDR or REDIS_ADDR must be set to publish cart events to Redis")
+        }
+        stream := os.Getenv("CART_EVENTS_STREAM")
+        if stream == "" {
+            stream = events.DefaultStream
         }
+        return events.NewOutbox(events.NewRedisStreamPublisher(addr, os.Getenv("REDIS_PASS"), stream, 0), 0)
+    case "webhook":
+        url := os.Getenv("CART_EVENTS_WEBHOOK_URL")
+        if url == "" {
+            log.Fatal("CART_EVENTS_WEBHOOK_URL must be set to publish cart events to a webhook")
+        }
+        return events.NewOutbox(events.NewWebhookPublisher(url, os.Getenv("CART_EVENTS_WEBHOOK_SECRET")), 0)
+    case "memory":
+        return events.NewMemoryPublisher(0)
+    default:
+        log.Fatalf("unknown CART_EVENTS %q, expected redis, webhook or memory", kind)
+        return nil
+    }
+}
 
-        lambda.Start(runLambda)
+// limitFromEnv reads a cart limit from an environment variable, or returns def if it isn't set. Zero disables the limit.
+func limitFromEnv(name string, def int) int {
+    s := os.Getenv(name)
+    if s == "" {
+        return def
+    }
+    v, err := strconv.ParseInt(s, 10, 32)
+    if err != nil || v < 0 {
+        log.Fatalf("failed to parse %s (%s) as a non-negative integer: %+v", name, s, err)
+    }
+    return int(v)
+}
 
-    } else {
-        if svc == nil {
-            log.Println("REDIS_ADDR environment variable not set")
-            svc = NewCartService(cartstore.NewInMemoryCartStore())
+func main() {
+    cartTTL := cartstore.DefaultCartTTL
+    if s := os.Getenv("CART_TTL"); s != "" {
+        v, err := time.ParseDuration(s)
+        if err != nil || v < 0 {
+            log.Fatalf("failed to parse CART_TTL (%s) as a non-negative time.Duration: %+v", s, err)
         }
+        cartTTL = v
+    }
+    log.Infof("cart TTL: %v", cartTTL)
+    limits := cartstore.Limits{
+        MaxItems:         limitFromEnv("CART_MAX_ITEMS", cartstore.DefaultLimits.MaxItems),
+        MaxItemQuantity:  int32(limitFromEnv("CART_MAX_ITEM_QUANTITY", int(cartstore.DefaultLimits.MaxItemQuantity))),
+        MaxTotalQuantity: int32(limitFromEnv("CART_MAX_TOTAL_QUANTITY", int(cartstore.DefaultLimits.MaxTotalQuantity))),
+    }
+    log.Infof("cart limits: %+v", limits)
+
+    endStorePhase := logging.InitPhase("cart_store")
+    svc = NewCartService(newCartStore(cartTTL), limits, newEventPublisher())
+    svc.cartStore.Ping() // opens the first connection to the storage during init
+    endStorePhase()
+    logging.InitDone(log)
 
+    if runningInLambda {
+        lambda.Start(runLambda)
+    } else {
         if err := runHTTPServer(); err != nil {
             log.Fatalf("HTTP server ended with error: %v", err)
         }
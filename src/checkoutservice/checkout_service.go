@@ -2,50 +2,36 @@ package main
 
 import (
     "fmt"
-    "os"
-    "time"
+    "net/http"
 
     "github.com/google/uuid"
     "github.com/sirupsen/logrus"
     "google.golang.org/grpc/codes"
     "google.golang.org/grpc/status"
 
-    stubs "main/client"
-    pb "main/genproto"
-    "main/money"
+    stubs "checkoutservice/client"
+    pb "checkoutservice/genproto"
+    "checkoutservice/money"
+    "logging"
 )
 
 const (
     usdCurrency = "USD"
 )
 
-var log *logrus.Logger
-
-func init() {
-    log = logrus.New()
-    log.Level = logrus.DebugLevel
-    log.Formatter = &logrus.JSONFormatter{
-        FieldMap: logrus.FieldMap{
-            logrus.FieldKeyTime:  "timestamp",
-            logrus.FieldKeyLevel: "severity",
-            logrus.FieldKeyMsg:   "message",
-        },
-        TimestampFormat: time.RFC3339Nano,
-    }
-    log.Out = os.Stdout
-}
-
 type checkoutService struct{}
 
 func (cs *checkoutService) PlaceOrder(req *pb.PlaceOrderRequest, headers *map[string]string) (*pb.PlaceOrderResponse, error) {
-    log.Infof("[PlaceOrder] user_id=%q user_currency=%q", req.UserId, req.UserCurrency)
+    reqLog := requestLogger(headers)
+    reqLog.Infof("[PlaceOrder] user_id=%q user_currency=%q", req.UserId, req.UserCurrency)
+    header := forwardedHeader(headers)
 
     orderID, err := uuid.NewUUID()
     if err != nil {
         return nil, status.Errorf(codes.Internal, "failed to generate order uuid")
     }
 
-    prep, err := cs.prepareOrderItemsAndShippingQuoteFromCart(req.UserId, req.UserCurrency, req.Address)
+    prep, err := cs.prepareOrderItemsAndShippingQuoteFromCart(req.UserId, req.UserCurrency, req.Address, header)
     if err != nil {
         return nil, status.Errorf(codes.Internal, err.Error())
     }
@@ -59,18 +45,34 @@ func (cs *checkoutService) PlaceOrder(req *pb.PlaceOrderRequest, headers *map[st
         total = money.Must(money.Sum(total, multPrice))
     }
 
-    txID, err := cs.chargeCard(&total, req.CreditCard)
+    // The items are held before the card is charged, so that an order never sells items that are out of stock. An
+    // empty cart has nothing to hold.
+    var reservationID string
+    if len(prep.cartItems) > 0 {
+        if reservationID, err = cs.reserveStock(prep.cartItems, header); err != nil {
+            return nil, err
+        }
+    }
+
+    txID, err := cs.chargeCard(&total, req.CreditCard, header)
     if err != nil {
+        cs.releaseStock(reqLog, reservationID, header)
         return nil, status.Errorf(codes.Internal, "failed to charge card: %+v", err)
     }
-    log.Infof("payment went through (transaction_id: %s)", txID)
+    reqLog.Infof("payment went through (transaction_id: %s)", txID)
 
-    shippingTrackingID, err := cs.shipOrder(req.Address, prep.cartItems)
+    shippingTrackingID, err := cs.shipOrder(req.Address, prep.cartItems, header)
     if err != nil {
+        cs.releaseStock(reqLog, reservationID, header)
         return nil, status.Errorf(codes.Unavailable, "shipping error: %+v", err)
     }
 
-    _ = cs.emptyUserCart(req.UserId)
+    if err := cs.commitStock(reservationID, header); err != nil {
+        // The order is placed all the same: only the stock count is off.
+        reqLog.Errorf("failed to take the items of order %s out of stock: %+v", orderID, err)
+    }
+
+    _ = cs.emptyUserCart(req.UserId, header)
 
     orderResult := &pb.OrderResult{
         OrderId:            orderID.String(),
@@ -80,36 +82,47 @@ func (cs *checkoutService) PlaceOrder(req *pb.PlaceOrderRequest, headers *map[st
         Items:              prep.orderItems,
     }
 
-    if err := cs.sendOrderConfirmation(req.Email, orderResult); err != nil {
-        log.Warnf("failed to send order confirmation to %q: %+v", req.Email, err)
+    if err := cs.sendOrderConfirmation(req.Email, orderResult, header); err != nil {
+        reqLog.Warnf("failed to send order confirmation to %q: %+v", req.Email, err)
     } else {
-        log.Infof("order confirmation email sent to %q", req.Email)
+        reqLog.Infof("order confirmation email sent to %q", req.Email)
     }
     resp := &pb.PlaceOrderResponse{Order: orderResult}
     return resp, nil
 }
 
+// forwardedHeader builds the headers that carry the caller's correlation IDs to downstream services.
+func forwardedHeader(headers *map[string]string) *http.Header {
+    header := http.Header{}
+    for _, key := range []string{logging.RequestIDHeader, logging.SessionIDHeader} {
+        if v := (*headers)[key]; v != "" {
+            header.Set(key, v)
+        }
+    }
+    return &header
+}
+
 type orderPrep struct {
     orderItems            []*pb.OrderItem
     cartItems             []*pb.CartItem
     shippingCostLocalized *pb.Money
 }
 
-func (cs *checkoutService) prepareOrderItemsAndShippingQuoteFromCart(userID, userCurrency string, address *pb.Address) (orderPrep, error) {
+func (cs *checkoutService) prepareOrderItemsAndShippingQuoteFromCart(userID, userCurrency string, address *pb.Address, header *http.Header) (orderPrep, error) {
     var out orderPrep
-    cartItems, err := cs.getUserCart(userID)
+    cartItems, err := cs.getUserCart(userID, header)
     if err != nil {
         return out, fmt.Errorf("cart failure: %+v", err)
     }
-    orderItems, err := cs.prepOrderItems(cartItems, userCurrency)
+    orderItems, err := cs.prepOrderItems(cartItems, userCurrency, header)
     if err != nil {
         return out, fmt.Errorf("failed to prepare order: %+v", err)
     }
-    shippingUSD, err := cs.quoteShipping(address, cartItems)
+    shippingUSD, err := cs.quoteShipping(address, cartItems, header)
     if err != nil {
         return out, fmt.Errorf("shipping quote failure: %+v", err)
     }
-    shippingPrice, err := cs.convertCurrency(shippingUSD, userCurrency)
+    shippingPrice, err := cs.convertCurrency(shippingUSD, userCurrency, header)
     if err != nil {
         return out, fmt.Errorf("failed to convert shipping cost to currency: %+v", err)
     }
@@ -120,38 +133,41 @@ func (cs *checkoutService) prepareOrderItemsAndShippingQuoteFromCart(userID, use
     return out, nil
 }
 
-func (cs *checkoutService) quoteShipping(address *pb.Address, items []*pb.CartItem) (*pb.Money, error) {
-    shippingQuote, err := stubs.GetQuote(&pb.GetQuoteRequest{Address: address, Items: items}, nil)
+func (cs *checkoutService) quoteShipping(address *pb.Address, items []*pb.CartItem, header *http.Header) (*pb.Money, error) {
+    shippingQuote, err := stubs.GetQuote(&pb.GetQuoteRequest{Address: address, Items: items}, header)
     if err != nil {
         return nil, fmt.Errorf("failed to get shipping quote: %+v", err)
     }
     return shippingQuote.GetCostUsd(), nil
 }
 
-func (cs *checkoutService) getUserCart(userID string) ([]*pb.CartItem, error) {
-    cart, err := stubs.GetCart(&pb.GetCartRequest{UserId: userID}, nil)
+func (cs *checkoutService) getUserCart(userID string, header *http.Header) ([]*pb.CartItem, error) {
+    cart, err := stubs.GetCart(&pb.GetCartRequest{UserId: userID}, header)
     if err != nil {
         return nil, fmt.Errorf("failed to get user cart during checkout: %+v", err)
     }
     return cart.GetItems(), nil
 }
 
-func (cs *checkoutService) emptyUserCart(userID string) error {
-    if _, err := stubs.EmptyCart(&pb.EmptyCartRequest{UserId: userID}, nil); err != nil {
+func (cs *checkoutService) emptyUserCart(userID string, header *http.Header) error {
+    if _, err := stubs.EmptyCart(&pb.EmptyCartRequest{UserId: userID}, header); err != nil {
         return fmt.Errorf("failed to empty user cart during checkout: %+v", err)
     }
     return nil
 }
 
-func (cs *checkoutService) prepOrderItems(items []*pb.CartItem, userCurrency string) ([]*pb.OrderItem, error) {
+// prepOrderItems prices the items of an order in the currency of the user. The prices are read from the catalog
+// rather than the cache, so that an order is charged the current ones.
+func (cs *checkoutService) prepOrderItems(items []*pb.CartItem, userCurrency string, header *http.Header) ([]*pb.OrderItem, error) {
     out := make([]*pb.OrderItem, len(items))
+    catalogHeader := stubs.BypassCatalogCache(header)
 
     for i, item := range items {
-        product, err := stubs.GetProduct(&pb.GetProductRequest{Id: item.GetProductId()}, nil)
+        product, err := stubs.GetProduct(&pb.GetProductRequest{Id: item.GetProductId()}, catalogHeader)
         if err != nil {
             return nil, fmt.Errorf("failed to get product #%q", item.GetProductId())
         }
-        price, err := cs.convertCurrency(product.GetPriceUsd(), userCurrency)
+        price, err := cs.convertCurrency(product.GetPriceUsd(), userCurrency, header)
         if err != nil {
             return nil, fmt.Errorf("failed to convert price of %q to %s", item.GetProductId(), userCurrency)
         }
@@ -162,29 +178,69 @@ func (cs *checkoutService) prepOrderItems(items []*pb.CartItem, userCurrency str
     return out, nil
 }
 
-func (cs *checkoutService) convertCurrency(from *pb.Money, toCurrency string) (*pb.Money, error) {
-    result, err := stubs.Convert(&pb.CurrencyConversionRequest{From: from, ToCode: toCurrency}, nil)
+// reserveStock holds the items of an order until it's placed or fails, and returns the ID of the reservation. It
+// fails with FailedPrecondition if some of the items are out of stock, and with InvalidArgument or NotFound if the
+// catalog refuses the items, since retrying the order won't help then. Any other failure is Unavailable.
+func (cs *checkoutService) reserveStock(items []*pb.CartItem, header *http.Header) (string, error) {
+    reservation, err := stubs.ReserveStock(&pb.ReserveStockRequest{Items: items}, header)
+    switch code := status.Code(err); code {
+    case codes.OK:
+        return reservation.GetReservationId(), nil
+    case codes.FailedPrecondition:
+        return "", status.Errorf(code, "items out of stock: %s", status.Convert(err).Message())
+    case codes.InvalidArgument, codes.NotFound:
+        return "", status.Errorf(code, "failed to reserve stock: %s", status.Convert(err).Message())
+    default:
+        return "", status.Errorf(codes.Unavailable, "failed to reserve stock: %+v", err)
+    }
+}
+
+// commitStock takes the items of a reservation out of stock, once their order is placed. An order without a
+// reservation has nothing to commit.
+func (cs *checkoutService) commitStock(reservationID string, header *http.Header) error {
+    if reservationID == "" {
+        return nil
+    }
+    if _, err := stubs.CommitReservation(&pb.CommitReservationRequest{ReservationId: reservationID}, header); err != nil {
+        return fmt.Errorf("failed to commit stock reservation %s: %+v", reservationID, err)
+    }
+    return nil
+}
+
+// releaseStock returns the items of a reservation to the stock, when their order fails. The reservation expires if
+// the release fails, so a failure is only logged.
+func (cs *checkoutService) releaseStock(reqLog *logrus.Entry, reservationID string, header *http.Header) {
+    if reservationID == "" {
+        return
+    }
+    if _, err := stubs.ReleaseReservation(&pb.ReleaseReservationRequest{ReservationId: reservationID}, header); err != nil {
+        reqLog.Warnf("failed to release stock reservation %s: %+v", reservationID, err)
+    }
+}
+
+func (cs *checkoutService) convertCurrency(from *pb.Money, toCurrency string, header *http.Header) (*pb.Money, error) {
+    result, err := stubs.Convert(&pb.CurrencyConversionRequest{From: from, ToCode: toCurrency}, header)
     if err != nil {
         return nil, fmt.Errorf("failed to convert currency: %+v", err)
     }
     return result, err
 }
 
-func (cs *checkoutService) chargeCard(amount *pb.Money, paymentInfo *pb.CreditCardInfo) (string, error) {
-    paymentResp, err := stubs.Charge(&pb.ChargeRequest{Amount: amount, CreditCard: paymentInfo}, nil)
+func (cs *checkoutService) chargeCard(amount *pb.Money, paymentInfo *pb.CreditCardInfo, header *http.Header) (string, error) {
+    paymentResp, err := stubs.Charge(&pb.ChargeRequest{Amount: amount, CreditCard: paymentInfo}, header)
     if err != nil {
         return "", fmt.Errorf("could not charge the card: %+v", err)
     }
     return paymentResp.GetTransactionId(), nil
 }
 
-func (cs *checkoutService) sendOrderConfirmation(email string, order *pb.OrderResult) error {
-    _, err := stubs.SendOrderConfirmation(&pb.SendOrderConfirmationRequest{Email: email, Order: order}, nil)
+func (cs *checkoutService) sendOrderConfirmation(email string, order *pb.OrderResult, header *http.Header) error {
+    _, err := stubs.SendOrderConfirmation(&pb.SendOrderConfirmationRequest{Email: email, Order: order}, header)
     return err
 }
 
-func (cs *checkoutService) shipOrder(address *pb.Address, items []*pb.CartItem) (string, error) {
-    resp, err := stubs.ShipOrder(&pb.ShipOrderRequest{Address: address, Items: items}, nil)
+func (cs *checkoutService) shipOrder(address *pb.Address, items []*pb.CartItem, header *http.Header) (string, error) {
+    resp, err := stubs.ShipOrder(&pb.ShipOrderRequest{Address: address, Items: items}, header)
     if err != nil {
         return "", fmt.Errorf("shipment failed: %+v", err)
     }
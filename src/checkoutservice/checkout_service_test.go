@@ -0,0 +1,232 @@
+package main
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+
+    pb "checkoutservice/genproto"
+)
+
+// fakeServices answers the RPCs of the services an order goes through, and records them. Every product costs $10,
+// shipping costs $5, and currencies convert one to one.
+type fakeServices struct {
+    mu    sync.Mutex
+    cart  []*pb.CartItem
+    calls []string
+    // reservations holds the reservation IDs the commit and release RPCs were given.
+    reservations map[string]string
+
+    // reserveErr and chargeErr make the reserve-stock and charge RPCs fail.
+    reserveErr error
+    chargeErr  error
+}
+
+// ServeHTTP serves a request of the stubs like the Lambda adapters of the services do: with the gRPC status in the
+// grpc-status header, and its message as the body of a failure.
+func (f *fakeServices) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    call := strings.TrimPrefix(r.URL.Path, "/") + "/" + r.Header.Get("rpc-name")
+    f.calls = append(f.calls, call)
+    body, _ := io.ReadAll(r.Body)
+
+    resp, err := f.answer(call, body)
+    st := status.Convert(err)
+    w.Header().Set("grpc-status", strconv.Itoa(int(st.Code())))
+    if err != nil {
+        _, _ = io.WriteString(w, st.Message())
+        return
+    }
+    b, _ := proto.Marshal(resp)
+    _, _ = w.Write(b)
+}
+
+func (f *fakeServices) answer(call string, body []byte) (proto.Message, error) {
+    switch call {
+    case "cart-service/get-cart":
+        return &pb.Cart{Items: f.cart}, nil
+    case "product-catalog-service/get-product":
+        req := &pb.GetProductRequest{}
+        if err := proto.Unmarshal(body, req); err != nil {
+            return nil, status.Error(codes.InvalidArgument, err.Error())
+        }
+        return &pb.Product{Id: req.Id, PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 10}}, nil
+    case "currency-service/convert":
+        req := &pb.CurrencyConversionRequest{}
+        if err := proto.Unmarshal(body, req); err != nil {
+            return nil, status.Error(codes.InvalidArgument, err.Error())
+        }
+        return &pb.Money{CurrencyCode: req.ToCode, Units: req.From.Units, Nanos: req.From.Nanos}, nil
+    case "shipping-service/get-quote":
+        return &pb.GetQuoteResponse{CostUsd: &pb.Money{CurrencyCode: "USD", Units: 5}}, nil
+    case "product-catalog-service/reserve-stock":
+        if f.reserveErr != nil {
+            return nil, f.reserveErr
+        }
+        return &pb.Reservation{ReservationId: "reservation-1"}, nil
+    case "payment-service/charge":
+        if f.chargeErr != nil {
+            return nil, f.chargeErr
+        }
+        return &pb.ChargeResponse{TransactionId: "transaction-1"}, nil
+    case "shipping-service/ship-order":
+        return &pb.ShipOrderResponse{TrackingId: "tracking-1"}, nil
+    case "product-catalog-service/commit-reservation":
+        req := &pb.CommitReservationRequest{}
+        _ = proto.Unmarshal(body, req)
+        f.reservations[call] = req.ReservationId
+        return &pb.Empty{}, nil
+    case "product-catalog-service/release-reservation":
+        req := &pb.ReleaseReservationRequest{}
+        _ = proto.Unmarshal(body, req)
+        f.reservations[call] = req.ReservationId
+        return &pb.Empty{}, nil
+    case "cart-service/empty-cart", "email-service/send-order-confirmation":
+        return &pb.Empty{}, nil
+    default:
+        return nil, status.Errorf(codes.Unimplemented, "unexpected call %s", call)
+    }
+}
+
+// called tells whether an RPC was called, and how many calls came before its first one.
+func (f *fakeServices) called(call string) (bool, int) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    for i, c := range f.calls {
+        if c == call {
+            return true, i
+        }
+    }
+    return false, -1
+}
+
+// handlerTransport serves the requests of an HTTP client with a handler, without a network.
+type handlerTransport struct {
+    handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    rec := httptest.NewRecorder()
+    t.handler.ServeHTTP(rec, req)
+    return rec.Result(), nil
+}
+
+// newFakeServices routes the requests of the stubs to fake services, for the duration of a test.
+func newFakeServices(t *testing.T, cart ...*pb.CartItem) *fakeServices {
+    services := &fakeServices{cart: cart, reservations: make(map[string]string)}
+    transport := http.DefaultTransport
+    http.DefaultTransport = handlerTransport{services}
+    t.Cleanup(func() { http.DefaultTransport = transport })
+
+    level := log.Logger.GetLevel()
+    log.Logger.SetLevel(logrus.ErrorLevel)
+    t.Cleanup(func() { log.Logger.SetLevel(level) })
+    return services
+}
+
+func placeOrder() (*pb.PlaceOrderResponse, error) {
+    return svc.PlaceOrder(&pb.PlaceOrderRequest{
+        UserId:       "alice",
+        UserCurrency: "USD",
+        Address:      &pb.Address{StreetAddress: "1600 Amphitheatre Parkway", City: "Mountain View", Country: "US"},
+        Email:        "alice@example.com",
+        CreditCard:   &pb.CreditCardInfo{CreditCardNumber: "4432-8015-6152-0454", CreditCardCvv: 672},
+    }, &map[string]string{})
+}
+
+func TestPlaceOrderCommitsStockOfShippedOrder(t *testing.T) {
+    services := newFakeServices(t, &pb.CartItem{ProductId: "OLJCESPC7Z", Quantity: 2})
+
+    resp, err := placeOrder()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := resp.Order.ShippingTrackingId; got != "tracking-1" {
+        t.Errorf("tracking ID = %q, want tracking-1", got)
+    }
+
+    _, reserved := services.called("product-catalog-service/reserve-stock")
+    _, charged := services.called("payment-service/charge")
+    _, shipped := services.called("shipping-service/ship-order")
+    committed, commit := services.called("product-catalog-service/commit-reservation")
+    if !(reserved >= 0 && reserved < charged && charged < shipped && shipped < commit) {
+        t.Errorf("calls = %v, want the stock reserved, the card charged, the order shipped, then the stock committed", services.calls)
+    }
+    if id := services.reservations["product-catalog-service/commit-reservation"]; !committed || id != "reservation-1" {
+        t.Errorf("committed reservation %q, want reservation-1", id)
+    }
+    if released, _ := services.called("product-catalog-service/release-reservation"); released {
+        t.Error("the reservation of a placed order was released")
+    }
+}
+
+func TestPlaceOrderReleasesStockWhenChargeFails(t *testing.T) {
+    services := newFakeServices(t, &pb.CartItem{ProductId: "OLJCESPC7Z", Quantity: 2})
+    services.chargeErr = status.Error(codes.InvalidArgument, "credit card declined")
+
+    if _, err := placeOrder(); err == nil {
+        t.Fatal("PlaceOrder() succeeded with a declined card")
+    }
+
+    _, charged := services.called("payment-service/charge")
+    released, release := services.called("product-catalog-service/release-reservation")
+    if !released || release < charged {
+        t.Errorf("calls = %v, want the stock released after the charge failed", services.calls)
+    }
+    if id := services.reservations["product-catalog-service/release-reservation"]; id != "reservation-1" {
+        t.Errorf("released reservation %q, want reservation-1", id)
+    }
+    for _, call := range []string{"shipping-service/ship-order", "product-catalog-service/commit-reservation", "cart-service/empty-cart"} {
+        if ok, _ := services.called(call); ok {
+            t.Errorf("%s was called for an order that failed", call)
+        }
+    }
+}
+
+func TestPlaceOrderEmptyCart(t *testing.T) {
+    services := newFakeServices(t)
+
+    if _, err := placeOrder(); err != nil {
+        t.Fatal(err)
+    }
+    for _, call := range []string{"product-catalog-service/reserve-stock", "product-catalog-service/commit-reservation"} {
+        if ok, _ := services.called(call); ok {
+            t.Errorf("%s was called for an empty cart", call)
+        }
+    }
+}
+
+func TestPlaceOrderReservationErrors(t *testing.T) {
+    for _, tc := range []struct {
+        code codes.Code
+        want codes.Code
+    }{
+        {codes.FailedPrecondition, codes.FailedPrecondition},
+        {codes.InvalidArgument, codes.InvalidArgument},
+        {codes.NotFound, codes.NotFound},
+        {codes.Unavailable, codes.Unavailable},
+        {codes.Internal, codes.Unavailable},
+    } {
+        t.Run(tc.code.String(), func(t *testing.T) {
+            services := newFakeServices(t, &pb.CartItem{ProductId: "OLJCESPC7Z", Quantity: 2})
+            services.reserveErr = status.Error(tc.code, "reservation refused")
+
+            if _, err := placeOrder(); status.Code(err) != tc.want {
+                t.Errorf("PlaceOrder() = %v, want %v", err, tc.want)
+            }
+            if charged, _ := services.called("payment-service/charge"); charged {
+                t.Error("the card was charged without a reservation")
+            }
+        })
+    }
+}
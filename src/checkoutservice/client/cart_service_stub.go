@@ -1,12 +1,11 @@
 package client
 
 import (
-    "log"
     "net/http"
     "os"
     "strconv"
 
-    pb "main/genproto"
+    pb "checkoutservice/genproto"
 )
 
 var (
@@ -86,11 +85,7 @@ func EmptyCart(request *pb.EmptyCartRequest, header *http.Header) (*pb.Empty, er
 
 // init loads the address and timeout variables.
 func init() {
-    a, ok := os.LookupEnv("CART_SERVICE_ADDR")
-    if !ok {
-        log.Fatal("CART_SERVICE_ADDR environment variable not set")
-    }
-    cartServiceAddr = &a
+    cartServiceAddr = lookupServiceAddr("CART_SERVICE_ADDR")
 
     t, ok := os.LookupEnv("CART_SERVICE_TIMEOUT")
     if !ok {
@@ -0,0 +1,202 @@
+package client
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/sirupsen/logrus"
+    logtest "github.com/sirupsen/logrus/hooks/test"
+    "google.golang.org/protobuf/proto"
+
+    pb "checkoutservice/genproto"
+    "logging"
+)
+
+// fakeCatalog answers GetProduct like the product catalog service does: with the ETag of its catalog, and Not
+// Modified to the requests that already have it.
+type fakeCatalog struct {
+    mu       sync.Mutex
+    etag     string
+    name     string
+    requests int
+    // ifNoneMatch is the If-None-Match header of the last request.
+    ifNoneMatch string
+}
+
+func (c *fakeCatalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.requests++
+    c.ifNoneMatch = r.Header.Get("if-none-match")
+
+    body, err := io.ReadAll(r.Body)
+    req := &pb.GetProductRequest{}
+    if err != nil || r.Header.Get("rpc-name") != getProductRPC || proto.Unmarshal(body, req) != nil {
+        http.Error(w, "unexpected request", http.StatusBadRequest)
+        return
+    }
+    w.Header().Set("etag", c.etag)
+    if c.ifNoneMatch == c.etag {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+    resp, _ := proto.Marshal(&pb.Product{Id: req.Id, Name: c.name})
+    w.Header().Set("grpc-status", "0")
+    _, _ = w.Write(resp)
+}
+
+// update changes the catalog, and so its ETag.
+func (c *fakeCatalog) update(etag, name string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.etag, c.name = etag, name
+}
+
+// served returns the number of requests served, and the If-None-Match header of the last one.
+func (c *fakeCatalog) served() (int, string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.requests, c.ifNoneMatch
+}
+
+// newTestCatalogCache starts a fake catalog service, points the stub at it, and returns an empty cache.
+func newTestCatalogCache(t *testing.T) (*catalogCache, *fakeCatalog) {
+    catalog := &fakeCatalog{etag: `"v1"`, name: "Mug"}
+    server := httptest.NewServer(catalog)
+    t.Cleanup(server.Close)
+
+    addr := productCatalogServiceAddr
+    productCatalogServiceAddr = &server.URL
+    t.Cleanup(func() { productCatalogServiceAddr = addr })
+
+    takeCacheCounts(t)
+    return &catalogCache{ttl: time.Minute, entries: make(map[string]*catalogCacheEntry)}, catalog
+}
+
+// takeCacheCounts returns the counts of the cache counters since they were last taken, as an invocation reports them.
+func takeCacheCounts(t *testing.T) [3]int64 {
+    t.Helper()
+    logger, hook := logtest.NewNullLogger()
+    logging.StartInvocation(context.Background()).End(logrus.NewEntry(logger))
+    fields := hook.LastEntry().Data
+    return [3]int64{
+        fields["catalog_cache_hits"].(int64),
+        fields["catalog_cache_revalidations"].(int64),
+        fields["catalog_cache_misses"].(int64),
+    }
+}
+
+// expire makes every cached response due for revalidation.
+func expire(c *catalogCache) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    for _, entry := range c.entries {
+        entry.expires = time.Now().Add(-time.Second)
+    }
+}
+
+func getProduct(t *testing.T, c *catalogCache, id string, header *http.Header) *pb.Product {
+    t.Helper()
+    msg, err := c.call(getProductRPC, &pb.GetProductRequest{Id: id}, header)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return msg.(*pb.Product)
+}
+
+func TestCatalogCacheHitsAndMisses(t *testing.T) {
+    cache, catalog := newTestCatalogCache(t)
+
+    getProduct(t, cache, "OLJCESPC7Z", nil)
+    // The change goes unseen until the cached response expires.
+    catalog.update(`"v2"`, "Tumbler")
+    if p := getProduct(t, cache, "OLJCESPC7Z", nil); p.Name != "Mug" {
+        t.Errorf("cached product = %q, want %q", p.Name, "Mug")
+    }
+    if p := getProduct(t, cache, "66VCHSJNUP", nil); p.Name != "Tumbler" {
+        t.Errorf("product missing from the cache = %q, want %q", p.Name, "Tumbler")
+    }
+
+    if n, _ := catalog.served(); n != 2 {
+        t.Errorf("catalog served %d requests, want 2", n)
+    }
+    if counts := takeCacheCounts(t); counts != [3]int64{1, 0, 2} {
+        t.Errorf("hits, revalidations and misses = %v, want [1 0 2]", counts)
+    }
+}
+
+func TestCatalogCacheRevalidatesExpiredResponses(t *testing.T) {
+    cache, catalog := newTestCatalogCache(t)
+
+    getProduct(t, cache, "OLJCESPC7Z", nil)
+    expire(cache)
+    if p := getProduct(t, cache, "OLJCESPC7Z", nil); p.Name != "Mug" {
+        t.Errorf("revalidated product = %q, want %q", p.Name, "Mug")
+    }
+    if n, ifNoneMatch := catalog.served(); n != 2 || ifNoneMatch != `"v1"` {
+        t.Errorf("catalog served %d requests, the last with If-None-Match %s, want 2 with \"v1\"", n, ifNoneMatch)
+    }
+
+    // The revalidated response is used for another TTL.
+    getProduct(t, cache, "OLJCESPC7Z", nil)
+    if n, _ := catalog.served(); n != 2 {
+        t.Errorf("catalog served %d requests after the revalidation, want 2", n)
+    }
+    if counts := takeCacheCounts(t); counts != [3]int64{1, 1, 1} {
+        t.Errorf("hits, revalidations and misses = %v, want [1 1 1]", counts)
+    }
+}
+
+func TestCatalogCacheRefetchesChangedCatalog(t *testing.T) {
+    cache, catalog := newTestCatalogCache(t)
+
+    getProduct(t, cache, "OLJCESPC7Z", nil)
+    catalog.update(`"v2"`, "Tumbler")
+    expire(cache)
+    if p := getProduct(t, cache, "OLJCESPC7Z", nil); p.Name != "Tumbler" {
+        t.Errorf("product after the catalog changed = %q, want %q", p.Name, "Tumbler")
+    }
+    if _, ifNoneMatch := catalog.served(); ifNoneMatch != `"v1"` {
+        t.Errorf("If-None-Match = %s, want the ETag of the cached response \"v1\"", ifNoneMatch)
+    }
+
+    // The new response replaces the cached one, along with its ETag.
+    expire(cache)
+    getProduct(t, cache, "OLJCESPC7Z", nil)
+    if _, ifNoneMatch := catalog.served(); ifNoneMatch != `"v2"` {
+        t.Errorf("If-None-Match = %s, want \"v2\"", ifNoneMatch)
+    }
+    if counts := takeCacheCounts(t); counts != [3]int64{0, 1, 2} {
+        t.Errorf("hits, revalidations and misses = %v, want [0 1 2]", counts)
+    }
+}
+
+func TestCatalogCacheBypass(t *testing.T) {
+    cache, catalog := newTestCatalogCache(t)
+
+    header := &http.Header{"x-request-id": []string{"checkout"}}
+    getProduct(t, cache, "OLJCESPC7Z", nil)
+    catalog.update(`"v2"`, "Tumbler")
+    if p := getProduct(t, cache, "OLJCESPC7Z", BypassCatalogCache(header)); p.Name != "Tumbler" {
+        t.Errorf("product read past the cache = %q, want %q", p.Name, "Tumbler")
+    }
+    if n, ifNoneMatch := catalog.served(); n != 2 || ifNoneMatch != "" {
+        t.Errorf("catalog served %d requests, the last with If-None-Match %s, want 2 unconditional ones", n, ifNoneMatch)
+    }
+    if header.Get("cache-control") != "" {
+        t.Error("BypassCatalogCache changed the headers it was given")
+    }
+
+    // The response read past the cache replaces the cached one.
+    if p := getProduct(t, cache, "OLJCESPC7Z", nil); p.Name != "Tumbler" {
+        t.Errorf("cached product = %q, want %q", p.Name, "Tumbler")
+    }
+    if counts := takeCacheCounts(t); counts != [3]int64{1, 0, 2} {
+        t.Errorf("hits, revalidations and misses = %v, want [1 0 2]", counts)
+    }
+}
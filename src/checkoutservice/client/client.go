@@ -4,21 +4,34 @@ import (
     "bytes"
     "fmt"
     "io"
+    "log"
     "net/http"
+    "os"
     "strconv"
+    "testing"
     "time"
 
     "google.golang.org/grpc/codes"
     "google.golang.org/grpc/status"
     "google.golang.org/protobuf/proto"
 
-    pb "main/genproto"
+    pb "checkoutservice/genproto"
 )
 
 const (
     defaultTimeout = 10
 )
 
+// lookupServiceAddr returns the address of a service, read from the named environment variable, and exits if it isn't
+// set. Tests may leave it unset, and serve the requests of the stubs with a transport of their own.
+func lookupServiceAddr(envVar string) *string {
+    a, ok := os.LookupEnv(envVar)
+    if !ok && !testing.Testing() {
+        log.Fatalf("%s environment variable not set", envVar)
+    }
+    return &a
+}
+
 // determineMessageType chooses the correct message type to initialize.
 func determineMessageType(rpcName string) proto.Message {
     var msg proto.Message
@@ -29,6 +42,10 @@ func determineMessageType(rpcName string) proto.Message {
         msg = &pb.Product{}
     case searchProductsRPC:
         msg = &pb.SearchProductsResponse{}
+    case getStockRPC:
+        msg = &pb.GetStockResponse{}
+    case reserveStockRPC:
+        msg = &pb.Reservation{}
     case getCartRPC:
         msg = &pb.Cart{}
     case getQuoteRPC:
@@ -45,6 +62,8 @@ func determineMessageType(rpcName string) proto.Message {
         /*
            CartService/AddItem
            CartService/EmptyCart
+           ProductCatalogService/CommitReservation
+           ProductCatalogService/ReleaseReservation
            EmailService/SendOrderConfirmation
         */
         msg = &pb.Empty{}
@@ -60,7 +79,7 @@ func sendRequest(addr, serviceName, rpcName string, binReq *[]byte, headers *htt
     }
 
     if headers != nil {
-        req.Header = *headers
+        req.Header = headers.Clone()
     }
     req.Header.Set("rpc-name", rpcName)
     req.Header.Set("content-type", "application/octet-stream")
@@ -72,6 +91,9 @@ func sendRequest(addr, serviceName, rpcName string, binReq *[]byte, headers *htt
     }
     defer resp.Body.Close()
 
+    if resp.StatusCode == http.StatusNotModified {
+        return nil, &resp.Header, errNotModified
+    }
     if resp.StatusCode != http.StatusOK {
         return nil, nil, fmt.Errorf("received non-OK response: %s", resp.Status)
     }
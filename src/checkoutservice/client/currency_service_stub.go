@@ -1,12 +1,11 @@
 package client
 
 import (
-    "log"
     "net/http"
     "os"
     "strconv"
 
-    pb "main/genproto"
+    pb "checkoutservice/genproto"
 )
 
 var (
@@ -64,11 +63,7 @@ func Convert(request *pb.CurrencyConversionRequest, header *http.Header) (*pb.Mo
 
 // init loads the address and timeout variables.
 func init() {
-    a, ok := os.LookupEnv("CURRENCY_SERVICE_ADDR")
-    if !ok {
-        log.Fatal("CURRENCY_SERVICE_ADDR environment variable not set")
-    }
-    currencyServiceAddr = &a
+    currencyServiceAddr = lookupServiceAddr("CURRENCY_SERVICE_ADDR")
 
     t, ok := os.LookupEnv("CURRENCY_SERVICE_TIMEOUT")
     if !ok {
@@ -1,12 +1,11 @@
 package client
 
 import (
-    "log"
     "net/http"
     "os"
     "strconv"
 
-    pb "main/genproto"
+    pb "checkoutservice/genproto"
 )
 
 var (
@@ -42,11 +41,7 @@ func SendOrderConfirmation(request *pb.SendOrderConfirmationRequest, header *htt
 
 // init loads the address and timeout variables.
 func init() {
-    a, ok := os.LookupEnv("EMAIL_SERVICE_ADDR")
-    if !ok {
-        log.Fatal("EMAIL_SERVICE_ADDR environment variable not set")
-    }
-    emailServiceAddr = &a
+    emailServiceAddr = lookupServiceAddr("EMAIL_SERVICE_ADDR")
 
     t, ok := os.LookupEnv("EMAIL_SERVICE_TIMEOUT")
     if !ok {
@@ -1,12 +1,11 @@
 package client
 
 import (
-    "log"
     "net/http"
     "os"
     "strconv"
 
-    pb "main/genproto"
+    pb "checkoutservice/genproto"
 )
 
 var (
@@ -42,11 +41,7 @@ func Charge(request *pb.ChargeRequest, header *http.Header) (*pb.ChargeResponse,
 
 // init loads the address and timeout variables.
 func init() {
-    a, ok := os.LookupEnv("PAYMENT_SERVICE_ADDR")
-    if !ok {
-        log.Fatal("PAYMENT_SERVICE_ADDR environment variable not set")
-    }
-    paymentServiceAddr = &a
+    paymentServiceAddr = lookupServiceAddr("PAYMENT_SERVICE_ADDR")
 
     t, ok := os.LookupEnv("PAYMENT_SERVICE_TIMEOUT")
     if !ok {
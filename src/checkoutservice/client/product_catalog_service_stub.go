@@ -5,8 +5,9 @@ import (
     "net/http"
     "os"
     "strconv"
+    "time"
 
-    pb "main/genproto"
+    pb "checkoutservice/genproto"
 )
 
 var (
@@ -19,78 +20,142 @@ const (
     listProductsRPC       = "list-products"
     getProductRPC         = "get-product"
     searchProductsRPC     = "search-products"
+    getStockRPC           = "get-stock"
+    reserveStockRPC       = "reserve-stock"
+    commitReservationRPC  = "commit-reservation"
+    releaseReservationRPC = "release-reservation"
 )
 
 // ListProducts represents the ProductCatalogService/ListProducts RPC.
+// context can be sent as custom headers. Responses are cached, see catalogCache and BypassCatalogCache.
+func ListProducts(request *pb.ListProductsRequest, header *http.Header) (*pb.ListProductsResponse, error) {
+    msg, err := productCatalogCache.call(listProductsRPC, request, header)
+    if err != nil {
+        return nil, err
+    }
+
+    return msg.(*pb.ListProductsResponse), nil
+}
+
+// GetProduct represents the ProductCatalogService/GetProduct RPC.
+// context can be sent as custom headers. Responses are cached, see catalogCache and BypassCatalogCache.
+func GetProduct(request *pb.GetProductRequest, header *http.Header) (*pb.Product, error) {
+    msg, err := productCatalogCache.call(getProductRPC, request, header)
+    if err != nil {
+        return nil, err
+    }
+
+    return msg.(*pb.Product), nil
+}
+
+// SearchProducts represents the ProductCatalogService/SearchProducts RPC.
 // context can be sent as custom headers.
-func ListProducts(request *pb.Empty, header *http.Header) (*pb.ListProductsResponse, error) {
+func SearchProducts(request *pb.SearchProductsRequest, header *http.Header) (*pb.SearchProductsResponse, error) {
     binReq, err := marshalRequest(request)
     if err != nil {
         return nil, err
     }
 
-    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, listProductsRPC, &binReq, header, *productCatalogServiceTimeout)
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, searchProductsRPC, &binReq, header, *productCatalogServiceTimeout)
     if err != nil {
         return nil, err
     }
 
-    msg, err := unmarshalResponse(respBody, header, listProductsRPC)
+    msg, err := unmarshalResponse(respBody, header, searchProductsRPC)
     if err != nil {
         return nil, err
     }
 
-    return (*msg).(*pb.ListProductsResponse), nil
+    return (*msg).(*pb.SearchProductsResponse), nil
 }
 
-// GetProduct represents the ProductCatalogService/GetProduct RPC.
+// GetStock represents the ProductCatalogService/GetStock RPC.
 // context can be sent as custom headers.
-func GetProduct(request *pb.GetProductRequest, header *http.Header) (*pb.Product, error) {
+func GetStock(request *pb.GetStockRequest, header *http.Header) (*pb.GetStockResponse, error) {
     binReq, err := marshalRequest(request)
     if err != nil {
         return nil, err
     }
 
-    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, getProductRPC, &binReq, header, *productCatalogServiceTimeout)
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, getStockRPC, &binReq, header, *productCatalogServiceTimeout)
     if err != nil {
         return nil, err
     }
 
-    msg, err := unmarshalResponse(respBody, header, getProductRPC)
+    msg, err := unmarshalResponse(respBody, header, getStockRPC)
     if err != nil {
         return nil, err
     }
 
-    return (*msg).(*pb.Product), nil
+    return (*msg).(*pb.GetStockResponse), nil
 }
 
-// SearchProducts represents the ProductCatalogService/SearchProducts RPC.
+// ReserveStock represents the ProductCatalogService/ReserveStock RPC.
 // context can be sent as custom headers.
-func SearchProducts(request *pb.SearchProductsRequest, header *http.Header) (*pb.SearchProductsResponse, error) {
+func ReserveStock(request *pb.ReserveStockRequest, header *http.Header) (*pb.Reservation, error) {
     binReq, err := marshalRequest(request)
     if err != nil {
         return nil, err
     }
 
-    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, searchProductsRPC, &binReq, header, *productCatalogServiceTimeout)
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, reserveStockRPC, &binReq, header, *productCatalogServiceTimeout)
     if err != nil {
         return nil, err
     }
 
-    msg, err := unmarshalResponse(respBody, header, searchProductsRPC)
+    msg, err := unmarshalResponse(respBody, header, reserveStockRPC)
     if err != nil {
         return nil, err
     }
 
-    return (*msg).(*pb.SearchProductsResponse), nil
+    return (*msg).(*pb.Reservation), nil
+}
+
+// CommitReservation represents the ProductCatalogService/CommitReservation RPC.
+// context can be sent as custom headers.
+func CommitReservation(request *pb.CommitReservationRequest, header *http.Header) (*pb.Empty, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, commitReservationRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, commitReservationRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Empty), nil
+}
+
+// ReleaseReservation represents the ProductCatalogService/ReleaseReservation RPC.
+// context can be sent as custom headers.
+func ReleaseReservation(request *pb.ReleaseReservationRequest, header *http.Header) (*pb.Empty, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, releaseReservationRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, releaseReservationRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Empty), nil
 }
 
 // init loads the address and timeout variables.
 func init() {
-    a, ok := os.LookupEnv("PRODUCT_CATALOG_SERVICE_ADDR")
-    if !ok {
-        log.Fatal("PRODUCT_CATALOG_SERVICE_ADDR environment variable not set")
-    }
-    productCatalogServiceAddr = &a
+    productCatalogServiceAddr = lookupServiceAddr("PRODUCT_CATALOG_SERVICE_ADDR")
 
     t, ok := os.LookupEnv("PRODUCT_CATALOG_SERVICE_TIMEOUT")
     if !ok {
@@ -104,4 +169,12 @@ func init() {
             productCatalogServiceTimeout = &t
         }
     }
+
+    if ttl, ok := os.LookupEnv("PRODUCT_CATALOG_CACHE_TTL"); ok {
+        d, err := time.ParseDuration(ttl)
+        if err != nil {
+            log.Fatalf("failed to parse PRODUCT_CATALOG_CACHE_TTL (%s) as time.Duration: %v", ttl, err)
+        }
+        productCatalogCache.ttl = d
+    }
 }
@@ -1,12 +1,11 @@
 package client
 
 import (
-    "log"
     "net/http"
     "os"
     "strconv"
 
-    pb "main/genproto"
+    pb "checkoutservice/genproto"
 )
 
 var (
@@ -64,11 +63,7 @@ func ShipOrder(request *pb.ShipOrderRequest, header *http.Header) (*pb.ShipOrder
 
 // init loads the address and timeout variables.
 func init() {
-    a, ok := os.LookupEnv("SHIPPING_SERVICE_ADDR")
-    if !ok {
-        log.Fatal("SHIPPING_SERVICE_ADDR environment variable not set")
-    }
-    shippingServiceAddr = &a
+    shippingServiceAddr = lookupServiceAddr("SHIPPING_SERVICE_ADDR")
 
     t, ok := os.LookupEnv("SHIPPING_SERVICE_TIMEOUT")
     if !ok {
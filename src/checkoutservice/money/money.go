@@ -3,7 +3,7 @@ package money
 import (
     "errors"
 
-    pb "main/genproto"
+    pb "checkoutservice/genproto"
 )
 
 const (
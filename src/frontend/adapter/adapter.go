@@ -0,0 +1,204 @@
+// Package adapter translates between API Gateway (HTTP API, payload version 2.0) events and regular HTTP
+// requests, so the frontend's HTTP handler can run unchanged inside a Lambda function.
+package adapter
+
+import (
+    "bytes"
+    "encoding/base64"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// RequestData represents the structure of the incoming JSON string.
+type RequestData struct {
+    RequestContext struct {
+        HTTP struct {
+            Method string `json:"method"`
+        } `json:"http"`
+    } `json:"requestContext"`
+    RawPath         string            `json:"rawPath"`
+    RawQueryString  string            `json:"rawQueryString"`
+    Headers         map[string]string `json:"headers"`
+    Cookies         []string          `json:"cookies"`
+    IsBase64Encoded bool              `json:"isBase64Encoded"`
+    Body            string            `json:"body"`
+}
+
+// ResponseData represents the structure of the outgoing JSON string.
+type ResponseData struct {
+    StatusCode      int               `json:"statusCode"`
+    Headers         map[string]string `json:"headers"`
+    Cookies         []string          `json:"cookies"`
+    IsBase64Encoded bool              `json:"isBase64Encoded"`
+    Body            string            `json:"body"`
+}
+
+// nonSplitHeaders is the set of header keys that should not be split based on a comma.
+var nonSplitHeaders = map[string]bool{
+    // Authentication
+    "authorization":       true,
+    "proxy-authorization": true,
+
+    // Cookies
+    "cookie":     true,
+    "set-cookie": true,
+
+    // User Agent
+    "user-agent": true,
+    "referer":    true, // May contain query strings with commas
+
+    // Caching
+    "if-match":            true,
+    "if-none-match":       true,
+    "if-unmodified-since": true,
+    "if-modified-since":   true,
+    "last-modified":       true,
+
+    // Content Headers
+    "content-disposition": true,
+    "content-type":        true,
+
+    // Range Requests
+    "range": true,
+
+    // Miscellaneous
+    "location":        true,
+    "link":            true, // May contain URIs with commas
+    "x-forwarded-for": true, // Often contains IP lists with commas
+}
+
+// ReconstructHTTPRequest reconstructs the incoming HTTP request.
+func ReconstructHTTPRequest(reqData *RequestData) (*http.Request, error) {
+    rawURL := reqData.RawPath
+    if reqData.RawQueryString != "" {
+        rawURL += "?" + reqData.RawQueryString
+    }
+    parsedURL, err := url.Parse(rawURL)
+    if err != nil {
+        return nil, err
+    }
+
+    var body io.Reader
+    if reqData.IsBase64Encoded {
+        decodedBody, err := base64.StdEncoding.DecodeString(reqData.Body)
+        if err != nil {
+            return nil, err
+        }
+        body = bytes.NewReader(decodedBody)
+    } else {
+        body = strings.NewReader(reqData.Body)
+    }
+
+    req, err := http.NewRequest(reqData.RequestContext.HTTP.Method, parsedURL.String(), body)
+    if err != nil {
+        return nil, err
+    }
+
+    for key, value := range reqData.Headers {
+        if nonSplitHeaders[strings.ToLower(key)] {
+            req.Header.Add(key, strings.TrimSpace(value))
+        } else {
+            for _, s := range strings.Split(value, ",") {
+                req.Header.Add(key, strings.TrimSpace(s))
+            }
+        }
+    }
+
+    for _, cookieStr := range reqData.Cookies {
+        parts := strings.Split(cookieStr, "; ")
+        if len(parts) == 0 {
+            continue
+        }
+
+        nameValue := strings.SplitN(parts[0], "=", 2)
+        if len(nameValue) != 2 {
+            continue
+        }
+        cookie := &http.Cookie{
+            Name:  nameValue[0],
+            Value: nameValue[1],
+        }
+
+        for _, attr := range parts[1:] {
+            attrParts := strings.SplitN(attr, "=", 2)
+            key := strings.ToLower(strings.TrimSpace(attrParts[0]))
+            var value string
+            if len(attrParts) > 1 {
+                value = strings.TrimSpace(attrParts[1])
+            }
+
+            switch key {
+            case "path":
+                cookie.Path = value
+            case "domain":
+                cookie.Domain = value
+            case "expires":
+                if t, err := time.Parse(time.RFC1123, value); err == nil {
+                    cookie.Expires = t
+                }
+            case "max-age":
+                if maxAge, err := strconv.Atoi(value); err == nil {
+                    cookie.MaxAge = maxAge
+                }
+            case "secure":
+                cookie.Secure = true
+            case "httponly":
+                cookie.HttpOnly = true
+            case "samesite":
+                switch strings.ToLower(value) {
+                case "lax":
+                    cookie.SameSite = http.SameSiteLaxMode
+                case "strict":
+                    cookie.SameSite = http.SameSiteStrictMode
+                case "none":
+                    cookie.SameSite = http.SameSiteNoneMode
+                }
+            }
+        }
+
+        req.AddCookie(cookie)
+    }
+
+    return req, nil
+}
+
+// ConvertToResponseData converts an HTTP response to ResponseData.
+func ConvertToResponseData(resp *http.Response) (*ResponseData, error) {
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    //resp.Header.Set("Content-Type", http.DetectContentType(body))
+
+    headers := make(map[string]string)
+    var cookies []string
+    for key, values := range resp.Header {
+        if key == "Set-Cookie" {
+            cookies = append(cookies, values...)
+        } else {
+            headers[key] = strings.Join(values, ",")
+        }
+    }
+
+    return &ResponseData{
+        StatusCode:      resp.StatusCode,
+        Headers:         headers,
+        Body:            base64.StdEncoding.EncodeToString(body),
+        IsBase64Encoded: true,
+        Cookies:         cookies,
+    }, nil
+}
+
+// Serve runs an HTTP request through the handler, recording the response, and converts it to ResponseData.
+func Serve(handler http.Handler, req *http.Request) (*ResponseData, error) {
+    respWriter := httptest.NewRecorder()
+    handler.ServeHTTP(respWriter, req)
+    return ConvertToResponseData(respWriter.Result())
+}
@@ -0,0 +1,141 @@
+package adapter
+
+import (
+    "bytes"
+    "encoding/base64"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// benchPage stands in for a rendered storefront page.
+var benchPage = strings.Repeat("<div class=\"product\"><img src=\"/static/img/products/mug.jpg\"><span>Mug</span></div>\n", 400)
+
+// benchHandler answers like a page handler of the frontend does.
+var benchHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    http.SetCookie(w, &http.Cookie{Name: "shop_session-id", Value: "4fd8f3c3-6e5a-4a39-a3c4-3f8d2f0e6b1a", MaxAge: 172800})
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    _, _ = io.WriteString(w, benchPage)
+})
+
+// benchEvent returns the event API Gateway sends for a form submission of the storefront.
+func benchEvent() *RequestData {
+    reqData := &RequestData{
+        RawPath:        "/cart",
+        RawQueryString: "",
+        Headers: map[string]string{
+            "accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+            "accept-encoding": "gzip, deflate, br",
+            "accept-language": "en-US,en;q=0.5",
+            "content-type":    "application/x-www-form-urlencoded",
+            "user-agent":      "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+            "x-forwarded-for": "203.0.113.7, 10.0.0.1",
+        },
+        Cookies:         []string{"shop_session-id=4fd8f3c3-6e5a-4a39-a3c4-3f8d2f0e6b1a", "shop_currency=EUR"},
+        IsBase64Encoded: true,
+        Body:            base64.StdEncoding.EncodeToString([]byte("product_id=OLJCESPC7Z&quantity=2")),
+    }
+    reqData.RequestContext.HTTP.Method = http.MethodPost
+    return reqData
+}
+
+func TestServe(t *testing.T) {
+    req, err := ReconstructHTTPRequest(benchEvent())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := req.Header.Get("x-forwarded-for"); got != "203.0.113.7, 10.0.0.1" {
+        t.Errorf("x-forwarded-for = %q, should not be split", got)
+    }
+    if c, err := req.Cookie("shop_currency"); err != nil || c.Value != "EUR" {
+        t.Errorf("shop_currency cookie = %v, %v", c, err)
+    }
+    if err := req.ParseForm(); err != nil || req.PostForm.Get("quantity") != "2" {
+        t.Errorf("form = %v, %v", req.PostForm, err)
+    }
+
+    respData, err := Serve(benchHandler, req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if respData.StatusCode != http.StatusOK || len(respData.Cookies) != 1 {
+        t.Errorf("unexpected response: status %d, cookies %v", respData.StatusCode, respData.Cookies)
+    }
+    body, err := base64.StdEncoding.DecodeString(respData.Body)
+    if err != nil || string(body) != benchPage {
+        t.Errorf("body does not round-trip: %v", err)
+    }
+}
+
+// BenchmarkReconstructHTTPRequest measures the conversion of an event into an HTTP request.
+func BenchmarkReconstructHTTPRequest(b *testing.B) {
+    reqData := benchEvent()
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        if _, err := ReconstructHTTPRequest(reqData); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+// BenchmarkConvertToResponseData measures the base64 encoding and header copying of a recorded response.
+func BenchmarkConvertToResponseData(b *testing.B) {
+    rec := httptest.NewRecorder()
+    benchHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    recorded := rec.Result()
+
+    b.SetBytes(int64(len(benchPage)))
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        resp := *recorded
+        resp.Body = io.NopCloser(bytes.NewReader(rec.Body.Bytes()))
+        if _, err := ConvertToResponseData(&resp); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+// BenchmarkServe measures the whole in-process Lambda request path, including the httptest recording.
+func BenchmarkServe(b *testing.B) {
+    reqData := benchEvent()
+    b.SetBytes(int64(len(benchPage)))
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        req, err := ReconstructHTTPRequest(reqData)
+        if err != nil {
+            b.Fatal(err)
+        }
+        if _, err := Serve(benchHandler, req); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+// BenchmarkHTTPLoopback measures the same request served by a plain HTTP server over a loopback connection.
+func BenchmarkHTTPLoopback(b *testing.B) {
+    server := httptest.NewServer(benchHandler)
+    b.Cleanup(server.Close)
+    reqData := benchEvent()
+    form, _ := base64.StdEncoding.DecodeString(reqData.Body)
+
+    b.SetBytes(int64(len(benchPage)))
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        req, err := http.NewRequest(http.MethodPost, server.URL+reqData.RawPath, strings.NewReader(string(form)))
+        if err != nil {
+            b.Fatal(err)
+        }
+        for k, v := range reqData.Headers {
+            req.Header.Set(k, v)
+        }
+        req.Header.Set("cookie", strings.Join(reqData.Cookies, "; "))
+        resp, err := server.Client().Do(req)
+        if err != nil {
+            b.Fatal(err)
+        }
+        _, _ = io.Copy(io.Discard, resp.Body)
+        resp.Body.Close()
+    }
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+    "errors"
+    "net/http"
+    "sync"
+    "time"
+
+    "google.golang.org/protobuf/proto"
+
+    "logging"
+)
+
+const (
+    // defaultCatalogCacheTTL is how long a cached catalog response is used before it's revalidated.
+    defaultCatalogCacheTTL = 30 * time.Second
+    // catalogCacheSize is the most responses the catalog cache holds.
+    catalogCacheSize = 1000
+)
+
+var (
+    // errNotModified is returned by sendRequest for a Not Modified response, which has no body.
+    errNotModified = errors.New("not modified")
+
+    catalogCacheHits          = logging.NewCounter("catalog_cache_hits")
+    catalogCacheRevalidations = logging.NewCounter("catalog_cache_revalidations")
+    catalogCacheMisses        = logging.NewCounter("catalog_cache_misses")
+)
+
+// catalogCache is a read-through cache of the responses of ListProducts and GetProduct. A response is used for ttl,
+// then revalidated with the ETag of the catalog it was read from: the service answers Not Modified, without a body,
+// until the catalog changes.
+type catalogCache struct {
+    // ttl is how long a response is used before it's revalidated; zero or less disables the cache.
+    ttl time.Duration
+
+    mu      sync.Mutex
+    entries map[string]*catalogCacheEntry
+}
+
+type catalogCacheEntry struct {
+    msg     proto.Message
+    etag    string
+    expires time.Time
+}
+
+var productCatalogCache = &catalogCache{ttl: defaultCatalogCacheTTL, entries: make(map[string]*catalogCacheEntry)}
+
+// BypassCatalogCache returns a copy of the headers of a call that makes ListProducts or GetProduct read the catalog
+// rather than the cache. The response still replaces the cached one.
+func BypassCatalogCache(header *http.Header) *http.Header {
+    bypass := http.Header{}
+    if header != nil {
+        bypass = header.Clone()
+    }
+    bypass.Set("cache-control", "no-cache")
+    return &bypass
+}
+
+// call returns the response of a catalog RPC from the cache or, if it isn't cached or expired, from the service.
+func (c *catalogCache) call(rpcName string, request proto.Message, header *http.Header) (proto.Message, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+    if c.ttl <= 0 {
+        return c.fetch(rpcName, &binReq, header)
+    }
+
+    key := rpcName + "\x00" + string(binReq)
+    bypass := header != nil && header.Get("cache-control") == "no-cache"
+    c.mu.Lock()
+    entry := c.entries[key]
+    c.mu.Unlock()
+    if entry != nil && !bypass {
+        if time.Now().Before(entry.expires) {
+            catalogCacheHits.Add(1)
+            return proto.Clone(entry.msg), nil
+        }
+        if entry.etag != "" {
+            conditional := http.Header{}
+            if header != nil {
+                conditional = header.Clone()
+            }
+            conditional.Set("if-none-match", entry.etag)
+            header = &conditional
+        }
+    }
+
+    respBody, respHeader, err := sendRequest(*productCatalogServiceAddr, productCatalogService, rpcName, &binReq, header, *productCatalogServiceTimeout)
+    if errors.Is(err, errNotModified) && entry != nil {
+        catalogCacheRevalidations.Add(1)
+        c.put(key, &catalogCacheEntry{msg: entry.msg, etag: entry.etag, expires: time.Now().Add(c.ttl)})
+        return proto.Clone(entry.msg), nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    msg, err := unmarshalResponse(respBody, respHeader, rpcName)
+    if err != nil {
+        return nil, err
+    }
+    catalogCacheMisses.Add(1)
+    c.put(key, &catalogCacheEntry{msg: proto.Clone(*msg), etag: respHeader.Get("etag"), expires: time.Now().Add(c.ttl)})
+    return *msg, nil
+}
+
+// fetch calls a catalog RPC without the cache.
+func (c *catalogCache) fetch(rpcName string, binReq *[]byte, header *http.Header) (proto.Message, error) {
+    respBody, respHeader, err := sendRequest(*productCatalogServiceAddr, productCatalogService, rpcName, binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+    msg, err := unmarshalResponse(respBody, respHeader, rpcName)
+    if err != nil {
+        return nil, err
+    }
+    return *msg, nil
+}
+
+// put caches a response. If the cache is full, it first drops the expired responses or, if none expired, an
+// arbitrary one.
+func (c *catalogCache) put(key string, entry *catalogCacheEntry) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if _, ok := c.entries[key]; !ok && len(c.entries) >= catalogCacheSize {
+        now := time.Now()
+        for k, e := range c.entries {
+            if !now.Before(e.expires) {
+                delete(c.entries, k)
+            }
+        }
+        for k := range c.entries {
+            if len(c.entries) < catalogCacheSize {
+                break
+            }
+            delete(c.entries, k)
+        }
+    }
+    c.entries[key] = entry
+}
@@ -12,7 +12,7 @@ import (
     "google.golang.org/grpc/status"
     "google.golang.org/protobuf/proto"
 
-    pb "main/genproto"
+    pb "frontend/genproto"
 )
 
 const (
@@ -29,7 +29,7 @@ func determineMessageType(rpcName string) proto.Message {
         msg = &pb.Product{}
     case searchProductsRPC:
         msg = &pb.SearchProductsResponse{}
-    case getCartRPC:
+    case getCartRPC, mergeCartsRPC:
         msg = &pb.Cart{}
     case getQuoteRPC:
         msg = &pb.GetQuoteResponse{}
@@ -49,6 +49,8 @@ func determineMessageType(rpcName string) proto.Message {
         /*
            CartService/AddItem
            CartService/EmptyCart
+           CartService/RemoveItem
+           CartService/SetItemQuantity
         */
         msg = &pb.Empty{}
     }
@@ -63,7 +65,7 @@ func sendRequest(addr, serviceName, rpcName string, binReq *[]byte, headers *htt
     }
 
     if headers != nil {
-        req.Header = *headers
+        req.Header = headers.Clone()
     }
     req.Header.Set("rpc-name", rpcName)
     req.Header.Set("content-type", "application/octet-stream")
@@ -75,6 +77,9 @@ func sendRequest(addr, serviceName, rpcName string, binReq *[]byte, headers *htt
     }
     defer resp.Body.Close()
 
+    if resp.StatusCode == http.StatusNotModified {
+        return nil, &resp.Header, errNotModified
+    }
     if resp.StatusCode != http.StatusOK {
         return nil, nil, fmt.Errorf("received non-OK response: %s", resp.Status)
     }
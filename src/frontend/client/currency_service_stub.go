@@ -6,7 +6,7 @@ import (
     "os"
     "strconv"
 
-    pb "main/genproto"
+    pb "frontend/genproto"
 )
 
 var (
@@ -5,8 +5,9 @@ import (
     "net/http"
     "os"
     "strconv"
+    "time"
 
-    pb "main/genproto"
+    pb "frontend/genproto"
 )
 
 var (
@@ -22,45 +23,25 @@ const (
 )
 
 // ListProducts represents the ProductCatalogService/ListProducts RPC.
-// context can be sent as custom headers.
-func ListProducts(request *pb.Empty, header *http.Header) (*pb.ListProductsResponse, error) {
-    binReq, err := marshalRequest(request)
-    if err != nil {
-        return nil, err
-    }
-
-    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, listProductsRPC, &binReq, header, *productCatalogServiceTimeout)
+// context can be sent as custom headers. Responses are cached, see catalogCache and BypassCatalogCache.
+func ListProducts(request *pb.ListProductsRequest, header *http.Header) (*pb.ListProductsResponse, error) {
+    msg, err := productCatalogCache.call(listProductsRPC, request, header)
     if err != nil {
         return nil, err
     }
 
-    msg, err := unmarshalResponse(respBody, header, listProductsRPC)
-    if err != nil {
-        return nil, err
-    }
-
-    return (*msg).(*pb.ListProductsResponse), nil
+    return msg.(*pb.ListProductsResponse), nil
 }
 
 // GetProduct represents the ProductCatalogService/GetProduct RPC.
-// context can be sent as custom headers.
+// context can be sent as custom headers. Responses are cached, see catalogCache and BypassCatalogCache.
 func GetProduct(request *pb.GetProductRequest, header *http.Header) (*pb.Product, error) {
-    binReq, err := marshalRequest(request)
+    msg, err := productCatalogCache.call(getProductRPC, request, header)
     if err != nil {
         return nil, err
     }
 
-    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, getProductRPC, &binReq, header, *productCatalogServiceTimeout)
-    if err != nil {
-        return nil, err
-    }
-
-    msg, err := unmarshalResponse(respBody, header, getProductRPC)
-    if err != nil {
-        return nil, err
-    }
-
-    return (*msg).(*pb.Product), nil
+    return msg.(*pb.Product), nil
 }
 
 // SearchProducts represents the ProductCatalogService/SearchProducts RPC.
@@ -104,4 +85,12 @@ func init() {
             productCatalogServiceTimeout = &t
         }
     }
+
+    if ttl, ok := os.LookupEnv("PRODUCT_CATALOG_CACHE_TTL"); ok {
+        d, err := time.ParseDuration(ttl)
+        if err != nil {
+            log.Fatalf("failed to parse PRODUCT_CATALOG_CACHE_TTL (%s) as time.Duration: %v", ttl, err)
+        }
+        productCatalogCache.ttl = d
+    }
 }
@@ -1,4 +1,4 @@
-module main
+module frontend
 
 go 1.22.3
 
@@ -12,6 +12,7 @@ require (
 	github.com/sirupsen/logrus v1.9.3
 	google.golang.org/grpc v1.65.0
 	google.golang.org/protobuf v1.34.2
+	logging v0.0.0
 )
 
 require (
@@ -26,3 +27,5 @@ require (
 	golang.org/x/text v0.16.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240701130421-f6361c86f094 // indirect
 )
+
+replace logging => ../logging
@@ -9,6 +9,7 @@ import (
     "math/rand"
     "net"
     "net/http"
+    "net/url"
     "os"
     "strconv"
     "strings"
@@ -17,11 +18,13 @@ import (
     "github.com/gorilla/mux"
     "github.com/pkg/errors"
     "github.com/sirupsen/logrus"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
 
-    stubs "main/client"
-    pb "main/genproto"
-    "main/money"
-    "main/validator"
+    stubs "frontend/client"
+    pb "frontend/genproto"
+    "frontend/money"
+    "frontend/validator"
 )
 
 type platformDetails struct {
@@ -51,11 +54,21 @@ func (fe *frontendServer) homeHandler(w http.ResponseWriter, r *http.Request) {
         renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve currencies"), http.StatusInternalServerError)
         return
     }
-    products, err := fe.getProducts(r.Context())
+    listing, err := parseProductListing(r.URL.Query())
     if err != nil {
-        renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve products"), http.StatusInternalServerError)
+        renderHTTPError(log, r, w, errors.Wrap(err, "invalid product listing"), http.StatusBadRequest)
         return
     }
+    page, err := fe.getProducts(r.Context(), listing.request())
+    if err != nil {
+        code := http.StatusInternalServerError
+        if rpcStatus(err).Code() == codes.InvalidArgument {
+            code = http.StatusBadRequest
+        }
+        renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve products"), code)
+        return
+    }
+    products := page.GetProducts()
     cart, err := fe.getCart(r.Context(), sessionID(r))
     if err != nil {
         renderHTTPError(log, r, w, errors.Wrap(err, "could not retrieve cart"), http.StatusInternalServerError)
@@ -99,6 +112,9 @@ func (fe *frontendServer) homeHandler(w http.ResponseWriter, r *http.Request) {
         "show_currency": true,
         "currencies":    currencies,
         "products":      ps,
+        "listing":       listing,
+        "total":         page.GetTotalSize(),
+        "next_page":     listing.pageURL(page.GetNextPageToken()),
         "cart_size":     cartSize(cart),
         "banner_color":  os.Getenv("BANNER_COLOR"), // illustrates canary deployments
         "ad":            fe.chooseAd(r.Context(), []string{}, log),
@@ -107,6 +123,105 @@ func (fe *frontendServer) homeHandler(w http.ResponseWriter, r *http.Request) {
     }
 }
 
+// homePageSize is the number of products on a page of the home page, which shows them three to a row.
+const homePageSize = 12
+
+// productSortOrders maps the values of the sort parameter of the home page to the sort orders of the catalog.
+var productSortOrders = map[string]pb.ProductSortOrder{
+    "":           pb.ProductSortOrder_PRODUCT_SORT_ORDER_UNSPECIFIED,
+    "name":       pb.ProductSortOrder_PRODUCT_SORT_ORDER_NAME,
+    "price-asc":  pb.ProductSortOrder_PRODUCT_SORT_ORDER_PRICE_ASCENDING,
+    "price-desc": pb.ProductSortOrder_PRODUCT_SORT_ORDER_PRICE_DESCENDING,
+}
+
+// productListing holds the query parameters of the home page: the category, sort order and price range, in whole US
+// dollars, to list products by, and the token of the page to show.
+type productListing struct {
+    Category string
+    Sort     string
+    MinPrice string
+    MaxPrice string
+    Page     string
+}
+
+// parseProductListing reads the query parameters of the home page.
+func parseProductListing(query url.Values) (productListing, error) {
+    l := productListing{
+        Category: strings.TrimSpace(query.Get("category")),
+        Sort:     query.Get("sort"),
+        MinPrice: strings.TrimSpace(query.Get("min_price")),
+        MaxPrice: strings.TrimSpace(query.Get("max_price")),
+        Page:     query.Get("page"),
+    }
+    if _, ok := productSortOrders[l.Sort]; !ok {
+        return l, fmt.Errorf("unknown sort order %q", l.Sort)
+    }
+    for _, price := range []string{l.MinPrice, l.MaxPrice} {
+        if _, err := parseDollars(price); err != nil {
+            return l, err
+        }
+    }
+    return l, nil
+}
+
+// parseDollars parses a price in whole US dollars, or returns nil if it's empty.
+func parseDollars(price string) (*pb.Money, error) {
+    if price == "" {
+        return nil, nil
+    }
+    units, err := strconv.ParseUint(price, 10, 32)
+    if err != nil {
+        return nil, fmt.Errorf("invalid price %q, expected a whole number of dollars", price)
+    }
+    return &pb.Money{CurrencyCode: "USD", Units: int64(units)}, nil
+}
+
+// request returns the catalog request of a listing.
+func (l productListing) request() *pb.ListProductsRequest {
+    minPrice, _ := parseDollars(l.MinPrice)
+    maxPrice, _ := parseDollars(l.MaxPrice)
+    return &pb.ListProductsRequest{
+        PageSize:    homePageSize,
+        PageToken:   l.Page,
+        Category:    l.Category,
+        MinPriceUsd: minPrice,
+        MaxPriceUsd: maxPrice,
+        SortOrder:   productSortOrders[l.Sort],
+    }
+}
+
+// pageURL returns the query string of the home page that shows the page of a token with the same filters and order,
+// or an empty string if there's no token.
+func (l productListing) pageURL(token string) string {
+    if token == "" {
+        return ""
+    }
+    return l.withPage(token)
+}
+
+// FirstPageURL returns the query string of the first page of the listing.
+func (l productListing) FirstPageURL() string {
+    return l.withPage("")
+}
+
+// CategoryURL returns the query string of the first page of a listing of a category, with the same order and price
+// range.
+func (l productListing) CategoryURL(category string) string {
+    l.Category = category
+    return l.withPage("")
+}
+
+func (l productListing) withPage(token string) string {
+    query := url.Values{}
+    for name, value := range map[string]string{"category": l.Category, "sort": l.Sort, "min_price": l.MinPrice,
+        "max_price": l.MaxPrice, "page": token} {
+        if value != "" {
+            query.Set(name, value)
+        }
+    }
+    return "?" + query.Encode()
+}
+
 func (plat *platformDetails) setPlatformDetails(env string) {
     if env == "aws" {
         plat.provider = "AWS"
@@ -218,7 +333,49 @@ func (fe *frontendServer) addToCartHandler(w http.ResponseWriter, r *http.Reques
     }
 
     if err := fe.insertCart(r.Context(), sessionID(r), p.GetId(), int32(payload.Quantity)); err != nil {
-        renderHTTPError(log, r, w, errors.Wrap(err, "failed to add to cart"), http.StatusInternalServerError)
+        renderHTTPError(log, r, w, errors.Wrap(err, "failed to add to cart"), cartErrorCode(err))
+        return
+    }
+    w.Header().Set("location", baseUrl+"/cart")
+    w.WriteHeader(http.StatusFound)
+}
+
+func (fe *frontendServer) updateCartItemHandler(w http.ResponseWriter, r *http.Request) {
+    log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+    quantity, err := strconv.ParseUint(r.FormValue("quantity"), 10, 32)
+    if err != nil {
+        renderHTTPError(log, r, w, errors.Wrap(err, "invalid quantity"), http.StatusUnprocessableEntity)
+        return
+    }
+    payload := validator.UpdateCartPayload{
+        Quantity:  quantity,
+        ProductID: r.FormValue("product_id"),
+    }
+    if err := payload.Validate(); err != nil {
+        renderHTTPError(log, r, w, validator.ValidationErrorResponse(err), http.StatusUnprocessableEntity)
+        return
+    }
+    log.WithField("product", payload.ProductID).WithField("quantity", payload.Quantity).Debug("updating cart item")
+
+    if err := fe.setCartItemQuantity(r.Context(), sessionID(r), payload.ProductID, int32(payload.Quantity)); err != nil {
+        renderHTTPError(log, r, w, errors.Wrap(err, "failed to update cart"), cartErrorCode(err))
+        return
+    }
+    w.Header().Set("location", baseUrl+"/cart")
+    w.WriteHeader(http.StatusFound)
+}
+
+func (fe *frontendServer) removeFromCartHandler(w http.ResponseWriter, r *http.Request) {
+    log := r.Context().Value(ctxKeyLog{}).(logrus.FieldLogger)
+    payload := validator.RemoveFromCartPayload{ProductID: r.FormValue("product_id")}
+    if err := payload.Validate(); err != nil {
+        renderHTTPError(log, r, w, validator.ValidationErrorResponse(err), http.StatusUnprocessableEntity)
+        return
+    }
+    log.WithField("product", payload.ProductID).Debug("removing from cart")
+
+    if err := fe.removeFromCart(r.Context(), sessionID(r), payload.ProductID); err != nil {
+        renderHTTPError(log, r, w, errors.Wrap(err, "failed to remove from cart"), cartErrorCode(err))
         return
     }
     w.Header().Set("location", baseUrl+"/cart")
@@ -357,7 +514,7 @@ func (fe *frontendServer) placeOrderHandler(w http.ResponseWriter, r *http.Reque
                 State:         payload.State,
                 ZipCode:       int32(payload.ZipCode),
                 Country:       payload.Country},
-        }, nil) // todo: perhaps use r.Context() ?
+        }, rpcHeader(r.Context()))
     if err != nil {
         renderHTTPError(log, r, w, errors.Wrap(err, "failed to complete the order"), http.StatusInternalServerError)
         return
@@ -534,15 +691,42 @@ func renderHTTPError(log logrus.FieldLogger, r *http.Request, w http.ResponseWri
     w.Header().Set("Content-Type", "text/html; charset=utf-8")
     w.WriteHeader(code)
 
-    if templateErr := templates.ExecuteTemplate(w, "error", injectCommonTemplateData(r, map[string]interface{}{
+    payload := map[string]interface{}{
         "error":       errMsg,
         "status_code": code,
         "status":      http.StatusText(code),
-    })); templateErr != nil {
+    }
+    // A backend that turned the request down explains why to the shopper.
+    if st := rpcStatus(err); st != nil && code < http.StatusInternalServerError {
+        payload["message"] = st.Message()
+    }
+    if templateErr := templates.ExecuteTemplate(w, "error", injectCommonTemplateData(r, payload)); templateErr != nil {
         log.Println(templateErr)
     }
 }
 
+// rpcStatus returns the status carried by the error of an RPC, through any wrapping, or nil if there's none.
+func rpcStatus(err error) *status.Status {
+    var se interface{ GRPCStatus() *status.Status }
+    if errors.As(err, &se) {
+        return se.GRPCStatus()
+    }
+    return nil
+}
+
+// cartErrorCode returns the HTTP status code of a failed cart update. The cart service turns down invalid requests
+// with InvalidArgument, and updates that would take the cart over its limits with FailedPrecondition.
+func cartErrorCode(err error) int {
+    switch rpcStatus(err).Code() {
+    case codes.InvalidArgument:
+        return http.StatusUnprocessableEntity
+    case codes.FailedPrecondition:
+        return http.StatusConflict
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
 func injectCommonTemplateData(r *http.Request, payload map[string]interface{}) map[string]interface{} {
     data := map[string]interface{}{
         "session_id":        sessionID(r),
@@ -8,13 +8,15 @@ import (
 
     "github.com/google/uuid"
     "github.com/sirupsen/logrus"
+
+    "logging"
 )
 
 type ctxKeyLog struct{}
 type ctxKeyRequestID struct{}
 
 type logHandler struct {
-    log  *logrus.Logger
+    log  *logrus.Entry
     next http.Handler
 }
 
@@ -42,18 +44,23 @@ func (r *responseRecorder) WriteHeader(statusCode int) {
 
 func (lh *logHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
     ctx := r.Context()
-    requestID, _ := uuid.NewRandom()
-    ctx = context.WithValue(ctx, ctxKeyRequestID{}, requestID.String())
+    requestID := r.Header.Get(logging.RequestIDHeader)
+    if requestID == "" {
+        u, _ := uuid.NewRandom()
+        requestID = u.String()
+    }
+    ctx = context.WithValue(ctx, ctxKeyRequestID{}, requestID)
+    w.Header().Set(logging.RequestIDHeader, requestID)
 
     start := time.Now()
     rr := &responseRecorder{w: w}
-    log := lh.log.WithFields(logrus.Fields{
-        "http.req.path":   r.URL.Path,
-        "http.req.method": r.Method,
-        "http.req.id":     requestID.String(),
+    log := logging.WithLambdaContext(lh.log, ctx).WithFields(logrus.Fields{
+        "http.req.path":        r.URL.Path,
+        "http.req.method":      r.Method,
+        logging.FieldRequestID: requestID,
     })
     if v, ok := r.Context().Value(ctxKeySessionID{}).(string); ok {
-        log = log.WithField("session", v)
+        log = log.WithField(logging.FieldSessionID, v)
     }
     log.Debug("request started")
     defer func() {
@@ -2,20 +2,35 @@ package main
 
 import (
     "context"
+    "net/http"
     "time"
 
     "github.com/pkg/errors"
 
-    stubs "main/client"
-    pb "main/genproto"
+    "logging"
+
+    stubs "frontend/client"
+    pb "frontend/genproto"
 )
 
 const (
     avoidNoopCurrencyConversionRPC = false
 )
 
+// rpcHeader builds the headers that carry the request's correlation IDs to the backend services.
+func rpcHeader(ctx context.Context) *http.Header {
+    header := http.Header{}
+    if v, ok := ctx.Value(ctxKeyRequestID{}).(string); ok {
+        header.Set(logging.RequestIDHeader, v)
+    }
+    if v, ok := ctx.Value(ctxKeySessionID{}).(string); ok {
+        header.Set(logging.SessionIDHeader, v)
+    }
+    return &header
+}
+
 func (fe *frontendServer) getCurrencies(ctx context.Context) ([]string, error) {
-    currs, err := stubs.GetSupportedCurrencies(&pb.Empty{}, nil)
+    currs, err := stubs.GetSupportedCurrencies(&pb.Empty{}, rpcHeader(ctx))
     if err != nil {
         return nil, err
     }
@@ -28,23 +43,22 @@ func (fe *frontendServer) getCurrencies(ctx context.Context) ([]string, error) {
     return out, nil
 }
 
-func (fe *frontendServer) getProducts(ctx context.Context) ([]*pb.Product, error) {
-    resp, err := stubs.ListProducts(&pb.Empty{}, nil)
-    return resp.GetProducts(), err
+func (fe *frontendServer) getProducts(ctx context.Context, req *pb.ListProductsRequest) (*pb.ListProductsResponse, error) {
+    return stubs.ListProducts(req, rpcHeader(ctx))
 }
 
 func (fe *frontendServer) getProduct(ctx context.Context, id string) (*pb.Product, error) {
-    resp, err := stubs.GetProduct(&pb.GetProductRequest{Id: id}, nil)
+    resp, err := stubs.GetProduct(&pb.GetProductRequest{Id: id}, rpcHeader(ctx))
     return resp, err
 }
 
 func (fe *frontendServer) getCart(ctx context.Context, userID string) ([]*pb.CartItem, error) {
-    resp, err := stubs.GetCart(&pb.GetCartRequest{UserId: userID}, nil)
+    resp, err := stubs.GetCart(&pb.GetCartRequest{UserId: userID}, rpcHeader(ctx))
     return resp.GetItems(), err
 }
 
 func (fe *frontendServer) emptyCart(ctx context.Context, userID string) error {
-    _, err := stubs.EmptyCart(&pb.EmptyCartRequest{UserId: userID}, nil)
+    _, err := stubs.EmptyCart(&pb.EmptyCartRequest{UserId: userID}, rpcHeader(ctx))
     return err
 }
 
@@ -54,7 +68,22 @@ func (fe *frontendServer) insertCart(ctx context.Context, userID, productID stri
         Item: &pb.CartItem{
             ProductId: productID,
             Quantity:  quantity},
-    }, nil)
+    }, rpcHeader(ctx))
+    return err
+}
+
+func (fe *frontendServer) removeFromCart(ctx context.Context, userID, productID string) error {
+    _, err := stubs.RemoveItem(&pb.RemoveItemRequest{UserId: userID, ProductId: productID}, rpcHeader(ctx))
+    return err
+}
+
+func (fe *frontendServer) setCartItemQuantity(ctx context.Context, userID, productID string, quantity int32) error {
+    _, err := stubs.SetItemQuantity(&pb.SetItemQuantityRequest{
+        UserId: userID,
+        Item: &pb.CartItem{
+            ProductId: productID,
+            Quantity:  quantity},
+    }, rpcHeader(ctx))
     return err
 }
 
@@ -62,7 +91,7 @@ func (fe *frontendServer) convertCurrency(ctx context.Context, money *pb.Money,
     if avoidNoopCurrencyConversionRPC && money.GetCurrencyCode() == currency {
         return money, nil
     }
-    return stubs.Convert(&pb.CurrencyConversionRequest{From: money, ToCode: currency}, nil)
+    return stubs.Convert(&pb.CurrencyConversionRequest{From: money, ToCode: currency}, rpcHeader(ctx))
 }
 
 func (fe *frontendServer) getShippingQuote(ctx context.Context, items []*pb.CartItem, currency string) (*pb.Money, error) {
@@ -70,7 +99,7 @@ func (fe *frontendServer) getShippingQuote(ctx context.Context, items []*pb.Cart
         &pb.GetQuoteRequest{
             Address: nil,
             Items:   items},
-        nil)
+        rpcHeader(ctx))
     if err != nil {
         return nil, err
     }
@@ -79,7 +108,7 @@ func (fe *frontendServer) getShippingQuote(ctx context.Context, items []*pb.Cart
 }
 
 func (fe *frontendServer) getRecommendations(ctx context.Context, userID string, productIDs []string) ([]*pb.Product, error) {
-    resp, err := stubs.ListRecommendations(&pb.ListRecommendationsRequest{UserId: userID, ProductIds: productIDs}, nil)
+    resp, err := stubs.ListRecommendations(&pb.ListRecommendationsRequest{UserId: userID, ProductIds: productIDs}, rpcHeader(ctx))
     if err != nil {
         return nil, err
     }
@@ -103,6 +132,6 @@ func (fe *frontendServer) getAd(ctx context.Context, ctxKeys []string) ([]*pb.Ad
 
     resp, err := stubs.GetAds(&pb.AdRequest{
         ContextKeys: ctxKeys,
-    }, nil)
+    }, rpcHeader(ctx))
     return resp.GetAds(), errors.Wrap(err, "failed to get ads")
 }
@@ -25,6 +25,15 @@ type AddToCartPayload struct {
     ProductID string `validate:"required"`
 }
 
+type UpdateCartPayload struct {
+    Quantity  uint64 `validate:"gte=0,lte=10"`
+    ProductID string `validate:"required"`
+}
+
+type RemoveFromCartPayload struct {
+    ProductID string `validate:"required"`
+}
+
 type PlaceOrderPayload struct {
     Email         string `validate:"required,email"`
     StreetAddress string `validate:"required,max=512"`
@@ -47,6 +56,14 @@ func (ad *AddToCartPayload) Validate() error {
     return validate.Struct(ad)
 }
 
+func (uc *UpdateCartPayload) Validate() error {
+    return validate.Struct(uc)
+}
+
+func (rc *RemoveFromCartPayload) Validate() error {
+    return validate.Struct(rc)
+}
+
 func (po *PlaceOrderPayload) Validate() error {
     return validate.Struct(po)
 }
@@ -0,0 +1,3 @@
+module loadgenerator
+
+go 1.22.3
@@ -0,0 +1,176 @@
+// Command loadgenerator simulates shoppers browsing the storefront, in the manner of the Locust load generator of the
+// upstream demo. It works against any deployment of the frontend: a Kubernetes service, a Docker container, or a
+// Lambda function behind a function URL or an API gateway.
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "strconv"
+    "sync"
+    "syscall"
+    "time"
+)
+
+const (
+    defaultUsers          = 10
+    defaultSpawnRate      = 1.0
+    defaultDuration       = 10 * time.Minute
+    defaultThinkTime      = "1s-10s"
+    defaultTimeout        = 30 * time.Second
+    defaultReportInterval = 30 * time.Second
+)
+
+// envOr returns the value of an environment variable, or a default value if it isn't set.
+func envOr(key, def string) string {
+    if v, ok := os.LookupEnv(key); ok {
+        return v
+    }
+    return def
+}
+
+func main() {
+    var (
+        frontendAddr   = flag.String("frontend", os.Getenv("FRONTEND_ADDR"), "base URL of the frontend, including BASE_URL if any (env FRONTEND_ADDR)")
+        users          = flag.String("users", envOr("USERS", strconv.Itoa(defaultUsers)), "number of concurrent users (env USERS)")
+        spawnRate      = flag.String("spawn-rate", envOr("SPAWN_RATE", strconv.FormatFloat(defaultSpawnRate, 'f', -1, 64)), "users started per second (env SPAWN_RATE)")
+        duration       = flag.String("duration", envOr("DURATION", defaultDuration.String()), "length of the run; 0 runs until interrupted (env DURATION)")
+        stages         = flag.String("profile", os.Getenv("PROFILE"), "ramp profile as duration:users stages, e.g. 1m:10,5m:50,1m:0; overrides users, spawn-rate and duration (env PROFILE)")
+        think          = flag.String("think-time", envOr("THINK_TIME", defaultThinkTime), "pause between the tasks of a user, as min-max (env THINK_TIME)")
+        timeout        = flag.Duration("timeout", defaultTimeout, "timeout of a single page request, including redirects")
+        reportInterval = flag.Duration("report-interval", defaultReportInterval, "how often to print intermediate statistics; 0 disables them")
+        reportFile     = flag.String("report", os.Getenv("REPORT_FILE"), "file to write the final JSON report to; stdout if empty (env REPORT_FILE)")
+    )
+    flag.Parse()
+
+    if *frontendAddr == "" {
+        log.Fatal("FRONTEND_ADDR environment variable not set")
+    }
+    thinkTime, err := parseThinkTime(*think)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    var p profile
+    if *stages != "" {
+        if p, err = parseProfile(*stages); err != nil {
+            log.Fatalf("invalid profile: %v", err)
+        }
+    } else {
+        n, err := strconv.Atoi(*users)
+        if err != nil || n < 0 {
+            log.Fatalf("invalid user count %q", *users)
+        }
+        rate, err := strconv.ParseFloat(*spawnRate, 64)
+        if err != nil || rate < 0 {
+            log.Fatalf("invalid spawn rate %q", *spawnRate)
+        }
+        d, err := time.ParseDuration(*duration)
+        if err != nil || d < 0 {
+            log.Fatalf("invalid duration %q", *duration)
+        }
+        if d == 0 {
+            d = time.Duration(1<<63 - 1)
+        }
+        p = rampProfile(n, rate, d)
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    log.Printf("Starting load on %s for %v", *frontendAddr, p.duration())
+    stats := newCollector()
+    peakUsers := run(ctx, p, *frontendAddr, thinkTime, *timeout, stats, *reportInterval)
+
+    report := stats.report(*frontendAddr, peakUsers)
+    writeTable(os.Stderr, report)
+    if err := writeReport(report, *reportFile); err != nil {
+        log.Fatalf("failed to write report: %v", err)
+    }
+}
+
+// run starts and stops shoppers to follow the profile until it ends or the context is cancelled,
+// and returns the highest number of concurrent shoppers.
+func run(ctx context.Context, p profile, baseURL string, think thinkTime, timeout time.Duration, stats *collector, reportInterval time.Duration) int {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.MaxIdleConnsPerHost = 100
+
+    var (
+        wg        sync.WaitGroup
+        cancels   []context.CancelFunc
+        peakUsers int
+        seed      = time.Now().UnixNano()
+    )
+    defer func() {
+        for _, cancel := range cancels {
+            cancel()
+        }
+        wg.Wait()
+    }()
+
+    tick := time.NewTicker(100 * time.Millisecond)
+    defer tick.Stop()
+    var reports <-chan time.Time
+    if reportInterval > 0 {
+        reportTicker := time.NewTicker(reportInterval)
+        defer reportTicker.Stop()
+        reports = reportTicker.C
+    }
+
+    start := time.Now()
+    for {
+        elapsed := time.Since(start)
+        if elapsed >= p.duration() {
+            return peakUsers
+        }
+
+        want := p.usersAt(elapsed)
+        for len(cancels) < want {
+            userCtx, cancel := context.WithCancel(ctx)
+            cancels = append(cancels, cancel)
+            s := newShopper(baseURL, transport, timeout, stats, think, seed+int64(len(cancels)))
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                s.run(userCtx)
+            }()
+        }
+        for len(cancels) > want {
+            cancels[len(cancels)-1]()
+            cancels = cancels[:len(cancels)-1]
+        }
+        if len(cancels) > peakUsers {
+            peakUsers = len(cancels)
+        }
+
+        select {
+        case <-ctx.Done():
+            log.Print("Interrupted, stopping users")
+            return peakUsers
+        case <-reports:
+            r := stats.report(baseURL, peakUsers)
+            log.Printf("%d users, %d requests, %d failures, %.2f req/s, p50 %.0fms, p95 %.0fms", len(cancels),
+                r.Total.Requests, r.Total.Failures, r.Total.ThroughputRPS, r.Total.LatencyMs.P50, r.Total.LatencyMs.P95)
+        case <-tick.C:
+        }
+    }
+}
+
+// writeReport writes the report as indented JSON to a file, or to stdout if path is empty.
+func writeReport(report *Report, path string) error {
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err != nil {
+        return err
+    }
+    data = append(data, '\n')
+    if path == "" {
+        _, err = os.Stdout.Write(data)
+        return err
+    }
+    return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// stage moves the number of users linearly to a target over a duration.
+type stage struct {
+    duration time.Duration
+    users    int
+}
+
+// profile is a sequence of stages, starting with zero users.
+type profile []stage
+
+// parseProfile parses a profile of the form "duration:users,duration:users,...", e.g. "1m:10,5m:10,1m:0" ramps up to
+// 10 users in a minute, holds them for five minutes, and ramps down in a minute.
+func parseProfile(s string) (profile, error) {
+    var p profile
+    for _, part := range strings.Split(s, ",") {
+        part = strings.TrimSpace(part)
+        if part == "" {
+            continue
+        }
+        durationStr, usersStr, ok := strings.Cut(part, ":")
+        if !ok {
+            return nil, fmt.Errorf("invalid stage %q, expected duration:users", part)
+        }
+        duration, err := time.ParseDuration(durationStr)
+        if err != nil || duration < 0 {
+            return nil, fmt.Errorf("invalid duration in stage %q", part)
+        }
+        users, err := strconv.Atoi(usersStr)
+        if err != nil || users < 0 {
+            return nil, fmt.Errorf("invalid user count in stage %q", part)
+        }
+        p = append(p, stage{duration: duration, users: users})
+    }
+    if len(p) == 0 {
+        return nil, fmt.Errorf("empty profile")
+    }
+    return p, nil
+}
+
+// rampProfile returns the profile of a Locust-style run: users are spawned at a fixed rate per second, then held
+// until the run has lasted the given duration.
+func rampProfile(users int, spawnRate float64, duration time.Duration) profile {
+    ramp := time.Duration(0)
+    if spawnRate > 0 {
+        ramp = time.Duration(float64(users) / spawnRate * float64(time.Second))
+    }
+    if ramp > duration {
+        ramp = duration
+    }
+    return profile{{duration: ramp, users: users}, {duration: duration - ramp, users: users}}
+}
+
+// duration returns the total length of the profile.
+func (p profile) duration() time.Duration {
+    var total time.Duration
+    for _, s := range p {
+        total += s.duration
+    }
+    return total
+}
+
+// usersAt returns the number of users that should be active at the given time since the start of the run.
+func (p profile) usersAt(elapsed time.Duration) int {
+    from := 0
+    for _, s := range p {
+        if elapsed < s.duration {
+            progress := float64(elapsed) / float64(s.duration)
+            return from + int(float64(s.users-from)*progress)
+        }
+        elapsed -= s.duration
+        from = s.users
+    }
+    return from
+}
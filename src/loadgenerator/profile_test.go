@@ -0,0 +1,51 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseProfile(t *testing.T) {
+    p, err := parseProfile("1m:10, 5m:10,30s:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := p.duration(); got != 6*time.Minute+30*time.Second {
+        t.Errorf("duration = %v", got)
+    }
+
+    for _, tc := range []struct {
+        elapsed time.Duration
+        users   int
+    }{
+        {0, 0},
+        {30 * time.Second, 5},
+        {time.Minute, 10},
+        {3 * time.Minute, 10},
+        {6*time.Minute + 15*time.Second, 5},
+        {time.Hour, 0},
+    } {
+        if got := p.usersAt(tc.elapsed); got != tc.users {
+            t.Errorf("usersAt(%v) = %d, want %d", tc.elapsed, got, tc.users)
+        }
+    }
+
+    for _, invalid := range []string{"", "1m", "1m:-1", "x:10", "1m:ten"} {
+        if _, err := parseProfile(invalid); err == nil {
+            t.Errorf("parseProfile(%q) should fail", invalid)
+        }
+    }
+}
+
+func TestRampProfile(t *testing.T) {
+    p := rampProfile(20, 2, time.Minute)
+    if got := p.usersAt(5 * time.Second); got != 10 {
+        t.Errorf("usersAt(5s) = %d, want 10", got)
+    }
+    if got := p.usersAt(30 * time.Second); got != 20 {
+        t.Errorf("usersAt(30s) = %d, want 20", got)
+    }
+    if got := p.duration(); got != time.Minute {
+        t.Errorf("duration = %v", got)
+    }
+}
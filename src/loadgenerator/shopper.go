@@ -0,0 +1,208 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "math/rand"
+    "net/http"
+    "net/http/cookiejar"
+    "net/url"
+    "strings"
+    "time"
+)
+
+var (
+    currencies = []string{"EUR", "USD", "JPY", "CAD", "GBP", "TRY"}
+
+    productIDs = []string{
+        "0PUK6V6EV0",
+        "1YMWWN1N4O",
+        "2ZYFJ3GM2N",
+        "66VCHSJNUP",
+        "6E92ZMYYFZ",
+        "9SIQT8TOJO",
+        "L9ECAV7KIM",
+        "LS4PSXUNUM",
+        "OLJCESPC7Z",
+    }
+)
+
+// task is one thing a shopper does on the storefront. The weights match the ones of the upstream Locust file.
+type task struct {
+    name   string
+    weight int
+    run    func(s *shopper) error
+}
+
+var tasks = []task{
+    {"index", 1, (*shopper).index},
+    {"setCurrency", 2, (*shopper).setCurrency},
+    {"browseProduct", 10, (*shopper).browseProduct},
+    {"addToCart", 2, (*shopper).addToCart},
+    {"viewCart", 3, (*shopper).viewCart},
+    {"checkout", 1, (*shopper).checkout},
+}
+
+// pickTask returns a random task, proportionally to the task weights.
+func pickTask(rnd *rand.Rand) *task {
+    total := 0
+    for _, t := range tasks {
+        total += t.weight
+    }
+    n := rnd.Intn(total)
+    for i := range tasks {
+        if n < tasks[i].weight {
+            return &tasks[i]
+        }
+        n -= tasks[i].weight
+    }
+    return &tasks[len(tasks)-1]
+}
+
+// shopper is a simulated user with its own session.
+type shopper struct {
+    baseURL   string
+    client    *http.Client
+    stats     *collector
+    rnd       *rand.Rand
+    thinkTime thinkTime
+}
+
+// thinkTime is the range of the pause between two tasks of a shopper.
+type thinkTime struct {
+    min time.Duration
+    max time.Duration
+}
+
+// parseThinkTime parses a think time of the form "min-max" (e.g. "1s-10s") or a fixed duration.
+func parseThinkTime(s string) (thinkTime, error) {
+    minStr, maxStr, ok := strings.Cut(s, "-")
+    if !ok {
+        maxStr = minStr
+    }
+    min, err := time.ParseDuration(strings.TrimSpace(minStr))
+    if err != nil {
+        return thinkTime{}, fmt.Errorf("invalid think time %q: %w", s, err)
+    }
+    max, err := time.ParseDuration(strings.TrimSpace(maxStr))
+    if err != nil {
+        return thinkTime{}, fmt.Errorf("invalid think time %q: %w", s, err)
+    }
+    if min < 0 || max < min {
+        return thinkTime{}, fmt.Errorf("invalid think time %q", s)
+    }
+    return thinkTime{min: min, max: max}, nil
+}
+
+// next returns a random pause within the range.
+func (t thinkTime) next(rnd *rand.Rand) time.Duration {
+    if t.max <= t.min {
+        return t.min
+    }
+    return t.min + time.Duration(rnd.Int63n(int64(t.max-t.min)))
+}
+
+// newShopper creates a shopper with an empty cookie jar, so it starts a new session on its first request.
+func newShopper(baseURL string, transport http.RoundTripper, timeout time.Duration, stats *collector, think thinkTime, seed int64) *shopper {
+    jar, _ := cookiejar.New(nil)
+    return &shopper{
+        baseURL:   strings.TrimSuffix(baseURL, "/"),
+        client:    &http.Client{Transport: transport, Jar: jar, Timeout: timeout},
+        stats:     stats,
+        rnd:       rand.New(rand.NewSource(seed)),
+        thinkTime: think,
+    }
+}
+
+// run performs tasks until the context is cancelled. Like a Locust user, a shopper starts on the home page.
+func (s *shopper) run(ctx context.Context) {
+    _ = s.index()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(s.thinkTime.next(s.rnd)):
+        }
+        _ = pickTask(s.rnd).run(s)
+    }
+}
+
+func (s *shopper) index() error {
+    return s.get("GET /", "/")
+}
+
+func (s *shopper) setCurrency() error {
+    return s.post("POST /setCurrency", "/setCurrency", url.Values{
+        "currency_code": {currencies[s.rnd.Intn(len(currencies))]},
+    })
+}
+
+func (s *shopper) browseProduct() error {
+    return s.get("GET /product/{id}", "/product/"+productIDs[s.rnd.Intn(len(productIDs))])
+}
+
+func (s *shopper) viewCart() error {
+    return s.get("GET /cart", "/cart")
+}
+
+func (s *shopper) addToCart() error {
+    productID := productIDs[s.rnd.Intn(len(productIDs))]
+    if err := s.get("GET /product/{id}", "/product/"+productID); err != nil {
+        return err
+    }
+    return s.post("POST /cart", "/cart", url.Values{
+        "product_id": {productID},
+        "quantity":   {fmt.Sprint(1 + s.rnd.Intn(10))},
+    })
+}
+
+func (s *shopper) checkout() error {
+    if err := s.addToCart(); err != nil {
+        return err
+    }
+    return s.post("POST /cart/checkout", "/cart/checkout", url.Values{
+        "email":                        {"someone@example.com"},
+        "street_address":               {"1600 Amphitheatre Parkway"},
+        "zip_code":                     {"94043"},
+        "city":                         {"Mountain View"},
+        "state":                        {"CA"},
+        "country":                      {"United States"},
+        "credit_card_number":           {"4432-8015-6152-0454"},
+        "credit_card_expiration_month": {"1"},
+        "credit_card_expiration_year":  {fmt.Sprint(time.Now().Year() + 1)},
+        "credit_card_cvv":              {"672"},
+    })
+}
+
+func (s *shopper) get(name, path string) error {
+    req, err := http.NewRequest(http.MethodGet, s.baseURL+path, nil)
+    if err != nil {
+        return err
+    }
+    return s.do(name, req)
+}
+
+func (s *shopper) post(name, path string, form url.Values) error {
+    req, err := http.NewRequest(http.MethodPost, s.baseURL+path, strings.NewReader(form.Encode()))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    return s.do(name, req)
+}
+
+// do sends a request, following redirects, and records its latency under the given page name.
+func (s *shopper) do(name string, req *http.Request) error {
+    start := time.Now()
+    resp, err := s.client.Do(req)
+    if err == nil {
+        _, err = io.Copy(io.Discard, resp.Body)
+        resp.Body.Close()
+        if err == nil && resp.StatusCode >= http.StatusBadRequest {
+            err = fmt.Errorf("received non-OK response: %s", resp.Status)
+        }
+    }
+    s.stats.record(name, time.Since(start), err)
+    return err
+}
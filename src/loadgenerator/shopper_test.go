@@ -0,0 +1,111 @@
+package main
+
+import (
+    "math/rand"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// fakeFrontend answers the pages a shopper visits and checks that the session cookie is kept.
+type fakeFrontend struct {
+    mu       sync.Mutex
+    sessions map[string]bool
+    orders   int
+}
+
+func (f *fakeFrontend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if c, err := r.Cookie("shop_session-id"); err == nil {
+        if !f.sessions[c.Value] {
+            http.Error(w, "unknown session", http.StatusBadRequest)
+            return
+        }
+    } else {
+        id := "session-" + time.Now().String()
+        f.sessions[id] = true
+        http.SetCookie(w, &http.Cookie{Name: "shop_session-id", Value: id, Path: "/"})
+    }
+
+    switch {
+    case r.Method == http.MethodPost && r.URL.Path == "/shop/cart":
+        if r.FormValue("product_id") == "" || r.FormValue("quantity") == "" {
+            http.Error(w, "missing form values", http.StatusBadRequest)
+            return
+        }
+        http.Redirect(w, r, "/shop/cart", http.StatusFound)
+    case r.Method == http.MethodPost && r.URL.Path == "/shop/cart/checkout":
+        if r.FormValue("credit_card_number") == "" {
+            http.Error(w, "missing form values", http.StatusBadRequest)
+            return
+        }
+        f.orders++
+    case r.Method == http.MethodPost && r.URL.Path == "/shop/setCurrency":
+        http.Redirect(w, r, "/shop/", http.StatusFound)
+    case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/shop/"):
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+func TestShopperTasks(t *testing.T) {
+    frontend := &fakeFrontend{sessions: make(map[string]bool)}
+    server := httptest.NewServer(frontend)
+    defer server.Close()
+
+    stats := newCollector()
+    s := newShopper(server.URL+"/shop/", http.DefaultTransport, time.Second, stats, thinkTime{}, 1)
+    for _, task := range tasks {
+        if err := task.run(s); err != nil {
+            t.Errorf("%s failed: %v", task.name, err)
+        }
+    }
+
+    if frontend.orders != 1 {
+        t.Errorf("placed %d orders, want 1", frontend.orders)
+    }
+    if len(frontend.sessions) != 1 {
+        t.Errorf("shopper used %d sessions, want 1", len(frontend.sessions))
+    }
+    report := stats.report(server.URL, 1)
+    if report.Total.Failures != 0 || len(report.Pages) != 6 {
+        t.Errorf("unexpected report: %+v", report.Total)
+    }
+}
+
+func TestFailuresAreRecorded(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "boom", http.StatusInternalServerError)
+    }))
+    defer server.Close()
+
+    stats := newCollector()
+    s := newShopper(server.URL, http.DefaultTransport, time.Second, stats, thinkTime{}, 1)
+    if err := s.viewCart(); err == nil {
+        t.Error("viewCart should fail")
+    }
+    report := stats.report(server.URL, 1)
+    if report.Total.Failures != 1 || report.Total.ErrorRate != 1 || len(report.Pages[0].Errors) != 1 {
+        t.Errorf("unexpected report: %+v", report.Pages[0])
+    }
+}
+
+func TestPickTaskFollowsWeights(t *testing.T) {
+    rnd := rand.New(rand.NewSource(1))
+    counts := make(map[string]int)
+    for i := 0; i < 19000; i++ {
+        counts[pickTask(rnd).name]++
+    }
+    // browseProduct has a weight of 10 out of 19.
+    if n := counts["browseProduct"]; n < 9500 || n > 10500 {
+        t.Errorf("browseProduct picked %d times out of 19000", n)
+    }
+    if len(counts) != len(tasks) {
+        t.Errorf("picked %d distinct tasks, want %d", len(counts), len(tasks))
+    }
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "sort"
+    "sync"
+    "text/tabwriter"
+    "time"
+)
+
+// maxErrorKinds bounds the number of distinct error messages kept per page.
+const maxErrorKinds = 10
+
+// collector gathers the outcome of every request, grouped by page.
+type collector struct {
+    mu    sync.Mutex
+    start time.Time
+    pages map[string]*pageStats
+}
+
+type pageStats struct {
+    latencies []time.Duration
+    failures  int
+    errors    map[string]int
+}
+
+func newCollector() *collector {
+    return &collector{start: time.Now(), pages: make(map[string]*pageStats)}
+}
+
+// record adds the outcome of a request.
+func (c *collector) record(page string, latency time.Duration, err error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    p, ok := c.pages[page]
+    if !ok {
+        p = &pageStats{errors: make(map[string]int)}
+        c.pages[page] = p
+    }
+    p.latencies = append(p.latencies, latency)
+    if err != nil {
+        p.failures++
+        if _, ok := p.errors[err.Error()]; ok || len(p.errors) < maxErrorKinds {
+            p.errors[err.Error()]++
+        }
+    }
+}
+
+// Report is the machine-readable summary of a run.
+type Report struct {
+    Target      string        `json:"target"`
+    StartedAt   time.Time     `json:"started_at"`
+    DurationSec float64       `json:"duration_sec"`
+    PeakUsers   int           `json:"peak_users"`
+    Total       PageReport    `json:"total"`
+    Pages       []*PageReport `json:"pages"`
+}
+
+// PageReport summarizes the requests of a page.
+type PageReport struct {
+    Name          string         `json:"name"`
+    Requests      int            `json:"requests"`
+    Failures      int            `json:"failures"`
+    ErrorRate     float64        `json:"error_rate"`
+    ThroughputRPS float64        `json:"throughput_rps"`
+    LatencyMs     Latency        `json:"latency_ms"`
+    Errors        map[string]int `json:"errors,omitempty"`
+}
+
+// Latency holds latency percentiles, in milliseconds.
+type Latency struct {
+    Min  float64 `json:"min"`
+    Mean float64 `json:"mean"`
+    P50  float64 `json:"p50"`
+    P90  float64 `json:"p90"`
+    P95  float64 `json:"p95"`
+    P99  float64 `json:"p99"`
+    Max  float64 `json:"max"`
+}
+
+// report summarizes everything recorded so far.
+func (c *collector) report(target string, peakUsers int) *Report {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    elapsed := time.Since(c.start)
+    r := &Report{
+        Target:      target,
+        StartedAt:   c.start.UTC(),
+        DurationSec: elapsed.Seconds(),
+        PeakUsers:   peakUsers,
+    }
+
+    var all []time.Duration
+    failures := 0
+    for name, p := range c.pages {
+        r.Pages = append(r.Pages, summarize(name, p.latencies, p.failures, p.errors, elapsed))
+        all = append(all, p.latencies...)
+        failures += p.failures
+    }
+    sort.Slice(r.Pages, func(i, j int) bool { return r.Pages[i].Name < r.Pages[j].Name })
+    r.Total = *summarize("Aggregated", all, failures, nil, elapsed)
+    return r
+}
+
+// summarize computes the report of a page. It sorts latencies in place.
+func summarize(name string, latencies []time.Duration, failures int, errors map[string]int, elapsed time.Duration) *PageReport {
+    p := &PageReport{Name: name, Requests: len(latencies), Failures: failures}
+    if len(errors) > 0 {
+        p.Errors = make(map[string]int, len(errors))
+        for msg, n := range errors {
+            p.Errors[msg] = n
+        }
+    }
+    if len(latencies) == 0 {
+        return p
+    }
+
+    sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+    var sum time.Duration
+    for _, l := range latencies {
+        sum += l
+    }
+    p.ErrorRate = float64(failures) / float64(len(latencies))
+    if elapsed > 0 {
+        p.ThroughputRPS = float64(len(latencies)) / elapsed.Seconds()
+    }
+    p.LatencyMs = Latency{
+        Min:  milliseconds(latencies[0]),
+        Mean: milliseconds(sum / time.Duration(len(latencies))),
+        P50:  milliseconds(percentile(latencies, 50)),
+        P90:  milliseconds(percentile(latencies, 90)),
+        P95:  milliseconds(percentile(latencies, 95)),
+        P99:  milliseconds(percentile(latencies, 99)),
+        Max:  milliseconds(latencies[len(latencies)-1]),
+    }
+    return p
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+    rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+    if rank < 1 {
+        rank = 1
+    }
+    return sorted[rank-1]
+}
+
+// milliseconds converts a duration to fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
+
+// writeTable prints a report as a table, like the console output of Locust.
+func writeTable(w io.Writer, r *Report) {
+    tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(tw, "Name\t# reqs\t# fails\tfail %\treq/s\tavg\tp50\tp95\tp99\tmax\t")
+    for _, p := range append(r.Pages, &r.Total) {
+        fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.2f\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\t\n", p.Name, p.Requests, p.Failures,
+            p.ErrorRate*100, p.ThroughputRPS, p.LatencyMs.Mean, p.LatencyMs.P50, p.LatencyMs.P95, p.LatencyMs.P99, p.LatencyMs.Max)
+    }
+    tw.Flush()
+}
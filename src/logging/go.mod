@@ -0,0 +1,10 @@
+module logging
+
+go 1.22.3
+
+require (
+	github.com/aws/aws-lambda-go v1.47.0
+	github.com/sirupsen/logrus v1.9.3
+)
+
+require golang.org/x/sys v0.20.0 // indirect
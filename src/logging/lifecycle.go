@@ -0,0 +1,193 @@
+package logging
+
+import (
+    "context"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/sirupsen/logrus"
+)
+
+// The lifecycle entries are written in the CloudWatch embedded metric format, so that Lambda turns them into
+// metrics without any extra setup. The namespace can be set with the METRICS_NAMESPACE environment variable.
+const (
+    defaultMetricsNamespace = "MicroservicesPort"
+    metricsNamespaceEnvVar  = "METRICS_NAMESPACE"
+
+    metricInitDuration       = "init_duration_ms"
+    metricInvocationDuration = "invocation_duration_ms"
+    metricRemainingTime      = "remaining_time_ms"
+    metricColdStart          = "cold_start"
+
+    initPhasePrefix = "init_phase_"
+    millisecondUnit = "Milliseconds"
+    countUnit       = "Count"
+)
+
+var (
+    // processStart approximates the start of the init phase. Package variables are initialized before the ones of
+    // the importing packages, so this is set before the service starts doing any work.
+    processStart = time.Now()
+
+    initPhasesMutex sync.Mutex
+    initPhases      []initPhase
+
+    // warm is set after the first invocation of the execution environment.
+    warm atomic.Bool
+
+    countersMutex sync.Mutex
+    counters      []*Counter
+)
+
+type initPhase struct {
+    name     string
+    duration time.Duration
+}
+
+// InitPhase starts measuring a named part of the initialization, such as loading the product catalog.
+// The returned function must be called when the phase is over.
+func InitPhase(name string) func() {
+    start := time.Now()
+    return func() {
+        initPhasesMutex.Lock()
+        defer initPhasesMutex.Unlock()
+        initPhases = append(initPhases, initPhase{name: name, duration: time.Since(start)})
+    }
+}
+
+// InitDone logs the duration of the initialization and of its measured phases.
+// It must be called right before the service starts serving requests.
+func InitDone(log *logrus.Entry) {
+    initPhasesMutex.Lock()
+    defer initPhasesMutex.Unlock()
+
+    fields := logrus.Fields{metricInitDuration: milliseconds(time.Since(processStart))}
+    metrics := []metric{{metricInitDuration, millisecondUnit}}
+    for _, phase := range initPhases {
+        fields[initPhasePrefix+phase.name+"_ms"] = milliseconds(phase.duration)
+        metrics = append(metrics, metric{initPhasePrefix + phase.name + "_ms", millisecondUnit})
+    }
+    fields["_aws"] = embeddedMetrics(metrics)
+
+    log.WithFields(fields).Info("Init phase finished")
+}
+
+// Invocation measures a single Lambda invocation.
+type Invocation struct {
+    ctx   context.Context
+    start time.Time
+    cold  bool
+}
+
+// StartInvocation starts measuring the invocation ctx belongs to. Only the first invocation of
+// an execution environment is marked as cold.
+func StartInvocation(ctx context.Context) *Invocation {
+    return &Invocation{
+        ctx:   ctx,
+        start: time.Now(),
+        cold:  !warm.Swap(true),
+    }
+}
+
+// End logs the duration of the invocation, whether it was cold, and how much time was left before the timeout.
+func (inv *Invocation) End(log *logrus.Entry) {
+    coldStartCount := 0
+    if inv.cold {
+        coldStartCount = 1
+    }
+
+    fields := logrus.Fields{
+        metricInvocationDuration: milliseconds(time.Since(inv.start)),
+        metricColdStart:          coldStartCount,
+    }
+    metrics := []metric{{metricInvocationDuration, millisecondUnit}, {metricColdStart, countUnit}}
+    if deadline, ok := inv.ctx.Deadline(); ok {
+        fields[metricRemainingTime] = milliseconds(time.Until(deadline))
+        metrics = append(metrics, metric{metricRemainingTime, millisecondUnit})
+    }
+    metrics = takeCounts(fields, metrics)
+    fields["_aws"] = embeddedMetrics(metrics)
+
+    log.WithFields(fields).Info("Invocation finished")
+}
+
+// Counter counts events, such as cache hits, that are reported as metrics: with every invocation in Lambda, and by
+// ReportCounters outside it. Every report covers the events since the previous one.
+type Counter struct {
+    name  string
+    count atomic.Int64
+}
+
+// NewCounter returns a counter reported as the named metric.
+func NewCounter(name string) *Counter {
+    countersMutex.Lock()
+    defer countersMutex.Unlock()
+    c := &Counter{name: name}
+    counters = append(counters, c)
+    return c
+}
+
+// Add counts n events.
+func (c *Counter) Add(n int64) {
+    c.count.Add(n)
+}
+
+// ReportCounters logs the counts of the counters every interval, for services that don't run in Lambda and so
+// never end an invocation. It never returns.
+func ReportCounters(log *logrus.Entry, interval time.Duration) {
+    for range time.Tick(interval) {
+        fields := logrus.Fields{}
+        metrics := takeCounts(fields, nil)
+        if len(metrics) == 0 {
+            continue
+        }
+        fields["_aws"] = embeddedMetrics(metrics)
+        log.WithFields(fields).Info("Counters reported")
+    }
+}
+
+// takeCounts adds the counts of the counters since the last report to the fields and metrics of a log entry, and
+// starts counting again from zero.
+func takeCounts(fields logrus.Fields, metrics []metric) []metric {
+    countersMutex.Lock()
+    defer countersMutex.Unlock()
+    for _, c := range counters {
+        fields[c.name] = c.count.Swap(0)
+        metrics = append(metrics, metric{c.name, countUnit})
+    }
+    return metrics
+}
+
+type metric struct {
+    name string
+    unit string
+}
+
+// embeddedMetrics builds the metadata object of the CloudWatch embedded metric format.
+// The metric values themselves are expected as top-level fields of the same log entry.
+func embeddedMetrics(metrics []metric) map[string]interface{} {
+    namespace := defaultMetricsNamespace
+    if ns, ok := os.LookupEnv(metricsNamespaceEnvVar); ok && ns != "" {
+        namespace = ns
+    }
+
+    definitions := make([]map[string]string, len(metrics))
+    for i, m := range metrics {
+        definitions[i] = map[string]string{"Name": m.name, "Unit": m.unit}
+    }
+
+    return map[string]interface{}{
+        "Timestamp": time.Now().UnixMilli(),
+        "CloudWatchMetrics": []map[string]interface{}{{
+            "Namespace":  namespace,
+            "Dimensions": [][]string{{FieldService}},
+            "Metrics":    definitions,
+        }},
+    }
+}
+
+func milliseconds(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+    "context"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/aws/aws-lambda-go/lambdacontext"
+    "github.com/sirupsen/logrus"
+)
+
+// Header names carrying the correlation data of a request.
+const (
+    RPCNameHeader   = "rpc-name"
+    RequestIDHeader = "x-request-id"
+    SessionIDHeader = "x-session-id"
+
+    // LambdaRequestIDHeader is set by the server runtime to the AWS request ID of the current invocation.
+    // It's never forwarded to other services.
+    LambdaRequestIDHeader = "lambda-request-id"
+)
+
+// Field names shared by the log entries of every service.
+const (
+    FieldService         = "service"
+    FieldRPC             = "rpc"
+    FieldRequestID       = "request_id"
+    FieldSessionID       = "session_id"
+    FieldLambdaRequestID = "lambda_request_id"
+)
+
+const (
+    defaultLevel = logrus.DebugLevel
+    formatEnvVar = "LOG_FORMAT"
+    levelEnvVar  = "LOG_LEVEL"
+    textFormat   = "text"
+    timestampKey = "timestamp"
+    severityKey  = "severity"
+    messageKey   = "message"
+)
+
+// New creates the logger of a service. Every entry is tagged with the service name.
+// The level is read from the LOG_LEVEL environment variable (default: debug), and the format
+// from the LOG_FORMAT environment variable, which is either json (default) or text.
+func New(service string) *logrus.Entry {
+    logger := logrus.New()
+    logger.Out = os.Stdout
+    logger.Level = levelFromEnv()
+    logger.Formatter = formatterFromEnv()
+    return logger.WithField(FieldService, service)
+}
+
+// WithRequest tags a logger with the correlation data found in the headers of an RPC request.
+func WithRequest(log *logrus.Entry, headers map[string]string) *logrus.Entry {
+    fields := logrus.Fields{}
+    for header, field := range map[string]string{
+        RPCNameHeader:         FieldRPC,
+        RequestIDHeader:       FieldRequestID,
+        SessionIDHeader:       FieldSessionID,
+        LambdaRequestIDHeader: FieldLambdaRequestID,
+    } {
+        if v := headers[header]; v != "" {
+            fields[field] = v
+        }
+    }
+    return log.WithFields(fields)
+}
+
+// WithLambdaContext tags a logger with the AWS request ID of the invocation, if ctx belongs to one.
+func WithLambdaContext(log *logrus.Entry, ctx context.Context) *logrus.Entry {
+    if id := LambdaRequestID(ctx); id != "" {
+        return log.WithField(FieldLambdaRequestID, id)
+    }
+    return log
+}
+
+// LambdaRequestID returns the AWS request ID of the invocation ctx belongs to, or an empty string.
+func LambdaRequestID(ctx context.Context) string {
+    if lc, ok := lambdacontext.FromContext(ctx); ok {
+        return lc.AwsRequestID
+    }
+    return ""
+}
+
+func levelFromEnv() logrus.Level {
+    if s, ok := os.LookupEnv(levelEnvVar); ok {
+        if level, err := logrus.ParseLevel(s); err == nil {
+            return level
+        }
+    }
+    return defaultLevel
+}
+
+func formatterFromEnv() logrus.Formatter {
+    fieldMap := logrus.FieldMap{
+        logrus.FieldKeyTime:  timestampKey,
+        logrus.FieldKeyLevel: severityKey,
+        logrus.FieldKeyMsg:   messageKey,
+    }
+
+    if strings.ToLower(os.Getenv(formatEnvVar)) == textFormat {
+        return &logrus.TextFormatter{
+            FieldMap:        fieldMap,
+            FullTimestamp:   true,
+            TimestampFormat: time.RFC3339Nano,
+        }
+    }
+    return &logrus.JSONFormatter{
+        FieldMap:        fieldMap,
+        TimestampFormat: time.RFC3339Nano,
+    }
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "errors"
+    "fmt"
+    "strings"
+    "unicode"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+
+    "logging"
+    pb "productcatalogservice/genproto"
+)
+
+const (
+    // maxBulkUpsert is the most products a BulkUpsertProducts call may write.
+    maxBulkUpsert = 1000
+    // maxCategoryLength is the longest a category may be, in bytes.
+    maxCategoryLength = 64
+    // catalogUpdateAttempts is how many times a change is tried when it loses races with other writers.
+    catalogUpdateAttempts = 3
+)
+
+// adminAuth authorizes the admin RPCs by the bearer tokens of admins, which callers send in the authorization header.
+type adminAuth struct {
+    // admins maps the SHA-256 of every token to the name of its admin, so that looking a token up takes the same
+    // time whatever the token.
+    admins map[[sha256.Size]byte]string
+}
+
+// parseAdminTokens parses a list of admins and their tokens, as name:token pairs separated by commas.
+func parseAdminTokens(s string) (*adminAuth, error) {
+    auth := &adminAuth{admins: make(map[[sha256.Size]byte]string)}
+    for i, pair := range strings.Split(s, ",") {
+        name, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+        if !ok || name == "" || token == "" {
+            // The pair may hold a token, which mustn't end up in the logs.
+            return nil, fmt.Errorf("admin %d isn't a name:token pair", i+1)
+        }
+        auth.admins[sha256.Sum256([]byte(token))] = name
+    }
+    return auth, nil
+}
+
+// authorize returns the name of the admin whose token the headers of a request carry.
+func (a *adminAuth) authorize(headers map[string]string) (string, error) {
+    if a == nil {
+        return "", status.Error(codes.PermissionDenied, "the admin RPCs are disabled")
+    }
+    scheme, token, _ := strings.Cut(headers["authorization"], " ")
+    if !strings.EqualFold(scheme, "Bearer") || token == "" {
+        return "", status.Error(codes.Unauthenticated, "the admin RPCs require an authorization header with a bearer token")
+    }
+    name, ok := a.admins[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+    if !ok {
+        return "", status.Error(codes.Unauthenticated, "invalid admin token")
+    }
+    return name, nil
+}
+
+// CreateProduct adds a product to the catalog, at version 1.
+func (p *productCatalog) CreateProduct(req *pb.CreateProductRequest, headers *map[string]string) (*pb.Product, error) {
+    products, err := p.write(*headers, []productWrite{{action: writeCreate, product: req.Product}})
+    if err != nil {
+        return nil, err
+    }
+    return products[0], nil
+}
+
+// UpdateProduct replaces a product of the catalog, if it's still at the version of the request.
+func (p *productCatalog) UpdateProduct(req *pb.UpdateProductRequest, headers *map[string]string) (*pb.Product, error) {
+    products, err := p.write(*headers, []productWrite{{action: writeUpdate, product: req.Product}})
+    if err != nil {
+        return nil, err
+    }
+    return products[0], nil
+}
+
+// DeleteProduct removes a product from the catalog, if it's still at the version of the request.
+func (p *productCatalog) DeleteProduct(req *pb.DeleteProductRequest, headers *map[string]string) (*pb.Empty, error) {
+    if _, err := p.write(*headers, []productWrite{{action: writeDelete, id: req.Id, version: req.Version}}); err != nil {
+        return nil, err
+    }
+    return &pb.Empty{}, nil
+}
+
+// BulkUpsertProducts creates or replaces products of the catalog, all of them or none.
+func (p *productCatalog) BulkUpsertProducts(req *pb.BulkUpsertProductsRequest, headers *map[string]string) (*pb.BulkUpsertProductsResponse, error) {
+    if len(req.Products) > maxBulkUpsert {
+        return nil, status.Errorf(codes.InvalidArgument, "at most %d products can be upserted at once, not %d",
+            maxBulkUpsert, len(req.Products))
+    }
+    writes := make([]productWrite, len(req.Products))
+    for i, product := range req.Products {
+        writes[i] = productWrite{action: writeUpsert, product: product}
+    }
+    products, err := p.write(*headers, writes)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.BulkUpsertProductsResponse{Products: products}, nil
+}
+
+// writeAction is what a write does to a product, which decides what the product must be in the catalog for the write
+// to apply.
+type writeAction int
+
+const (
+    // writeCreate adds a product whose ID isn't in the catalog.
+    writeCreate writeAction = iota
+    // writeUpdate replaces a product at the version of the new product.
+    writeUpdate
+    // writeUpsert replaces a product at the version of the new product, or adds it if its version is 0 and its ID
+    // isn't in the catalog.
+    writeUpsert
+    // writeDelete removes a product at a version.
+    writeDelete
+)
+
+// productWrite is the change of a product by an admin RPC.
+type productWrite struct {
+    action writeAction
+    // product is the new product, for every action but writeDelete.
+    product *pb.Product
+    // id and version are the product that writeDelete removes.
+    id      string
+    version int64
+}
+
+// write authorizes and validates the writes of an admin RPC, applies them to the catalog store in one step, records
+// them in the audit log, and reloads the catalog. It returns the products as they were written, with their new
+// versions, nil for those it deleted.
+func (p *productCatalog) write(headers map[string]string, writes []productWrite) ([]*pb.Product, error) {
+    actor, err := p.admin.authorize(headers)
+    if err != nil {
+        return nil, err
+    }
+    store, ok := p.source.(CatalogStore)
+    if !ok {
+        return nil, status.Error(codes.FailedPrecondition, "the catalog source can't be changed")
+    }
+
+    ids := make([]string, len(writes))
+    seen := make(map[string]bool, len(writes))
+    for i, w := range writes {
+        field := "product"
+        if len(writes) > 1 {
+            field = fmt.Sprintf("products[%d]", i)
+        }
+        if w.action == writeDelete {
+            if w.id == "" {
+                return nil, status.Error(codes.InvalidArgument, "id is required")
+            }
+        } else {
+            product, err := validateProduct(w.product, field)
+            if err != nil {
+                return nil, err
+            }
+            writes[i].product, w.id = product, product.Id
+        }
+        if seen[w.id] {
+            return nil, status.Errorf(codes.InvalidArgument, "%s: product %s appears more than once", field, w.id)
+        }
+        ids[i], seen[w.id] = w.id, true
+    }
+
+    var written []*pb.Product
+    var entries []auditEntry
+    ctx, cancel := context.WithTimeout(context.Background(), catalogLoadTimeout)
+    defer cancel()
+    for attempt := 1; ; attempt++ {
+        err = store.Update(ctx, ids, func(current []*pb.Product) ([]*pb.Product, error) {
+            written, entries = make([]*pb.Product, len(writes)), nil
+            for i, w := range writes {
+                next, err := w.apply(current[i])
+                if err != nil {
+                    return nil, err
+                }
+                entry, err := newAuditEntry(actor, headers[logging.RequestIDHeader], current[i], next)
+                if err != nil {
+                    return nil, status.Errorf(codes.Internal, "failed to record the change of product %s: %v", ids[i], err)
+                }
+                written[i], entries = next, append(entries, entry)
+            }
+            return written, nil
+        })
+        if !errors.Is(err, errCatalogConflict) || attempt == catalogUpdateAttempts {
+            break
+        }
+    }
+    switch {
+    case errors.Is(err, errCatalogConflict):
+        return nil, status.Error(codes.Aborted, "the catalog kept changing during the update, try again")
+    case err != nil && status.Code(err) == codes.Unknown:
+        return nil, status.Errorf(codes.Unavailable, "failed to write the catalog: %v", err)
+    case err != nil:
+        return nil, err
+    }
+
+    reqLog := requestLogger(&headers)
+    audit := p.audit
+    if audit == nil {
+        audit = loggerAuditLog{log: log}
+    }
+    if err := audit.Record(entries); err != nil {
+        // The catalog changed all the same, so the call succeeds, but the change must not go unnoticed.
+        reqLog.Errorf("failed to record %d catalog changes by %s in the audit log: %v", len(entries), actor, err)
+    }
+    if _, err := p.reload(); err != nil {
+        reqLog.Warnf("failed to reload the catalog after a change, it will show on the next reload: %v", err)
+    }
+    return written, nil
+}
+
+// apply returns what a write turns the current product of its ID into, nil if it deletes it, or fails if the product
+// isn't what the write expects.
+func (w productWrite) apply(current *pb.Product) (*pb.Product, error) {
+    id, version := w.id, w.version
+    if w.product != nil {
+        id, version = w.product.Id, w.product.Version
+    }
+    switch {
+    case w.action == writeCreate && current != nil:
+        return nil, status.Errorf(codes.AlreadyExists, "product %s already exists", id)
+    case (w.action == writeUpdate || w.action == writeDelete) && current == nil:
+        return nil, status.Errorf(codes.NotFound, "no product with ID %s", id)
+    case w.action == writeUpsert && current == nil && version != 0:
+        return nil, status.Errorf(codes.Aborted, "product %s doesn't exist, so it isn't at version %d", id, version)
+    case w.action != writeCreate && current != nil && current.Version != version:
+        return nil, status.Errorf(codes.Aborted, "product %s is at version %d, not %d", id, current.Version, version)
+    }
+
+    if w.action == writeDelete {
+        return nil, nil
+    }
+    next := proto.Clone(w.product).(*pb.Product)
+    next.Version = 1
+    if current != nil {
+        next.Version = current.Version + 1
+    }
+    return next, nil
+}
+
+// validateProduct checks a product that an admin RPC writes, and returns a copy of it with its categories
+// normalized: trimmed and in lower case, as the catalog looks them up. field names the product in errors.
+func validateProduct(product *pb.Product, field string) (*pb.Product, error) {
+    invalid := func(format string, args ...any) error {
+        return status.Errorf(codes.InvalidArgument, field+": "+format, args...)
+    }
+    if product == nil {
+        return nil, invalid("the product is required")
+    }
+    if product.Id == "" {
+        return nil, invalid("id is required")
+    }
+    if strings.IndexFunc(product.Id, func(r rune) bool { return unicode.IsSpace(r) || !unicode.IsPrint(r) }) >= 0 {
+        return nil, invalid("id %q must not contain spaces or control characters", product.Id)
+    }
+    if strings.TrimSpace(product.Name) == "" {
+        return nil, invalid("name is required")
+    }
+
+    price := product.PriceUsd
+    switch {
+    case price == nil:
+        return nil, invalid("price_usd is required")
+    case price.CurrencyCode != "USD":
+        return nil, invalid("price_usd must be in USD, not %q", price.CurrencyCode)
+    case price.Nanos < -999999999 || price.Nanos > 999999999:
+        return nil, invalid("price_usd has %d nanos, which must be between -999999999 and 999999999", price.Nanos)
+    case price.Units != 0 && price.Nanos != 0 && (price.Units < 0) != (price.Nanos < 0):
+        return nil, invalid("price_usd has units and nanos of different signs")
+    case price.Units < 0 || price.Nanos < 0:
+        return nil, invalid("price_usd must not be negative")
+    }
+
+    product = proto.Clone(product).(*pb.Product)
+    seen := make(map[string]bool, len(product.Categories))
+    for i, category := range product.Categories {
+        category = strings.ToLower(strings.TrimSpace(category))
+        switch {
+        case category == "":
+            return nil, invalid("categories[%d] is empty", i)
+        case len(category) > maxCategoryLength:
+            return nil, invalid("categories[%d] is longer than %d bytes", i, maxCategoryLength)
+        case strings.Contains(category, ","):
+            return nil, invalid("categories[%d] (%q) must not contain commas", i, category)
+        case seen[category]:
+            return nil, invalid("categories[%d] (%q) appears more than once", i, category)
+        }
+        product.Categories[i], seen[category] = category, true
+    }
+    return product, nil
+}
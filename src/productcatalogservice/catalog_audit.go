@@ -0,0 +1,122 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/golang/protobuf/jsonpb"
+    "github.com/sirupsen/logrus"
+
+    "logging"
+    pb "productcatalogservice/genproto"
+)
+
+// auditEntry records a change of a product made through the admin RPCs.
+type auditEntry struct {
+    Time time.Time `json:"time"`
+    // Actor is the name of the admin who made the change.
+    Actor     string `json:"actor"`
+    RequestID string `json:"request_id,omitempty"`
+    // Action is create, update or delete.
+    Action    string `json:"action"`
+    ProductID string `json:"product_id"`
+    // Version is the version of the product after the change, or the version that was deleted.
+    Version int64 `json:"version"`
+    // Before and After are the product before and after the change, in protobuf's JSON mapping, or null if it didn't
+    // exist.
+    Before json.RawMessage `json:"before"`
+    After  json.RawMessage `json:"after"`
+}
+
+// newAuditEntry returns the entry of a change from one product to another, either of which may be nil.
+func newAuditEntry(actor, requestID string, before, after *pb.Product) (auditEntry, error) {
+    e := auditEntry{Time: time.Now().UTC(), Actor: actor, RequestID: requestID}
+    switch {
+    case before == nil:
+        e.Action, e.ProductID, e.Version = "create", after.Id, after.Version
+    case after == nil:
+        e.Action, e.ProductID, e.Version = "delete", before.Id, before.Version
+    default:
+        e.Action, e.ProductID, e.Version = "update", after.Id, after.Version
+    }
+    var err error
+    if e.Before, err = auditJSON(before); err != nil {
+        return e, err
+    }
+    e.After, err = auditJSON(after)
+    return e, err
+}
+
+func auditJSON(product *pb.Product) (json.RawMessage, error) {
+    if product == nil {
+        return json.RawMessage("null"), nil
+    }
+    s, err := (&jsonpb.Marshaler{}).MarshalToString(product)
+    return json.RawMessage(s), err
+}
+
+// auditLog keeps the record of the changes of the catalog.
+type auditLog interface {
+    // Record adds the entries of a change to the log.
+    Record(entries []auditEntry) error
+}
+
+// loggerAuditLog writes the audit entries to a logger, tagged with audit=true so that they can be told apart from the
+// rest of the logs (e.g. with a CloudWatch Logs filter).
+type loggerAuditLog struct {
+    log *logrus.Entry
+}
+
+func (l loggerAuditLog) Record(entries []auditEntry) error {
+    for _, e := range entries {
+        l.log.WithFields(logrus.Fields{
+            "audit":                true,
+            "actor":                e.Actor,
+            logging.FieldRequestID: e.RequestID,
+            "action":               e.Action,
+            "product_id":           e.ProductID,
+            "version":              e.Version,
+            "before":               string(e.Before),
+            "after":                string(e.After),
+        }).Infof("audit: %s by %s of product %s, version %d", e.Action, e.Actor, e.ProductID, e.Version)
+    }
+    return nil
+}
+
+// fileAuditLog appends the audit entries to a file, one JSON object per line.
+type fileAuditLog struct {
+    path string
+    mu   sync.Mutex
+}
+
+func (l *fileAuditLog) Record(entries []auditEntry) error {
+    var data []byte
+    for _, e := range entries {
+        line, err := json.Marshal(e)
+        if err != nil {
+            return err
+        }
+        data = append(append(data, line...), '\n')
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+    if err != nil {
+        return fmt.Errorf("failed to open the audit log: %w", err)
+    }
+    _, err = f.Write(data)
+    if err == nil {
+        err = f.Sync()
+    }
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        return fmt.Errorf("failed to write the audit log: %w", err)
+    }
+    return nil
+}
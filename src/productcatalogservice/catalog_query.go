@@ -0,0 +1,220 @@
+package main
+
+import (
+    "container/heap"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "hash/fnv"
+    "math"
+    "strings"
+
+    pb "productcatalogservice/genproto"
+)
+
+const (
+    // defaultPageSize is the number of products of a page that doesn't ask for a size.
+    defaultPageSize = 50
+    // maxPageSize caps the number of products a page may ask for, which keeps responses well within the payload
+    // limit of Lambda.
+    maxPageSize = 500
+)
+
+// productQuery is what ListProducts and SearchProducts look for, except which page.
+type productQuery struct {
+    // text holds the words to search for; empty lists every product that passes the filter.
+    text   string
+    filter productFilter
+    order  pb.ProductSortOrder
+}
+
+// productFilter restricts the products of a query.
+type productFilter struct {
+    // category is case-folded; empty lets every category through.
+    category string
+    // minPrice and maxPrice bound the price in nano USD, inclusive.
+    minPrice, maxPrice int64
+}
+
+// noFilter lets every product through.
+var noFilter = productFilter{minPrice: math.MinInt64, maxPrice: math.MaxInt64}
+
+// passes reports whether the product at a position passes a filter.
+func (s *catalogSnapshot) passes(pos int32, filter productFilter) bool {
+    if filter.category != "" && !s.inCategory(pos, filter.category) {
+        return false
+    }
+    return s.prices[pos] >= filter.minPrice && s.prices[pos] <= filter.maxPrice
+}
+
+// find returns up to limit products of a query, starting from an offset in the order of the query, and the number of
+// products of the query on all pages.
+func (s *catalogSnapshot) find(q productQuery, offset, limit int) ([]*pb.Product, int) {
+    buf := getScoreBuffers(len(s.products))
+    defer putScoreBuffers(buf)
+
+    var matched []int32
+    tokens := tokenize(q.text)
+    if len(tokens) > 0 {
+        matched = s.score(tokens, q.filter, buf)
+        defer func() {
+            for _, pos := range matched {
+                buf.scores[pos] = 0
+            }
+        }()
+    } else {
+        matched = s.filter(q.filter, buf)
+    }
+    total := len(matched)
+    if offset >= total {
+        return nil, total
+    }
+
+    var page []int32
+    if before := s.ordering(q.order, len(tokens) > 0, buf.scores); before == nil {
+        // The products are already in catalog order.
+        page = matched[offset:min(offset+limit, total)]
+    } else {
+        page = topPositions(matched, offset+limit, before)[offset:]
+    }
+    products := make([]*pb.Product, len(page))
+    for i, pos := range page {
+        products[i] = s.products[pos]
+    }
+    return products, total
+}
+
+// filter returns the positions of the products that pass a filter, in increasing order, in buf.matched.
+func (s *catalogSnapshot) filter(filter productFilter, buf *scoreBuffers) []int32 {
+    matched := buf.matched[:0]
+    if filter.category != "" {
+        for _, pos := range s.byCategory[filter.category] {
+            if s.passes(pos, filter) {
+                matched = append(matched, pos)
+            }
+        }
+    } else {
+        for i := range s.products {
+            if s.passes(int32(i), filter) {
+                matched = append(matched, int32(i))
+            }
+        }
+    }
+    buf.matched = matched
+    return matched
+}
+
+// ordering returns whether the product at position a comes before the one at b in an order, or nil for catalog
+// order. Searches default to the highest scores first. Products that tie stay in catalog order.
+func (s *catalogSnapshot) ordering(order pb.ProductSortOrder, search bool, scores []float64) func(a, b int32) bool {
+    switch order {
+    case pb.ProductSortOrder_PRODUCT_SORT_ORDER_NAME:
+        return func(a, b int32) bool {
+            if s.names[a] != s.names[b] {
+                return s.names[a] < s.names[b]
+            }
+            return a < b
+        }
+    case pb.ProductSortOrder_PRODUCT_SORT_ORDER_PRICE_ASCENDING:
+        return func(a, b int32) bool {
+            if s.prices[a] != s.prices[b] {
+                return s.prices[a] < s.prices[b]
+            }
+            return a < b
+        }
+    case pb.ProductSortOrder_PRODUCT_SORT_ORDER_PRICE_DESCENDING:
+        return func(a, b int32) bool {
+            if s.prices[a] != s.prices[b] {
+                return s.prices[a] > s.prices[b]
+            }
+            return a < b
+        }
+    }
+    if !search {
+        return nil
+    }
+    return func(a, b int32) bool {
+        if scores[a] != scores[b] {
+            return scores[a] > scores[b]
+        }
+        return a < b
+    }
+}
+
+// topPositions returns the first n positions in an order, in that order, without sorting all of them.
+func topPositions(positions []int32, n int, before func(a, b int32) bool) []int32 {
+    // Keep the first n in a heap whose root is the last of them, then take them out last first.
+    top := &positionHeap{before: before}
+    for _, pos := range positions {
+        if len(top.positions) < n {
+            heap.Push(top, pos)
+        } else if before(pos, top.positions[0]) {
+            top.positions[0] = pos
+            heap.Fix(top, 0)
+        }
+    }
+    out := make([]int32, len(top.positions))
+    for i := len(out) - 1; i >= 0; i-- {
+        out[i] = heap.Pop(top).(int32)
+    }
+    return out
+}
+
+// positionHeap is a heap of product positions whose root is the position that comes last in an order.
+type positionHeap struct {
+    positions []int32
+    before    func(a, b int32) bool
+}
+
+func (h *positionHeap) Len() int           { return len(h.positions) }
+func (h *positionHeap) Less(i, j int) bool { return h.before(h.positions[j], h.positions[i]) }
+func (h *positionHeap) Swap(i, j int) {
+    h.positions[i], h.positions[j] = h.positions[j], h.positions[i]
+}
+func (h *positionHeap) Push(x any) { h.positions = append(h.positions, x.(int32)) }
+func (h *positionHeap) Pop() any {
+    last := h.positions[len(h.positions)-1]
+    h.positions = h.positions[:len(h.positions)-1]
+    return last
+}
+
+// pageToken is what an opaque page token holds: the offset of the next page, and a fingerprint of the query it
+// belongs to, so that a token isn't used with another query. As the offset counts products, a reload of the catalog
+// between two pages may skip or repeat products.
+type pageToken struct {
+    Offset      int    `json:"o"`
+    Fingerprint uint64 `json:"q"`
+}
+
+// fingerprint returns a hash of a query.
+func (q productQuery) fingerprint() uint64 {
+    h := fnv.New64a()
+    fmt.Fprintf(h, "%q|%q|%d|%d|%d", strings.Join(tokenize(q.text), " "), q.filter.category, q.filter.minPrice,
+        q.filter.maxPrice, q.order)
+    return h.Sum64()
+}
+
+// encodePageToken returns the token of the page of a query that starts at an offset.
+func encodePageToken(q productQuery, offset int) string {
+    data, _ := json.Marshal(pageToken{Offset: offset, Fingerprint: q.fingerprint()})
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken returns the offset of a page token of a query; an empty token is the first page.
+func decodePageToken(q productQuery, token string) (int, error) {
+    if token == "" {
+        return 0, nil
+    }
+    data, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return 0, fmt.Errorf("malformed page token")
+    }
+    var t pageToken
+    if err := json.Unmarshal(data, &t); err != nil || t.Offset < 0 {
+        return 0, fmt.Errorf("malformed page token")
+    }
+    if t.Fingerprint != q.fingerprint() {
+        return 0, fmt.Errorf("page token belongs to another query")
+    }
+    return t.Offset, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "strings"
+    "testing"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "productcatalogservice/genproto"
+)
+
+func TestListProducts(t *testing.T) {
+    useSnapshot(t, newCatalogSnapshot(loadTestCatalog(t)))
+    headers := map[string]string{}
+    for _, tc := range []struct {
+        name string
+        req  *pb.ListProductsRequest
+        want []string
+    }{
+        {"everything in catalog order", &pb.ListProductsRequest{}, []string{"OLJCESPC7Z", "66VCHSJNUP", "1YMWWN1N4O",
+            "L9ECAV7KIM", "2ZYFJ3GM2N", "0PUK6V6EV0", "LS4PSXUNUM", "9SIQT8TOJO", "6E92ZMYYFZ"}},
+        {"category", &pb.ListProductsRequest{Category: " Kitchen"}, []string{"LS4PSXUNUM", "9SIQT8TOJO", "6E92ZMYYFZ"}},
+        {"price range", &pb.ListProductsRequest{MinPriceUsd: &pb.Money{Units: 18, Nanos: 990000000},
+            MaxPriceUsd: &pb.Money{CurrencyCode: "USD", Units: 20}}, []string{"OLJCESPC7Z", "66VCHSJNUP", "0PUK6V6EV0"}},
+        {"minimum price only", &pb.ListProductsRequest{MinPriceUsd: &pb.Money{Units: 50}}, []string{"1YMWWN1N4O", "L9ECAV7KIM"}},
+        {"cheapest first", &pb.ListProductsRequest{Category: "kitchen",
+            SortOrder: pb.ProductSortOrder_PRODUCT_SORT_ORDER_PRICE_ASCENDING}, []string{"9SIQT8TOJO", "6E92ZMYYFZ", "LS4PSXUNUM"}},
+        {"most expensive first", &pb.ListProductsRequest{PageSize: 2,
+            SortOrder: pb.ProductSortOrder_PRODUCT_SORT_ORDER_PRICE_DESCENDING}, []string{"1YMWWN1N4O", "L9ECAV7KIM"}},
+        {"ties stay in catalog order", &pb.ListProductsRequest{MaxPriceUsd: &pb.Money{Units: 18, Nanos: 990000000},
+            SortOrder: pb.ProductSortOrder_PRODUCT_SORT_ORDER_PRICE_DESCENDING},
+            []string{"66VCHSJNUP", "0PUK6V6EV0", "LS4PSXUNUM", "6E92ZMYYFZ", "9SIQT8TOJO"}},
+        {"by name", &pb.ListProductsRequest{Category: "accessories",
+            SortOrder: pb.ProductSortOrder_PRODUCT_SORT_ORDER_NAME}, []string{"OLJCESPC7Z", "1YMWWN1N4O"}},
+        {"unknown category", &pb.ListProductsRequest{Category: "garden"}, nil},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            resp, err := svc.ListProducts(tc.req, &headers)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := productIDs(resp.Products); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+                t.Errorf("ListProducts(%v) = %v, want %v", tc.req, got, tc.want)
+            }
+        })
+    }
+}
+
+func TestListProductsPages(t *testing.T) {
+    useSnapshot(t, newCatalogSnapshot(loadTestCatalog(t)))
+    headers := map[string]string{}
+    all, err := svc.ListProducts(&pb.ListProductsRequest{SortOrder: pb.ProductSortOrder_PRODUCT_SORT_ORDER_NAME}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Walking the pages, with a page size that changes on the way, returns every product once, in order.
+    var got []*pb.Product
+    req := &pb.ListProductsRequest{PageSize: 2, SortOrder: pb.ProductSortOrder_PRODUCT_SORT_ORDER_NAME}
+    for pages := 1; ; pages++ {
+        resp, err := svc.ListProducts(req, &headers)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if resp.TotalSize != 9 {
+            t.Errorf("page %d: total size %d, want 9", pages, resp.TotalSize)
+        }
+        got = append(got, resp.Products...)
+        if resp.NextPageToken == "" {
+            break
+        }
+        if pages > 9 {
+            t.Fatal("too many pages")
+        }
+        req.PageToken = resp.NextPageToken
+        req.PageSize = 3
+    }
+    if ids, want := productIDs(got), productIDs(all.Products); strings.Join(ids, ",") != strings.Join(want, ",") {
+        t.Errorf("pages = %v, want %v", ids, want)
+    }
+}
+
+func TestSearchProductsPages(t *testing.T) {
+    useSnapshot(t, newCatalogSnapshot(loadTestCatalog(t)))
+    headers := map[string]string{}
+    req := &pb.SearchProductsRequest{Query: "kitchen mug", PageSize: 2}
+    first, err := svc.SearchProducts(req, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    req.PageToken = first.NextPageToken
+    second, err := svc.SearchProducts(req, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := productIDs(append(first.Results, second.Results...)); strings.Join(got, ",") != "6E92ZMYYFZ,LS4PSXUNUM,9SIQT8TOJO" {
+        t.Errorf("pages = %v, want every match once, the most relevant first", got)
+    }
+    if first.TotalSize != 3 || second.NextPageToken != "" {
+        t.Errorf("total size %d, last token %q, want 3 and no token", first.TotalSize, second.NextPageToken)
+    }
+}
+
+func TestListProductsInvalidArguments(t *testing.T) {
+    useSnapshot(t, newCatalogSnapshot(loadTestCatalog(t)))
+    headers := map[string]string{}
+    first, err := svc.ListProducts(&pb.ListProductsRequest{PageSize: 1}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for name, req := range map[string]*pb.ListProductsRequest{
+        "malformed token":        {PageToken: "not a token"},
+        "token of another query": {PageToken: first.NextPageToken, Category: "kitchen"},
+        "other currency":         {MinPriceUsd: &pb.Money{CurrencyCode: "EUR", Units: 10}},
+        "empty price range":      {MinPriceUsd: &pb.Money{Units: 20}, MaxPriceUsd: &pb.Money{Units: 10}},
+        "unknown sort order":     {SortOrder: pb.ProductSortOrder(42)},
+    } {
+        if _, err := svc.ListProducts(req, &headers); status.Code(err) != codes.InvalidArgument {
+            t.Errorf("%s: ListProducts() returned %v, want InvalidArgument", name, err)
+        }
+    }
+
+    // A token of another page size is fine, as is a token of a search with the same words in another case.
+    if _, err := svc.ListProducts(&pb.ListProductsRequest{PageSize: 5, PageToken: first.NextPageToken}, &headers); err != nil {
+        t.Errorf("ListProducts() with another page size returned %v", err)
+    }
+    search, err := svc.SearchProducts(&pb.SearchProductsRequest{Query: "Kitchen", PageSize: 1}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := svc.SearchProducts(&pb.SearchProductsRequest{Query: "kitchen!", PageToken: search.NextPageToken}, &headers); err != nil {
+        t.Errorf("SearchProducts() with the same words returned %v", err)
+    }
+}
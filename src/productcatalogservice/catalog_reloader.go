@@ -0,0 +1,116 @@
+package main
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+)
+
+// fileSettleDelay is how long the reloader waits after the last change to the catalog file before it reloads it, so
+// that a file still being written is read once it's complete.
+const fileSettleDelay = 200 * time.Millisecond
+
+// catalogReloader reloads the catalog in the background: when the catalog file changes, every poll interval, and
+// whenever a signal such as SIGHUP arrives. Reloads run one at a time on the reloader's goroutine, and only swap the
+// catalog if its content changed.
+type catalogReloader struct {
+    catalog *productCatalog
+    // file is the catalog file to watch, or empty to not watch any.
+    file string
+    // pollInterval is how often to reload the catalog whether or not anything changed, as a database can't be
+    // watched; zero doesn't poll.
+    pollInterval time.Duration
+    // signals triggers a reload on every signal it receives.
+    signals <-chan os.Signal
+}
+
+// start sets up the watch of the catalog file and reloads the catalog in the background until the context is done.
+// It returns a channel that's closed once the reloader stopped.
+func (r *catalogReloader) start(ctx context.Context) (<-chan struct{}, error) {
+    var watcher *fsnotify.Watcher
+    if r.file != "" {
+        var err error
+        if watcher, err = fsnotify.NewWatcher(); err != nil {
+            return nil, err
+        }
+        // Watch the directory rather than the file: a file replaced by a rename, as editors and Kubernetes config
+        // maps do, drops out of a watch on the file itself.
+        if err := watcher.Add(filepath.Dir(r.file)); err != nil {
+            watcher.Close()
+            return nil, err
+        }
+    }
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        r.run(ctx, watcher)
+    }()
+    return done, nil
+}
+
+// run reloads the catalog until the context is done.
+func (r *catalogReloader) run(ctx context.Context, watcher *fsnotify.Watcher) {
+    var (
+        fileEvents <-chan fsnotify.Event
+        fileErrors <-chan error
+    )
+    if watcher != nil {
+        defer watcher.Close()
+        fileEvents, fileErrors = watcher.Events, watcher.Errors
+    }
+
+    var poll <-chan time.Time
+    if r.pollInterval > 0 {
+        ticker := time.NewTicker(r.pollInterval)
+        defer ticker.Stop()
+        poll = ticker.C
+    }
+
+    var settled <-chan time.Time
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case event := <-fileEvents:
+            if r.affectsFile(event) {
+                settled = time.After(fileSettleDelay)
+            }
+        case err := <-fileErrors:
+            log.Warnf("error watching the catalog file: %v", err)
+        case <-settled:
+            settled = nil
+            r.reload("the catalog file changed")
+        case <-poll:
+            r.reload("polling")
+        case sig := <-r.signals:
+            r.reload("received " + sig.String())
+        }
+    }
+}
+
+// affectsFile reports whether a change in the directory of the catalog file may have changed the file: a change to
+// the file itself, or the swap of the "..data" link through which Kubernetes updates the files of a config map.
+func (r *catalogReloader) affectsFile(event fsnotify.Event) bool {
+    if event.Op == fsnotify.Chmod {
+        return false
+    }
+    name := filepath.Clean(event.Name)
+    return name == filepath.Clean(r.file) || filepath.Base(name) == "..data"
+}
+
+// reload reloads the catalog, and logs what happened and why.
+func (r *catalogReloader) reload(reason string) {
+    changed, err := r.catalog.reload()
+    switch {
+    case err != nil:
+        log.Warnf("failed to reload the catalog (%s), keeping the current one: %v", reason, err)
+    case changed:
+        log.Infof("reloaded the catalog (%s): %d products", reason, len(r.catalog.catalog.Load().products))
+    default:
+        log.Debugf("the catalog didn't change (%s)", reason)
+    }
+}
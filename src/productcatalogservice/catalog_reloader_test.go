@@ -0,0 +1,247 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "sync"
+    "syscall"
+    "testing"
+    "time"
+
+    "github.com/golang/protobuf/jsonpb"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "productcatalogservice/genproto"
+)
+
+// fakeSource is a catalog source whose products a test sets, and which counts its loads.
+type fakeSource struct {
+    mu       sync.Mutex
+    products []*pb.Product
+    err      error
+    loads    int
+}
+
+func (f *fakeSource) set(products []*pb.Product, err error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.products, f.err = products, err
+}
+
+func (f *fakeSource) Load(ctx context.Context) ([]*pb.Product, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.loads++
+    return f.products, f.err
+}
+
+func (f *fakeSource) String() string {
+    return "fake"
+}
+
+func (f *fakeSource) loadCount() int {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.loads
+}
+
+// sourceFunc is a catalog source that calls a function.
+type sourceFunc func(ctx context.Context) ([]*pb.Product, error)
+
+func (f sourceFunc) Load(ctx context.Context) ([]*pb.Product, error) {
+    return f(ctx)
+}
+
+func (f sourceFunc) String() string {
+    return "func"
+}
+
+// waitFor polls a condition until it holds, or fails the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+        if cond() {
+            return
+        }
+    }
+    t.Fatalf("timed out waiting for %s", what)
+}
+
+// startReloader runs a reloader until the test ends.
+func startReloader(t *testing.T, r *catalogReloader) {
+    quietLogs(t)
+    ctx, cancel := context.WithCancel(context.Background())
+    done, err := r.start(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        cancel()
+        <-done
+    })
+}
+
+func TestReloadSwapsOnlyChangedCatalogs(t *testing.T) {
+    source := &fakeSource{products: []*pb.Product{{Id: "A", Name: "Mug"}}}
+    catalog := &productCatalog{source: source}
+
+    first := loaded(t, catalog)
+    if len(first.products) != 1 {
+        t.Fatalf("first snapshot has %d products, want 1", len(first.products))
+    }
+    // The same content in new messages isn't a change.
+    source.set([]*pb.Product{{Id: "A", Name: "Mug"}}, nil)
+    if changed, err := catalog.reload(); changed || err != nil || loaded(t, catalog) != first {
+        t.Errorf("reload() of the same catalog = %v, %v, want no swap", changed, err)
+    }
+
+    source.set(nil, errors.New("database down"))
+    if changed, err := catalog.reload(); changed || err == nil || loaded(t, catalog) != first {
+        t.Errorf("failed reload() = %v, %v, want an error and the previous snapshot", changed, err)
+    }
+
+    source.set([]*pb.Product{{Id: "A", Name: "Mug"}, {Id: "B", Name: "Jar"}}, nil)
+    if changed, err := catalog.reload(); !changed || err != nil || len(loaded(t, catalog).products) != 2 {
+        t.Errorf("reload() of a changed catalog = %v, %v, want a swap to 2 products", changed, err)
+    }
+}
+
+func TestSnapshotLoadsOnce(t *testing.T) {
+    source := &fakeSource{products: []*pb.Product{{Id: "A"}}}
+    catalog := &productCatalog{source: source}
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            catalog.snapshot()
+        }()
+    }
+    wg.Wait()
+    if loads := source.loadCount(); loads != 1 {
+        t.Errorf("concurrent first requests loaded the catalog %d times, want once", loads)
+    }
+
+    // A catalog that fails to load makes requests fail as Unavailable, and is tried again after a while.
+    source = &fakeSource{err: errors.New("missing file")}
+    clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    catalog = &productCatalog{source: source, now: func() time.Time { return clock }}
+    if _, err := catalog.snapshot(); status.Code(err) != codes.Unavailable {
+        t.Errorf("snapshot() of a failed load = %v, want Unavailable", err)
+    }
+    source.set([]*pb.Product{{Id: "A"}}, nil)
+    if _, err := catalog.snapshot(); status.Code(err) != codes.Unavailable || source.loadCount() != 1 {
+        t.Errorf("snapshot() right after a failed load = %v after %d loads, want Unavailable after 1", err, source.loadCount())
+    }
+    clock = clock.Add(catalogRetryInterval)
+    if s, err := catalog.snapshot(); err != nil || len(s.products) != 1 {
+        t.Errorf("snapshot() after the retry interval = %v, %v, want 1 product", s, err)
+    }
+}
+
+func TestSnapshotReloadsAfterMaxAge(t *testing.T) {
+    quietLogs(t)
+    source := &fakeSource{products: []*pb.Product{{Id: "A"}}}
+    clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    catalog := &productCatalog{source: source, maxAge: time.Minute, now: func() time.Time { return clock }}
+    loaded(t, catalog)
+
+    source.set([]*pb.Product{{Id: "A"}, {Id: "B"}}, nil)
+    clock = clock.Add(30 * time.Second)
+    if s := loaded(t, catalog); s.product("B") != nil || source.loadCount() != 1 {
+        t.Errorf("catalog reloaded before maxAge (%d loads)", source.loadCount())
+    }
+    clock = clock.Add(30 * time.Second)
+    if s := loaded(t, catalog); s.product("B") == nil || source.loadCount() != 2 {
+        t.Errorf("catalog not reloaded after maxAge (%d loads)", source.loadCount())
+    }
+
+    // A failed reload keeps the current catalog, and isn't tried again before maxAge.
+    source.set(nil, errors.New("database down"))
+    clock = clock.Add(time.Minute)
+    loaded(t, catalog)
+    if s := loaded(t, catalog); s.product("B") == nil || source.loadCount() != 3 {
+        t.Errorf("after a failed reload, got %v after %d loads, want the current catalog after 3", s.products, source.loadCount())
+    }
+}
+
+// loaded returns the current snapshot of a catalog, or fails the test if it can't be loaded.
+func loaded(t testing.TB, catalog *productCatalog) *catalogSnapshot {
+    t.Helper()
+    snapshot, err := catalog.snapshot()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return snapshot
+}
+
+// writeCatalogFile writes a catalog file, replacing it by a rename as editors and deployments do.
+func writeCatalogFile(t *testing.T, path string, products ...*pb.Product) {
+    t.Helper()
+    data, err := (&jsonpb.Marshaler{}).MarshalToString(&pb.ListProductsResponse{Products: products})
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestReloaderWatchesFile(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "products.json")
+    writeCatalogFile(t, path, &pb.Product{Id: "A"})
+    var loads int
+    var mu sync.Mutex
+    file := newFileCatalogSource(path)
+    catalog := &productCatalog{source: sourceFunc(func(ctx context.Context) ([]*pb.Product, error) {
+        mu.Lock()
+        loads++
+        mu.Unlock()
+        return file.Load(ctx)
+    })}
+    catalog.snapshot()
+    startReloader(t, &catalogReloader{catalog: catalog, file: path})
+
+    writeCatalogFile(t, path, &pb.Product{Id: "A"}, &pb.Product{Id: "B"})
+    waitFor(t, "the new catalog", func() bool { return loaded(t, catalog).product("B") != nil })
+
+    // Other files of the directory don't trigger reloads.
+    mu.Lock()
+    before := loads
+    mu.Unlock()
+    if err := os.WriteFile(filepath.Join(filepath.Dir(path), "notes.txt"), []byte("hi"), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    time.Sleep(3 * fileSettleDelay)
+    mu.Lock()
+    defer mu.Unlock()
+    if loads != before {
+        t.Errorf("writing another file reloaded the catalog")
+    }
+}
+
+func TestReloaderPollsAndHandlesSignals(t *testing.T) {
+    source := &fakeSource{products: []*pb.Product{{Id: "A"}}}
+    catalog := &productCatalog{source: source}
+    catalog.snapshot()
+
+    signals := make(chan os.Signal, 1)
+    startReloader(t, &catalogReloader{catalog: catalog, signals: signals})
+    source.set([]*pb.Product{{Id: "B"}}, nil)
+    signals <- syscall.SIGHUP
+    waitFor(t, "a reload on SIGHUP", func() bool { return loaded(t, catalog).product("B") != nil })
+
+    polled := &fakeSource{products: []*pb.Product{{Id: "A"}}}
+    catalog = &productCatalog{source: polled}
+    catalog.snapshot()
+    startReloader(t, &catalogReloader{catalog: catalog, pollInterval: 10 * time.Millisecond})
+    polled.set([]*pb.Product{{Id: "C"}}, nil)
+    waitFor(t, "a reload by polling", func() bool { return loaded(t, catalog).product("C") != nil })
+}
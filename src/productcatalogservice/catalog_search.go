@@ -0,0 +1,209 @@
+package main
+
+import (
+    "math"
+    "sort"
+    "strings"
+    "sync"
+)
+
+const (
+    // bm25K1 and bm25B are the usual BM25 parameters: how quickly repeating a word stops raising the score, and how
+    // much longer texts are penalized.
+    bm25K1 = 1.2
+    bm25B  = 0.75
+
+    // prefixWeight and fuzzyWeight scale the score of a product that matches a query word only by a word that starts
+    // with it, or only by a word a typo or two away from it.
+    prefixWeight = 0.7
+    fuzzyWeight  = 0.5
+)
+
+// score scores the products that pass a filter and match the words of a query, and returns their positions in
+// buf.matched, in no particular order, with their scores in buf.scores. A product matches if its name or description
+// contains any word of the query; words are compared by their stems, ignoring case, and a query word the catalog
+// doesn't have matches the words that start with it or, failing that, the words a typo or two away. Scores are BM25,
+// with the words of the name counting more.
+func (s *catalogSnapshot) score(tokens []string, filter productFilter, buf *scoreBuffers) []int32 {
+    // best holds the score of every product for the current query word, and touched the positions it set.
+    scores, best := buf.scores, buf.best
+    matched, touched := buf.matched[:0], buf.touched[:0]
+    seen := make(map[string]bool, len(tokens))
+    for _, token := range tokens {
+        if seen[token] {
+            continue
+        }
+        seen[token] = true
+
+        // A product gets the score of the best of the words that match a query word, so a product containing both
+        // "sunglass" and "sunhat" doesn't count "sun" twice.
+        touched = touched[:0]
+        for _, match := range s.expand(token) {
+            list := s.postings[match.term]
+            idf := math.Log(1 + (float64(len(s.products))-float64(len(list))+0.5)/(float64(len(list))+0.5))
+            for _, p := range list {
+                if !s.passes(p.pos, filter) {
+                    continue
+                }
+                norm := bm25K1 * (1 - bm25B + bm25B*s.lengths[p.pos]/s.avgLength)
+                score := match.weight * idf * p.freq * (bm25K1 + 1) / (p.freq + norm)
+                if score > best[p.pos] {
+                    if best[p.pos] == 0 {
+                        touched = append(touched, p.pos)
+                    }
+                    best[p.pos] = score
+                }
+            }
+        }
+        for _, pos := range touched {
+            if scores[pos] == 0 {
+                matched = append(matched, pos)
+            }
+            scores[pos] += best[pos]
+            best[pos] = 0
+        }
+    }
+    buf.matched, buf.touched = matched, touched
+    return matched
+}
+
+// scoreBuffers are the per-product arrays of a query, which are pooled as allocating them for every query of a large
+// catalog would cost more than the query itself. A query hands them back with every score zeroed.
+type scoreBuffers struct {
+    scores, best     []float64
+    matched, touched []int32
+}
+
+var scoreBufferPool sync.Pool
+
+// getScoreBuffers returns zeroed score buffers for a catalog of n products.
+func getScoreBuffers(n int) *scoreBuffers {
+    buf, _ := scoreBufferPool.Get().(*scoreBuffers)
+    if buf == nil || len(buf.scores) < n {
+        return &scoreBuffers{scores: make([]float64, n), best: make([]float64, n)}
+    }
+    return buf
+}
+
+func putScoreBuffers(buf *scoreBuffers) {
+    scoreBufferPool.Put(buf)
+}
+
+// termMatch is a word of the index that matches a query word, and how much a match on it counts.
+type termMatch struct {
+    term   string
+    weight float64
+}
+
+// expand returns the words of the index that match a query word: its stem if the index has it, else the words that
+// start with the query word, as while the user is still typing, else the words within the edit distance allowed for
+// its length.
+func (s *catalogSnapshot) expand(token string) []termMatch {
+    root := stem(token)
+    if _, ok := s.postings[root]; ok {
+        return []termMatch{{root, 1}}
+    }
+
+    var matches []termMatch
+    for i := sort.SearchStrings(s.terms, token); i < len(s.terms) && strings.HasPrefix(s.terms[i], token); i++ {
+        matches = append(matches, termMatch{s.terms[i], prefixWeight})
+    }
+    if len(matches) > 0 {
+        return matches
+    }
+
+    maxDistance := typoTolerance(root)
+    if maxDistance == 0 {
+        return nil
+    }
+    for _, term := range s.terms {
+        if d := editDistance(root, term, maxDistance); d <= maxDistance {
+            matches = append(matches, termMatch{term, fuzzyWeight / float64(d)})
+        }
+    }
+    return matches
+}
+
+// typoTolerance returns how many typos a query word of that length may have: none for short words, which would match
+// too many others, one from four letters and two from eight.
+func typoTolerance(word string) int {
+    switch n := len([]rune(word)); {
+    case n >= 8:
+        return 2
+    case n >= 4:
+        return 1
+    default:
+        return 0
+    }
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and transpositions of adjacent letters
+// that turn a into b, or bound+1 if it's more than bound.
+func editDistance(a, b string, bound int) int {
+    ra, rb := []rune(a), []rune(b)
+    if d := len(ra) - len(rb); d > bound || -d > bound {
+        return bound + 1
+    }
+    // rows[2] is the current row of the dynamic programming table, rows[1] and rows[0] the two before.
+    rows := [3][]int{make([]int, len(rb)+1), make([]int, len(rb)+1), make([]int, len(rb)+1)}
+    for j := range rows[1] {
+        rows[1][j] = j
+    }
+    for i := 1; i <= len(ra); i++ {
+        cur, prev, prev2 := rows[2], rows[1], rows[0]
+        cur[0] = i
+        rowMin := cur[0]
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+            if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+                cur[j] = min(cur[j], prev2[j-2]+1)
+            }
+            rowMin = min(rowMin, cur[j])
+        }
+        if rowMin > bound {
+            return bound + 1
+        }
+        rows[0], rows[1], rows[2] = prev, cur, prev2
+    }
+    return min(rows[1][len(rb)], bound+1)
+}
+
+// stem reduces an English word to a stem shared by its inflections, such as the plural of a noun or the tenses of a
+// verb: "glasses" and "glass" both become "glass", "accessories" "accessory", "settings" "set". It's a light stemmer
+// that only strips common suffixes, and leaves short words and words that end in "ss", "us" or "is" alone.
+func stem(word string) string {
+    if len(word) <= 3 {
+        return word
+    }
+    switch {
+    case strings.HasSuffix(word, "ies") && len(word) > 4:
+        return word[:len(word)-3] + "y"
+    case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "shes"), strings.HasSuffix(word, "ches"),
+        strings.HasSuffix(word, "xes"), strings.HasSuffix(word, "zes"):
+        word = word[:len(word)-2]
+    case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+        return word
+    case strings.HasSuffix(word, "s"):
+        word = word[:len(word)-1]
+    }
+    switch {
+    case strings.HasSuffix(word, "ing") && len(word) > 5:
+        return undouble(word[:len(word)-3])
+    case strings.HasSuffix(word, "ed") && len(word) > 4:
+        return undouble(word[:len(word)-2])
+    }
+    return word
+}
+
+// undouble drops the doubled final consonant that "ing" and "ed" leave behind, as in "shipping" or "zipped".
+func undouble(word string) string {
+    n := len(word)
+    if n >= 2 && word[n-1] == word[n-2] && !strings.ContainsRune("aeiouls", rune(word[n-1])) {
+        return word[:n-1]
+    }
+    return word
+}
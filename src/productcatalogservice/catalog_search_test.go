@@ -0,0 +1,113 @@
+package main
+
+import (
+    "strings"
+    "testing"
+
+    pb "productcatalogservice/genproto"
+)
+
+func TestSearchProducts(t *testing.T) {
+    useSnapshot(t, newCatalogSnapshot(loadTestCatalog(t)))
+    headers := map[string]string{}
+    for _, tc := range []struct {
+        name string
+        req  *pb.SearchProductsRequest
+        want []string
+    }{
+        {"case is ignored", &pb.SearchProductsRequest{Query: "KITCHEN"}, []string{"LS4PSXUNUM", "9SIQT8TOJO"}},
+        {"punctuation is ignored", &pb.SearchProductsRequest{Query: "  Sunglasses! "}, []string{"OLJCESPC7Z"}},
+        {"stems match", &pb.SearchProductsRequest{Query: "sunglass"}, []string{"OLJCESPC7Z"}},
+        {"plurals match", &pb.SearchProductsRequest{Query: "glasses"}, []string{"9SIQT8TOJO"}},
+        {"prefixes match", &pb.SearchProductsRequest{Query: "sun"}, []string{"OLJCESPC7Z"}},
+        {"one typo", &pb.SearchProductsRequest{Query: "wacth"}, []string{"1YMWWN1N4O"}},
+        {"two typos in a long word", &pb.SearchProductsRequest{Query: "hiardyer"}, []string{"2ZYFJ3GM2N"}},
+        {"no typos in a short word", &pb.SearchProductsRequest{Query: "mog"}, nil},
+        {"any word matches", &pb.SearchProductsRequest{Query: "watch bamboo"}, []string{"1YMWWN1N4O", "9SIQT8TOJO"}},
+        {"shorter texts rank first", &pb.SearchProductsRequest{Query: "outfits"}, []string{"OLJCESPC7Z", "1YMWWN1N4O"}},
+        {"no match", &pb.SearchProductsRequest{Query: "xylophone"}, nil},
+        {"category", &pb.SearchProductsRequest{Query: "perfect", Category: "Kitchen"}, []string{"9SIQT8TOJO"}},
+        {"category without a query", &pb.SearchProductsRequest{Category: "accessories"}, []string{"OLJCESPC7Z", "1YMWWN1N4O"}},
+        {"prefixes only match words the catalog lacks", &pb.SearchProductsRequest{Query: "perfect"}, []string{"2ZYFJ3GM2N", "9SIQT8TOJO"}},
+        {"page size", &pb.SearchProductsRequest{Query: "perfect", PageSize: 1}, []string{"2ZYFJ3GM2N"}},
+        {"page size without a query", &pb.SearchProductsRequest{PageSize: 2}, []string{"OLJCESPC7Z", "66VCHSJNUP"}},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            resp, err := svc.SearchProducts(tc.req, &headers)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := productIDs(resp.Results); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+                t.Errorf("SearchProducts(%v) = %v, want %v", tc.req, got, tc.want)
+            }
+        })
+    }
+}
+
+func TestSearchRanking(t *testing.T) {
+    s := newCatalogSnapshot([]*pb.Product{
+        {Id: "shade", Name: "Lamp shade", Description: "Fits any reading light."},
+        {Id: "lamp", Name: "Desk lamp", Description: "A reading lamp with a warm light."},
+        {Id: "light", Name: "Reading light", Description: "Brighter than a lamp."},
+        {Id: "lampshade", Name: "Lampshade", Description: "Linen."},
+    })
+    for _, tc := range []struct {
+        query string
+        want  []string
+    }{
+        // The name counts more than the description, and repeating the word counts more than saying it once. As the
+        // catalog has the word, the words that start with it don't match.
+        {"lamp", []string{"lamp", "shade", "light"}},
+        {"lamps", []string{"lamp", "shade", "light"}},
+        {"lampsh", []string{"lampshade"}},
+        // Matching more words of the query counts more than matching one.
+        {"reading light", []string{"light", "shade", "lamp"}},
+    } {
+        got, _ := s.find(productQuery{text: tc.query, filter: noFilter}, 0, maxPageSize)
+        if got := productIDs(got); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+            t.Errorf("search(%q) = %v, want %v", tc.query, got, tc.want)
+        }
+    }
+}
+
+func TestStem(t *testing.T) {
+    for word, want := range map[string]string{
+        "glasses":     "glass",
+        "glass":       "glass",
+        "sunglasses":  "sunglass",
+        "accessories": "accessory",
+        "watches":     "watch",
+        "candles":     "candle",
+        "shakers":     "shaker",
+        "settings":    "set",
+        "cropped":     "crop",
+        "scooped":     "scoop",
+        "stainless":   "stainless",
+        "cactus":      "cactus",
+        "oz":          "oz",
+    } {
+        if got := stem(word); got != want {
+            t.Errorf("stem(%q) = %q, want %q", word, got, want)
+        }
+    }
+}
+
+func TestEditDistance(t *testing.T) {
+    for _, tc := range []struct {
+        a, b  string
+        bound int
+        want  int
+    }{
+        {"watch", "watch", 1, 0},
+        {"wacth", "watch", 1, 1},
+        {"wach", "watch", 1, 1},
+        {"watches", "watch", 1, 2},
+        {"hiardyer", "hairdryer", 2, 2},
+        {"kitchen", "mug", 2, 3},
+        {"café", "cafe", 1, 1},
+    } {
+        if got := editDistance(tc.a, tc.b, tc.bound); got != tc.want {
+            t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tc.a, tc.b, tc.bound, got, tc.want)
+        }
+    }
+}
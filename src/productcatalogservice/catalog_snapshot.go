@@ -0,0 +1,139 @@
+package main
+
+import (
+    "sort"
+    "strings"
+    "unicode"
+
+    pb "productcatalogservice/genproto"
+)
+
+// nameBoost is how many times more a word of a product's name counts than a word of its description, both in the
+// term frequencies and in the lengths of the indexed text.
+const nameBoost = 3
+
+// catalogSnapshot is an immutable view of the catalog with the indexes the RPCs look products up with. A reload
+// builds a new snapshot and swaps it in whole, so requests never see a catalog half loaded; nothing may modify a
+// snapshot, or the products it holds, once it's built.
+type catalogSnapshot struct {
+    // products are in the order of the catalog, which is the default order of ListProducts.
+    products []*pb.Product
+    // byID maps product IDs to products.
+    byID map[string]*pb.Product
+    // byCategory maps case-folded categories to the positions in products of their products, in increasing order.
+    byCategory map[string][]int32
+    // categories holds the case-folded categories of every product, by position in products.
+    categories [][]string
+    // prices holds the price of every product in nano USD, by position in products.
+    prices []int64
+    // names holds the case-folded name of every product, by position in products, to sort products by name.
+    names []string
+    // postings maps the stem of every word of the product names and descriptions to the products that contain it,
+    // in increasing positions.
+    postings map[string][]posting
+    // terms are the keys of postings, sorted, to find the stems that start with a prefix.
+    terms []string
+    // lengths holds the length of the indexed text of every product, by position in products, with the words of the
+    // name counted nameBoost times.
+    lengths []float64
+    // avgLength is the average of lengths.
+    avgLength float64
+    // etag identifies the content of the catalog, for clients to revalidate the responses they cache, or is empty if
+    // the snapshot isn't of a loaded catalog.
+    etag string
+}
+
+// posting records that a product contains a stem.
+type posting struct {
+    // pos is the position of the product in the snapshot's products.
+    pos int32
+    // freq is how many times the product contains the stem, with the occurrences in the name counted nameBoost
+    // times.
+    freq float64
+}
+
+// newCatalogSnapshot indexes a list of products. The snapshot takes ownership of the products.
+func newCatalogSnapshot(products []*pb.Product) *catalogSnapshot {
+    s := &catalogSnapshot{
+        products:   products,
+        byID:       make(map[string]*pb.Product, len(products)),
+        byCategory: make(map[string][]int32),
+        categories: make([][]string, len(products)),
+        prices:     make([]int64, len(products)),
+        names:      make([]string, len(products)),
+        postings:   make(map[string][]posting),
+        lengths:    make([]float64, len(products)),
+    }
+    var total float64
+    for i, product := range products {
+        if _, ok := s.byID[product.Id]; !ok {
+            // The first product of an ID wins, as it did when GetProduct scanned the list.
+            s.byID[product.Id] = product
+        }
+        pos := int32(i)
+        for _, category := range product.Categories {
+            category = strings.ToLower(strings.TrimSpace(category))
+            if category != "" && !s.inCategory(pos, category) {
+                s.byCategory[category] = append(s.byCategory[category], pos)
+                s.categories[i] = append(s.categories[i], category)
+            }
+        }
+        s.prices[i] = nanos(product.PriceUsd)
+        s.names[i] = strings.ToLower(product.Name)
+
+        for _, field := range []struct {
+            text   string
+            weight float64
+        }{{product.Name, nameBoost}, {product.Description, 1}} {
+            for _, token := range tokenize(field.text) {
+                // Positions only grow, so the product is already in the list if it's the last one.
+                term := stem(token)
+                list := s.postings[term]
+                if n := len(list); n > 0 && list[n-1].pos == pos {
+                    list[n-1].freq += field.weight
+                } else {
+                    s.postings[term] = append(list, posting{pos: pos, freq: field.weight})
+                }
+                s.lengths[i] += field.weight
+            }
+        }
+        total += s.lengths[i]
+    }
+    if len(products) > 0 {
+        s.avgLength = total / float64(len(products))
+    }
+
+    s.terms = make([]string, 0, len(s.postings))
+    for term := range s.postings {
+        s.terms = append(s.terms, term)
+    }
+    sort.Strings(s.terms)
+    return s
+}
+
+// tokenize splits a text into its case-folded words, which are runs of letters and digits.
+func tokenize(text string) []string {
+    return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    })
+}
+
+// product returns the product of an ID, or nil if the catalog has none.
+func (s *catalogSnapshot) product(id string) *pb.Product {
+    return s.byID[id]
+}
+
+// inCategory reports whether the product at a position has a case-folded category.
+func (s *catalogSnapshot) inCategory(pos int32, category string) bool {
+    for _, c := range s.categories[pos] {
+        if c == category {
+            return true
+        }
+    }
+    return false
+}
+
+// nanos returns an amount of money in nano units of its currency.
+func nanos(m *pb.Money) int64 {
+    return m.GetUnits()*1e9 + int64(m.GetNanos())
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "math/rand"
+    "strings"
+    "sync"
+    "testing"
+
+    pb "productcatalogservice/genproto"
+)
+
+// loadTestCatalog returns the products of products.json.
+func loadTestCatalog(t testing.TB) []*pb.Product {
+    t.Helper()
+    products, err := newFileCatalogSource(catalogFile).Load(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    return products
+}
+
+// useSnapshot makes the service serve a snapshot until the test ends.
+func useSnapshot(t testing.TB, s *catalogSnapshot) {
+    prev := svc.catalog.Load()
+    svc.catalog.Store(s)
+    t.Cleanup(func() { svc.catalog.Store(prev) })
+}
+
+// productIDs returns the IDs of products, in order.
+func productIDs(products []*pb.Product) []string {
+    ids := make([]string, len(products))
+    for i, product := range products {
+        ids[i] = product.Id
+    }
+    return ids
+}
+
+func TestCatalogSnapshotLookups(t *testing.T) {
+    products := []*pb.Product{
+        {Id: "A", Name: "Mug", Categories: []string{"Kitchen"}},
+        {Id: "B", Name: "Jar", Categories: []string{"kitchen", "storage"}},
+        {Id: "A", Name: "Duplicate"},
+    }
+    s := newCatalogSnapshot(products)
+
+    if got := s.product("A"); got != products[0] {
+        t.Errorf("product(A) = %v, want the first product with that ID", got)
+    }
+    if got := s.product("Z"); got != nil {
+        t.Errorf("product(Z) = %v, want nil", got)
+    }
+    if got := s.byCategory["kitchen"]; len(got) != 2 || got[0] != 0 || got[1] != 1 {
+        t.Errorf("byCategory[kitchen] = %v, want [0 1]", got)
+    }
+    if got := s.categories[0]; len(got) != 1 || got[0] != "kitchen" {
+        t.Errorf("categories[0] = %v, want the case-folded category", got)
+    }
+}
+
+func TestGetProduct(t *testing.T) {
+    useSnapshot(t, newCatalogSnapshot(loadTestCatalog(t)))
+    headers := map[string]string{}
+
+    product, err := svc.GetProduct(&pb.GetProductRequest{Id: "OLJCESPC7Z"}, &headers)
+    if err != nil || product.Name != "Sunglasses" {
+        t.Errorf("GetProduct(OLJCESPC7Z) = %v, %v, want the sunglasses", product, err)
+    }
+    if _, err := svc.GetProduct(&pb.GetProductRequest{Id: "missing"}, &headers); err == nil {
+        t.Error("GetProduct(missing) succeeded")
+    }
+}
+
+// TestSnapshotSwap checks that requests running while the catalog is swapped see either the old or the new catalog,
+// and never a mix of the two.
+func TestSnapshotSwap(t *testing.T) {
+    old := newCatalogSnapshot([]*pb.Product{{Id: "A", Name: "Old mug"}, {Id: "B", Name: "Old jar"}})
+    replacement := newCatalogSnapshot([]*pb.Product{{Id: "C", Name: "New mug"}})
+    useSnapshot(t, old)
+
+    var wg sync.WaitGroup
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        for i := 0; i < 1000; i++ {
+            if i%2 == 0 {
+                svc.catalog.Store(replacement)
+            } else {
+                svc.catalog.Store(old)
+            }
+        }
+    }()
+    headers := map[string]string{}
+    for i := 0; i < 1000; i++ {
+        resp, err := svc.SearchProducts(&pb.SearchProductsRequest{Query: "mug"}, &headers)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(resp.Results) != 1 || (resp.Results[0].Id != "A" && resp.Results[0].Id != "C") {
+            t.Fatalf("search during a swap returned %v", productIDs(resp.Results))
+        }
+    }
+    wg.Wait()
+}
+
+// benchWords is the vocabulary of the synthetic catalog. Its first words are the most frequent.
+var benchWords = strings.Fields(`vintage classic modern leather cotton steel bamboo ceramic glass wooden kitchen
+    garden outdoor travel office home sport summer winter linen wool copper marble velvet canvas denim silk brass
+    walnut oak maple cedar mug jar bottle lamp chair table shelf basket blanket pillow towel scarf hat jacket boots
+    sandals watch sunglasses backpack wallet notebook pen candle vase plate bowl spoon knife kettle teapot`)
+
+// syntheticCatalog returns a catalog of n products with random names, descriptions and categories drawn from
+// benchWords, skewed towards the first words as text is in real catalogs.
+func syntheticCatalog(n int) []*pb.Product {
+    rnd := rand.New(rand.NewSource(int64(n)))
+    word := func() string {
+        return benchWords[int(float64(len(benchWords))*rnd.Float64()*rnd.Float64())]
+    }
+    sentence := func(words int) string {
+        ws := make([]string, words)
+        for i := range ws {
+            ws[i] = word()
+        }
+        return strings.Join(ws, " ")
+    }
+    products := make([]*pb.Product, n)
+    for i := range products {
+        products[i] = &pb.Product{
+            Id:          fmt.Sprintf("P%09d", i),
+            Name:        sentence(3),
+            Description: sentence(20) + ".",
+            Picture:     fmt.Sprintf("/static/img/products/p%d.jpg", i),
+            PriceUsd:    &pb.Money{CurrencyCode: "USD", Units: int64(1 + rnd.Intn(500)), Nanos: 990000000},
+            Categories:  []string{benchWords[rnd.Intn(12)], benchWords[12+rnd.Intn(12)]},
+        }
+    }
+    return products
+}
+
+const benchCatalogSize = 100000
+
+// BenchmarkNewCatalogSnapshot measures how long a reload of a 100k product catalog takes to index.
+func BenchmarkNewCatalogSnapshot(b *testing.B) {
+    products := syntheticCatalog(benchCatalogSize)
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        newCatalogSnapshot(products)
+    }
+}
+
+// BenchmarkCatalogGetProduct measures GetProduct over a 100k product catalog.
+func BenchmarkCatalogGetProduct(b *testing.B) {
+    quietLogs(b)
+    products := syntheticCatalog(benchCatalogSize)
+    useSnapshot(b, newCatalogSnapshot(products))
+    headers := map[string]string{}
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if _, err := svc.GetProduct(&pb.GetProductRequest{Id: products[i%len(products)].Id}, &headers); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+// BenchmarkCatalogSearchProducts measures SearchProducts over a 100k product catalog, for queries that match many,
+// few or no products, by prefix or with a typo. results/op is the number of matches before the limit.
+func BenchmarkCatalogSearchProducts(b *testing.B) {
+    quietLogs(b)
+    useSnapshot(b, newCatalogSnapshot(syntheticCatalog(benchCatalogSize)))
+    headers := map[string]string{}
+    for _, query := range []string{"vintage", "kettle", "kettle teapot", "tea", "ketle", "xylophone"} {
+        req := &pb.SearchProductsRequest{Query: query}
+        _, matches := loaded(b, svc).find(productQuery{text: query, filter: noFilter}, 0, 1)
+        b.Run(strings.ReplaceAll(query, " ", "+"), func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := svc.SearchProducts(req, &headers); err != nil {
+                    b.Fatal(err)
+                }
+            }
+            b.ReportMetric(float64(matches), "results/op")
+        })
+    }
+}
+
+// BenchmarkCatalogListProducts measures pages of ListProducts over a 100k product catalog, with and without filters
+// and sorting.
+func BenchmarkCatalogListProducts(b *testing.B) {
+    quietLogs(b)
+    useSnapshot(b, newCatalogSnapshot(syntheticCatalog(benchCatalogSize)))
+    headers := map[string]string{}
+    for _, bc := range []struct {
+        name string
+        req  *pb.ListProductsRequest
+    }{
+        {"first-page", &pb.ListProductsRequest{}},
+        {"category", &pb.ListProductsRequest{Category: benchWords[0]}},
+        {"price-range", &pb.ListProductsRequest{MinPriceUsd: &pb.Money{Units: 100}, MaxPriceUsd: &pb.Money{Units: 120}}},
+        {"sorted-by-price", &pb.ListProductsRequest{SortOrder: pb.ProductSortOrder_PRODUCT_SORT_ORDER_PRICE_ASCENDING}},
+        {"sorted-by-name", &pb.ListProductsRequest{SortOrder: pb.ProductSortOrder_PRODUCT_SORT_ORDER_NAME}},
+    } {
+        b.Run(bc.name, func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := svc.ListProducts(bc.req, &headers); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
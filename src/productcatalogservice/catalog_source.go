@@ -0,0 +1,190 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sync"
+
+    "github.com/golang/protobuf/jsonpb"
+
+    pb "productcatalogservice/genproto"
+)
+
+// catalogFile is the catalog file that's read unless the configuration selects another source.
+const catalogFile = "products.json"
+
+// CatalogSource is where the products of the catalog are kept: a file, a database table or an object of a bucket. The
+// catalog loads every product at once, on the first request and on every reload; loads never overlap.
+type CatalogSource interface {
+    // Load reads every product of the catalog.
+    Load(ctx context.Context) ([]*pb.Product, error)
+    // String describes the source in logs.
+    String() string
+}
+
+// CatalogStore is a catalog source that the admin RPCs can change.
+type CatalogStore interface {
+    CatalogSource
+    // Update changes the products of some IDs in a single atomic step. It passes the current products of the IDs to
+    // change, nil for the IDs without a product, and replaces them by the products change returns, in the same
+    // order, deleting those it returns nil for. Nothing is written if change fails, and its error is returned as it
+    // is. Update fails with errCatalogConflict if another writer changed the products in the meantime.
+    Update(ctx context.Context, ids []string, change func(current []*pb.Product) ([]*pb.Product, error)) error
+}
+
+// errCatalogConflict is the error of an update that lost a race with another writer; trying again may succeed.
+var errCatalogConflict = errors.New("the catalog changed during the update")
+
+// fileCatalogSource reads the catalog from a JSON file in the format of products.json. Updates rewrite the whole
+// file, one at a time; other processes must not write to it.
+type fileCatalogSource struct {
+    fsys fs.FS
+    name string
+    // path is the path of the file on disk, which the reloader watches, or empty if the file isn't on disk.
+    path string
+
+    // writeMu serializes updates.
+    writeMu sync.Mutex
+}
+
+// newFileCatalogSource returns a source that reads the file at a path.
+func newFileCatalogSource(path string) *fileCatalogSource {
+    return &fileCatalogSource{fsys: os.DirFS(filepath.Dir(path)), name: filepath.Base(path), path: path}
+}
+
+func (s *fileCatalogSource) Load(ctx context.Context) ([]*pb.Product, error) {
+    data, err := fs.ReadFile(s.fsys, s.name)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open the catalog file: %w", err)
+    }
+    return parseCatalogJSON(data)
+}
+
+// Update rewrites the catalog file with the changes: the new catalog is written to a temporary file, which then
+// replaces the catalog file, so that readers never see it half written.
+func (s *fileCatalogSource) Update(ctx context.Context, ids []string, change func([]*pb.Product) ([]*pb.Product, error)) error {
+    if s.path == "" {
+        return errors.New("the catalog file isn't on disk, so it can't be changed")
+    }
+    s.writeMu.Lock()
+    defer s.writeMu.Unlock()
+
+    products, err := s.Load(ctx)
+    if err != nil {
+        return err
+    }
+    products, err = applyProductChange(products, ids, change)
+    if err != nil {
+        return err
+    }
+    data, err := marshalCatalogJSON(products)
+    if err != nil {
+        return err
+    }
+
+    info, err := os.Stat(s.path)
+    if err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+"-*")
+    if err != nil {
+        return fmt.Errorf("failed to write the catalog file: %w", err)
+    }
+    defer os.Remove(tmp.Name())
+    _, err = tmp.Write(data)
+    if err == nil {
+        err = tmp.Chmod(info.Mode().Perm())
+    }
+    if err == nil {
+        err = tmp.Sync()
+    }
+    if closeErr := tmp.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(tmp.Name(), s.path)
+    }
+    if err != nil {
+        return fmt.Errorf("failed to write the catalog file: %w", err)
+    }
+    return nil
+}
+
+func (s *fileCatalogSource) String() string {
+    if s.path != "" {
+        return "file " + s.path
+    }
+    return "file " + s.name
+}
+
+// parseCatalogJSON parses a catalog in the format of products.json: a ListProductsResponse in protobuf's JSON mapping.
+func parseCatalogJSON(data []byte) ([]*pb.Product, error) {
+    var catalog pb.ListProductsResponse
+    if err := jsonpb.Unmarshal(bytes.NewReader(data), &catalog); err != nil {
+        return nil, fmt.Errorf("failed to parse the catalog JSON: %w", err)
+    }
+    return catalog.Products, nil
+}
+
+// marshalCatalogJSON formats a catalog in the format of products.json.
+func marshalCatalogJSON(products []*pb.Product) ([]byte, error) {
+    var buf bytes.Buffer
+    if err := (&jsonpb.Marshaler{Indent: "    "}).Marshal(&buf, &pb.ListProductsResponse{Products: products}); err != nil {
+        return nil, fmt.Errorf("failed to format the catalog JSON: %w", err)
+    }
+    buf.WriteByte('\n')
+    return buf.Bytes(), nil
+}
+
+// applyProductChange applies the change of an update to the whole list of products of a catalog, and returns the new
+// list, in which replaced products keep their positions and new products come last. The list it's given is left as
+// it is.
+func applyProductChange(products []*pb.Product, ids []string, change func([]*pb.Product) ([]*pb.Product, error)) ([]*pb.Product, error) {
+    positions := make(map[string]int, len(ids))
+    for _, id := range ids {
+        positions[id] = -1
+    }
+    for i, product := range products {
+        if pos, ok := positions[product.Id]; ok && pos < 0 {
+            positions[product.Id] = i
+        }
+    }
+    current := make([]*pb.Product, len(ids))
+    for i, id := range ids {
+        if pos := positions[id]; pos >= 0 {
+            current[i] = products[pos]
+        }
+    }
+
+    updated, err := change(current)
+    if err != nil {
+        return nil, err
+    }
+    if len(updated) != len(ids) {
+        return nil, fmt.Errorf("the change of %d products returned %d", len(ids), len(updated))
+    }
+    replaced := make(map[int]*pb.Product, len(ids))
+    var added []*pb.Product
+    for i, id := range ids {
+        if pos := positions[id]; pos >= 0 {
+            replaced[pos] = updated[i]
+        } else if updated[i] != nil {
+            added = append(added, updated[i])
+        }
+    }
+    result := make([]*pb.Product, 0, len(products)+len(added))
+    for i, product := range products {
+        if p, ok := replaced[i]; ok {
+            product = p
+        }
+        if product != nil {
+            result = append(result, product)
+        }
+    }
+    return append(result, added...), nil
+}
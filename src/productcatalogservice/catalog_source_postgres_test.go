@@ -0,0 +1,312 @@
+package main
+
+import (
+    "context"
+    "encoding/binary"
+    "fmt"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgproto3"
+    "google.golang.org/protobuf/proto"
+
+    pb "productcatalogservice/genproto"
+)
+
+// Type OIDs of the columns of a catalog table.
+const (
+    pgTextOID = 25
+    pgInt8OID = 20
+    pgInt4OID = 23
+)
+
+// fakePostgresColumns are the columns of the table of a fake PostgreSQL server, in the order of postgresCatalogColumns,
+// followed by the version.
+var fakePostgresColumns = []struct {
+    name string
+    oid  uint32
+}{
+    {"id", pgTextOID}, {"name", pgTextOID}, {"description", pgTextOID}, {"picture", pgTextOID},
+    {"price_usd_currency_code", pgTextOID}, {"price_usd_units", pgInt8OID}, {"price_usd_nanos", pgInt4OID},
+    {"categories", pgTextOID}, {"version", pgInt8OID},
+}
+
+// fakePostgres is a stand-in for PostgreSQL that speaks enough of its wire protocol for pgx to query a catalog table,
+// with the extended query protocol pgx uses by default. It understands no SQL: any query that selects from its table
+// returns every row, and any other query fails as if the table didn't exist.
+type fakePostgres struct {
+    listener net.Listener
+    table    string
+
+    mu    sync.Mutex
+    rows  [][]any // the values of a row are strings, except for int64 units, int32 nanos and an int64 version
+    conns []net.Conn
+}
+
+// newFakePostgres starts a fake server, which stops when the test ends, and returns its connection string.
+func newFakePostgres(t *testing.T, table string) (*fakePostgres, string) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    f := &fakePostgres{listener: listener, table: table}
+    go f.serve()
+    t.Cleanup(func() {
+        listener.Close()
+        f.mu.Lock()
+        defer f.mu.Unlock()
+        for _, conn := range f.conns {
+            conn.Close()
+        }
+    })
+    return f, fmt.Sprintf("postgres://catalog@%s/catalog?sslmode=disable", listener.Addr())
+}
+
+func (f *fakePostgres) insert(values ...any) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.rows = append(f.rows, values)
+}
+
+func (f *fakePostgres) serve() {
+    for {
+        conn, err := f.listener.Accept()
+        if err != nil {
+            return
+        }
+        f.mu.Lock()
+        f.conns = append(f.conns, conn)
+        f.mu.Unlock()
+        go f.serveConn(conn)
+    }
+}
+
+func (f *fakePostgres) serveConn(conn net.Conn) {
+    defer conn.Close()
+    backend := pgproto3.NewBackend(conn, conn)
+    if _, err := backend.ReceiveStartupMessage(); err != nil {
+        return
+    }
+    backend.Send(&pgproto3.AuthenticationOk{})
+    backend.Send(&pgproto3.ParameterStatus{Name: "server_version", Value: "16.0"})
+    backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+    backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+    if backend.Flush() != nil {
+        return
+    }
+
+    var (
+        formats []int16 // the result format codes of the portal
+        failed  bool    // whether an error skips the messages until the next Sync
+    )
+    for {
+        msg, err := backend.Receive()
+        if err != nil {
+            return
+        }
+        if _, ok := msg.(*pgproto3.Sync); !ok && failed {
+            continue
+        }
+        switch msg := msg.(type) {
+        case *pgproto3.Parse:
+            if !strings.Contains(msg.Query, "FROM "+pgx.Identifier{f.table}.Sanitize()) {
+                backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42P01",
+                    Message: "relation does not exist: " + msg.Query})
+                failed = true
+                continue
+            }
+            backend.Send(&pgproto3.ParseComplete{})
+        case *pgproto3.Describe:
+            if msg.ObjectType == 'S' {
+                backend.Send(&pgproto3.ParameterDescription{})
+                backend.Send(f.rowDescription(nil))
+            } else {
+                backend.Send(f.rowDescription(formats))
+            }
+        case *pgproto3.Bind:
+            formats = msg.ResultFormatCodes
+            backend.Send(&pgproto3.BindComplete{})
+        case *pgproto3.Execute:
+            f.mu.Lock()
+            for _, row := range f.rows {
+                backend.Send(encodeFakePostgresRow(row, formats))
+            }
+            backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT " + strconv.Itoa(len(f.rows)))})
+            f.mu.Unlock()
+        case *pgproto3.Sync:
+            failed = false
+            backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+            if backend.Flush() != nil {
+                return
+            }
+        case *pgproto3.Close:
+            backend.Send(&pgproto3.CloseComplete{})
+        case *pgproto3.Terminate:
+            return
+        default:
+            backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "0A000",
+                Message: fmt.Sprintf("%T isn't supported", msg)})
+        }
+    }
+}
+
+// fakePostgresFormat returns the format of a column from the result format codes of a Bind message.
+func fakePostgresFormat(formats []int16, column int) int16 {
+    switch len(formats) {
+    case 0:
+        return pgproto3.TextFormat
+    case 1:
+        return formats[0]
+    default:
+        return formats[column]
+    }
+}
+
+func (f *fakePostgres) rowDescription(formats []int16) *pgproto3.RowDescription {
+    desc := &pgproto3.RowDescription{}
+    for i, column := range fakePostgresColumns {
+        size := int16(-1)
+        switch column.oid {
+        case pgInt8OID:
+            size = 8
+        case pgInt4OID:
+            size = 4
+        }
+        desc.Fields = append(desc.Fields, pgproto3.FieldDescription{Name: []byte(column.name),
+            DataTypeOID: column.oid, DataTypeSize: size, TypeModifier: -1, Format: fakePostgresFormat(formats, i)})
+    }
+    return desc
+}
+
+func encodeFakePostgresRow(row []any, formats []int16) *pgproto3.DataRow {
+    values := make([][]byte, len(row))
+    for i, v := range row {
+        inBinary := fakePostgresFormat(formats, i) == pgproto3.BinaryFormat
+        switch v := v.(type) {
+        case string:
+            values[i] = []byte(v)
+        case int64:
+            if inBinary {
+                values[i] = binary.BigEndian.AppendUint64(nil, uint64(v))
+            } else {
+                values[i] = []byte(strconv.FormatInt(v, 10))
+            }
+        case int32:
+            if inBinary {
+                values[i] = binary.BigEndian.AppendUint32(nil, uint32(v))
+            } else {
+                values[i] = []byte(strconv.FormatInt(int64(v), 10))
+            }
+        }
+    }
+    return &pgproto3.DataRow{Values: values}
+}
+
+// newTestPostgresCatalog returns the connection string and the table of a catalog in a fake PostgreSQL server or, if
+// CATALOG_TEST_POSTGRES_DSN is set, in a new table of that database, and a function that inserts a product in it.
+func newTestPostgresCatalog(t *testing.T) (string, string, func(values ...any)) {
+    t.Helper()
+    dsn := os.Getenv("CATALOG_TEST_POSTGRES_DSN")
+    if dsn == "" {
+        fake, dsn := newFakePostgres(t, defaultCatalogTable)
+        return dsn, defaultCatalogTable, fake.insert
+    }
+
+    ctx := context.Background()
+    conn, err := pgx.Connect(ctx, dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    table := fmt.Sprintf("catalog_test_%d", time.Now().UnixNano())
+    _, err = conn.Exec(ctx, `CREATE TABLE `+table+` (
+        id                      TEXT PRIMARY KEY,
+        name                    TEXT NOT NULL,
+        description             TEXT NOT NULL,
+        picture                 TEXT NOT NULL,
+        price_usd_currency_code TEXT NOT NULL,
+        price_usd_units         BIGINT NOT NULL,
+        price_usd_nanos         INTEGER NOT NULL,
+        categories              TEXT NOT NULL,
+        version                 BIGINT NOT NULL DEFAULT 0
+    )`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        conn.Exec(ctx, "DROP TABLE "+table)
+        conn.Close(ctx)
+    })
+    return dsn, table, func(values ...any) {
+        if _, err := conn.Exec(ctx, "INSERT INTO "+table+" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)", values...); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestPostgresCatalogSource(t *testing.T) {
+    dsn, table, insert := newTestPostgresCatalog(t)
+    insert("OLJCESPC7Z", "Sunglasses", "Add a modern touch to your outfits.", "/static/img/products/sunglasses.jpg",
+        "USD", int64(19), int32(990000000), "Accessories", int64(0))
+    insert("9SIQT8TOJO", "Bamboo Glass Jar", "This bamboo glass jar can hold 57 oz.", "/static/img/products/bamboo-glass-jar.jpg",
+        "USD", int64(5), int32(490000000), "Kitchen,Decor", int64(3))
+
+    source, err := newPostgresCatalogSource(dsn, table)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(source.Close)
+    ctx := context.Background()
+    products, err := source.Load(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := &pb.Product{Id: "9SIQT8TOJO", Name: "Bamboo Glass Jar", Description: "This bamboo glass jar can hold 57 oz.",
+        Picture: "/static/img/products/bamboo-glass-jar.jpg", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 5, Nanos: 490000000},
+        Categories: []string{"kitchen", "decor"}, Version: 3}
+    if len(products) != 2 || !proto.Equal(products[1], want) {
+        t.Errorf("Load() = %v, want the 2 products of the table, the last one %v", products, want)
+    }
+
+    // Reloads see the changes of the table, on the connections of the first load.
+    insert("LS4PSXUNUM", "Salt & Pepper Shakers", "Add some flavor to your kitchen.", "/static/img/products/salt-and-pepper-shakers.jpg",
+        "USD", int64(18), int32(490000000), "kitchen", int64(0))
+    if products, err := source.Load(ctx); err != nil || len(products) != 3 {
+        t.Errorf("Load() after an insert = %v, %v, want 3 products", productIDs(products), err)
+    }
+    if fake := os.Getenv("CATALOG_TEST_POSTGRES_DSN") == ""; fake && source.pool.Stat().TotalConns() != 1 {
+        t.Errorf("the source has %d connections, want 1", source.pool.Stat().TotalConns())
+    }
+
+    missing, err := newPostgresCatalogSource(dsn, table+"_missing")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(missing.Close)
+    if _, err := missing.Load(ctx); err == nil {
+        t.Error("Load() of a missing table succeeded")
+    }
+}
+
+// TestPostgresCatalogStore runs against the PostgreSQL database of CATALOG_TEST_POSTGRES_DSN, if it's set: the fake
+// server only answers the queries of loads.
+func TestPostgresCatalogStore(t *testing.T) {
+    if os.Getenv("CATALOG_TEST_POSTGRES_DSN") == "" {
+        t.Skip("CATALOG_TEST_POSTGRES_DSN not set")
+    }
+    dsn, table, insert := newTestPostgresCatalog(t)
+    for _, product := range loadTestCatalog(t) {
+        insert(postgresProductValues(product)...)
+    }
+    source, err := newPostgresCatalogSource(dsn, table)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(source.Close)
+    testCatalogStore(t, source)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "sync"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+    "github.com/aws/aws-sdk-go-v2/config"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+
+    pb "productcatalogservice/genproto"
+)
+
+// s3CatalogSource reads the catalog from an object of an S3 bucket, or of a service compatible with S3 such as MinIO,
+// in the JSON format of products.json. A reload only downloads the object again if its ETag changed. Updates rewrite
+// the whole object, on the condition that it didn't change since it was read, so that writers never overwrite each
+// other's changes.
+type s3CatalogSource struct {
+    client *s3.Client
+    bucket string
+    key    string
+
+    mu sync.Mutex
+    // etag is the ETag of the object of the last load, and products its products.
+    etag     string
+    products []*pb.Product
+}
+
+// newS3CatalogSource returns a source that reads an object of a bucket, with the AWS configuration of the environment
+// (e.g. the role of a Lambda function). An endpoint, such as the one of MinIO, replaces the regional endpoint of S3 if
+// it isn't empty; the bucket is then addressed in the path of the requests rather than in the host name.
+func newS3CatalogSource(bucket, key, endpoint string) (*s3CatalogSource, error) {
+    cfg, err := config.LoadDefaultConfig(context.Background())
+    if err != nil {
+        return nil, fmt.Errorf("failed to load the AWS configuration: %w", err)
+    }
+    client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+        if endpoint != "" {
+            o.BaseEndpoint = aws.String(endpoint)
+            o.UsePathStyle = true
+        }
+    })
+    return &s3CatalogSource{client: client, bucket: bucket, key: key}, nil
+}
+
+func (s *s3CatalogSource) Load(ctx context.Context) ([]*pb.Product, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.loadLocked(ctx)
+}
+
+func (s *s3CatalogSource) loadLocked(ctx context.Context) ([]*pb.Product, error) {
+    input := &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)}
+    if s.etag != "" {
+        input.IfNoneMatch = aws.String(s.etag)
+    }
+    out, err := s.client.GetObject(ctx, input)
+    var respErr *awshttp.ResponseError
+    if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+        return s.products, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to get the catalog object: %w", err)
+    }
+    defer out.Body.Close()
+
+    data, err := io.ReadAll(out.Body)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read the catalog object: %w", err)
+    }
+    products, err := parseCatalogJSON(data)
+    if err != nil {
+        return nil, err
+    }
+    s.etag, s.products = aws.ToString(out.ETag), products
+    return products, nil
+}
+
+func (s *s3CatalogSource) Update(ctx context.Context, ids []string, change func([]*pb.Product) ([]*pb.Product, error)) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    products, err := s.loadLocked(ctx)
+    if err != nil {
+        return err
+    }
+    products, err = applyProductChange(products, ids, change)
+    if err != nil {
+        return err
+    }
+    data, err := marshalCatalogJSON(products)
+    if err != nil {
+        return err
+    }
+    out, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+        Bucket:      aws.String(s.bucket),
+        Key:         aws.String(s.key),
+        Body:        bytes.NewReader(data),
+        ContentType: aws.String("application/json"),
+        IfMatch:     aws.String(s.etag),
+    })
+    var respErr *awshttp.ResponseError
+    if errors.As(err, &respErr) && (respErr.HTTPStatusCode() == http.StatusPreconditionFailed ||
+        respErr.HTTPStatusCode() == http.StatusConflict) {
+        // The object changed since it was read, or another write of it is in progress.
+        return errCatalogConflict
+    }
+    if err != nil {
+        return fmt.Errorf("failed to put the catalog object: %w", err)
+    }
+    s.etag, s.products = aws.ToString(out.ETag), products
+    return nil
+}
+
+func (s *s3CatalogSource) String() string {
+    return "s3://" + s.bucket + "/" + s.key
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/md5"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+
+    pb "productcatalogservice/genproto"
+)
+
+// fakeS3 is a stand-in for S3 that serves the objects of a bucket to path-style GetObject and PutObject requests, with
+// ETags and conditional requests.
+type fakeS3 struct {
+    mu        sync.Mutex
+    bucket    string
+    objects   map[string][]byte
+    downloads int // the number of objects served whole
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.objects[key] = data
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+    data, ok := f.objects[key]
+    if r.Method == http.MethodPut && bucket == f.bucket {
+        if match := r.Header.Get("If-Match"); match != "" && (!ok || match != fakeETag(data)) {
+            w.Header().Set("Content-Type", "application/xml")
+            w.WriteHeader(http.StatusPreconditionFailed)
+            fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+            return
+        }
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        f.objects[key] = body
+        w.Header().Set("ETag", fakeETag(body))
+        return
+    }
+    if r.Method != http.MethodGet || bucket != f.bucket || !ok {
+        w.Header().Set("Content-Type", "application/xml")
+        w.WriteHeader(http.StatusNotFound)
+        fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+        return
+    }
+    etag := fakeETag(data)
+    w.Header().Set("ETag", etag)
+    if r.Header.Get("If-None-Match") == etag {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+    f.downloads++
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(data)
+}
+
+// fakeETag returns the ETag of an object, which is the MD5 of its content for the objects S3 stores in one part.
+func fakeETag(data []byte) string {
+    sum := md5.Sum(data)
+    return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// newTestS3CatalogSource returns a source on an object of a fake S3 server or, if CATALOG_TEST_S3_ENDPOINT is set, of a
+// new bucket of that endpoint (e.g. MinIO), and a function that writes the object. The fake is nil in the latter case.
+func newTestS3CatalogSource(t *testing.T) (*s3CatalogSource, *fakeS3, func(data []byte)) {
+    t.Helper()
+    for key, value := range map[string]string{
+        "AWS_ACCESS_KEY_ID":           "test",
+        "AWS_SECRET_ACCESS_KEY":       "test",
+        "AWS_REGION":                  "us-east-1",
+        "AWS_CONFIG_FILE":             os.DevNull,
+        "AWS_SHARED_CREDENTIALS_FILE": os.DevNull,
+        "AWS_EC2_METADATA_DISABLED":   "true",
+    } {
+        t.Setenv(key, value)
+    }
+
+    bucket, key := "catalog", catalogFile
+    endpoint := os.Getenv("CATALOG_TEST_S3_ENDPOINT")
+    var fake *fakeS3
+    if endpoint == "" {
+        fake = &fakeS3{bucket: bucket, objects: make(map[string][]byte)}
+        server := httptest.NewServer(fake)
+        t.Cleanup(server.Close)
+        endpoint = server.URL
+    } else {
+        bucket = fmt.Sprintf("catalog-test-%d", time.Now().UnixNano())
+    }
+
+    source, err := newS3CatalogSource(bucket, key, endpoint)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fake != nil {
+        return source, fake, func(data []byte) { fake.put(key, data) }
+    }
+
+    ctx := context.Background()
+    if _, err := source.client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        source.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+        source.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucket)})
+    })
+    return source, nil, func(data []byte) {
+        _, err := source.client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key),
+            Body: bytes.NewReader(data)})
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+func TestS3CatalogSource(t *testing.T) {
+    source, fake, put := newTestS3CatalogSource(t)
+    ctx := context.Background()
+    if _, err := source.Load(ctx); err == nil {
+        t.Error("Load() of a missing object succeeded")
+    }
+
+    catalog, err := os.ReadFile(catalogFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    put(catalog)
+    products, err := source.Load(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(products) != 9 || products[0].Id != "OLJCESPC7Z" {
+        t.Errorf("Load() = %v, want the 9 products of products.json", productIDs(products))
+    }
+
+    // A reload of the same object doesn't download it again.
+    if again, err := source.Load(ctx); err != nil || len(again) != 9 {
+        t.Errorf("Load() of the same object = %v, %v, want the 9 products", productIDs(again), err)
+    }
+    if fake != nil && fake.downloads != 1 {
+        t.Errorf("the object was downloaded %d times, want once", fake.downloads)
+    }
+
+    put([]byte(`{"products": [{"id": "A", "name": "Mug"}]}`))
+    if products, err := source.Load(ctx); err != nil || len(products) != 1 || products[0].Id != "A" {
+        t.Errorf("Load() of a new object = %v, %v, want product A", productIDs(products), err)
+    }
+    put([]byte(`not json`))
+    if _, err := source.Load(ctx); err == nil {
+        t.Error("Load() of an invalid object succeeded")
+    }
+}
+
+func TestS3CatalogStore(t *testing.T) {
+    source, _, put := newTestS3CatalogSource(t)
+    catalog, err := os.ReadFile(catalogFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    put(catalog)
+    testCatalogStore(t, source)
+
+    // An update fails rather than overwrites the object if another writer changed it since it was read.
+    err = source.Update(context.Background(), []string{"OLJCESPC7Z"}, func(current []*pb.Product) ([]*pb.Product, error) {
+        put(catalog)
+        return current, nil
+    })
+    if err != errCatalogConflict {
+        t.Errorf("Update() of an object changed in the meantime returned %v, want errCatalogConflict", err)
+    }
+    if products, err := source.Load(context.Background()); err != nil || len(products) != 9 || products[0].Version != 0 {
+        t.Errorf("Load() after a conflict = %v, %v, want the products of the other writer", productIDs(products), err)
+    }
+}
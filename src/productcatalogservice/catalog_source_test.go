@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "embed"
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "testing"
+    "testing/fstest"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+
+    pb "productcatalogservice/genproto"
+)
+
+//go:embed products.json
+var embeddedCatalog embed.FS
+
+func TestFileCatalogSource(t *testing.T) {
+    ctx := context.Background()
+    products, err := (&fileCatalogSource{fsys: embeddedCatalog, name: catalogFile}).Load(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(products) != 9 || products[0].Id != "OLJCESPC7Z" || products[0].PriceUsd.GetUnits() != 19 {
+        t.Errorf("Load() = %v, want the 9 products of products.json", productIDs(products))
+    }
+
+    files := fstest.MapFS{"broken.json": {Data: []byte(`{"products": [{"id": 1}]}`)}}
+    if _, err := (&fileCatalogSource{fsys: files, name: "broken.json"}).Load(ctx); err == nil {
+        t.Error("Load() of an invalid catalog succeeded")
+    }
+    if _, err := (&fileCatalogSource{fsys: files, name: "missing.json"}).Load(ctx); !errors.Is(err, fs.ErrNotExist) {
+        t.Errorf("Load() of a missing file returned %v, want fs.ErrNotExist", err)
+    }
+}
+
+// testCatalogStore checks the updates of a store that holds the products of products.json.
+func testCatalogStore(t *testing.T, store CatalogStore) {
+    t.Helper()
+    ctx := context.Background()
+    before, err := store.Load(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Replace a product, add one and delete one.
+    ids := []string{"OLJCESPC7Z", "NEWPRODUCT", "66VCHSJNUP"}
+    err = store.Update(ctx, ids, func(current []*pb.Product) ([]*pb.Product, error) {
+        if current[0].GetName() != "Sunglasses" || current[1] != nil || current[2].GetName() != "Tank Top" {
+            t.Errorf("Update() passed %v, want the sunglasses, nil and the tank top", current)
+        }
+        sunglasses := proto.Clone(current[0]).(*pb.Product)
+        sunglasses.PriceUsd, sunglasses.Version = &pb.Money{CurrencyCode: "USD", Units: 24, Nanos: 990000000}, 1
+        added := &pb.Product{Id: "NEWPRODUCT", Name: "Teapot", PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 30},
+            Categories: []string{"kitchen"}, Version: 1}
+        return []*pb.Product{sunglasses, added, nil}, nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    after, err := store.Load(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    byID := make(map[string]*pb.Product)
+    for _, product := range after {
+        byID[product.Id] = product
+    }
+    if len(after) != len(before) || byID["66VCHSJNUP"] != nil {
+        t.Errorf("Load() after the update = %v, want the tank top replaced by the teapot", productIDs(after))
+    }
+    if p := byID["OLJCESPC7Z"]; p.GetPriceUsd().GetUnits() != 24 || p.GetVersion() != 1 || p.GetName() != "Sunglasses" {
+        t.Errorf("updated sunglasses = %v, want version 1 at $24.99", p)
+    }
+    if p := byID["NEWPRODUCT"]; p.GetName() != "Teapot" || p.GetVersion() != 1 || len(p.GetCategories()) != 1 {
+        t.Errorf("added product = %v, want the teapot", p)
+    }
+
+    // A change that fails writes nothing.
+    failure := status.Error(codes.Aborted, "version mismatch")
+    err = store.Update(ctx, []string{"OLJCESPC7Z"}, func([]*pb.Product) ([]*pb.Product, error) {
+        return nil, failure
+    })
+    if err != failure {
+        t.Errorf("Update() with a failing change returned %v, want the change's error", err)
+    }
+    if again, err := store.Load(ctx); err != nil || len(again) != len(after) {
+        t.Errorf("Load() after a failed update = %v, %v", productIDs(again), err)
+    }
+}
+
+func TestFileCatalogStore(t *testing.T) {
+    data, err := os.ReadFile(catalogFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(t.TempDir(), catalogFile)
+    if err := os.WriteFile(path, data, 0o640); err != nil {
+        t.Fatal(err)
+    }
+    testCatalogStore(t, newFileCatalogSource(path))
+
+    if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+        t.Errorf("the rewritten file has mode %v, %v, want 0640", info.Mode(), err)
+    }
+    if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+        t.Errorf("the directory of the catalog has %d files, want only the catalog", len(entries))
+    }
+    embedded := &fileCatalogSource{fsys: embeddedCatalog, name: catalogFile}
+    if err := embedded.Update(context.Background(), nil, nil); err == nil {
+        t.Error("Update() of an embedded file succeeded")
+    }
+}
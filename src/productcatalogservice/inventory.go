@@ -0,0 +1,338 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "productcatalogservice/genproto"
+)
+
+const (
+    // defaultReservationTTL is how long a reservation holds its items if its request doesn't say.
+    defaultReservationTTL = 10 * time.Minute
+    // maxReservationTTL is the longest a reservation may hold its items.
+    maxReservationTTL = time.Hour
+    // stockTimeout is how long a call to the stock store may take before it's abandoned.
+    stockTimeout = 10 * time.Second
+)
+
+// StockStore keeps the stock of products and the reservations of their items. Products it has no stock for are never
+// out of stock. A store kept in a database or a bucket is shared by every instance of the service, so that an instance
+// can commit or release the reservation of another.
+type StockStore interface {
+    // Levels returns the stock levels of products, in the order of their IDs.
+    Levels(ctx context.Context, ids []string) ([]*pb.StockLevel, error)
+    // Reserve holds items for ttl, all of them or none, and returns the ID of the reservation and when it expires. It
+    // fails with FailedPrecondition if too few items of a product are available.
+    Reserve(ctx context.Context, items []*pb.CartItem, ttl time.Duration) (string, time.Time, error)
+    // Commit takes the items of a reservation out of stock. It fails with NotFound if there's no such reservation, or
+    // if it expired.
+    Commit(ctx context.Context, id string) error
+    // Release returns the items of a reservation to the stock. Releasing a reservation that doesn't exist, such as one
+    // that expired, does nothing.
+    Release(ctx context.Context, id string) error
+}
+
+// reservedQuantities sums the quantities of items by product, since several items may be of the same product, and
+// returns the IDs of the products in order, so that stores lock their stock in the same order. The sums may not fit
+// in an int32.
+func reservedQuantities(items []*pb.CartItem) (map[string]int64, []string, error) {
+    wanted := make(map[string]int64)
+    for _, item := range items {
+        if item.Quantity <= 0 {
+            return nil, nil, status.Errorf(codes.InvalidArgument, "quantity must be positive, not %d", item.Quantity)
+        }
+        wanted[item.ProductId] += int64(item.Quantity)
+    }
+    ids := make([]string, 0, len(wanted))
+    for id := range wanted {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    return wanted, ids, nil
+}
+
+// untrackedStock is the stock store of a catalog that counts the stock of no product. Its reservations hold nothing,
+// so it keeps none, and commits and releases always succeed, on any instance.
+type untrackedStock struct{}
+
+func (untrackedStock) Levels(ctx context.Context, ids []string) ([]*pb.StockLevel, error) {
+    levels := make([]*pb.StockLevel, len(ids))
+    for i, id := range ids {
+        levels[i] = &pb.StockLevel{ProductId: id}
+    }
+    return levels, nil
+}
+
+func (untrackedStock) Reserve(ctx context.Context, items []*pb.CartItem, ttl time.Duration) (string, time.Time, error) {
+    return uuid.NewString(), time.Now().Add(ttl), nil
+}
+
+func (untrackedStock) Commit(ctx context.Context, id string) error {
+    return nil
+}
+
+func (untrackedStock) Release(ctx context.Context, id string) error {
+    return nil
+}
+
+// inventory is a stock store kept in memory, from stock levels read at startup. Every instance of the service thus
+// counts the stock on its own.
+type inventory struct {
+    // now returns the current time; nil uses time.Now.
+    now func() time.Time
+
+    mu sync.Mutex
+    // onHand is the number of items in stock of every product whose stock is counted, reserved or not.
+    onHand map[string]int32
+    // reserved is the number of items of every product that reservations hold.
+    reserved     map[string]int32
+    reservations map[string]*reservation
+}
+
+// reservation holds items until it's committed, released or expired.
+type reservation struct {
+    items   []*pb.CartItem
+    expires time.Time
+}
+
+// newInventory returns an inventory with the stock levels of products, by their ID.
+func newInventory(stock map[string]int32) *inventory {
+    onHand := make(map[string]int32, len(stock))
+    for id, count := range stock {
+        onHand[id] = count
+    }
+    return &inventory{onHand: onHand, reserved: make(map[string]int32), reservations: make(map[string]*reservation)}
+}
+
+// loadInventory reads the stock levels of products from a JSON object that maps their IDs to their number of items.
+func loadInventory(path string) (*inventory, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    stock, err := parseStockLevels(data)
+    if err != nil {
+        return nil, err
+    }
+    return newInventory(stock), nil
+}
+
+// parseStockLevels parses a JSON object that maps the IDs of products to their number of items.
+func parseStockLevels(data []byte) (map[string]int32, error) {
+    var stock map[string]int32
+    if err := json.Unmarshal(data, &stock); err != nil {
+        return nil, fmt.Errorf("failed to parse the inventory: %w", err)
+    }
+    for id, count := range stock {
+        if count < 0 {
+            return nil, fmt.Errorf("product %s has a negative stock (%d)", id, count)
+        }
+    }
+    return stock, nil
+}
+
+func (inv *inventory) clock() time.Time {
+    if inv.now != nil {
+        return inv.now()
+    }
+    return time.Now()
+}
+
+func (inv *inventory) Levels(ctx context.Context, ids []string) ([]*pb.StockLevel, error) {
+    inv.mu.Lock()
+    defer inv.mu.Unlock()
+    inv.expireLocked()
+    levels := make([]*pb.StockLevel, len(ids))
+    for i, id := range ids {
+        onHand, tracked := inv.onHand[id]
+        if !tracked {
+            levels[i] = &pb.StockLevel{ProductId: id}
+            continue
+        }
+        reserved := inv.reserved[id]
+        levels[i] = &pb.StockLevel{ProductId: id, Tracked: true, Available: onHand - reserved, Reserved: reserved}
+    }
+    return levels, nil
+}
+
+func (inv *inventory) Reserve(ctx context.Context, items []*pb.CartItem, ttl time.Duration) (string, time.Time, error) {
+    inv.mu.Lock()
+    defer inv.mu.Unlock()
+    inv.expireLocked()
+
+    wanted, ids, err := reservedQuantities(items)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    for _, id := range ids {
+        onHand, tracked := inv.onHand[id]
+        if available := onHand - inv.reserved[id]; tracked && wanted[id] > int64(available) {
+            return "", time.Time{}, status.Errorf(codes.FailedPrecondition,
+                "only %d items of product %s are available, not %d", available, id, wanted[id])
+        }
+    }
+
+    for id, quantity := range wanted {
+        if _, tracked := inv.onHand[id]; tracked {
+            // The quantity is at most what's available, so it fits in an int32.
+            inv.reserved[id] += int32(quantity)
+        }
+    }
+    id := uuid.NewString()
+    r := &reservation{items: items, expires: inv.clock().Add(ttl)}
+    inv.reservations[id] = r
+    return id, r.expires, nil
+}
+
+func (inv *inventory) Commit(ctx context.Context, id string) error {
+    inv.mu.Lock()
+    defer inv.mu.Unlock()
+    inv.expireLocked()
+    r, ok := inv.reservations[id]
+    if !ok {
+        return status.Errorf(codes.NotFound, "no reservation %s, or it expired", id)
+    }
+    inv.removeLocked(id, r)
+    for _, item := range r.items {
+        if _, tracked := inv.onHand[item.ProductId]; tracked {
+            inv.onHand[item.ProductId] -= item.Quantity
+        }
+    }
+    return nil
+}
+
+func (inv *inventory) Release(ctx context.Context, id string) error {
+    inv.mu.Lock()
+    defer inv.mu.Unlock()
+    if r, ok := inv.reservations[id]; ok {
+        inv.removeLocked(id, r)
+    }
+    return nil
+}
+
+// expireLocked releases the reservations that expired.
+func (inv *inventory) expireLocked() {
+    now := inv.clock()
+    for id, r := range inv.reservations {
+        if !now.Before(r.expires) {
+            inv.removeLocked(id, r)
+        }
+    }
+}
+
+// removeLocked drops a reservation and the hold of its items.
+func (inv *inventory) removeLocked(id string, r *reservation) {
+    delete(inv.reservations, id)
+    for _, item := range r.items {
+        if _, tracked := inv.onHand[item.ProductId]; !tracked {
+            continue
+        }
+        if inv.reserved[item.ProductId] -= item.Quantity; inv.reserved[item.ProductId] <= 0 {
+            delete(inv.reserved, item.ProductId)
+        }
+    }
+}
+
+// stockError returns the error of a call to the stock store as a status: the errors of the backend are Unavailable.
+func stockError(err error, action string) error {
+    if status.Code(err) == codes.Unknown {
+        return status.Errorf(codes.Unavailable, "failed to %s: %v", action, err)
+    }
+    return err
+}
+
+// stock returns the stock store of the catalog.
+func (p *productCatalog) stock() StockStore {
+    if p.stockStore == nil {
+        return untrackedStock{}
+    }
+    return p.stockStore
+}
+
+// GetStock returns the stock levels of products of the catalog.
+func (p *productCatalog) GetStock(req *pb.GetStockRequest, headers *map[string]string) (*pb.GetStockResponse, error) {
+    snapshot, err := p.snapshot()
+    if err != nil {
+        return nil, err
+    }
+    for _, id := range req.ProductIds {
+        if snapshot.product(id) == nil {
+            return nil, status.Errorf(codes.NotFound, "no product with ID %s", id)
+        }
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), stockTimeout)
+    defer cancel()
+    levels, err := p.stock().Levels(ctx, req.ProductIds)
+    if err != nil {
+        return nil, stockError(err, "read the stock")
+    }
+    return &pb.GetStockResponse{Stock: levels}, nil
+}
+
+// ReserveStock holds the items of an order, all of them or none, until the reservation is committed or released, or
+// it expires.
+func (p *productCatalog) ReserveStock(req *pb.ReserveStockRequest, headers *map[string]string) (*pb.Reservation, error) {
+    if len(req.Items) == 0 {
+        return nil, status.Error(codes.InvalidArgument, "at least one item is required")
+    }
+    snapshot, err := p.snapshot()
+    if err != nil {
+        return nil, err
+    }
+    items := make([]*pb.CartItem, len(req.Items))
+    for i, item := range req.Items {
+        if item.GetQuantity() <= 0 {
+            return nil, status.Errorf(codes.InvalidArgument, "items[%d]: quantity must be positive, not %d", i, item.GetQuantity())
+        }
+        if snapshot.product(item.ProductId) == nil {
+            return nil, status.Errorf(codes.NotFound, "items[%d]: no product with ID %s", i, item.ProductId)
+        }
+        items[i] = &pb.CartItem{ProductId: item.ProductId, Quantity: item.Quantity}
+    }
+    ttl := time.Duration(req.TtlSeconds) * time.Second
+    if ttl <= 0 {
+        ttl = defaultReservationTTL
+    }
+    ttl = min(ttl, maxReservationTTL)
+
+    ctx, cancel := context.WithTimeout(context.Background(), stockTimeout)
+    defer cancel()
+    id, expires, err := p.stock().Reserve(ctx, items, ttl)
+    if err != nil {
+        return nil, stockError(err, "reserve the stock")
+    }
+    requestLogger(headers).Infof("reserved %d items until %v as %s", len(items), expires.Format(time.RFC3339), id)
+    return &pb.Reservation{ReservationId: id, Items: items, ExpireTime: expires.Unix()}, nil
+}
+
+// CommitReservation takes the items of a reservation out of stock, once the order that reserved them is placed.
+func (p *productCatalog) CommitReservation(req *pb.CommitReservationRequest, headers *map[string]string) (*pb.Empty, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), stockTimeout)
+    defer cancel()
+    if err := p.stock().Commit(ctx, req.ReservationId); err != nil {
+        return nil, stockError(err, "commit the reservation")
+    }
+    requestLogger(headers).Infof("committed reservation %s", req.ReservationId)
+    return &pb.Empty{}, nil
+}
+
+// ReleaseReservation returns the items of a reservation to the stock, when the order that reserved them fails.
+func (p *productCatalog) ReleaseReservation(req *pb.ReleaseReservationRequest, headers *map[string]string) (*pb.Empty, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), stockTimeout)
+    defer cancel()
+    if err := p.stock().Release(ctx, req.ReservationId); err != nil {
+        return nil, stockError(err, "release the reservation")
+    }
+    requestLogger(headers).Infof("released reservation %s", req.ReservationId)
+    return &pb.Empty{}, nil
+}
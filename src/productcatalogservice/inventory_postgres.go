@@ -0,0 +1,248 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgconn"
+    "github.com/jackc/pgx/v5/pgxpool"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "productcatalogservice/genproto"
+)
+
+// Codes of the PostgreSQL errors of transactions that lost a race with another one.
+const (
+    pgSerializationFailure = "40001"
+    pgDeadlockDetected     = "40P01"
+)
+
+// postgresStockStore keeps the stock in the database of a PostgreSQL catalog source, on the connections of the source:
+// a stock table holds the number of items available and reserved of every product whose stock is counted, and a
+// reservations table the quantity of every product of a reservation. A reservation takes its items from the
+// available ones with a conditional decrement, so that concurrent reservations, from any instance, never hold more
+// items than there are. Every instance sees the same counts, and can commit or release the reservations of the others.
+type postgresStockStore struct {
+    source *postgresCatalogSource
+    // table is the name of the stock table, which may be qualified by its schema. The reservations table has the same
+    // name followed by _reservations.
+    table string
+}
+
+// tables returns the names of the stock and reservations tables, quoted for SQL.
+func (s *postgresStockStore) tables() (string, string) {
+    parts := strings.Split(s.table, ".")
+    reservations := append([]string(nil), parts...)
+    reservations[len(reservations)-1] += "_reservations"
+    return pgx.Identifier(parts).Sanitize(), pgx.Identifier(reservations).Sanitize()
+}
+
+// expire returns the items of the expired reservations to the available ones, in a single statement, so that a
+// reservation is never returned twice.
+func (s *postgresStockStore) expire(ctx context.Context, pool *pgxpool.Pool) error {
+    stock, reservations := s.tables()
+    _, err := pool.Exec(ctx, `WITH expired AS (
+            DELETE FROM `+reservations+` WHERE expires_at <= now() RETURNING product_id, quantity
+        ), returned AS (
+            SELECT product_id, SUM(quantity)::bigint AS quantity FROM expired GROUP BY product_id
+        )
+        UPDATE `+stock+` AS stock SET available = stock.available + returned.quantity, reserved = stock.reserved - returned.quantity
+        FROM returned WHERE stock.product_id = returned.product_id`)
+    if err != nil {
+        return postgresStockError("expire the reservations", err)
+    }
+    return nil
+}
+
+func (s *postgresStockStore) Levels(ctx context.Context, ids []string) ([]*pb.StockLevel, error) {
+    pool, err := s.source.connect(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.expire(ctx, pool); err != nil {
+        return nil, err
+    }
+    stock, _ := s.tables()
+    rows, err := pool.Query(ctx, "SELECT product_id, available, reserved FROM "+stock+" WHERE product_id = ANY($1)", ids)
+    if err != nil {
+        return nil, postgresStockError("query the stock", err)
+    }
+    defer rows.Close()
+    byID := make(map[string]*pb.StockLevel, len(ids))
+    for rows.Next() {
+        level := &pb.StockLevel{Tracked: true}
+        if err := rows.Scan(&level.ProductId, &level.Available, &level.Reserved); err != nil {
+            return nil, fmt.Errorf("failed to scan query result row: %w", err)
+        }
+        byID[level.ProductId] = level
+    }
+    if err := rows.Err(); err != nil {
+        return nil, postgresStockError("query the stock", err)
+    }
+
+    levels := make([]*pb.StockLevel, len(ids))
+    for i, id := range ids {
+        if levels[i] = byID[id]; levels[i] == nil {
+            levels[i] = &pb.StockLevel{ProductId: id}
+        }
+    }
+    return levels, nil
+}
+
+// Reserve takes the items from the available ones of their products, in the order of their IDs so that concurrent
+// reservations don't deadlock, and records the reservation, in a transaction. The expiry is set by the clock of the
+// database, which every instance shares.
+func (s *postgresStockStore) Reserve(ctx context.Context, items []*pb.CartItem, ttl time.Duration) (string, time.Time, error) {
+    wanted, ids, err := reservedQuantities(items)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    pool, err := s.source.connect(ctx)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    if err := s.expire(ctx, pool); err != nil {
+        return "", time.Time{}, err
+    }
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return "", time.Time{}, fmt.Errorf("failed to begin the transaction: %w", err)
+    }
+    defer tx.Rollback(ctx)
+
+    stock, reservations := s.tables()
+    for _, id := range ids {
+        tag, err := tx.Exec(ctx, "UPDATE "+stock+" SET available = available - $2::bigint, reserved = reserved + $2::bigint "+
+            "WHERE product_id = $1 AND available >= $2::bigint", id, wanted[id])
+        if err != nil {
+            return "", time.Time{}, postgresStockError("reserve the stock", err)
+        }
+        if tag.RowsAffected() > 0 {
+            continue
+        }
+        // Either the stock of the product isn't counted, or too few of its items are available.
+        var available int32
+        err = tx.QueryRow(ctx, "SELECT available FROM "+stock+" WHERE product_id = $1", id).Scan(&available)
+        if errors.Is(err, pgx.ErrNoRows) {
+            continue
+        }
+        if err != nil {
+            return "", time.Time{}, postgresStockError("query the stock", err)
+        }
+        return "", time.Time{}, status.Errorf(codes.FailedPrecondition,
+            "only %d items of product %s are available, not %d", available, id, wanted[id])
+    }
+
+    var expires time.Time
+    if err := tx.QueryRow(ctx, "SELECT now() + make_interval(secs => $1)", ttl.Seconds()).Scan(&expires); err != nil {
+        return "", time.Time{}, postgresStockError("reserve the stock", err)
+    }
+    // The products whose stock isn't counted are recorded too, so that the reservation exists even if it holds none.
+    reservationID := uuid.NewString()
+    batch := &pgx.Batch{}
+    for _, id := range ids {
+        batch.Queue("INSERT INTO "+reservations+" (id, product_id, quantity, expires_at) VALUES ($1, $2, $3, $4)",
+            reservationID, id, wanted[id], expires)
+    }
+    if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+        return "", time.Time{}, postgresStockError("record the reservation", err)
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return "", time.Time{}, postgresStockError("commit the transaction", err)
+    }
+    return reservationID, expires, nil
+}
+
+// Commit deletes the reservation, unless it expired, and takes its items out of the reserved ones.
+func (s *postgresStockStore) Commit(ctx context.Context, id string) error {
+    found, err := s.remove(ctx, id, "expires_at > now()", "reserved = reserved - $2::bigint")
+    if err != nil {
+        return err
+    }
+    if !found {
+        return status.Errorf(codes.NotFound, "no reservation %s, or it expired", id)
+    }
+    return nil
+}
+
+// Release deletes the reservation and returns its items to the available ones.
+func (s *postgresStockStore) Release(ctx context.Context, id string) error {
+    _, err := s.remove(ctx, id, "true", "available = available + $2::bigint, reserved = reserved - $2::bigint")
+    return err
+}
+
+// remove deletes the rows of a reservation that meet a condition, and applies an assignment to the stock of each of
+// its products, with the product ID as $1 and its quantity as $2, in a transaction. It reports whether the reservation
+// was found.
+func (s *postgresStockStore) remove(ctx context.Context, id, condition, assignment string) (bool, error) {
+    pool, err := s.source.connect(ctx)
+    if err != nil {
+        return false, err
+    }
+    tx, err := pool.Begin(ctx)
+    if err != nil {
+        return false, fmt.Errorf("failed to begin the transaction: %w", err)
+    }
+    defer tx.Rollback(ctx)
+
+    stock, reservations := s.tables()
+    rows, err := tx.Query(ctx, "DELETE FROM "+reservations+" WHERE id = $1 AND "+condition+" RETURNING product_id, quantity", id)
+    if err != nil {
+        return false, postgresStockError("delete the reservation", err)
+    }
+    quantities := make(map[string]int64)
+    for rows.Next() {
+        var productID string
+        var quantity int64
+        if err := rows.Scan(&productID, &quantity); err != nil {
+            rows.Close()
+            return false, fmt.Errorf("failed to scan query result row: %w", err)
+        }
+        quantities[productID] = quantity
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return false, postgresStockError("delete the reservation", err)
+    }
+    if len(quantities) == 0 {
+        return false, nil
+    }
+
+    ids := make([]string, 0, len(quantities))
+    for productID := range quantities {
+        ids = append(ids, productID)
+    }
+    sort.Strings(ids)
+    batch := &pgx.Batch{}
+    for _, productID := range ids {
+        batch.Queue("UPDATE "+stock+" SET "+assignment+" WHERE product_id = $1", productID, quantities[productID])
+    }
+    if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+        return false, postgresStockError("update the stock", err)
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return false, postgresStockError("commit the transaction", err)
+    }
+    return true, nil
+}
+
+func (s *postgresStockStore) String() string {
+    return s.source.name + " (table " + s.table + ")"
+}
+
+// postgresStockError wraps the error of a query of the stock store. A transaction that lost a race with another is
+// Aborted, as trying again may succeed.
+func postgresStockError(action string, err error) error {
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) && (pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected) {
+        return status.Errorf(codes.Aborted, "failed to %s, try again: %v", action, err)
+    }
+    return fmt.Errorf("failed to %s: %w", action, err)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// TestPostgresStockStore runs against the PostgreSQL database of CATALOG_TEST_POSTGRES_DSN, if it's set: the fake
+// server only answers the queries of loads. Its reservations expire by the clock of the database, so it waits for them.
+func TestPostgresStockStore(t *testing.T) {
+    dsn := os.Getenv("CATALOG_TEST_POSTGRES_DSN")
+    if dsn == "" {
+        t.Skip("CATALOG_TEST_POSTGRES_DSN not set")
+    }
+    ctx := context.Background()
+    conn, err := pgx.Connect(ctx, dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    table := fmt.Sprintf("stock_test_%d", time.Now().UnixNano())
+    _, err = conn.Exec(ctx, `CREATE TABLE `+table+` (
+        product_id TEXT PRIMARY KEY,
+        available  INTEGER NOT NULL CHECK (available >= 0),
+        reserved   INTEGER NOT NULL DEFAULT 0
+    );
+    CREATE TABLE `+table+`_reservations (
+        id         TEXT NOT NULL,
+        product_id TEXT NOT NULL,
+        quantity   BIGINT NOT NULL,
+        expires_at TIMESTAMPTZ NOT NULL,
+        PRIMARY KEY (id, product_id)
+    )`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        conn.Exec(ctx, "DROP TABLE "+table+", "+table+"_reservations")
+        conn.Close(ctx)
+    })
+    for id, count := range testStock {
+        if _, err := conn.Exec(ctx, "INSERT INTO "+table+" (product_id, available) VALUES ($1, $2)", id, count); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    newStore := func() *postgresStockStore {
+        source, err := newPostgresCatalogSource(dsn, defaultCatalogTable)
+        if err != nil {
+            t.Fatal(err)
+        }
+        t.Cleanup(source.Close)
+        return &postgresStockStore{source: source, table: table}
+    }
+    testStockStore(t, newStore(), newStore(), time.Sleep)
+}
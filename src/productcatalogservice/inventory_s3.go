@@ -0,0 +1,215 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "github.com/google/uuid"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "productcatalogservice/genproto"
+)
+
+// stockUpdateAttempts is how many times a change of the stock object is tried while other writers change it first.
+const stockUpdateAttempts = 5
+
+// s3StockStore keeps the stock in an object of the bucket of an S3 catalog source, on the client of the source. Every
+// change rewrites the object on the condition that it didn't change since it was read, and starts over if it did, so
+// that the changes of concurrent writers, from any instance, never overwrite each other.
+type s3StockStore struct {
+    client *s3.Client
+    bucket string
+    key    string
+    // now returns the current time; nil uses time.Now.
+    now func() time.Time
+}
+
+// s3Stock is the content of a stock object, in JSON, e.g. {"stock": {"OLJCESPC7Z": 10, "66VCHSJNUP": 0}}.
+type s3Stock struct {
+    // Stock is the number of items in stock of every product whose stock is counted, reserved or not.
+    Stock map[string]int32 `json:"stock"`
+    // Reservations holds the reservations, by their ID, until they're committed, released or expired.
+    Reservations map[string]*s3Reservation `json:"reservations,omitempty"`
+}
+
+// s3Reservation is a reservation of a stock object: the quantity of every product it holds, and when it expires.
+type s3Reservation struct {
+    Items   map[string]int64 `json:"items"`
+    Expires time.Time        `json:"expires"`
+}
+
+// newS3StockStore returns a store that keeps the stock in an object of the bucket of a source.
+func newS3StockStore(source *s3CatalogSource, key string) *s3StockStore {
+    return &s3StockStore{client: source.client, bucket: source.bucket, key: key}
+}
+
+func (s *s3StockStore) clock() time.Time {
+    if s.now != nil {
+        return s.now()
+    }
+    return time.Now()
+}
+
+// read returns the content of the object, without the reservations that expired, and its ETag.
+func (s *s3StockStore) read(ctx context.Context) (*s3Stock, string, error) {
+    out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: aws.String(s.key)})
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to get the stock object: %w", err)
+    }
+    defer out.Body.Close()
+    data, err := io.ReadAll(out.Body)
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to read the stock object: %w", err)
+    }
+    stock := &s3Stock{}
+    if err := json.Unmarshal(data, stock); err != nil {
+        return nil, "", fmt.Errorf("failed to parse the stock object: %w", err)
+    }
+    if stock.Stock == nil {
+        stock.Stock = make(map[string]int32)
+    }
+    if stock.Reservations == nil {
+        stock.Reservations = make(map[string]*s3Reservation)
+    }
+    now := s.clock()
+    for id, r := range stock.Reservations {
+        if !now.Before(r.Expires) {
+            delete(stock.Reservations, id)
+        }
+    }
+    return stock, aws.ToString(out.ETag), nil
+}
+
+// update reads the object, applies a change to it and writes it back, unless the change reports that it changed
+// nothing. It starts over if another writer changed the object in the meantime, and fails with Aborted if that keeps
+// happening. Nothing is written if change fails, and its error is returned as it is.
+func (s *s3StockStore) update(ctx context.Context, change func(stock *s3Stock) (bool, error)) error {
+    for attempt := 1; attempt <= stockUpdateAttempts; attempt++ {
+        stock, etag, err := s.read(ctx)
+        if err != nil {
+            return err
+        }
+        if changed, err := change(stock); err != nil || !changed {
+            return err
+        }
+        data, err := json.Marshal(stock)
+        if err != nil {
+            return fmt.Errorf("failed to format the stock object: %w", err)
+        }
+        _, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+            Bucket:      aws.String(s.bucket),
+            Key:         aws.String(s.key),
+            Body:        bytes.NewReader(data),
+            ContentType: aws.String("application/json"),
+            IfMatch:     aws.String(etag),
+        })
+        var respErr *awshttp.ResponseError
+        if errors.As(err, &respErr) && (respErr.HTTPStatusCode() == http.StatusPreconditionFailed ||
+            respErr.HTTPStatusCode() == http.StatusConflict) {
+            // The object changed since it was read, or another write of it is in progress.
+            continue
+        }
+        if err != nil {
+            return fmt.Errorf("failed to put the stock object: %w", err)
+        }
+        return nil
+    }
+    return status.Error(codes.Aborted, "the stock kept changing during the update, try again")
+}
+
+// reserved returns the number of items of every product whose stock is counted that the reservations hold.
+func (stock *s3Stock) reserved() map[string]int64 {
+    reserved := make(map[string]int64)
+    for _, r := range stock.Reservations {
+        for id, quantity := range r.Items {
+            if _, tracked := stock.Stock[id]; tracked {
+                reserved[id] += quantity
+            }
+        }
+    }
+    return reserved
+}
+
+func (s *s3StockStore) Levels(ctx context.Context, ids []string) ([]*pb.StockLevel, error) {
+    stock, _, err := s.read(ctx)
+    if err != nil {
+        return nil, err
+    }
+    reserved := stock.reserved()
+    levels := make([]*pb.StockLevel, len(ids))
+    for i, id := range ids {
+        onHand, tracked := stock.Stock[id]
+        if !tracked {
+            levels[i] = &pb.StockLevel{ProductId: id}
+            continue
+        }
+        levels[i] = &pb.StockLevel{ProductId: id, Tracked: true, Available: onHand - int32(reserved[id]),
+            Reserved: int32(reserved[id])}
+    }
+    return levels, nil
+}
+
+func (s *s3StockStore) Reserve(ctx context.Context, items []*pb.CartItem, ttl time.Duration) (string, time.Time, error) {
+    wanted, ids, err := reservedQuantities(items)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    id, expires := uuid.NewString(), s.clock().Add(ttl)
+    err = s.update(ctx, func(stock *s3Stock) (bool, error) {
+        reserved := stock.reserved()
+        for _, productID := range ids {
+            onHand, tracked := stock.Stock[productID]
+            if available := int64(onHand) - reserved[productID]; tracked && wanted[productID] > available {
+                return false, status.Errorf(codes.FailedPrecondition,
+                    "only %d items of product %s are available, not %d", available, productID, wanted[productID])
+            }
+        }
+        stock.Reservations[id] = &s3Reservation{Items: wanted, Expires: expires}
+        return true, nil
+    })
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    return id, expires, nil
+}
+
+func (s *s3StockStore) Commit(ctx context.Context, id string) error {
+    return s.update(ctx, func(stock *s3Stock) (bool, error) {
+        r, ok := stock.Reservations[id]
+        if !ok {
+            return false, status.Errorf(codes.NotFound, "no reservation %s, or it expired", id)
+        }
+        delete(stock.Reservations, id)
+        for productID, quantity := range r.Items {
+            if onHand, tracked := stock.Stock[productID]; tracked {
+                // The reservation held the items, so they're in stock.
+                stock.Stock[productID] = onHand - int32(quantity)
+            }
+        }
+        return true, nil
+    })
+}
+
+func (s *s3StockStore) Release(ctx context.Context, id string) error {
+    return s.update(ctx, func(stock *s3Stock) (bool, error) {
+        if _, ok := stock.Reservations[id]; !ok {
+            return false, nil
+        }
+        delete(stock.Reservations, id)
+        return true, nil
+    })
+}
+
+func (s *s3StockStore) String() string {
+    return "s3://" + s.bucket + "/" + s.key
+}
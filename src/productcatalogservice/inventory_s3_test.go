@@ -0,0 +1,89 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/aws/aws-sdk-go-v2/aws"
+    "github.com/aws/aws-sdk-go-v2/service/s3"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "productcatalogservice/genproto"
+)
+
+// newTestS3StockStore returns two stores on the same stock object, in the bucket of a test catalog source, with
+// testStock, a clock they share, and a function that writes the object.
+func newTestS3StockStore(t *testing.T) (*s3StockStore, *s3StockStore, func(time.Duration), func(stock *s3Stock)) {
+    t.Helper()
+    source, _, _ := newTestS3CatalogSource(t)
+    ctx := context.Background()
+    put := func(stock *s3Stock) {
+        data, err := json.Marshal(stock)
+        if err != nil {
+            t.Fatal(err)
+        }
+        _, err = source.client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(source.bucket), Key: aws.String("stock.json"),
+            Body: bytes.NewReader(data)})
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    put(&s3Stock{Stock: testStock})
+    t.Cleanup(func() {
+        source.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(source.bucket), Key: aws.String("stock.json")})
+    })
+
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    clock := func() time.Time { return now }
+    store, other := newS3StockStore(source, "stock.json"), newS3StockStore(source, "stock.json")
+    store.now, other.now = clock, clock
+    return store, other, func(d time.Duration) { now = now.Add(d) }, put
+}
+
+func TestS3StockStore(t *testing.T) {
+    store, other, advance, _ := newTestS3StockStore(t)
+    testStockStore(t, store, other, advance)
+}
+
+func TestS3StockStoreConflicts(t *testing.T) {
+    store, other, _, put := newTestS3StockStore(t)
+    ctx := context.Background()
+
+    // Another instance reserves the last tank tops while this one is about to write its reservation of one, which
+    // then starts over, and finds none left.
+    written := false
+    err := store.update(ctx, func(stock *s3Stock) (bool, error) {
+        if !written {
+            written = true
+            if _, _, err := other.Reserve(ctx, []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 2}}, time.Minute); err != nil {
+                t.Fatal(err)
+            }
+        }
+        if int64(stock.Stock["66VCHSJNUP"])-stock.reserved()["66VCHSJNUP"] < 1 {
+            return false, status.Error(codes.FailedPrecondition, "no tank top left")
+        }
+        stock.Reservations["mine"] = &s3Reservation{Items: map[string]int64{"66VCHSJNUP": 1}, Expires: store.clock().Add(time.Minute)}
+        return true, nil
+    })
+    if status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("update() after a conflict returned %v, want the FailedPrecondition of the second attempt", err)
+    }
+    if available, reserved := levelsOf(t, store, "66VCHSJNUP"); available != 0 || reserved != 2 {
+        t.Errorf("tank tops: %d available and %d reserved, want the reservation of the other instance kept", available, reserved)
+    }
+
+    // A writer that keeps changing the object makes the update give up.
+    restocked := int32(0)
+    err = store.update(ctx, func(stock *s3Stock) (bool, error) {
+        restocked++
+        put(&s3Stock{Stock: map[string]int32{"OLJCESPC7Z": restocked}})
+        return true, nil
+    })
+    if status.Code(err) != codes.Aborted {
+        t.Errorf("update() of an object that keeps changing returned %v, want Aborted", err)
+    }
+}
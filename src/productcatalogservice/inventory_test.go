@@ -0,0 +1,326 @@
+package main
+
+import (
+    "context"
+    "math"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+
+    pb "productcatalogservice/genproto"
+)
+
+// testStock is the stock the stores of the tests start with: 5 sunglasses and 2 tank tops.
+var testStock = map[string]int32{"OLJCESPC7Z": 5, "66VCHSJNUP": 2}
+
+// newTestInventoryCatalog returns the catalog of products.json, with 5 sunglasses and 2 tank tops in stock, and a
+// function that moves its inventory's clock forward.
+func newTestInventoryCatalog(t *testing.T) (*productCatalog, func(time.Duration)) {
+    t.Helper()
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    inv := newInventory(testStock)
+    inv.now = func() time.Time { return now }
+    catalog := &productCatalog{source: &fileCatalogSource{fsys: embeddedCatalog, name: catalogFile}, stockStore: inv}
+    return catalog, func(d time.Duration) { now = now.Add(d) }
+}
+
+// stockOf returns the stock level of a product of a catalog.
+func stockOf(t *testing.T, catalog *productCatalog, id string) *pb.StockLevel {
+    t.Helper()
+    headers := map[string]string{}
+    resp, err := catalog.GetStock(&pb.GetStockRequest{ProductIds: []string{id}}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return resp.Stock[0]
+}
+
+func TestGetStock(t *testing.T) {
+    catalog, _ := newTestInventoryCatalog(t)
+    headers := map[string]string{}
+    resp, err := catalog.GetStock(&pb.GetStockRequest{ProductIds: []string{"OLJCESPC7Z", "9SIQT8TOJO"}}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := resp.Stock[0]; !s.Tracked || s.Available != 5 || s.Reserved != 0 {
+        t.Errorf("GetStock() of the sunglasses = %v, want 5 available", s)
+    }
+    if s := resp.Stock[1]; s.Tracked || s.ProductId != "9SIQT8TOJO" {
+        t.Errorf("GetStock() of a product without stock = %v, want it untracked", s)
+    }
+    if _, err := catalog.GetStock(&pb.GetStockRequest{ProductIds: []string{"MISSING"}}, &headers); status.Code(err) != codes.NotFound {
+        t.Errorf("GetStock() of a missing product returned %v, want NotFound", err)
+    }
+}
+
+func TestReserveAndCommitStock(t *testing.T) {
+    catalog, _ := newTestInventoryCatalog(t)
+    headers := map[string]string{}
+    req := &pb.ReserveStockRequest{Items: []*pb.CartItem{
+        {ProductId: "OLJCESPC7Z", Quantity: 2},
+        {ProductId: "9SIQT8TOJO", Quantity: 100},
+        {ProductId: "OLJCESPC7Z", Quantity: 1},
+    }}
+    reservation, err := catalog.ReserveStock(req, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := time.Date(2024, 1, 1, 12, 10, 0, 0, time.UTC).Unix(); reservation.ExpireTime != want || len(reservation.Items) != 3 {
+        t.Errorf("ReserveStock() = %v, want the 3 items until %d", reservation, want)
+    }
+    if s := stockOf(t, catalog, "OLJCESPC7Z"); s.Available != 2 || s.Reserved != 3 {
+        t.Errorf("stock after the reservation = %v, want 2 available and 3 reserved", s)
+    }
+
+    // Only 2 of the 3 sunglasses are left, so nothing is reserved, not even the tank top.
+    req.Items = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}, {ProductId: "OLJCESPC7Z", Quantity: 3}}
+    if _, err := catalog.ReserveStock(req, &headers); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("ReserveStock() of too many items returned %v, want FailedPrecondition", err)
+    }
+    if s := stockOf(t, catalog, "66VCHSJNUP"); s.Available != 2 {
+        t.Errorf("stock after a failed reservation = %v, want the 2 tank tops available", s)
+    }
+
+    commit := &pb.CommitReservationRequest{ReservationId: reservation.ReservationId}
+    if _, err := catalog.CommitReservation(commit, &headers); err != nil {
+        t.Fatal(err)
+    }
+    if s := stockOf(t, catalog, "OLJCESPC7Z"); s.Available != 2 || s.Reserved != 0 {
+        t.Errorf("stock after the commit = %v, want 2 available and none reserved", s)
+    }
+    if _, err := catalog.CommitReservation(commit, &headers); status.Code(err) != codes.NotFound {
+        t.Errorf("second CommitReservation() returned %v, want NotFound", err)
+    }
+}
+
+func TestReleaseAndExpireReservations(t *testing.T) {
+    catalog, advance := newTestInventoryCatalog(t)
+    headers := map[string]string{}
+    items := []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 2}}
+
+    released, err := catalog.ReserveStock(&pb.ReserveStockRequest{Items: items}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    release := &pb.ReleaseReservationRequest{ReservationId: released.ReservationId}
+    if _, err := catalog.ReleaseReservation(release, &headers); err != nil {
+        t.Fatal(err)
+    }
+    if s := stockOf(t, catalog, "66VCHSJNUP"); s.Available != 2 || s.Reserved != 0 {
+        t.Errorf("stock after the release = %v, want the 2 tank tops available", s)
+    }
+    // Releasing twice does nothing.
+    if _, err := catalog.ReleaseReservation(release, &headers); err != nil {
+        t.Errorf("second ReleaseReservation() returned %v", err)
+    }
+
+    expiring, err := catalog.ReserveStock(&pb.ReserveStockRequest{Items: items, TtlSeconds: 60}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := stockOf(t, catalog, "66VCHSJNUP"); s.Available != 0 {
+        t.Errorf("stock during the reservation = %v, want no tank top available", s)
+    }
+    advance(time.Minute)
+    if s := stockOf(t, catalog, "66VCHSJNUP"); s.Available != 2 || s.Reserved != 0 {
+        t.Errorf("stock after the reservation expired = %v, want the 2 tank tops available", s)
+    }
+    commit := &pb.CommitReservationRequest{ReservationId: expiring.ReservationId}
+    if _, err := catalog.CommitReservation(commit, &headers); status.Code(err) != codes.NotFound {
+        t.Errorf("CommitReservation() of an expired reservation returned %v, want NotFound", err)
+    }
+
+    long, err := catalog.ReserveStock(&pb.ReserveStockRequest{Items: items, TtlSeconds: 86400}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := time.Date(2024, 1, 1, 13, 1, 0, 0, time.UTC).Unix(); long.ExpireTime != want {
+        t.Errorf("ReserveStock() for a day expires at %d, want %d, an hour later", long.ExpireTime, want)
+    }
+}
+
+func TestReserveStockValidation(t *testing.T) {
+    catalog, _ := newTestInventoryCatalog(t)
+    headers := map[string]string{}
+    tests := []struct {
+        name  string
+        items []*pb.CartItem
+        want  codes.Code
+    }{
+        {"no items", nil, codes.InvalidArgument},
+        {"no quantity", []*pb.CartItem{{ProductId: "OLJCESPC7Z"}}, codes.InvalidArgument},
+        {"negative quantity", []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: -1}}, codes.InvalidArgument},
+        {"missing product", []*pb.CartItem{{ProductId: "MISSING", Quantity: 1}}, codes.NotFound},
+        {"quantities over an int32", []*pb.CartItem{
+            {ProductId: "OLJCESPC7Z", Quantity: math.MaxInt32},
+            {ProductId: "OLJCESPC7Z", Quantity: math.MaxInt32},
+        }, codes.FailedPrecondition},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            _, err := catalog.ReserveStock(&pb.ReserveStockRequest{Items: test.items}, &headers)
+            if status.Code(err) != test.want {
+                t.Errorf("ReserveStock() returned %v, want %v", err, test.want)
+            }
+        })
+    }
+}
+
+func TestLoadInventory(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "inventory.json")
+    if err := os.WriteFile(path, []byte(`{"OLJCESPC7Z": 5, "66VCHSJNUP": 0}`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    inv, err := loadInventory(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if levels, err := inv.Levels(context.Background(), []string{"66VCHSJNUP"}); err != nil || !levels[0].Tracked || levels[0].Available != 0 {
+        t.Errorf("Levels() of a product out of stock = %v, %v, want it tracked with none available", levels, err)
+    }
+
+    for name, data := range map[string]string{"negative": `{"A": -1}`, "invalid": `["A"]`} {
+        path := filepath.Join(dir, name+".json")
+        if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := loadInventory(path); err == nil {
+            t.Errorf("loadInventory() of a %s inventory succeeded", name)
+        }
+    }
+}
+
+func TestReservationsWithoutStock(t *testing.T) {
+    catalog := &productCatalog{source: &fileCatalogSource{fsys: embeddedCatalog, name: catalogFile}}
+    headers := map[string]string{}
+    items := []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 100}}
+    reservation, err := catalog.ReserveStock(&pb.ReserveStockRequest{Items: items}, &headers)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s := stockOf(t, catalog, "OLJCESPC7Z"); s.Tracked {
+        t.Errorf("stock without a store = %v, want it untracked", s)
+    }
+
+    // The reservations hold nothing, so any instance can commit or release them, as many times as it likes.
+    other := &productCatalog{source: catalog.source}
+    for _, c := range []*productCatalog{catalog, other} {
+        if _, err := c.CommitReservation(&pb.CommitReservationRequest{ReservationId: reservation.ReservationId}, &headers); err != nil {
+            t.Errorf("CommitReservation() returned %v", err)
+        }
+        if _, err := c.ReleaseReservation(&pb.ReleaseReservationRequest{ReservationId: reservation.ReservationId}, &headers); err != nil {
+            t.Errorf("ReleaseReservation() returned %v", err)
+        }
+    }
+}
+
+func TestInventoryStockStore(t *testing.T) {
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    inv := newInventory(testStock)
+    inv.now = func() time.Time { return now }
+    testStockStore(t, inv, nil, func(d time.Duration) { now = now.Add(d) })
+}
+
+// levelsOf returns the available and reserved items of a product of a stock store.
+func levelsOf(t *testing.T, store StockStore, id string) (int32, int32) {
+    t.Helper()
+    levels, err := store.Levels(context.Background(), []string{id})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return levels[0].Available, levels[0].Reserved
+}
+
+// testStockStore checks that a store keeps the stock of a reservation from beginning to end. The store starts with
+// testStock; other is another instance of the store on the same backend, or nil if instances don't share it, and
+// advance moves the clock of the store forward.
+func testStockStore(t *testing.T, store, other StockStore, advance func(time.Duration)) {
+    t.Helper()
+    ctx := context.Background()
+    levels, err := store.Levels(ctx, []string{"OLJCESPC7Z", "9SIQT8TOJO"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if l := levels[0]; !l.Tracked || l.ProductId != "OLJCESPC7Z" || l.Available != 5 || l.Reserved != 0 {
+        t.Errorf("Levels() of the sunglasses = %v, want 5 available", l)
+    }
+    if l := levels[1]; l.Tracked || l.ProductId != "9SIQT8TOJO" {
+        t.Errorf("Levels() of a product without stock = %v, want it untracked", l)
+    }
+
+    items := []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 2}, {ProductId: "9SIQT8TOJO", Quantity: 100},
+        {ProductId: "OLJCESPC7Z", Quantity: 1}}
+    committed, _, err := store.Reserve(ctx, items, time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if available, reserved := levelsOf(t, store, "OLJCESPC7Z"); available != 2 || reserved != 3 {
+        t.Errorf("sunglasses after the reservation: %d available and %d reserved, want 2 and 3", available, reserved)
+    }
+    items = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 1}, {ProductId: "OLJCESPC7Z", Quantity: 3}}
+    if _, _, err := store.Reserve(ctx, items, time.Minute); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("Reserve() of too many items returned %v, want FailedPrecondition", err)
+    }
+    if available, _ := levelsOf(t, store, "66VCHSJNUP"); available != 2 {
+        t.Errorf("%d tank tops available after a failed reservation, want 2", available)
+    }
+
+    // Another instance commits the reservation.
+    committer := store
+    if other != nil {
+        committer = other
+    }
+    if err := committer.Commit(ctx, committed); err != nil {
+        t.Fatal(err)
+    }
+    if available, reserved := levelsOf(t, store, "OLJCESPC7Z"); available != 2 || reserved != 0 {
+        t.Errorf("sunglasses after the commit: %d available and %d reserved, want 2 and 0", available, reserved)
+    }
+    if err := store.Commit(ctx, committed); status.Code(err) != codes.NotFound {
+        t.Errorf("second Commit() returned %v, want NotFound", err)
+    }
+
+    items = []*pb.CartItem{{ProductId: "66VCHSJNUP", Quantity: 2}}
+    released, _, err := store.Reserve(ctx, items, time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := committer.Release(ctx, released); err != nil {
+        t.Fatal(err)
+    }
+    if available, reserved := levelsOf(t, store, "66VCHSJNUP"); available != 2 || reserved != 0 {
+        t.Errorf("tank tops after the release: %d available and %d reserved, want 2 and 0", available, reserved)
+    }
+    if err := store.Release(ctx, released); err != nil {
+        t.Errorf("second Release() returned %v", err)
+    }
+
+    // A reservation of products whose stock isn't counted holds nothing, but can still be committed once.
+    untracked, _, err := store.Reserve(ctx, []*pb.CartItem{{ProductId: "9SIQT8TOJO", Quantity: 1}}, time.Minute)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := committer.Commit(ctx, untracked); err != nil {
+        t.Errorf("Commit() of a reservation of untracked products returned %v", err)
+    }
+
+    expiring, _, err := store.Reserve(ctx, items, time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if available, _ := levelsOf(t, store, "66VCHSJNUP"); available != 0 {
+        t.Errorf("%d tank tops available during the reservation, want 0", available)
+    }
+    advance(time.Second)
+    if available, reserved := levelsOf(t, store, "66VCHSJNUP"); available != 2 || reserved != 0 {
+        t.Errorf("tank tops after the reservation expired: %d available and %d reserved, want 2 and 0", available, reserved)
+    }
+    if err := committer.Commit(ctx, expiring); status.Code(err) != codes.NotFound {
+        t.Errorf("Commit() of an expired reservation returned %v, want NotFound", err)
+    }
+}
@@ -1,62 +1,239 @@
 package main
 
 import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
     "strings"
+    "sync"
+    "sync/atomic"
     "time"
 
     "google.golang.org/grpc/codes"
     "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
 
-    pb "main/genproto"
+    pb "productcatalogservice/genproto"
+)
+
+const (
+    // catalogLoadTimeout is how long a load of the catalog may take before it's abandoned.
+    catalogLoadTimeout = 30 * time.Second
+    // catalogRetryInterval is how long requests fail with the error of the first load of the catalog before one tries
+    // to load it again.
+    catalogRetryInterval = 5 * time.Second
 )
 
 type productCatalog struct {
-    catalog pb.ListProductsResponse
+    // catalog is the current snapshot of the catalog, or nil until it's first loaded. Requests only ever read it, and
+    // never wait for a load.
+    catalog atomic.Pointer[catalogSnapshot]
+    // source is where the products of the catalog are read from; nil reads catalogFile. The admin RPCs write to it
+    // if it's a CatalogStore.
+    source CatalogSource
+    // admin authorizes the admin RPCs; nil disables them.
+    admin *adminAuth
+    // audit records the changes of the admin RPCs; nil logs them.
+    audit auditLog
+    // stockStore keeps the stock of products; nil counts the stock of none.
+    stockStore StockStore
+
+    // maxAge is how long the catalog is used after it was last loaded before a request reloads it, for environments
+    // where a catalogReloader can't run, such as Lambda; zero never reloads it on requests.
+    maxAge time.Duration
+    // now returns the current time; nil uses time.Now.
+    now func() time.Time
+    // loaded is when the catalog was last loaded, or tried to be, in Unix nanoseconds.
+    loaded atomic.Int64
+
+    // loadMu serializes loads, and guards the fields below.
+    loadMu sync.Mutex
+    // checksum is the checksum of the products of the current snapshot.
+    checksum [sha256.Size]byte
+    // loadErr is the error of the last load if the catalog never loaded, and loadFailed is when it failed.
+    loadErr    error
+    loadFailed time.Time
 }
 
-func (p *productCatalog) ListProducts(empty *pb.Empty, headers *map[string]string) (*pb.ListProductsResponse, error) {
+// ListProducts returns a page of the products that pass the filters of a request.
+func (p *productCatalog) ListProducts(req *pb.ListProductsRequest, headers *map[string]string) (*pb.ListProductsResponse, error) {
     time.Sleep(extraLatency)
 
-    return &pb.ListProductsResponse{Products: p.parseCatalog()}, nil
+    products, next, total, err := p.find("", req.Category, req.MinPriceUsd, req.MaxPriceUsd, req.SortOrder,
+        req.PageSize, req.PageToken)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.ListProductsResponse{Products: products, NextPageToken: next, TotalSize: int32(total)}, nil
 }
 
 func (p *productCatalog) GetProduct(req *pb.GetProductRequest, headers *map[string]string) (*pb.Product, error) {
     time.Sleep(extraLatency)
 
-    var found *pb.Product
-    for i := 0; i < len(p.parseCatalog()); i++ {
-        if req.Id == p.parseCatalog()[i].Id {
-            found = p.parseCatalog()[i]
-        }
+    snapshot, err := p.snapshot()
+    if err != nil {
+        return nil, err
     }
-
+    found := snapshot.product(req.Id)
     if found == nil {
         return nil, status.Errorf(codes.NotFound, "no product with ID %s", req.Id)
     }
     return found, nil
 }
 
+// SearchProducts returns a page of the products that match the words of a query and pass the filters of a request,
+// the most relevant first unless the request asks for another order. See catalogSnapshot.score for how products are
+// matched and ranked.
 func (p *productCatalog) SearchProducts(req *pb.SearchProductsRequest, headers *map[string]string) (*pb.SearchProductsResponse, error) {
     time.Sleep(extraLatency)
 
-    var ps []*pb.Product
-    for _, product := range p.parseCatalog() {
-        if strings.Contains(strings.ToLower(product.Name), strings.ToLower(req.Query)) ||
-            strings.Contains(strings.ToLower(product.Description), strings.ToLower(req.Query)) {
-            ps = append(ps, product)
+    products, next, total, err := p.find(req.Query, req.Category, req.MinPriceUsd, req.MaxPriceUsd, req.SortOrder,
+        req.PageSize, req.PageToken)
+    if err != nil {
+        return nil, err
+    }
+    return &pb.SearchProductsResponse{Results: products, NextPageToken: next, TotalSize: int32(total)}, nil
+}
+
+// find validates the fields that ListProducts and SearchProducts share, and returns the page they ask for, the token
+// of the next page, and the number of matching products on all pages.
+func (p *productCatalog) find(text, category string, minPrice, maxPrice *pb.Money, order pb.ProductSortOrder,
+    pageSize int32, pageToken string) ([]*pb.Product, string, int, error) {
+    q := productQuery{text: text, filter: noFilter, order: order}
+    q.filter.category = strings.ToLower(strings.TrimSpace(category))
+    if _, ok := pb.ProductSortOrder_name[int32(order)]; !ok {
+        return nil, "", 0, status.Errorf(codes.InvalidArgument, "unknown sort order %d", order)
+    }
+    for _, bound := range []struct {
+        price *pb.Money
+        name  string
+        value *int64
+    }{{minPrice, "min_price_usd", &q.filter.minPrice}, {maxPrice, "max_price_usd", &q.filter.maxPrice}} {
+        if bound.price == nil {
+            continue
+        }
+        if code := bound.price.CurrencyCode; code != "" && code != "USD" {
+            return nil, "", 0, status.Errorf(codes.InvalidArgument, "%s must be in USD, not %s", bound.name, code)
         }
+        *bound.value = nanos(bound.price)
+    }
+    if q.filter.minPrice > q.filter.maxPrice {
+        return nil, "", 0, status.Error(codes.InvalidArgument, "min_price_usd is above max_price_usd")
     }
 
-    return &pb.SearchProductsResponse{Results: ps}, nil
+    offset, err := decodePageToken(q, pageToken)
+    if err != nil {
+        return nil, "", 0, status.Error(codes.InvalidArgument, err.Error())
+    }
+    limit := int(pageSize)
+    if limit <= 0 {
+        limit = defaultPageSize
+    } else if limit > maxPageSize {
+        limit = maxPageSize
+    }
+
+    snapshot, err := p.snapshot()
+    if err != nil {
+        return nil, "", 0, err
+    }
+    products, total := snapshot.find(q, offset, limit)
+    next := ""
+    if offset+len(products) < total {
+        next = encodePageToken(q, offset+len(products))
+    }
+    return products, next, total, nil
 }
 
-func (p *productCatalog) parseCatalog() []*pb.Product {
-    if reloadCatalog || len(p.catalog.Products) == 0 {
-        err := loadCatalog(&p.catalog)
-        if err != nil {
-            return []*pb.Product{}
+// snapshot returns the current snapshot of the catalog, loading it first if it was never loaded. If the catalog
+// fails to load, it returns an Unavailable error, and so do the calls of the next catalogRetryInterval, without
+// trying again. If the snapshot is older than maxAge, one request reloads it while the others keep using it.
+func (p *productCatalog) snapshot() (*catalogSnapshot, error) {
+    if current := p.catalog.Load(); current != nil {
+        if p.maxAge > 0 && p.stale() && p.loadMu.TryLock() {
+            defer p.loadMu.Unlock()
+            if p.stale() {
+                if _, err := p.reloadLocked(); err != nil {
+                    log.Warnf("failed to reload the catalog, keeping the current one: %v", err)
+                }
+            }
+            return p.catalog.Load(), nil
         }
+        return current, nil
+    }
+
+    p.loadMu.Lock()
+    defer p.loadMu.Unlock()
+    if current := p.catalog.Load(); current != nil {
+        // Another request loaded it while this one waited.
+        return current, nil
+    }
+    if p.loadErr != nil && p.clock().Sub(p.loadFailed) < catalogRetryInterval {
+        return nil, p.loadErr
+    }
+    if _, err := p.reloadLocked(); err != nil {
+        log.Warnf("failed to load the catalog: %v", err)
+        p.loadErr = status.Errorf(codes.Unavailable, "the catalog isn't available: %v", err)
+        p.loadFailed = p.clock()
+        return nil, p.loadErr
+    }
+    p.loadErr = nil
+    return p.catalog.Load(), nil
+}
+
+// stale reports whether the catalog was last loaded more than maxAge ago.
+func (p *productCatalog) stale() bool {
+    return p.clock().Sub(time.Unix(0, p.loaded.Load())) >= p.maxAge
+}
+
+func (p *productCatalog) clock() time.Time {
+    if p.now != nil {
+        return p.now()
     }
+    return time.Now()
+}
 
-    return p.catalog.Products
+// reload loads the catalog, and swaps in a snapshot of it if its products changed. Requests keep using the previous
+// snapshot until the new one is ready, and keep it if the catalog fails to load. It reports whether it swapped the
+// snapshot.
+func (p *productCatalog) reload() (bool, error) {
+    p.loadMu.Lock()
+    defer p.loadMu.Unlock()
+    return p.reloadLocked()
+}
+
+func (p *productCatalog) reloadLocked() (bool, error) {
+    source := p.source
+    if source == nil {
+        source = newFileCatalogSource(catalogFile)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), catalogLoadTimeout)
+    defer cancel()
+    p.loaded.Store(p.clock().UnixNano())
+    log.Debugf("loading the catalog from %v", source)
+    products, err := source.Load(ctx)
+    if err != nil {
+        return false, err
+    }
+
+    checksum, err := productsChecksum(products)
+    if err != nil {
+        return false, err
+    }
+    if p.catalog.Load() != nil && checksum == p.checksum {
+        return false, nil
+    }
+    snapshot := newCatalogSnapshot(products)
+    snapshot.etag = `"` + hex.EncodeToString(checksum[:8]) + `"`
+    p.catalog.Store(snapshot)
+    p.checksum = checksum
+    return true, nil
+}
+
+// productsChecksum returns a checksum of the content of a list of products, which changes when any product does.
+func productsChecksum(products []*pb.Product) ([sha256.Size]byte, error) {
+    data, err := proto.MarshalOptions{Deterministic: true}.Marshal(&pb.ListProductsResponse{Products: products})
+    if err != nil {
+        return [sha256.Size]byte{}, err
+    }
+    return sha256.Sum256(data), nil
 }
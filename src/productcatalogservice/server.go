@@ -1,6 +1,8 @@
 package main
 
 import (
+    "context"
+    "crypto/rand"
     "encoding/base64"
     "flag"
     "fmt"
@@ -9,11 +11,10 @@ import (
     "os"
     "strconv"
     "strings"
-    "sync"
     "time"
 
-    //"os/signal"
-    //"syscall"
+    "os/signal"
+    "syscall"
 
     "github.com/aws/aws-lambda-go/lambda"
     "github.com/sirupsen/logrus"
@@ -21,19 +22,18 @@ import (
     "google.golang.org/grpc/status"
     "google.golang.org/protobuf/proto"
 
-    pb "main/genproto"
+    "logging"
+    pb "productcatalogservice/genproto"
 )
 
 var (
     runningInLambda = os.Getenv("RUN_LAMBDA") == "1"
 
-    catalogMutex *sync.Mutex
-    log          *logrus.Logger
+    log = logging.New("productcatalogservice")
+
     extraLatency time.Duration
 
     svc = &productCatalog{}
-
-    reloadCatalog bool
 )
 
 const (
@@ -42,43 +42,100 @@ const (
     listProductsRPC   = "list-products"
     getProductRPC     = "get-product"
     searchProductsRPC = "search-products"
-)
 
-func init() {
-    log = logrus.New()
-    log.Formatter = &logrus.JSONFormatter{
-        FieldMap: logrus.FieldMap{
-            logrus.FieldKeyTime:  "timestamp",
-            logrus.FieldKeyLevel: "severity",
-            logrus.FieldKeyMsg:   "message",
-        },
-        TimestampFormat: time.RFC3339Nano,
-    }
-    log.Out = os.Stdout
-    catalogMutex = &sync.Mutex{}
-}
+    createProductRPC      = "create-product"
+    updateProductRPC      = "update-product"
+    deleteProductRPC      = "delete-product"
+    bulkUpsertProductsRPC = "bulk-upsert-products"
+
+    getStockRPC           = "get-stock"
+    reserveStockRPC       = "reserve-stock"
+    commitReservationRPC  = "commit-reservation"
+    releaseReservationRPC = "release-reservation"
+)
 
 // callRPC chooses the correct handler function to call.
 func callRPC(msg *proto.Message, reqData *RequestData) (proto.Message, error) {
     switch reqData.Headers["rpc-name"] {
     case listProductsRPC:
-        return svc.ListProducts((*msg).(*pb.Empty), &reqData.Headers)
+        return svc.ListProducts((*msg).(*pb.ListProductsRequest), &reqData.Headers)
     case getProductRPC:
         return svc.GetProduct((*msg).(*pb.GetProductRequest), &reqData.Headers)
+    case createProductRPC:
+        return svc.CreateProduct((*msg).(*pb.CreateProductRequest), &reqData.Headers)
+    case updateProductRPC:
+        return svc.UpdateProduct((*msg).(*pb.UpdateProductRequest), &reqData.Headers)
+    case deleteProductRPC:
+        return svc.DeleteProduct((*msg).(*pb.DeleteProductRequest), &reqData.Headers)
+    case bulkUpsertProductsRPC:
+        return svc.BulkUpsertProducts((*msg).(*pb.BulkUpsertProductsRequest), &reqData.Headers)
+    case getStockRPC:
+        return svc.GetStock((*msg).(*pb.GetStockRequest), &reqData.Headers)
+    case reserveStockRPC:
+        return svc.ReserveStock((*msg).(*pb.ReserveStockRequest), &reqData.Headers)
+    case commitReservationRPC:
+        return svc.CommitReservation((*msg).(*pb.CommitReservationRequest), &reqData.Headers)
+    case releaseReservationRPC:
+        return svc.ReleaseReservation((*msg).(*pb.ReleaseReservationRequest), &reqData.Headers)
     default:
         return svc.SearchProducts((*msg).(*pb.SearchProductsRequest), &reqData.Headers)
     }
 }
 
+// serveRPC calls the handler of a request and encodes its response. The responses of ListProducts and GetProduct
+// carry the ETag of the catalog they were read from, and a request that sends that ETag in If-None-Match while it's
+// still current gets a Not Modified response without a body, for clients that cache the responses.
+func serveRPC(msg *proto.Message, reqData *RequestData) (*ResponseData, error) {
+    var etag string
+    if rpcName := reqData.Headers["rpc-name"]; rpcName == listProductsRPC || rpcName == getProductRPC {
+        // The ETag is taken before the call so that a response read from a newer catalog is tagged as older, which
+        // only costs an extra download, rather than the other way around, which would keep it stale.
+        if snapshot, err := svc.snapshot(); err == nil {
+            etag = snapshot.etag
+        }
+    }
+    if etag != "" && reqData.Headers["if-none-match"] == etag {
+        return &ResponseData{
+            StatusCode: http.StatusNotModified,
+            Headers: map[string]string{
+                "etag":        etag,
+                "grpc-status": strconv.Itoa(int(codes.OK))},
+        }, nil
+    }
+
+    respMsg, rpcError := callRPC(msg, reqData)
+    respData, err := encodeResponse(&respMsg, rpcError)
+    if err == nil && rpcError == nil && etag != "" {
+        respData.Headers["etag"] = etag
+    }
+    return respData, err
+}
+
 // determineMessageType chooses the correct message type to initialize.
 func determineMessageType(rpcName string) proto.Message {
     switch rpcName {
     case listProductsRPC:
-        return &pb.Empty{}
+        return &pb.ListProductsRequest{}
     case getProductRPC:
         return &pb.GetProductRequest{}
     case searchProductsRPC:
         return &pb.SearchProductsRequest{}
+    case createProductRPC:
+        return &pb.CreateProductRequest{}
+    case updateProductRPC:
+        return &pb.UpdateProductRequest{}
+    case deleteProductRPC:
+        return &pb.DeleteProductRequest{}
+    case bulkUpsertProductsRPC:
+        return &pb.BulkUpsertProductsRequest{}
+    case getStockRPC:
+        return &pb.GetStockRequest{}
+    case reserveStockRPC:
+        return &pb.ReserveStockRequest{}
+    case commitReservationRPC:
+        return &pb.CommitReservationRequest{}
+    case releaseReservationRPC:
+        return &pb.ReleaseReservationRequest{}
     default:
         return nil
     }
@@ -175,78 +232,131 @@ func generateErrorResponse(code codes.Code, message string) *ResponseData {
     }
 }
 
-func runLambda(reqData *RequestData) (*ResponseData, error) {
-    log.Infof("Handler started. Event data: %v", reqData)
+// ensureRequestID adopts the caller's request ID, or mints a new one if there is none,
+// and records it in the request headers so RPC functions can see it.
+func ensureRequestID(reqData *RequestData) string {
+    if reqData.Headers == nil {
+        reqData.Headers = make(map[string]string)
+    }
+    requestID := reqData.Headers[logging.RequestIDHeader]
+    if requestID == "" {
+        requestID = newRequestID()
+        reqData.Headers[logging.RequestIDHeader] = requestID
+    }
+    return requestID
+}
+
+// newRequestID generates a random (version 4) UUID string.
+func newRequestID() string {
+    b := make([]byte, 16)
+    _, _ = rand.Read(b)
+    b[6] = (b[6] & 0x0f) | 0x40
+    b[8] = (b[8] & 0x3f) | 0x80
+    return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// requestLogger returns a logger that tags every entry with the correlation data of a request.
+func requestLogger(headers *map[string]string) *logrus.Entry {
+    return logging.WithRequest(log, *headers)
+}
+
+func runLambda(ctx context.Context, reqData *RequestData) (*ResponseData, error) {
+    inv := logging.StartInvocation(ctx)
+    requestID := ensureRequestID(reqData)
+    if lambdaRequestID := logging.LambdaRequestID(ctx); lambdaRequestID != "" {
+        reqData.Headers[logging.LambdaRequestIDHeader] = lambdaRequestID
+    }
+    reqLog := requestLogger(&reqData.Headers)
+    defer inv.End(reqLog)
+
+    logged := *reqData
+    logged.Headers = redactHeaders(reqData.Headers)
+    reqLog.Infof("Handler started. Event data: %v", &logged)
 
     reqMsg, respData, err := decodeRequest(reqData)
     if err != nil {
         return nil, fmt.Errorf("error decoding request: %w", err)
 
     } else if respData == nil {
-        respMsg, rpcError := callRPC(reqMsg, reqData)
-
-        respData, err = encodeResponse(&respMsg, rpcError)
+        respData, err = serveRPC(reqMsg, reqData)
         if err != nil {
             return nil, fmt.Errorf("error encoding response: %w", err)
         }
     }
 
-    log.Infof("Handler finished. Response: %v", respData)
+    respData.Headers[logging.RequestIDHeader] = requestID
+    reqLog.Infof("Handler finished. Response: %v", respData)
     return respData, nil
 }
 
-func runHTTPServer() error {
-    httpHandler := func(w http.ResponseWriter, r *http.Request) {
-        reqBody, err := io.ReadAll(r.Body)
-        if err != nil {
-            log.Infof("Error reading request body: %v", err)
-            http.Error(w, "failed to read request body", http.StatusInternalServerError)
-            return
+// redactHeaders returns a copy of request headers with the credentials they carry hidden, for logs.
+func redactHeaders(headers map[string]string) map[string]string {
+    redacted := make(map[string]string, len(headers))
+    for k, v := range headers {
+        if k == "authorization" && v != "" {
+            v = "[redacted]"
         }
-        defer r.Body.Close()
+        redacted[k] = v
+    }
+    return redacted
+}
 
-        headers := make(map[string]string)
-        for k, vs := range r.Header {
-            s := vs[0]
-            for _, v := range vs[1:] {
-                s += "," + v
-            }
-            headers[strings.ToLower(k)] = s
-        }
+// flattenHeaders converts HTTP headers into the lowercase, comma-joined form that RequestData carries.
+func flattenHeaders(header http.Header) map[string]string {
+    headers := make(map[string]string, len(header))
+    for k, vs := range header {
+        headers[strings.ToLower(k)] = strings.Join(vs, ",")
+    }
+    return headers
+}
 
-        reqData := &RequestData{
-            BinBody:         reqBody,
-            Headers:         headers,
-            IsBase64Encoded: false,
-        }
+// httpHandler serves a single RPC over plain HTTP.
+func httpHandler(w http.ResponseWriter, r *http.Request) {
+    reqBody, err := io.ReadAll(r.Body)
+    if err != nil {
+        log.Infof("Error reading request body: %v", err)
+        http.Error(w, "failed to read request body", http.StatusInternalServerError)
+        return
+    }
+    defer r.Body.Close()
 
-        var respData *ResponseData
-        reqMsg, respData, err := decodeRequest(reqData)
-        if err != nil {
-            log.Infof("Error decoding request: %v", err)
-            http.Error(w, "failed to decode request", http.StatusInternalServerError)
-            return
+    headers := flattenHeaders(r.Header)
+    delete(headers, logging.LambdaRequestIDHeader)
 
-        } else if respData == nil {
-            respMsg, rpcError := callRPC(reqMsg, reqData)
+    reqData := &RequestData{
+        BinBody:         reqBody,
+        Headers:         headers,
+        IsBase64Encoded: false,
+    }
+    requestID := ensureRequestID(reqData)
 
-            respData, err = encodeResponse(&respMsg, rpcError)
-            if err != nil {
-                log.Infof("Error encoding response: %v", err)
-                http.Error(w, "failed to encode response", http.StatusInternalServerError)
-                return
-            }
-        }
+    var respData *ResponseData
+    reqMsg, respData, err := decodeRequest(reqData)
+    if err != nil {
+        log.Infof("Error decoding request: %v", err)
+        http.Error(w, "failed to decode request", http.StatusInternalServerError)
+        return
 
-        for k, v := range respData.Headers {
-            w.Header().Set(k, v)
-        }
-        w.WriteHeader(respData.StatusCode)
-        if _, err := w.Write(respData.BinBody); err != nil {
-            log.Infof("Error writing response: %v", err)
+    } else if respData == nil {
+        respData, err = serveRPC(reqMsg, reqData)
+        if err != nil {
+            log.Infof("Error encoding response: %v", err)
+            http.Error(w, "failed to encode response", http.StatusInternalServerError)
+            return
         }
     }
 
+    respData.Headers[logging.RequestIDHeader] = requestID
+    for k, v := range respData.Headers {
+        w.Header().Set(k, v)
+    }
+    w.WriteHeader(respData.StatusCode)
+    if _, err := w.Write(respData.BinBody); err != nil {
+        log.Infof("Error writing response: %v", err)
+    }
+}
+
+func runHTTPServer() error {
     port := defaultPort
     if p, ok := os.LookupEnv("PORT"); ok {
         port = p
@@ -258,6 +368,175 @@ func runHTTPServer() error {
     return http.ListenAndServe(addr+":"+port, nil)
 }
 
+// newCatalogSource creates the source of the catalog selected by CATALOG_SOURCE: file, postgres, s3 or alloydb. If
+// CATALOG_SOURCE isn't set, the catalog is read from AlloyDB if ALLOYDB_CLUSTER_NAME is set, and from a file otherwise.
+func newCatalogSource() CatalogSource {
+    kind, ok := os.LookupEnv("CATALOG_SOURCE")
+    if !ok {
+        kind = "file"
+        if os.Getenv("ALLOYDB_CLUSTER_NAME") != "" {
+            kind = "alloydb"
+        }
+    }
+    log.Infof("catalog source: %s", kind)
+
+    switch kind {
+    case "file":
+        path := os.Getenv("CATALOG_FILE")
+        if path == "" {
+            path = catalogFile
+        }
+        return newFileCatalogSource(path)
+    case "postgres":
+        dsn, ok := os.LookupEnv("POSTGRES_DSN")
+        if !ok {
+            log.Fatal("POSTGRES_DSN environment variable not set")
+        }
+        table := os.Getenv("CATALOG_TABLE")
+        if table == "" {
+            table = defaultCatalogTable
+        }
+        source, err := newPostgresCatalogSource(dsn, table)
+        if err != nil {
+            log.Fatalf("failed to parse POSTGRES_DSN: %v", err)
+        }
+        return source
+    case "s3":
+        bucket, ok := os.LookupEnv("CATALOG_S3_BUCKET")
+        if !ok {
+            log.Fatal("CATALOG_S3_BUCKET environment variable not set")
+        }
+        key := os.Getenv("CATALOG_S3_KEY")
+        if key == "" {
+            key = catalogFile
+        }
+        source, err := newS3CatalogSource(bucket, key, os.Getenv("S3_ENDPOINT"))
+        if err != nil {
+            log.Fatalf("failed to create the S3 catalog source: %v", err)
+        }
+        return source
+    case "alloydb":
+        return newAlloyDBCatalogSource()
+    default:
+        log.Fatalf("unknown CATALOG_SOURCE %q, expected file, postgres, s3 or alloydb", kind)
+        return nil
+    }
+}
+
+// configureCatalogAdmin enables the admin RPCs for the admins of CATALOG_ADMIN_TOKENS, a list of name:token pairs
+// separated by commas, and records their changes in the file CATALOG_AUDIT_LOG, or in the service's logs if it isn't
+// set.
+func configureCatalogAdmin() {
+    tokens := os.Getenv("CATALOG_ADMIN_TOKENS")
+    if tokens == "" {
+        return
+    }
+    admin, err := parseAdminTokens(tokens)
+    if err != nil {
+        log.Fatalf("failed to parse CATALOG_ADMIN_TOKENS: %v", err)
+    }
+    svc.admin = admin
+    if path := os.Getenv("CATALOG_AUDIT_LOG"); path != "" {
+        svc.audit = &fileAuditLog{path: path}
+    }
+    if _, ok := svc.source.(CatalogStore); !ok {
+        log.Warnf("the admin RPCs are enabled, but the catalog source (%v) can't be changed", svc.source)
+    }
+    log.Infof("catalog admin RPCs enabled for %d admins", len(admin.admins))
+}
+
+// configureInventory sets up where the stock of products is counted: in the table INVENTORY_TABLE of a postgres or
+// alloydb catalog source, in the object INVENTORY_S3_KEY of the bucket of an s3 one, or in memory, from the levels of
+// INVENTORY_FILE. A table or an object is shared by every instance of the service. The stock in memory is counted by
+// every instance on its own instead, which is refused in Lambda, whose execution environments come and go, each with
+// its own count, so the reservations wouldn't hold. Without any of them, the stock of no product is counted.
+func configureInventory() {
+    table, key, path := os.Getenv("INVENTORY_TABLE"), os.Getenv("INVENTORY_S3_KEY"), os.Getenv("INVENTORY_FILE")
+    set := 0
+    for _, v := range []string{table, key, path} {
+        if v != "" {
+            set++
+        }
+    }
+    if set > 1 {
+        log.Fatal("only one of INVENTORY_TABLE, INVENTORY_S3_KEY and INVENTORY_FILE can be set")
+    }
+
+    switch {
+    case table != "":
+        source, ok := svc.source.(*postgresCatalogSource)
+        if !ok {
+            log.Fatalf("INVENTORY_TABLE needs a postgres or alloydb catalog source, not %v", svc.source)
+        }
+        store := &postgresStockStore{source: source, table: table}
+        svc.stockStore = store
+        log.Infof("counting the stock in %v", store)
+    case key != "":
+        source, ok := svc.source.(*s3CatalogSource)
+        if !ok {
+            log.Fatalf("INVENTORY_S3_KEY needs an s3 catalog source, not %v", svc.source)
+        }
+        store := newS3StockStore(source, key)
+        svc.stockStore = store
+        log.Infof("counting the stock in %v", store)
+    case path != "":
+        if runningInLambda {
+            log.Fatal("INVENTORY_FILE can't be used in Lambda, as every execution environment would count the stock on its own; use INVENTORY_TABLE or INVENTORY_S3_KEY")
+        }
+        inv, err := loadInventory(path)
+        if err != nil {
+            log.Fatalf("failed to load INVENTORY_FILE (%s): %v", path, err)
+        }
+        svc.stockStore = inv
+        log.Infof("counting the stock of %d products in memory", len(inv.onHand))
+    }
+}
+
+// catalogPollInterval returns how often the catalog is reloaded besides the reloads on changes: CATALOG_POLL_INTERVAL,
+// which defaults to a minute for a table or an object and to never for a file.
+func catalogPollInterval() time.Duration {
+    if s := os.Getenv("CATALOG_POLL_INTERVAL"); s != "" {
+        v, err := time.ParseDuration(s)
+        if err != nil {
+            log.Fatalf("failed to parse CATALOG_POLL_INTERVAL (%s) as time.Duration: %v", s, err)
+        }
+        return v
+    }
+    if _, ok := svc.source.(*fileCatalogSource); ok {
+        return 0
+    }
+    return time.Minute
+}
+
+// startCatalogReloader reloads the catalog in the background, as configured by the environment: on SIGHUP, when the
+// catalog file changes unless CATALOG_WATCH is false, and every CATALOG_POLL_INTERVAL, which defaults to a minute for
+// a catalog in a database or a bucket and to never for a file.
+func startCatalogReloader() {
+    reloader := &catalogReloader{catalog: svc}
+    if file, ok := svc.source.(*fileCatalogSource); ok {
+        reloader.file = file.path
+        if s := os.Getenv("CATALOG_WATCH"); s != "" {
+            watch, err := strconv.ParseBool(s)
+            if err != nil {
+                log.Fatalf("failed to parse CATALOG_WATCH (%s) as a bool: %v", s, err)
+            }
+            if !watch {
+                reloader.file = ""
+            }
+        }
+    }
+    reloader.pollInterval = catalogPollInterval()
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGHUP)
+    reloader.signals = signals
+
+    if _, err := reloader.start(context.Background()); err != nil {
+        log.Warnf("failed to start reloading the catalog: %v", err)
+        return
+    }
+    log.Infof("reloading the catalog on SIGHUP (watching %q, polling every %v)", reloader.file, reloader.pollInterval)
+}
+
 func main() {
     flag.Parse()
 
@@ -273,26 +552,21 @@ func main() {
         extraLatency = time.Duration(0)
     }
 
-    reloadCatalog = false // todo: disabled reload signaling. perhaps debug and enable later?
-    //sigs := make(chan os.Signal, 1)
-    //signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
-    //go func() {
-    //    for {
-    //        sig := <-sigs
-    //        log.Printf("Received signal: %s", sig)
-    //        if sig == syscall.SIGUSR1 {
-    //            reloadCatalog = true
-    //            log.Infof("Enable catalog reloading")
-    //        } else {
-    //            reloadCatalog = false
-    //            log.Infof("Disable catalog reloading")
-    //        }
-    //    }
-    //}()
+    endCatalogPhase := logging.InitPhase("catalog")
+    svc.source = newCatalogSource()
+    configureCatalogAdmin()
+    configureInventory()
+    svc.snapshot()
+    endCatalogPhase()
+    logging.InitDone(log)
 
     if runningInLambda {
+        // A Lambda function is frozen between invocations, so instead of a reloader, the requests reload the catalog
+        // once it's older than the poll interval. A file only changes with a new deployment.
+        svc.maxAge = catalogPollInterval()
         lambda.Start(runLambda)
     } else {
+        startCatalogReloader()
         if err := runHTTPServer(); err != nil {
             log.Fatalf("HTTP server ended with error: %v", err)
         }
@@ -0,0 +1,261 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "net/http"
+    "net/http/httptest"
+    "sort"
+    "testing"
+
+    "github.com/sirupsen/logrus"
+    "google.golang.org/protobuf/proto"
+
+    "logging"
+    pb "productcatalogservice/genproto"
+)
+
+// benchRequests holds a representative request for every RPC of the service.
+var benchRequests = map[string]proto.Message{
+    listProductsRPC: &pb.ListProductsRequest{},
+    getProductRPC: &pb.GetProductRequest{
+        Id: "OLJCESPC7Z",
+    },
+    searchProductsRPC: &pb.SearchProductsRequest{
+        Query: "kitchen",
+    },
+}
+
+// setupBench prepares the service for a benchmark, loading the catalog from products.json.
+func setupBench(b *testing.B) {
+    quietLogs(b)
+    if len(loaded(b, svc).products) == 0 {
+        b.Fatal("the catalog is empty")
+    }
+}
+
+// quietLogs keeps the per-request log lines out of the measurements.
+func quietLogs(b testing.TB) {
+    level := log.Logger.GetLevel()
+    log.Logger.SetLevel(logrus.WarnLevel)
+    b.Cleanup(func() { log.Logger.SetLevel(level) })
+}
+
+// setLambdaMode switches encodeResponse between its Lambda and HTTP outputs for a benchmark.
+func setLambdaMode(b *testing.B, on bool) {
+    prev := runningInLambda
+    runningInLambda = on
+    b.Cleanup(func() { runningInLambda = prev })
+}
+
+// benchRPCNames returns the RPC names in a stable order.
+func benchRPCNames() []string {
+    names := make([]string, 0, len(benchRequests))
+    for name := range benchRequests {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// benchHeaders returns the headers a caller sends with every request.
+func benchHeaders(rpcName string) map[string]string {
+    return map[string]string{
+        logging.RPCNameHeader:   rpcName,
+        logging.RequestIDHeader: "00000000-0000-4000-8000-000000000000",
+        "content-type":          "application/octet-stream",
+    }
+}
+
+// benchRequestData builds the RequestData of an RPC, as an API Gateway event or as an HTTP request.
+func benchRequestData(b *testing.B, rpcName string, lambdaMode bool) *RequestData {
+    binReq, err := proto.Marshal(benchRequests[rpcName])
+    if err != nil {
+        b.Fatal(err)
+    }
+    if lambdaMode {
+        return &RequestData{
+            Headers:         benchHeaders(rpcName),
+            IsBase64Encoded: true,
+            Body:            base64.StdEncoding.EncodeToString(binReq),
+        }
+    }
+    return &RequestData{
+        Headers: benchHeaders(rpcName),
+        BinBody: binReq,
+    }
+}
+
+// BenchmarkDecodeRequest measures base64 decoding and proto unmarshalling of requests.
+func BenchmarkDecodeRequest(b *testing.B) {
+    setupBench(b)
+    for _, rpcName := range benchRPCNames() {
+        for _, mode := range []string{"lambda", "http"} {
+            reqData := benchRequestData(b, rpcName, mode == "lambda")
+            b.Run(rpcName+"/"+mode, func(b *testing.B) {
+                b.ReportAllocs()
+                for i := 0; i < b.N; i++ {
+                    if _, respData, err := decodeRequest(reqData); err != nil || respData != nil {
+                        b.Fatalf("decodeRequest failed: %v %v", err, respData)
+                    }
+                }
+            })
+        }
+    }
+}
+
+// BenchmarkEncodeResponse measures proto marshalling and base64 encoding of responses.
+func BenchmarkEncodeResponse(b *testing.B) {
+    setupBench(b)
+    for _, rpcName := range benchRPCNames() {
+        reqData := benchRequestData(b, rpcName, false)
+        reqMsg, _, err := decodeRequest(reqData)
+        if err != nil {
+            b.Fatal(err)
+        }
+        respMsg, err := callRPC(reqMsg, reqData)
+        if err != nil {
+            b.Fatal(err)
+        }
+
+        for _, mode := range []string{"lambda", "http"} {
+            b.Run(rpcName+"/"+mode, func(b *testing.B) {
+                setLambdaMode(b, mode == "lambda")
+                b.ReportAllocs()
+                for i := 0; i < b.N; i++ {
+                    if _, err := encodeResponse(&respMsg, nil); err != nil {
+                        b.Fatal(err)
+                    }
+                }
+            })
+        }
+    }
+}
+
+// BenchmarkFlattenHeaders measures the header conversion of the HTTP server.
+func BenchmarkFlattenHeaders(b *testing.B) {
+    header := http.Header{}
+    for k, v := range benchHeaders(getProductRPC) {
+        header.Set(k, v)
+    }
+    header.Set("user-agent", "Go-http-client/1.1")
+    header.Set("accept-encoding", "gzip")
+    header.Add("x-forwarded-for", "10.0.0.1")
+    header.Add("x-forwarded-for", "10.0.0.2")
+
+    b.ReportAllocs()
+    for i := 0; i < b.N; i++ {
+        flattenHeaders(header)
+    }
+}
+
+// BenchmarkRunLambda measures the whole in-process Lambda request path.
+func BenchmarkRunLambda(b *testing.B) {
+    setupBench(b)
+    setLambdaMode(b, true)
+    for _, rpcName := range benchRPCNames() {
+        reqData := benchRequestData(b, rpcName, true)
+        b.Run(rpcName, func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := runLambda(context.Background(), reqData); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+// BenchmarkHTTPHandler measures the whole in-process HTTP request path.
+func BenchmarkHTTPHandler(b *testing.B) {
+    setupBench(b)
+    setLambdaMode(b, false)
+    for _, rpcName := range benchRPCNames() {
+        reqData := benchRequestData(b, rpcName, false)
+        b.Run(rpcName, func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqData.BinBody))
+                for k, v := range reqData.Headers {
+                    r.Header.Set(k, v)
+                }
+                w := httptest.NewRecorder()
+                httpHandler(w, r)
+                if w.Code != http.StatusOK {
+                    b.Fatalf("unexpected status: %d", w.Code)
+                }
+            }
+        })
+    }
+}
+
+// BenchmarkHTTPLoopback measures the HTTP request path over a loopback connection.
+func BenchmarkHTTPLoopback(b *testing.B) {
+    setupBench(b)
+    setLambdaMode(b, false)
+    server := httptest.NewServer(http.HandlerFunc(httpHandler))
+    b.Cleanup(server.Close)
+
+    for _, rpcName := range benchRPCNames() {
+        reqData := benchRequestData(b, rpcName, false)
+        b.Run(rpcName, func(b *testing.B) {
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(reqData.BinBody))
+                if err != nil {
+                    b.Fatal(err)
+                }
+                for k, v := range reqData.Headers {
+                    req.Header.Set(k, v)
+                }
+                resp, err := server.Client().Do(req)
+                if err != nil {
+                    b.Fatal(err)
+                }
+                resp.Body.Close()
+                if resp.StatusCode != http.StatusOK || resp.Header.Get("grpc-status") != "0" {
+                    b.Fatalf("unexpected response: %s grpc-status=%s", resp.Status, resp.Header.Get("grpc-status"))
+                }
+            }
+        })
+    }
+}
+
+func TestCatalogETag(t *testing.T) {
+    quietLogs(t)
+    serve := func(rpcName, ifNoneMatch string) *httptest.ResponseRecorder {
+        binReq, err := proto.Marshal(benchRequests[rpcName])
+        if err != nil {
+            t.Fatal(err)
+        }
+        r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(binReq))
+        for k, v := range benchHeaders(rpcName) {
+            r.Header.Set(k, v)
+        }
+        if ifNoneMatch != "" {
+            r.Header.Set("if-none-match", ifNoneMatch)
+        }
+        w := httptest.NewRecorder()
+        httpHandler(w, r)
+        return w
+    }
+
+    first := serve(getProductRPC, "")
+    etag := first.Header().Get("etag")
+    if first.Code != http.StatusOK || etag == "" {
+        t.Fatalf("GetProduct returned %d with ETag %q, want 200 and an ETag", first.Code, etag)
+    }
+    if w := serve(listProductsRPC, ""); w.Header().Get("etag") != etag {
+        t.Errorf("ListProducts returned ETag %q, want the GetProduct one, %q", w.Header().Get("etag"), etag)
+    }
+    if w := serve(getProductRPC, etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("grpc-status") != "0" {
+        t.Errorf("GetProduct with the current ETag returned %d and %d bytes, want 304 without a body", w.Code, w.Body.Len())
+    }
+    if w := serve(getProductRPC, `"stale"`); w.Code != http.StatusOK || w.Body.Len() == 0 {
+        t.Errorf("GetProduct with a stale ETag returned %d, want 200 with the product", w.Code)
+    }
+    if w := serve(searchProductsRPC, etag); w.Code != http.StatusOK || w.Header().Get("etag") != "" {
+        t.Errorf("SearchProducts returned %d with ETag %q, want 200 and no ETag", w.Code, w.Header().Get("etag"))
+    }
+}
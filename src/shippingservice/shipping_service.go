@@ -2,15 +2,15 @@ package main
 
 import (
     "fmt"
-    "log"
 
-    pb "main/genproto"
+    pb "shippingservice/genproto"
 )
 
 // GetQuote produces a shipping quote (cost) in USD.
 func handleGetQuote(in *pb.GetQuoteRequest, headers *map[string]string) (*pb.GetQuoteResponse, error) {
-    log.Print("[GetQuote] received request")
-    defer log.Print("[GetQuote] completed request")
+    reqLog := requestLogger(headers)
+    reqLog.Print("[GetQuote] received request")
+    defer reqLog.Print("[GetQuote] completed request")
 
     // 1. Generate a quote based on the total number of items to be shipped.
     quote := CreateQuoteFromCount(0)
@@ -27,8 +27,9 @@ func handleGetQuote(in *pb.GetQuoteRequest, headers *map[string]string) (*pb.Get
 // ShipOrder mocks that the requested items will be shipped.
 // It supplies a tracking ID for notional lookup of shipment delivery status.
 func handleShipOrder(in *pb.ShipOrderRequest, headers *map[string]string) (*pb.ShipOrderResponse, error) {
-    log.Print("[ShipOrder] received request")
-    defer log.Print("[ShipOrder] completed request")
+    reqLog := requestLogger(headers)
+    reqLog.Print("[ShipOrder] received request")
+    defer reqLog.Print("[ShipOrder] completed request")
     // 1. Create a Tracking ID
     baseAddress := fmt.Sprintf("%s, %s, %s", in.Address.StreetAddress, in.Address.City, in.Address.State)
     id := CreateTrackingId(baseAddress)
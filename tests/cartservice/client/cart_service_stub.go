@@ -15,10 +15,13 @@ var (
 )
 
 const (
-    cartService  = "cart-service"
-    addItemRPC   = "add-item"
-    getCartRPC   = "get-cart"
-    emptyCartRPC = "empty-cart"
+    cartService        = "cart-service"
+    addItemRPC         = "add-item"
+    getCartRPC         = "get-cart"
+    emptyCartRPC       = "empty-cart"
+    removeItemRPC      = "remove-item"
+    setItemQuantityRPC = "set-item-quantity"
+    mergeCartsRPC      = "merge-carts"
 )
 
 // AddItem represents the CartService/AddItem RPC.
@@ -84,6 +87,69 @@ func EmptyCart(request *pb.EmptyCartRequest, header *http.Header) (*pb.Empty, er
     return (*msg).(*pb.Empty), nil
 }
 
+// RemoveItem represents the CartService/RemoveItem RPC.
+// context can be sent as custom headers.
+func RemoveItem(request *pb.RemoveItemRequest, header *http.Header) (*pb.Empty, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*cartServiceAddr, cartService, removeItemRPC, &binReq, header, *cartServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, removeItemRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Empty), nil
+}
+
+// SetItemQuantity represents the CartService/SetItemQuantity RPC.
+// context can be sent as custom headers.
+func SetItemQuantity(request *pb.SetItemQuantityRequest, header *http.Header) (*pb.Empty, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*cartServiceAddr, cartService, setItemQuantityRPC, &binReq, header, *cartServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, setItemQuantityRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Empty), nil
+}
+
+// MergeCarts represents the CartService/MergeCarts RPC.
+// context can be sent as custom headers.
+func MergeCarts(request *pb.MergeCartsRequest, header *http.Header) (*pb.Cart, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*cartServiceAddr, cartService, mergeCartsRPC, &binReq, header, *cartServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, mergeCartsRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Cart), nil
+}
+
 // init loads the address and timeout variables.
 func init() {
     a, ok := os.LookupEnv("CART_SERVICE_ADDR")
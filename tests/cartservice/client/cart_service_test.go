@@ -55,6 +55,59 @@ func TestCartService(t *testing.T) {
         t.Fatalf("quantity is %d, expected %d", cart.Items[0].Quantity, 2)
     }
 
+    // Change the quantity of the item
+    _, err = SetItemQuantity(
+        &pb.SetItemQuantityRequest{UserId: userId, Item: &pb.CartItem{ProductId: productId, Quantity: 5}},
+        nil,
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    cart, err = GetCart(&pb.GetCartRequest{UserId: userId}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(cart.Items) != 1 || cart.Items[0].Quantity != 5 {
+        t.Fatalf("cart is %v, expected one item with quantity 5", cart.Items)
+    }
+
+    // Remove the item
+    _, err = RemoveItem(&pb.RemoveItemRequest{UserId: userId, ProductId: productId}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cart, err = GetCart(&pb.GetCartRequest{UserId: userId}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(cart.Items) != 0 {
+        t.Fatal("cart is not empty after removing its only item")
+    }
+
+    // Merge another user's cart into the cart
+    anonymousId := "anonymous0"
+    _, err = AddItem(
+        &pb.AddItemRequest{UserId: anonymousId, Item: &pb.CartItem{ProductId: productId, Quantity: 3}},
+        nil,
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    cart, err = MergeCarts(&pb.MergeCartsRequest{SourceUserId: anonymousId, TargetUserId: userId}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(cart.Items) != 1 || cart.Items[0].Quantity != 3 {
+        t.Fatalf("merged cart is %v, expected one item with quantity 3", cart.Items)
+    }
+    cart, err = GetCart(&pb.GetCartRequest{UserId: anonymousId}, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(cart.Items) != 0 {
+        t.Fatal("merged cart is not empty")
+    }
+
     // Empty the cart
     _, err = EmptyCart(&pb.EmptyCartRequest{UserId: userId}, nil)
     if err != nil {
@@ -25,7 +25,7 @@ func determineMessageType(rpcName string) proto.Message {
     switch rpcName {
     case addItemRPC:
         msg = &pb.Empty{}
-    case getCartRPC:
+    case getCartRPC, mergeCartsRPC:
         msg = &pb.Cart{}
     default:
         msg = &pb.Empty{}
@@ -0,0 +1,5 @@
+module loaddriver
+
+go 1.22.3
+
+require google.golang.org/protobuf v1.34.2
@@ -0,0 +1,218 @@
+// Command loaddriver replays representative requests against the services, either running their HTTP servers or
+// running as Lambda functions behind an invocation endpoint, and writes the measurements as a JSON report.
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "google.golang.org/protobuf/proto"
+)
+
+// target is a service endpoint to drive, in the form service=mode=url.
+type target struct {
+    service string
+    mode    string
+    url     string
+}
+
+type targetList []target
+
+func (t *targetList) String() string {
+    parts := make([]string, len(*t))
+    for i, tg := range *t {
+        parts[i] = tg.service + "=" + tg.mode + "=" + tg.url
+    }
+    return strings.Join(parts, ",")
+}
+
+func (t *targetList) Set(value string) error {
+    parts := strings.SplitN(value, "=", 3)
+    if len(parts) != 3 {
+        return fmt.Errorf("expected service=mode=url, got %q", value)
+    }
+    if _, ok := scenarios[parts[0]]; !ok {
+        return fmt.Errorf("unknown service %q", parts[0])
+    }
+    if parts[1] != httpMode && parts[1] != lambdaMode {
+        return fmt.Errorf("unknown mode %q, expected %q or %q", parts[1], httpMode, lambdaMode)
+    }
+    *t = append(*t, target{service: parts[0], mode: parts[1], url: parts[2]})
+    return nil
+}
+
+// options control the load put on each target.
+type options struct {
+    duration    time.Duration
+    requests    int
+    concurrency int
+    warmup      int
+    timeout     time.Duration
+}
+
+func main() {
+    var targets targetList
+    var opts options
+    flag.Var(&targets, "target", "service=mode=url to drive; mode is http or lambda (repeatable)")
+    flag.DurationVar(&opts.duration, "duration", 10*time.Second, "how long to drive each target")
+    flag.IntVar(&opts.requests, "requests", 0, "stop after this many measured requests per target (0 = no limit)")
+    flag.IntVar(&opts.concurrency, "concurrency", 4, "number of concurrent workers per target")
+    flag.IntVar(&opts.warmup, "warmup", 10, "requests per target to send before measuring")
+    flag.DurationVar(&opts.timeout, "timeout", 10*time.Second, "timeout of a single request")
+    benchFile := flag.String("bench", "", "file with `go test -bench` output to include in the report")
+    out := flag.String("out", "-", "where to write the JSON report (- for stdout)")
+    flag.Parse()
+
+    if len(targets) == 0 && *benchFile == "" {
+        flag.Usage()
+        os.Exit(2)
+    }
+    if opts.concurrency < 1 {
+        opts.concurrency = 1
+    }
+
+    report := Report{
+        StartedAt:   time.Now().UTC(),
+        GoVersion:   runtime.Version(),
+        Concurrency: opts.concurrency,
+    }
+    for _, tg := range targets {
+        log.Printf("driving %s in %s mode at %s", tg.service, tg.mode, tg.url)
+        result, err := drive(tg, opts)
+        if err != nil {
+            log.Fatalf("failed to drive %s: %v", tg.service, err)
+        }
+        log.Printf("%s/%s: %d requests, %d errors, %.1f req/s, p50 %.2fms, p99 %.2fms", tg.service, tg.mode,
+            result.Total.Requests, result.Total.Errors, result.Total.ThroughputRPS, result.Total.LatencyMs.P50, result.Total.LatencyMs.P99)
+        report.Targets = append(report.Targets, *result)
+    }
+
+    if *benchFile != "" {
+        f, err := os.Open(*benchFile)
+        if err != nil {
+            log.Fatalf("failed to open benchmark output: %v", err)
+        }
+        report.Benchmarks, err = parseBenchmarks(f)
+        f.Close()
+        if err != nil {
+            log.Fatalf("failed to read benchmark output: %v", err)
+        }
+    }
+
+    if err := writeReport(&report, *out); err != nil {
+        log.Fatalf("failed to write report: %v", err)
+    }
+}
+
+// drive replays the scenario of a service against a target and summarizes the measured requests.
+func drive(tg target, opts options) (*TargetResult, error) {
+    client := &http.Client{
+        Timeout: opts.timeout,
+        Transport: &http.Transport{
+            MaxIdleConns:        opts.concurrency,
+            MaxIdleConnsPerHost: opts.concurrency,
+        },
+    }
+    inv, err := newInvoker(tg.mode, tg.url, client)
+    if err != nil {
+        return nil, err
+    }
+
+    calls := scenarios[tg.service]
+    binReqs := make([][]byte, len(calls))
+    for i, c := range calls {
+        if binReqs[i], err = proto.Marshal(c.request); err != nil {
+            return nil, fmt.Errorf("failed to marshal %s request: %w", c.rpcName, err)
+        }
+    }
+    send := func(n int) sample {
+        i := n % len(calls)
+        start := time.Now()
+        sent, received, err := inv.invoke(&calls[i], binReqs[i], fmt.Sprintf("loaddriver-%s-%d", tg.mode, n))
+        return sample{rpcName: calls[i].rpcName, latency: time.Since(start), sent: sent, received: received, err: err}
+    }
+
+    result := &TargetResult{Service: tg.service, Mode: tg.mode, URL: tg.url}
+    first := send(0)
+    result.FirstRequestMs = milliseconds(first.latency)
+    if first.err != nil {
+        log.Printf("first request to %s failed: %v", tg.service, first.err)
+    }
+    for n := 1; n <= opts.warmup; n++ {
+        send(n)
+    }
+
+    var (
+        counter   atomic.Int64
+        wg        sync.WaitGroup
+        perWorker = make([][]sample, opts.concurrency)
+        memBefore runtime.MemStats
+        memAfter  runtime.MemStats
+    )
+    counter.Store(int64(opts.warmup))
+    deadline := time.Now().Add(opts.duration)
+
+    runtime.ReadMemStats(&memBefore)
+    start := time.Now()
+    for w := 0; w < opts.concurrency; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for time.Now().Before(deadline) {
+                n := counter.Add(1)
+                if opts.requests > 0 && n > int64(opts.warmup+opts.requests) {
+                    return
+                }
+                perWorker[w] = append(perWorker[w], send(int(n)))
+            }
+        }(w)
+    }
+    wg.Wait()
+    elapsed := time.Since(start)
+    runtime.ReadMemStats(&memAfter)
+
+    var samples []sample
+    for _, s := range perWorker {
+        samples = append(samples, s...)
+    }
+    result.Total = summarize(samples, elapsed)
+    if len(samples) > 0 {
+        result.DriverAllocsPerRequest = float64(memAfter.Mallocs-memBefore.Mallocs) / float64(len(samples))
+        result.DriverAllocBytesPerRequest = float64(memAfter.TotalAlloc-memBefore.TotalAlloc) / float64(len(samples))
+    }
+
+    byRPC := make(map[string][]sample)
+    for _, s := range samples {
+        byRPC[s.rpcName] = append(byRPC[s.rpcName], s)
+    }
+    result.RPCs = make(map[string]Stats, len(byRPC))
+    for rpcName, s := range byRPC {
+        result.RPCs[rpcName] = summarize(s, elapsed)
+    }
+    return result, nil
+}
+
+// writeReport writes the report as indented JSON to a file, or to stdout if path is "-".
+func writeReport(report *Report, path string) error {
+    sort.SliceStable(report.Targets, func(i, j int) bool { return report.Targets[i].Service < report.Targets[j].Service })
+    data, err := json.MarshalIndent(report, "", "  ")
+    if err != nil {
+        return err
+    }
+    data = append(data, '\n')
+    if path == "-" {
+        _, err = os.Stdout.Write(data)
+        return err
+    }
+    return os.WriteFile(path, data, 0o644)
+}
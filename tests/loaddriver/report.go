@@ -0,0 +1,177 @@
+package main
+
+import (
+    "bufio"
+    "io"
+    "math"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Report is the machine-readable output of a run.
+type Report struct {
+    StartedAt   time.Time      `json:"started_at"`
+    GoVersion   string         `json:"go_version"`
+    Concurrency int            `json:"concurrency"`
+    Targets     []TargetResult `json:"targets"`
+    Benchmarks  []Benchmark    `json:"benchmarks,omitempty"`
+}
+
+// TargetResult holds the measurements of one service in one mode.
+type TargetResult struct {
+    Service string `json:"service"`
+    Mode    string `json:"mode"`
+    URL     string `json:"url"`
+
+    // FirstRequestMs is the latency of the very first request, which includes a cold start in Lambda mode.
+    FirstRequestMs float64 `json:"first_request_ms"`
+
+    Total Stats            `json:"total"`
+    RPCs  map[string]Stats `json:"rpcs"`
+
+    // Allocations of the driver itself, not of the service, per measured request: they show the cost of the client side
+    // of the transport.
+    DriverAllocsPerRequest     float64 `json:"driver_allocs_per_request"`
+    DriverAllocBytesPerRequest float64 `json:"driver_alloc_bytes_per_request"`
+}
+
+// Stats summarizes the requests of a target, or of one of its RPCs.
+type Stats struct {
+    Requests      int     `json:"requests"`
+    Errors        int     `json:"errors"`
+    DurationSec   float64 `json:"duration_sec"`
+    ThroughputRPS float64 `json:"throughput_rps"`
+    BytesSent     int64   `json:"bytes_sent"`
+    BytesReceived int64   `json:"bytes_received"`
+    LatencyMs     Latency `json:"latency_ms"`
+    FirstError    string  `json:"first_error,omitempty"`
+}
+
+// Latency holds latency percentiles, in milliseconds.
+type Latency struct {
+    Min  float64 `json:"min"`
+    Mean float64 `json:"mean"`
+    P50  float64 `json:"p50"`
+    P90  float64 `json:"p90"`
+    P95  float64 `json:"p95"`
+    P99  float64 `json:"p99"`
+    Max  float64 `json:"max"`
+}
+
+// Benchmark is one result line of `go test -bench`.
+type Benchmark struct {
+    Package     string  `json:"package,omitempty"`
+    Name        string  `json:"name"`
+    Iterations  int64   `json:"iterations"`
+    NsPerOp     float64 `json:"ns_per_op"`
+    MBPerSec    float64 `json:"mb_per_sec,omitempty"`
+    BytesPerOp  float64 `json:"bytes_per_op"`
+    AllocsPerOp float64 `json:"allocs_per_op"`
+}
+
+// sample is the outcome of a single request.
+type sample struct {
+    rpcName  string
+    latency  time.Duration
+    sent     int
+    received int
+    err      error
+}
+
+// summarize computes the stats of a set of samples taken over the given wall-clock duration.
+func summarize(samples []sample, elapsed time.Duration) Stats {
+    stats := Stats{
+        Requests:    len(samples),
+        DurationSec: elapsed.Seconds(),
+    }
+    if len(samples) == 0 {
+        return stats
+    }
+
+    latencies := make([]time.Duration, 0, len(samples))
+    var sum time.Duration
+    for _, s := range samples {
+        if s.err != nil {
+            stats.Errors++
+            if stats.FirstError == "" {
+                stats.FirstError = s.err.Error()
+            }
+        }
+        stats.BytesSent += int64(s.sent)
+        stats.BytesReceived += int64(s.received)
+        latencies = append(latencies, s.latency)
+        sum += s.latency
+    }
+    sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+    if elapsed > 0 {
+        stats.ThroughputRPS = float64(len(samples)) / elapsed.Seconds()
+    }
+    stats.LatencyMs = Latency{
+        Min:  milliseconds(latencies[0]),
+        Mean: milliseconds(sum / time.Duration(len(latencies))),
+        P50:  milliseconds(percentile(latencies, 50)),
+        P90:  milliseconds(percentile(latencies, 90)),
+        P95:  milliseconds(percentile(latencies, 95)),
+        P99:  milliseconds(percentile(latencies, 99)),
+        Max:  milliseconds(latencies[len(latencies)-1]),
+    }
+    return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+    rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+    if rank < 1 {
+        rank = 1
+    }
+    return sorted[rank-1]
+}
+
+// milliseconds converts a duration to fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+    return float64(d) / float64(time.Millisecond)
+}
+
+// parseBenchmarks reads the output of `go test -bench` and returns its result lines.
+func parseBenchmarks(r io.Reader) ([]Benchmark, error) {
+    var benchmarks []Benchmark
+    pkg := ""
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) == 2 && fields[0] == "pkg:" {
+            pkg = fields[1]
+            continue
+        }
+        if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+            continue
+        }
+        iterations, err := strconv.ParseInt(fields[1], 10, 64)
+        if err != nil {
+            continue
+        }
+
+        b := Benchmark{Package: pkg, Name: fields[0], Iterations: iterations}
+        for i := 2; i+1 < len(fields); i += 2 {
+            value, err := strconv.ParseFloat(fields[i], 64)
+            if err != nil {
+                continue
+            }
+            switch fields[i+1] {
+            case "ns/op":
+                b.NsPerOp = value
+            case "MB/s":
+                b.MBPerSec = value
+            case "B/op":
+                b.BytesPerOp = value
+            case "allocs/op":
+                b.AllocsPerOp = value
+            }
+        }
+        benchmarks = append(benchmarks, b)
+    }
+    return benchmarks, scanner.Err()
+}
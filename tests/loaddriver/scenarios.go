@@ -0,0 +1,66 @@
+package main
+
+import (
+    "google.golang.org/protobuf/proto"
+
+    pb "loaddriver/genproto"
+)
+
+// call is one RPC request that is replayed against a service.
+type call struct {
+    rpcName  string
+    request  proto.Message
+    response func() proto.Message
+}
+
+var (
+    address = &pb.Address{
+        StreetAddress: "1600 Amphitheatre Parkway",
+        City:          "Mountain View",
+        State:         "CA",
+        Country:       "United States",
+        ZipCode:       94043,
+    }
+    items = []*pb.CartItem{
+        {ProductId: "OLJCESPC7Z", Quantity: 1},
+        {ProductId: "66VCHSJNUP", Quantity: 3},
+        {ProductId: "1YMWWN1N4O", Quantity: 2},
+    }
+)
+
+// scenarios holds the requests replayed against each service, in the order they are sent.
+// The requests mirror the ones the frontend sends while a user browses the shop and checks out.
+var scenarios = map[string][]call{
+    "adservice": {
+        {"get-ads", &pb.AdRequest{ContextKeys: []string{"clothing", "accessories"}}, func() proto.Message { return &pb.AdResponse{} }},
+        {"get-ads", &pb.AdRequest{}, func() proto.Message { return &pb.AdResponse{} }},
+    },
+    "cartservice": {
+        {"add-item", &pb.AddItemRequest{UserId: "loaddriver", Item: items[0]}, func() proto.Message { return &pb.Empty{} }},
+        {"get-cart", &pb.GetCartRequest{UserId: "loaddriver"}, func() proto.Message { return &pb.Cart{} }},
+        {"empty-cart", &pb.EmptyCartRequest{UserId: "loaddriver"}, func() proto.Message { return &pb.Empty{} }},
+    },
+    "checkoutservice": {
+        {"place-order", &pb.PlaceOrderRequest{
+            UserId:       "loaddriver",
+            UserCurrency: "USD",
+            Address:      address,
+            Email:        "someone@example.com",
+            CreditCard: &pb.CreditCardInfo{
+                CreditCardNumber:          "4432-8015-6152-0454",
+                CreditCardCvv:             672,
+                CreditCardExpirationYear:  2039,
+                CreditCardExpirationMonth: 1,
+            },
+        }, func() proto.Message { return &pb.PlaceOrderResponse{} }},
+    },
+    "productcatalogservice": {
+        {"list-products", &pb.Empty{}, func() proto.Message { return &pb.ListProductsResponse{} }},
+        {"get-product", &pb.GetProductRequest{Id: "OLJCESPC7Z"}, func() proto.Message { return &pb.Product{} }},
+        {"search-products", &pb.SearchProductsRequest{Query: "kitchen"}, func() proto.Message { return &pb.SearchProductsResponse{} }},
+    },
+    "shippingservice": {
+        {"get-quote", &pb.GetQuoteRequest{Address: address, Items: items}, func() proto.Message { return &pb.GetQuoteResponse{} }},
+        {"ship-order", &pb.ShipOrderRequest{Address: address, Items: items}, func() proto.Message { return &pb.ShipOrderResponse{} }},
+    },
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+
+    "google.golang.org/protobuf/proto"
+)
+
+const (
+    httpMode   = "http"
+    lambdaMode = "lambda"
+)
+
+// invoker sends a marshalled request to a service and unmarshals its response.
+// It returns the number of bytes sent and received on the wire.
+type invoker interface {
+    invoke(c *call, binReq []byte, requestID string) (sent, received int, err error)
+}
+
+// newInvoker returns the invoker of a mode.
+func newInvoker(mode, url string, client *http.Client) (invoker, error) {
+    switch mode {
+    case httpMode:
+        return &httpInvoker{url: url, client: client}, nil
+    case lambdaMode:
+        return &lambdaInvoker{url: url, client: client}, nil
+    default:
+        return nil, fmt.Errorf("unknown mode %q, expected %q or %q", mode, httpMode, lambdaMode)
+    }
+}
+
+// httpInvoker talks to a service running its HTTP server, the same way the gRPC clients of the services do.
+type httpInvoker struct {
+    url    string
+    client *http.Client
+}
+
+func (h *httpInvoker) invoke(c *call, binReq []byte, requestID string) (int, int, error) {
+    req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(binReq))
+    if err != nil {
+        return 0, 0, fmt.Errorf("failed to create HTTP request: %w", err)
+    }
+    req.Header.Set("rpc-name", c.rpcName)
+    req.Header.Set("content-type", "application/octet-stream")
+    req.Header.Set("x-request-id", requestID)
+
+    resp, err := h.client.Do(req)
+    if err != nil {
+        return len(binReq), 0, fmt.Errorf("failed to send HTTP request: %w", err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return len(binReq), 0, fmt.Errorf("failed to read response body: %w", err)
+    }
+    if resp.StatusCode != http.StatusOK {
+        return len(binReq), len(respBody), fmt.Errorf("received non-OK response: %s", resp.Status)
+    }
+    return len(binReq), len(respBody), unmarshalResponse(c, respBody, resp.Header.Get("grpc-status"))
+}
+
+// lambdaInvoker talks to a service running as a Lambda function through an invocation endpoint, such as the one of
+// the Lambda Runtime Interface Emulator. The request is wrapped in the same JSON event API Gateway sends.
+type lambdaInvoker struct {
+    url    string
+    client *http.Client
+}
+
+// lambdaEvent is the JSON form of the server's RequestData and ResponseData.
+type lambdaEvent struct {
+    StatusCode      int               `json:"statusCode,omitempty"`
+    Headers         map[string]string `json:"headers"`
+    IsBase64Encoded bool              `json:"isBase64Encoded"`
+    Body            string            `json:"body"`
+}
+
+func (l *lambdaInvoker) invoke(c *call, binReq []byte, requestID string) (int, int, error) {
+    event, err := json.Marshal(&lambdaEvent{
+        Headers: map[string]string{
+            "rpc-name":     c.rpcName,
+            "content-type": "application/octet-stream",
+            "x-request-id": requestID,
+        },
+        IsBase64Encoded: true,
+        Body:            base64.StdEncoding.EncodeToString(binReq),
+    })
+    if err != nil {
+        return 0, 0, fmt.Errorf("failed to marshal event: %w", err)
+    }
+
+    resp, err := l.client.Post(l.url, "application/json", bytes.NewReader(event))
+    if err != nil {
+        return len(event), 0, fmt.Errorf("failed to invoke function: %w", err)
+    }
+    defer resp.Body.Close()
+
+    respBody, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return len(event), 0, fmt.Errorf("failed to read invocation response: %w", err)
+    }
+    if resp.StatusCode != http.StatusOK {
+        return len(event), len(respBody), fmt.Errorf("received non-OK response: %s", resp.Status)
+    }
+
+    var respData lambdaEvent
+    if err := json.Unmarshal(respBody, &respData); err != nil {
+        return len(event), len(respBody), fmt.Errorf("failed to unmarshal invocation response: %w", err)
+    }
+    body := []byte(respData.Body)
+    if respData.IsBase64Encoded {
+        if body, err = base64.StdEncoding.DecodeString(respData.Body); err != nil {
+            return len(event), len(respBody), fmt.Errorf("failed to decode base64 body: %w", err)
+        }
+    }
+    return len(event), len(respBody), unmarshalResponse(c, body, respData.Headers["grpc-status"])
+}
+
+// unmarshalResponse checks the gRPC status of a response and unmarshals its body.
+func unmarshalResponse(c *call, body []byte, grpcStatus string) error {
+    code, err := strconv.Atoi(grpcStatus)
+    if err != nil {
+        return fmt.Errorf("invalid grpc-status header %q", grpcStatus)
+    }
+    if code != 0 {
+        return fmt.Errorf("rpc error: code = %d desc = %s", code, body)
+    }
+    if err := proto.Unmarshal(body, c.response()); err != nil {
+        return fmt.Errorf("failed to unmarshal response: %w", err)
+    }
+    return nil
+}
@@ -25,8 +25,16 @@ func determineMessageType(rpcName string) proto.Message {
     switch rpcName {
     case listProductsRPC:
         msg = &pb.ListProductsResponse{}
-    case getProductRPC:
+    case getProductRPC, createProductRPC, updateProductRPC:
         msg = &pb.Product{}
+    case deleteProductRPC, commitReservationRPC, releaseReservationRPC:
+        msg = &pb.Empty{}
+    case getStockRPC:
+        msg = &pb.GetStockResponse{}
+    case reserveStockRPC:
+        msg = &pb.Reservation{}
+    case bulkUpsertProductsRPC:
+        msg = &pb.BulkUpsertProductsResponse{}
     default:
         msg = &pb.SearchProductsResponse{}
     }
@@ -19,11 +19,19 @@ const (
     listProductsRPC       = "list-products"
     getProductRPC         = "get-product"
     searchProductsRPC     = "search-products"
+    createProductRPC      = "create-product"
+    updateProductRPC      = "update-product"
+    deleteProductRPC      = "delete-product"
+    bulkUpsertProductsRPC = "bulk-upsert-products"
+    getStockRPC           = "get-stock"
+    reserveStockRPC       = "reserve-stock"
+    commitReservationRPC  = "commit-reservation"
+    releaseReservationRPC = "release-reservation"
 )
 
 // ListProducts represents the ProductCatalogService/ListProducts RPC.
 // context can be sent as custom headers.
-func ListProducts(request *pb.Empty, header *http.Header) (*pb.ListProductsResponse, error) {
+func ListProducts(request *pb.ListProductsRequest, header *http.Header) (*pb.ListProductsResponse, error) {
     binReq, err := marshalRequest(request)
     if err != nil {
         return nil, err
@@ -84,6 +92,174 @@ func SearchProducts(request *pb.SearchProductsRequest, header *http.Header) (*pb
     return (*msg).(*pb.SearchProductsResponse), nil
 }
 
+// CreateProduct represents the ProductCatalogService/CreateProduct RPC.
+// context can be sent as custom headers, and the admin token in the authorization header.
+func CreateProduct(request *pb.CreateProductRequest, header *http.Header) (*pb.Product, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, createProductRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, createProductRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Product), nil
+}
+
+// UpdateProduct represents the ProductCatalogService/UpdateProduct RPC.
+// context can be sent as custom headers, and the admin token in the authorization header.
+func UpdateProduct(request *pb.UpdateProductRequest, header *http.Header) (*pb.Product, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, updateProductRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, updateProductRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Product), nil
+}
+
+// DeleteProduct represents the ProductCatalogService/DeleteProduct RPC.
+// context can be sent as custom headers, and the admin token in the authorization header.
+func DeleteProduct(request *pb.DeleteProductRequest, header *http.Header) (*pb.Empty, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, deleteProductRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, deleteProductRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Empty), nil
+}
+
+// BulkUpsertProducts represents the ProductCatalogService/BulkUpsertProducts RPC.
+// context can be sent as custom headers, and the admin token in the authorization header.
+func BulkUpsertProducts(request *pb.BulkUpsertProductsRequest, header *http.Header) (*pb.BulkUpsertProductsResponse, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, bulkUpsertProductsRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, bulkUpsertProductsRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.BulkUpsertProductsResponse), nil
+}
+
+// GetStock represents the ProductCatalogService/GetStock RPC.
+// context can be sent as custom headers.
+func GetStock(request *pb.GetStockRequest, header *http.Header) (*pb.GetStockResponse, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, getStockRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, getStockRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.GetStockResponse), nil
+}
+
+// ReserveStock represents the ProductCatalogService/ReserveStock RPC.
+// context can be sent as custom headers.
+func ReserveStock(request *pb.ReserveStockRequest, header *http.Header) (*pb.Reservation, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, reserveStockRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, reserveStockRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Reservation), nil
+}
+
+// CommitReservation represents the ProductCatalogService/CommitReservation RPC.
+// context can be sent as custom headers.
+func CommitReservation(request *pb.CommitReservationRequest, header *http.Header) (*pb.Empty, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, commitReservationRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, commitReservationRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Empty), nil
+}
+
+// ReleaseReservation represents the ProductCatalogService/ReleaseReservation RPC.
+// context can be sent as custom headers.
+func ReleaseReservation(request *pb.ReleaseReservationRequest, header *http.Header) (*pb.Empty, error) {
+    binReq, err := marshalRequest(request)
+    if err != nil {
+        return nil, err
+    }
+
+    respBody, header, err := sendRequest(*productCatalogServiceAddr, productCatalogService, releaseReservationRPC, &binReq, header, *productCatalogServiceTimeout)
+    if err != nil {
+        return nil, err
+    }
+
+    msg, err := unmarshalResponse(respBody, header, releaseReservationRPC)
+    if err != nil {
+        return nil, err
+    }
+
+    return (*msg).(*pb.Empty), nil
+}
+
 // init loads the address and timeout variables.
 func init() {
     a, ok := os.LookupEnv("PRODUCT_CATALOG_SERVICE_ADDR")
@@ -37,7 +37,7 @@ func TestGetProductNotFound(t *testing.T) {
 func TestListProducts(t *testing.T) {
     flag.Parse()
     products, err := ListProducts(
-        &pb.Empty{},
+        &pb.ListProductsRequest{},
         nil,
     )
     if err != nil {
@@ -48,6 +48,35 @@ func TestListProducts(t *testing.T) {
     }
 }
 
+func TestListProductsPages(t *testing.T) {
+    flag.Parse()
+    req := &pb.ListProductsRequest{PageSize: 4, Category: "kitchen"}
+    first, err := ListProducts(req, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, want := len(first.Products), 3; got != want {
+        t.Errorf("got %d, want %d", got, want)
+    }
+
+    req = &pb.ListProductsRequest{PageSize: 4}
+    first, err = ListProducts(req, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first.NextPageToken == "" || first.TotalSize != 9 {
+        t.Fatalf("got token %q and total size %d, want a token and 9", first.NextPageToken, first.TotalSize)
+    }
+    req.PageToken = first.NextPageToken
+    second, err := ListProducts(req, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, want := second.Products[0].Id, "2ZYFJ3GM2N"; got != want {
+        t.Errorf("got %s, want %s", got, want)
+    }
+}
+
 func TestSearchProducts(t *testing.T) {
     flag.Parse()
     products, err := SearchProducts(
@@ -61,3 +90,37 @@ func TestSearchProducts(t *testing.T) {
         t.Errorf("got %d, want %d", got, want)
     }
 }
+
+func TestCreateProductRequiresAdminToken(t *testing.T) {
+    flag.Parse()
+    _, err := CreateProduct(
+        &pb.CreateProductRequest{Product: &pb.Product{Id: "TEAPOT", Name: "Teapot",
+            PriceUsd: &pb.Money{CurrencyCode: "USD", Units: 30}}},
+        nil,
+    )
+    // The admin RPCs are either disabled or refuse requests without a token.
+    if got := status.Code(err); got != codes.Unauthenticated && got != codes.PermissionDenied {
+        t.Errorf("got %s, want %s or %s", got, codes.Unauthenticated, codes.PermissionDenied)
+    }
+}
+
+func TestReserveAndReleaseStock(t *testing.T) {
+    flag.Parse()
+    reservation, err := ReserveStock(
+        &pb.ReserveStockRequest{Items: []*pb.CartItem{{ProductId: "OLJCESPC7Z", Quantity: 1}}},
+        nil,
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    if reservation.ReservationId == "" || reservation.ExpireTime == 0 {
+        t.Errorf("got reservation %v, want an ID and an expiry", reservation)
+    }
+    if _, err := ReleaseReservation(&pb.ReleaseReservationRequest{ReservationId: reservation.ReservationId}, nil); err != nil {
+        t.Fatal(err)
+    }
+    _, err = CommitReservation(&pb.CommitReservationRequest{ReservationId: reservation.ReservationId}, nil)
+    if got, want := status.Code(err), codes.NotFound; got != want {
+        t.Errorf("got %s, want %s", got, want)
+    }
+}